@@ -0,0 +1,26 @@
+// Fake HIS serving sample patients, to develop hospitals connected to a HIS offline:
+//
+//	FAKE_HIS_PORT=8090 go run ./cmd/fakehis
+//
+// then set the his_base_url setting of a hospital to http://<host>:8090.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/fakehis"
+)
+
+func main() {
+	port := os.Getenv("FAKE_HIS_PORT")
+	if port == "" {
+		port = "8090"
+	}
+
+	his := fakehis.New(fakehis.SamplePatients()...)
+
+	log.Printf("Fake HIS listening on :%s", port)
+	log.Fatal(http.ListenAndServe(":"+port, his.Handler()))
+}
@@ -0,0 +1,29 @@
+// Generate a key signing login tokens, to add to JWT_SIGNING_KEYS:
+//
+//	go run ./cmd/jwtkey 2025-07
+//
+// prints the 2025-07=<seed> entry of the key. Keep it secret, like a password.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+func main() {
+	// Keys are named after their creation month unless a kid is given
+	id := time.Now().Format("2006-01")
+	if len(os.Args) > 1 {
+		id = os.Args[1]
+	}
+
+	key, err := pkg.GenerateSigningKey(id)
+	if err != nil {
+		log.Fatalf("Failed to generate the signing key: %v", err)
+	}
+	fmt.Println(key)
+}
@@ -11,6 +11,11 @@ CREATE TABLE IF NOT EXISTS hospitals (
     name VARCHAR(255)
 );
 
+-- Hospital code (e.g. Thai MOPH 5-digit code), NULL for hospitals created before codes,
+-- and per hospital settings as JSON
+ALTER TABLE hospitals ADD COLUMN IF NOT EXISTS code VARCHAR(5) UNIQUE;
+ALTER TABLE hospitals ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+
 -- Create a "patient" table
 CREATE TABLE IF NOT EXISTS patients (
     id SERIAL PRIMARY KEY,
@@ -21,19 +26,183 @@ CREATE TABLE IF NOT EXISTS patients (
     middle_name_en VARCHAR(255),
     last_name_en VARCHAR(255),
     date_of_birth DATE NOT NULL,
-    patient_hn VARCHAR(50) NOT NULL UNIQUE,
-    national_id VARCHAR(50) NOT NULL UNIQUE,
-    passport_id VARCHAR(50) NOT NULL UNIQUE,
+    patient_hn VARCHAR(50) NOT NULL,
+    national_id VARCHAR(50),
+    passport_id VARCHAR(50),
     phone_number VARCHAR(50) NOT NULL,
-    email VARCHAR(255) UNIQUE,
+    email VARCHAR(255),
     gender CHAR(1),
     hospital_id INT REFERENCES hospitals(id) -- Foreign key
 );
 
+-- Soft delete marker of patients, rows with deleted_at set are hidden from the API
+ALTER TABLE patients ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_patients_deleted_at ON patients (deleted_at);
+
+-- Emails of patients are unique per hospital among live patients, patients without an email store ''
+ALTER TABLE patients DROP CONSTRAINT IF EXISTS patients_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS patients_email_key ON patients (hospital_id, email) WHERE email <> '' AND deleted_at IS NULL;
+
+-- Every identifier of a patient (HN, national IDs, passports and others), unique per hospital.
+-- patient_hn, national_id and passport_id of patients keep the primary identifiers.
+CREATE TABLE IF NOT EXISTS patient_identifiers (
+    id SERIAL PRIMARY KEY,
+    patient_id INT NOT NULL REFERENCES patients(id), -- Foreign key
+    hospital_id INT REFERENCES hospitals(id), -- Foreign key
+    type VARCHAR(20) NOT NULL,
+    value VARCHAR(50) NOT NULL,
+    issuer VARCHAR(50) NOT NULL DEFAULT '',
+    CONSTRAINT uq_patient_identifiers UNIQUE (hospital_id, type, issuer, value)
+);
+CREATE INDEX IF NOT EXISTS idx_patient_identifiers_patient_id ON patient_identifiers (patient_id);
+CREATE INDEX IF NOT EXISTS idx_patient_identifiers_type_value ON patient_identifiers (type, value);
+
+-- Copy the primary identifiers of existing patients, then let identifiers be unique per hospital only
+INSERT INTO patient_identifiers (patient_id, hospital_id, type, value, issuer)
+SELECT id, hospital_id, 'hn', patient_hn, '' FROM patients WHERE patient_hn <> '' AND deleted_at IS NULL
+UNION ALL
+SELECT id, hospital_id, 'national_id', national_id, 'TH' FROM patients WHERE national_id <> '' AND deleted_at IS NULL
+UNION ALL
+SELECT id, hospital_id, 'passport', passport_id, '' FROM patients WHERE passport_id <> '' AND deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE patients ALTER COLUMN national_id DROP NOT NULL;
+ALTER TABLE patients ALTER COLUMN passport_id DROP NOT NULL;
+ALTER TABLE patients DROP CONSTRAINT IF EXISTS patients_patient_hn_key;
+ALTER TABLE patients DROP CONSTRAINT IF EXISTS patients_national_id_key;
+ALTER TABLE patients DROP CONSTRAINT IF EXISTS patients_passport_id_key;
+
+-- Normalize Thai local phone numbers (0XXXXXXXXX) of existing patients to E.164 (+66XXXXXXXXX)
+UPDATE patients
+SET phone_number = '+66' || substr(regexp_replace(phone_number, '[\s().-]', '', 'g'), 2)
+WHERE regexp_replace(phone_number, '[\s().-]', '', 'g') ~ '^0[0-9]{8,9}$';
+
 -- Create a "staff" table
 CREATE TABLE IF NOT EXISTS staffs (
     id SERIAL PRIMARY KEY,
     username VARCHAR(255) NOT NULL UNIQUE,
     password VARCHAR(255) NOT NULL,
+    role VARCHAR(30) NOT NULL,
     hospital_id INT REFERENCES hospitals(id) -- Foreign key
-);
\ No newline at end of file
+);
+
+-- Email receiving password reset tokens, lowercase and unique when set
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS email VARCHAR(255) NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS staffs_email_key ON staffs (email) WHERE email <> '';
+
+-- Role of staff (admin, doctor, nurse, registration_clerk). Existing staff become registration clerks
+-- until an admin assigns their role.
+ALTER TABLE staffs ADD COLUMN IF NOT EXISTS role VARCHAR(30) NOT NULL DEFAULT 'registration_clerk';
+ALTER TABLE staffs ALTER COLUMN role DROP DEFAULT;
+
+-- Pending invitations of staff who have not set their password yet (staffs.password is empty until then)
+CREATE TABLE IF NOT EXISTS staff_invitations (
+    id SERIAL PRIMARY KEY,
+    staff_id INT NOT NULL UNIQUE REFERENCES staffs(id), -- Foreign key
+    token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the invitation token
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Login tokens revoked before they expire, e.g. by logout (token_id is the jti claim)
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Login tokens of a staff member issued before revoked_before are revoked, except those of the login except_family_id
+CREATE TABLE IF NOT EXISTS staff_session_revocations (
+    staff_id INT PRIMARY KEY REFERENCES staffs(id), -- Foreign key
+    revoked_before TIMESTAMPTZ NOT NULL,
+    except_family_id VARCHAR(64) NOT NULL DEFAULT '' -- empty revokes every login
+);
+
+-- Former password hashes of staff members, refused when set again
+CREATE TABLE IF NOT EXISTS password_histories (
+    id SERIAL PRIMARY KEY,
+    staff_id INT NOT NULL REFERENCES staffs(id), -- Foreign key
+    password_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_histories_staff_id ON password_histories (staff_id);
+
+-- Pending password resets, one per staff member. Only the hash of the emailed token is stored.
+CREATE TABLE IF NOT EXISTS password_resets (
+    id SERIAL PRIMARY KEY,
+    staff_id INT NOT NULL UNIQUE REFERENCES staffs(id), -- Foreign key
+    token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the token
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Refresh tokens of logins, stored hashed. A refresh marks the token used and creates the next one of its family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    staff_id INT NOT NULL REFERENCES staffs(id), -- Foreign key
+    family_id VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the refresh token
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_staff_id ON refresh_tokens (staff_id);
+
+-- Authentication methods of the login (amr claim), tokens of logins before MFA used a password
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS auth_methods VARCHAR(50) NOT NULL DEFAULT 'pwd';
+
+-- TOTP authenticators of staff, the secret encrypted with TOTP_ENCRYPTION_KEY
+CREATE TABLE IF NOT EXISTS staff_totps (
+    staff_id INT PRIMARY KEY REFERENCES staffs(id), -- Foreign key
+    secret VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    confirmed_at TIMESTAMPTZ, -- NULL while the enrolment is pending
+    last_used_step BIGINT NOT NULL DEFAULT 0 -- codes are used once
+);
+
+-- Single-use recovery codes of staff with TOTP, stored hashed
+CREATE TABLE IF NOT EXISTS staff_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    staff_id INT NOT NULL REFERENCES staffs(id), -- Foreign key
+    code_hash VARCHAR(64) NOT NULL -- SHA-256 of the code
+);
+
+CREATE INDEX IF NOT EXISTS idx_staff_recovery_codes_staff_id ON staff_recovery_codes (staff_id);
+
+-- Failed logins per username and per client IP, throttling password guessing
+CREATE TABLE IF NOT EXISTS login_failures (
+    key VARCHAR(320) PRIMARY KEY, -- username:<username> or ip:<address>
+    failures INT NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last_failure_at ON login_failures (last_failure_at);
+
+-- Trigram indexes backing prefix/contains (ILIKE) patient searches
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_th_trgm ON patients USING gin (first_name_th gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_middle_name_th_trgm ON patients USING gin (middle_name_th gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_th_trgm ON patients USING gin (last_name_th gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_en_trgm ON patients USING gin (first_name_en gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_middle_name_en_trgm ON patients USING gin (middle_name_en gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_en_trgm ON patients USING gin (last_name_en gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_email_trgm ON patients USING gin (email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_phone_number_trgm ON patients USING gin (phone_number gin_trgm_ops);
+
+-- Expression indexes backing case-insensitive exact (LOWER(column) = LOWER(?)) patient searches
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_th_lower ON patients (LOWER(first_name_th));
+CREATE INDEX IF NOT EXISTS idx_patients_middle_name_th_lower ON patients (LOWER(middle_name_th));
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_th_lower ON patients (LOWER(last_name_th));
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_en_lower ON patients (LOWER(first_name_en));
+CREATE INDEX IF NOT EXISTS idx_patients_middle_name_en_lower ON patients (LOWER(middle_name_en));
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_en_lower ON patients (LOWER(last_name_en));
+CREATE INDEX IF NOT EXISTS idx_patients_email_lower ON patients (LOWER(email));
+CREATE INDEX IF NOT EXISTS idx_patients_phone_number_lower ON patients (LOWER(phone_number));
+
+-- Composite indexes backing keyset pagination of patient search per hospital
+CREATE INDEX IF NOT EXISTS idx_patients_hospital_hn_id ON patients (hospital_id, patient_hn, id);
+CREATE INDEX IF NOT EXISTS idx_patients_hospital_last_name_en_id ON patients (hospital_id, COALESCE(last_name_en, ''), id);
+CREATE INDEX IF NOT EXISTS idx_patients_hospital_last_name_th_id ON patients (hospital_id, COALESCE(last_name_th, ''), id);
+CREATE INDEX IF NOT EXISTS idx_patients_hospital_dob_id ON patients (hospital_id, date_of_birth, id);
@@ -0,0 +1,89 @@
+// Package fakehis is an in-memory Hospital Information System serving the HIS search
+// endpoint, to develop and test the HIS patient repository offline.
+package fakehis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+// HIS holding patients in memory, safe for concurrent use
+type HIS struct {
+	mu       sync.RWMutex
+	patients []pkg.HISPatient
+}
+
+func New(patients ...pkg.HISPatient) *HIS {
+	return &HIS{patients: patients}
+}
+
+func (h *HIS) AddPatient(patient pkg.HISPatient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.patients = append(h.patients, patient)
+}
+
+// HTTP handler of GET /patient/search/{id} where {id} is a national ID or a passport ID
+func (h *HIS) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /patient/search/{id}", h.searchPatient)
+	return mux
+}
+
+// Start a local server of the HIS, its base URL is the URL field. Close it when done.
+func (h *HIS) NewServer() *httptest.Server {
+	return httptest.NewServer(h.Handler())
+}
+
+func (h *HIS) searchPatient(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, patient := range h.patients {
+		if patient.NationalID == id || (patient.PassportID != "" && strings.EqualFold(patient.PassportID, id)) {
+			writeJSON(w, http.StatusOK, patient)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "patient not found"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Sample patients of a HIS, for local development
+func SamplePatients() []pkg.HISPatient {
+	return []pkg.HISPatient{
+		{
+			FirstNameTh: "สมชาย",
+			LastNameTh:  "ใจดี",
+			FirstNameEn: "Somchai",
+			LastNameEn:  "Jaidee",
+			DateOfBirth: "1985-04-12",
+			PatientHN:   "HN000001",
+			NationalID:  "1101700230708",
+			PhoneNumber: "0812345678",
+			Email:       "somchai.j@example.com",
+			Gender:      "M",
+		},
+		{
+			FirstNameEn: "Emily",
+			LastNameEn:  "Clarke",
+			DateOfBirth: "1992-11-03",
+			PatientHN:   "HN000002",
+			PassportID:  "GB1234567",
+			PhoneNumber: "+447700900123",
+			Email:       "emily.clarke@example.com",
+			Gender:      "F",
+		},
+	}
+}
@@ -0,0 +1,168 @@
+// Package fakesmtp is an in-memory SMTP server keeping the emails it receives, like MailHog,
+// to develop and test emails offline. It accepts every sender and recipient without authentication.
+package fakesmtp
+
+import (
+	"bufio"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+// Email received by the server
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	Data    string // headers and body as received
+}
+
+// SMTP server listening on a local port, safe for concurrent use
+type Server struct {
+	Addr string // host:port
+
+	listener net.Listener
+	mu       sync.Mutex
+	messages []Message
+	received chan struct{}
+}
+
+// Start a server on a free local port. Close it when done.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Addr: listener.Addr().String(), listener: listener, received: make(chan struct{}, 100)}
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Emails received so far, the oldest first
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Signaled once per received email, e.g. to wait for an email sent in the background
+func (s *Server) Received() <-chan struct{} {
+	return s.received
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// Minimal SMTP session: EHLO/HELO, MAIL, RCPT, DATA, RSET, NOOP and QUIT
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+
+	if !reply("220 fakesmtp ready") {
+		return
+	}
+	var message Message
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 fakesmtp")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = Message{From: address(line[len("MAIL FROM:"):])}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.To = append(message.To, address(line[len("RCPT TO:"):]))
+			reply("250 OK")
+		case command == "DATA":
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			data, ok := readData(reader)
+			if !ok {
+				return
+			}
+			message.Data = data
+			if parsed, err := mail.ReadMessage(strings.NewReader(data)); err == nil {
+				message.Subject, _ = new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+				body := new(strings.Builder)
+				bufio.NewReader(parsed.Body).WriteTo(body)
+				message.Body = strings.ReplaceAll(body.String(), "\r\n", "\n")
+			}
+			s.store(message)
+			reply("250 OK")
+		case command == "RSET":
+			message = Message{}
+			reply("250 OK")
+		case command == "NOOP":
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// Lines of the DATA command until the line with a single dot, without dot-stuffing
+func readData(reader *bufio.Reader) (string, bool) {
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		if strings.TrimRight(line, "\r\n") == "." {
+			return data.String(), true
+		}
+		data.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+// Address of a MAIL FROM or RCPT TO argument, e.g. <staff@hospital.example> SIZE=100
+func address(argument string) string {
+	argument = strings.TrimSpace(argument)
+	if end := strings.Index(argument, ">"); strings.HasPrefix(argument, "<") && end > 0 {
+		return argument[1:end]
+	}
+	if fields := strings.Fields(argument); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+func (s *Server) store(message Message) {
+	s.mu.Lock()
+	s.messages = append(s.messages, message)
+	s.mu.Unlock()
+
+	select {
+	case s.received <- struct{}{}:
+	default:
+	}
+}
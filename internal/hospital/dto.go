@@ -0,0 +1,48 @@
+package hospital
+
+import (
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+// Request body of hospital creation and replacement
+type HospitalRequest struct {
+	Name     string               `json:"name" validate:"required,max=255" example:"Siriraj Hospital"`
+	Code     string               `json:"code" validate:"required,len=5,numeric" example:"13781"` // Thai MOPH 5-digit hospital code
+	Settings pkg.HospitalSettings `json:"settings"`
+}
+
+// Hospital as returned by the API, without its patients and staff
+type HospitalResponse struct {
+	ID       int                  `json:"id"`
+	Name     string               `json:"name"`
+	Code     *string              `json:"code"`
+	Settings pkg.HospitalSettings `json:"settings"`
+}
+
+// Hospital model of the request, to be pinned to an ID by the caller
+func (r *HospitalRequest) ToHospital() *pkg.Hospital {
+	code := r.Code
+	return &pkg.Hospital{
+		Name:     r.Name,
+		Code:     &code,
+		Settings: r.Settings,
+	}
+}
+
+func NewHospitalResponse(hospital *pkg.Hospital) HospitalResponse {
+	return HospitalResponse{
+		ID:       hospital.ID,
+		Name:     hospital.Name,
+		Code:     hospital.Code,
+		Settings: hospital.Settings,
+	}
+}
+
+// Always an array in JSON, never null
+func NewHospitalResponses(hospitals []pkg.Hospital) []HospitalResponse {
+	responses := make([]HospitalResponse, 0, len(hospitals))
+	for i := range hospitals {
+		responses = append(responses, NewHospitalResponse(&hospitals[i]))
+	}
+	return responses
+}
@@ -0,0 +1,154 @@
+package hospital
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// Primary adapter
+type HospitalHandler struct {
+	Service HospitalServiceInterface
+}
+
+// Just define what struct will do
+type HospitalHandlerInterface interface {
+	CreateHospital(c *gin.Context)
+	ListHospitals(c *gin.Context)
+	UpdateHospital(c *gin.Context)
+}
+
+func NewHttpHospitalHandler(service HospitalServiceInterface) *HospitalHandler {
+	return &HospitalHandler{Service: service}
+}
+
+// CreateHospital godoc
+// @Summary Create a hospital
+// @Description Create a hospital with its code and settings. Restricted to the platform super-admin.
+// @Tags Hospital
+// @Accept json
+// @Produce json
+// @Param hospital body HospitalRequest true "Hospital details"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /hospital [post]
+func (h *HospitalHandler) CreateHospital(c *gin.Context) {
+	var request HospitalRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	createdHospital, err := h.Service.CreateHospital(request.ToHospital())
+	if err != nil {
+		respondHospitalError(c, err)
+		return
+	}
+
+	// Success creation
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Created successfully",
+		"data":    NewHospitalResponse(createdHospital),
+	})
+}
+
+// ListHospitals godoc
+// @Summary List hospitals
+// @Description List every hospital with its code and settings. Restricted to the platform super-admin.
+// @Tags Hospital
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /hospital [get]
+func (h *HospitalHandler) ListHospitals(c *gin.Context) {
+	hospitals, err := h.Service.ListHospitals()
+	if err != nil {
+		respondHospitalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": NewHospitalResponses(hospitals)})
+}
+
+// UpdateHospital godoc
+// @Summary Replace a hospital
+// @Description Replace the name, code and settings of a hospital. Restricted to the platform super-admin.
+// @Tags Hospital
+// @Accept json
+// @Produce json
+// @Param id path int true "Hospital ID"
+// @Param hospital body HospitalRequest true "Hospital details"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /hospital/{id} [put]
+func (h *HospitalHandler) UpdateHospital(c *gin.Context) {
+	hospitalID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || hospitalID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hospital ID must be a positive integer"})
+		return
+	}
+
+	var request HospitalRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hospital := request.ToHospital()
+	hospital.ID = hospitalID
+
+	// Call service
+	updatedHospital, err := h.Service.UpdateHospital(hospital)
+	if err != nil {
+		respondHospitalError(c, err)
+		return
+	}
+
+	// Success update
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Updated successfully",
+		"data":    NewHospitalResponse(updatedHospital),
+	})
+}
+
+// Map service errors to HTTP status codes
+func respondHospitalError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidHNPattern), errors.Is(err, ErrInvalidHISBaseURL), errors.Is(err, ErrInvalidHISTimeout),
+		errors.Is(err, ErrInvalidMFARoles):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrHospitalNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrDuplicateHospitalCode):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,79 @@
+package hospital
+
+import (
+	"errors"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Secondary port
+type HospitalRepositoryInterface interface {
+	CreateHospital(hospital *pkg.Hospital) error
+	ListHospitals() ([]pkg.Hospital, error)
+	GetHospitalByID(id int) (*pkg.Hospital, error)
+	UpdateHospital(hospital *pkg.Hospital) error
+}
+
+// Secondary adapter
+type GormHospitalRepository struct {
+	db *gorm.DB
+}
+
+// Initiate secondary adapter
+func NewGormHospitalRepository(db *gorm.DB) HospitalRepositoryInterface {
+	return &GormHospitalRepository{db: db}
+}
+
+func (r *GormHospitalRepository) CreateHospital(hospital *pkg.Hospital) error {
+	err := r.db.Omit(clause.Associations).Create(hospital).Error
+	return translateHospitalError(err)
+}
+
+// Every hospital in creation order, without their patients and staff
+func (r *GormHospitalRepository) ListHospitals() ([]pkg.Hospital, error) {
+	var hospitals []pkg.Hospital
+	if err := r.db.Order("id").Find(&hospitals).Error; err != nil {
+		return nil, err
+	}
+
+	return hospitals, nil
+}
+
+func (r *GormHospitalRepository) GetHospitalByID(id int) (*pkg.Hospital, error) {
+	var hospital pkg.Hospital
+	if err := r.db.Where("id = ?", id).First(&hospital).Error; err != nil {
+		return nil, err
+	}
+
+	return &hospital, nil
+}
+
+// Replace the name, code and settings of an existing hospital
+func (r *GormHospitalRepository) UpdateHospital(hospital *pkg.Hospital) error {
+	result := r.db.Model(&pkg.Hospital{}).
+		Where("id = ?", hospital.ID).
+		Select("name", "code", "settings").
+		Updates(hospital)
+	if result.Error != nil {
+		return translateHospitalError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func translateHospitalError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == "hospitals_code_key" {
+		return ErrDuplicateHospitalCode
+	}
+	return err
+}
+
+// Postgres SQLSTATE of unique_violation
+const pgUniqueViolation = "23505"
@@ -0,0 +1,139 @@
+package hospital
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrHospitalNotFound      = errors.New("hospital not found")
+	ErrDuplicateHospitalCode = errors.New("a hospital with this code already exists")
+	ErrInvalidHNPattern      = errors.New("settings.patient_hn_pattern is not a valid regular expression")
+	ErrInvalidHISBaseURL     = errors.New("settings.his_base_url must be an absolute http or https URL")
+	ErrInvalidHISTimeout     = fmt.Errorf("settings.his_timeout_ms must be between 0 (default) and %d", pkg.MaxHISTimeout.Milliseconds())
+	ErrInvalidMFARoles       = errors.New("settings.mfa_required_roles must only contain staff roles of a hospital")
+)
+
+// Primary port
+type HospitalServiceInterface interface {
+	CreateHospital(hospital *pkg.Hospital) (*pkg.Hospital, error)
+	ListHospitals() ([]pkg.Hospital, error)
+	UpdateHospital(hospital *pkg.Hospital) (*pkg.Hospital, error)
+	LoadPatientHNPatterns() error
+}
+
+// Told about the hospitals created or updated, e.g. to drop what it cached of their settings
+type SettingsObserver interface {
+	HospitalSettingsChanged(hospital *pkg.Hospital)
+}
+
+type HospitalService struct {
+	repo      HospitalRepositoryInterface
+	observers []SettingsObserver
+}
+
+func NewHospitalService(repo HospitalRepositoryInterface, observers ...SettingsObserver) HospitalServiceInterface {
+	return &HospitalService{repo: repo, observers: observers}
+}
+
+func (s *HospitalService) CreateHospital(hospital *pkg.Hospital) (*pkg.Hospital, error) {
+	if err := checkSettings(hospital.Settings); err != nil {
+		return nil, err
+	}
+
+	// ID is always assigned by the database
+	hospital.ID = 0
+
+	if err := s.repo.CreateHospital(hospital); err != nil {
+		return nil, err
+	}
+
+	if err := applySettings(hospital); err != nil {
+		return nil, err
+	}
+	s.settingsChanged(hospital)
+	return hospital, nil
+}
+
+func (s *HospitalService) ListHospitals() ([]pkg.Hospital, error) {
+	return s.repo.ListHospitals()
+}
+
+func (s *HospitalService) UpdateHospital(hospital *pkg.Hospital) (*pkg.Hospital, error) {
+	if err := checkSettings(hospital.Settings); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateHospital(hospital); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrHospitalNotFound
+		}
+		return nil, err
+	}
+
+	if err := applySettings(hospital); err != nil {
+		return nil, err
+	}
+	s.settingsChanged(hospital)
+	return hospital, nil
+}
+
+// Register the HN pattern of every hospital which has one, overriding PATIENT_HN_PATTERNS.
+// Called at startup, other instances pick up later changes when they restart.
+func (s *HospitalService) LoadPatientHNPatterns() error {
+	hospitals, err := s.repo.ListHospitals()
+	if err != nil {
+		return err
+	}
+
+	for i := range hospitals {
+		if err := applySettings(&hospitals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkSettings(settings pkg.HospitalSettings) error {
+	if settings.PatientHNPattern != "" {
+		if _, err := regexp.Compile(settings.PatientHNPattern); err != nil {
+			return ErrInvalidHNPattern
+		}
+	}
+	if settings.HISBaseURL != "" {
+		hisURL, err := url.Parse(settings.HISBaseURL)
+		if err != nil || (hisURL.Scheme != "http" && hisURL.Scheme != "https") || hisURL.Host == "" {
+			return ErrInvalidHISBaseURL
+		}
+	}
+	if settings.HISTimeoutMs < 0 || int64(settings.HISTimeoutMs) > pkg.MaxHISTimeout.Milliseconds() {
+		return ErrInvalidHISTimeout
+	}
+	// The super-admin belongs to no hospital, MFA_REQUIRED_ROLES covers it
+	for _, role := range settings.MFARequiredRoles {
+		if !role.IsValid() || role == pkg.RoleSuperAdmin {
+			return ErrInvalidMFARoles
+		}
+	}
+	return nil
+}
+
+func (s *HospitalService) settingsChanged(hospital *pkg.Hospital) {
+	for _, observer := range s.observers {
+		observer.HospitalSettingsChanged(hospital)
+	}
+}
+
+// An empty HN pattern falls back to the one of PATIENT_HN_PATTERNS, or the default
+func applySettings(hospital *pkg.Hospital) error {
+	if hospital.Settings.PatientHNPattern == "" {
+		pkg.ClearPatientHNPattern(hospital.ID)
+		return nil
+	}
+	return pkg.SetPatientHNPattern(hospital.ID, hospital.Settings.PatientHNPattern)
+}
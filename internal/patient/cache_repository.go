@@ -0,0 +1,157 @@
+package patient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+// Time a patient lookup or search stays cached. Short, as patients may also change outside of this API.
+const DefaultPatientCacheTTL = 30 * time.Second
+
+// Secondary adapter caching the lookups and searches of another patient repository, per hospital.
+// Patient writes through it drop every cached entry of the hospital. Errors, including unknown
+// patients, are not cached, and an unavailable cache falls back to the repository.
+type CachingPatientRepository struct {
+	Next  PatientRepositoryInterface
+	Cache pkg.Cache
+	TTL   time.Duration
+}
+
+func NewCachingPatientRepository(next PatientRepositoryInterface, cache pkg.Cache, ttl time.Duration) PatientRepositoryInterface {
+	return &CachingPatientRepository{
+		Next:  next,
+		Cache: cache,
+		TTL:   ttl,
+	}
+}
+
+func (r *CachingPatientRepository) SearchPatient(request *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error) {
+	// The hospital of the filter is not part of its JSON
+	criteria, err := json.Marshal(request)
+	if err != nil {
+		return r.Next.SearchPatient(request)
+	}
+	hash := sha256.Sum256(criteria)
+	key := hospitalCachePrefix(request.HospitalID) + "search:" + hex.EncodeToString(hash[:])
+
+	var result pkg.PatientSearchResult
+	if r.get(key, &result) {
+		// gob does not keep empty slices apart from nil ones
+		if result.Patients == nil {
+			result.Patients = []pkg.Patient{}
+		}
+		return &result, nil
+	}
+
+	found, err := r.Next.SearchPatient(request)
+	if err != nil {
+		return nil, err
+	}
+	r.set(key, found)
+	return found, nil
+}
+
+func (r *CachingPatientRepository) GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error) {
+	key := hospitalCachePrefix(hospitalID) + "identifier:" + identifier
+
+	var patient pkg.Patient
+	if r.get(key, &patient) {
+		return &patient, nil
+	}
+
+	found, err := r.Next.GetPatientByIdentifier(hospitalID, identifier)
+	if err != nil {
+		return nil, err
+	}
+	r.set(key, found)
+	return found, nil
+}
+
+func (r *CachingPatientRepository) GetPatientByID(hospitalID int, id int) (*pkg.Patient, error) {
+	key := hospitalCachePrefix(hospitalID) + "id:" + strconv.Itoa(id)
+
+	var patient pkg.Patient
+	if r.get(key, &patient) {
+		return &patient, nil
+	}
+
+	found, err := r.Next.GetPatientByID(hospitalID, id)
+	if err != nil {
+		return nil, err
+	}
+	r.set(key, found)
+	return found, nil
+}
+
+func (r *CachingPatientRepository) CreatePatient(patient *pkg.Patient) error {
+	// A new patient may now match cached searches
+	defer r.invalidate(patient.HospitalID)
+	return r.Next.CreatePatient(patient)
+}
+
+func (r *CachingPatientRepository) UpdatePatient(patient *pkg.Patient) error {
+	defer r.invalidate(patient.HospitalID)
+	return r.Next.UpdatePatient(patient)
+}
+
+func (r *CachingPatientRepository) DeletePatient(hospitalID int, id int) error {
+	defer r.invalidate(hospitalID)
+	return r.Next.DeletePatient(hospitalID, id)
+}
+
+// Keys of a hospital share a prefix so that its entries can be dropped together
+func hospitalCachePrefix(hospitalID int) string {
+	return fmt.Sprintf("patient:%d:", hospitalID)
+}
+
+// Keys may hold the identifier looked up, so only their hospital prefix is logged
+func loggedCacheKey(key string) string {
+	namespace, rest, _ := strings.Cut(key, ":")
+	hospitalID, _, _ := strings.Cut(rest, ":")
+	return namespace + ":" + hospitalID + ":"
+}
+
+// Values are encoded with gob, which unlike JSON keeps the fields hidden from API responses
+func (r *CachingPatientRepository) get(key string, value interface{}) bool {
+	cached, ok, err := r.Cache.Get(key)
+	if err != nil {
+		log.Printf("patient cache error: %v", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(cached)).Decode(value); err != nil {
+		log.Printf("patient cache error: %s: %v", loggedCacheKey(key), err)
+		return false
+	}
+	return true
+}
+
+func (r *CachingPatientRepository) set(key string, value interface{}) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		log.Printf("patient cache error: %s: %v", loggedCacheKey(key), err)
+		return
+	}
+	if err := r.Cache.Set(key, buffer.Bytes(), r.TTL); err != nil {
+		log.Printf("patient cache error: %v", err)
+	}
+}
+
+// Entries may be stale until their TTL when the cache cannot be reached
+func (r *CachingPatientRepository) invalidate(hospitalID int) {
+	if err := r.Cache.DeletePrefix(hospitalCachePrefix(hospitalID)); err != nil {
+		log.Printf("patient cache error: %v", err)
+	}
+}
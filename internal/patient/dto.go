@@ -0,0 +1,160 @@
+package patient
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+// API representation of a patient identifier
+type Identifier struct {
+	Type   string `json:"type" example:"passport"`
+	Value  string `json:"value" example:"AA1234567"`
+	Issuer string `json:"issuer" example:"GB"`
+}
+
+// Request body of patient create, replace and partial update.
+// The hospital is never read from the client, it is always the staff member's hospital.
+type PatientRequest struct {
+	FirstNameTh  string       `json:"first_name_th"`
+	MiddleNameTh string       `json:"middle_name_th"`
+	LastNameTh   string       `json:"last_name_th"`
+	FirstNameEn  string       `json:"first_name_en"`
+	MiddleNameEn string       `json:"middle_name_en"`
+	LastNameEn   string       `json:"last_name_en"`
+	DateOfBirth  pkg.Date     `json:"date_of_birth" swaggertype:"string" format:"date" example:"1990-05-01"`
+	PatientHN    string       `json:"patient_hn"`
+	NationalID   string       `json:"national_id"`
+	PassportID   string       `json:"passport_id"`
+	PhoneNumber  string       `json:"phone_number"`
+	Email        string       `json:"email"`
+	Gender       string       `json:"gender"`
+	Identifiers  []Identifier `json:"identifiers"`
+}
+
+// Patient as returned by the API, without the hospital association or soft delete marker
+type PatientResponse struct {
+	ID           int          `json:"id"`
+	FirstNameTh  string       `json:"first_name_th"`
+	MiddleNameTh string       `json:"middle_name_th"`
+	LastNameTh   string       `json:"last_name_th"`
+	FirstNameEn  string       `json:"first_name_en"`
+	MiddleNameEn string       `json:"middle_name_en"`
+	LastNameEn   string       `json:"last_name_en"`
+	DateOfBirth  pkg.Date     `json:"date_of_birth" swaggertype:"string" format:"date" example:"1990-05-01"`
+	PatientHN    string       `json:"patient_hn"`
+	NationalID   string       `json:"national_id"`
+	PassportID   string       `json:"passport_id"`
+	PhoneNumber  string       `json:"phone_number"`
+	Email        string       `json:"email"`
+	Gender       string       `json:"gender"`
+	HospitalID   int          `json:"hospital_id"`
+	Identifiers  []Identifier `json:"identifiers"`
+}
+
+// Request body of a patient with the current values of a stored patient, used as the base of partial updates
+func NewPatientRequest(patient *pkg.Patient) PatientRequest {
+	return PatientRequest{
+		FirstNameTh:  patient.FirstNameTh,
+		MiddleNameTh: patient.MiddleNameTh,
+		LastNameTh:   patient.LastNameTh,
+		FirstNameEn:  patient.FirstNameEn,
+		MiddleNameEn: patient.MiddleNameEn,
+		LastNameEn:   patient.LastNameEn,
+		DateOfBirth:  pkg.Date{Time: patient.DateOfBirth},
+		PatientHN:    patient.PatientHN,
+		NationalID:   patient.NationalID,
+		PassportID:   patient.PassportID,
+		PhoneNumber:  patient.PhoneNumber,
+		Email:        patient.Email,
+		Gender:       patient.Gender,
+		Identifiers:  newIdentifiers(patient.Identifiers),
+	}
+}
+
+// Patient model of the request, to be pinned to an ID and hospital by the caller
+func (r *PatientRequest) ToPatient() *pkg.Patient {
+	patient := &pkg.Patient{
+		FirstNameTh:  r.FirstNameTh,
+		MiddleNameTh: r.MiddleNameTh,
+		LastNameTh:   r.LastNameTh,
+		FirstNameEn:  r.FirstNameEn,
+		MiddleNameEn: r.MiddleNameEn,
+		LastNameEn:   r.LastNameEn,
+		DateOfBirth:  r.DateOfBirth.Time,
+		PatientHN:    r.PatientHN,
+		NationalID:   r.NationalID,
+		PassportID:   r.PassportID,
+		PhoneNumber:  r.PhoneNumber,
+		Email:        r.Email,
+		Gender:       r.Gender,
+	}
+	for _, identifier := range r.Identifiers {
+		patient.Identifiers = append(patient.Identifiers, pkg.PatientIdentifier{
+			Type:   identifier.Type,
+			Value:  identifier.Value,
+			Issuer: identifier.Issuer,
+		})
+	}
+	return patient
+}
+
+func NewPatientResponse(patient *pkg.Patient) PatientResponse {
+	return PatientResponse{
+		ID:           patient.ID,
+		FirstNameTh:  patient.FirstNameTh,
+		MiddleNameTh: patient.MiddleNameTh,
+		LastNameTh:   patient.LastNameTh,
+		FirstNameEn:  patient.FirstNameEn,
+		MiddleNameEn: patient.MiddleNameEn,
+		LastNameEn:   patient.LastNameEn,
+		DateOfBirth:  pkg.Date{Time: patient.DateOfBirth},
+		PatientHN:    patient.PatientHN,
+		NationalID:   patient.NationalID,
+		PassportID:   patient.PassportID,
+		PhoneNumber:  patient.PhoneNumber,
+		Email:        patient.Email,
+		Gender:       patient.Gender,
+		HospitalID:   patient.HospitalID,
+		Identifiers:  newIdentifiers(patient.Identifiers),
+	}
+}
+
+// Always an array in JSON, never null
+func newIdentifiers(identifiers []pkg.PatientIdentifier) []Identifier {
+	result := make([]Identifier, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		result = append(result, Identifier{
+			Type:   identifier.Type,
+			Value:  identifier.Value,
+			Issuer: identifier.Issuer,
+		})
+	}
+	return result
+}
+
+// Hide the phone number and email from staff who may not see full contact details
+func (r *PatientResponse) MaskContactDetails() {
+	r.PhoneNumber = maskPhoneNumber(r.PhoneNumber)
+	r.Email = maskEmail(r.Email)
+}
+
+// Keep only the last 4 characters, e.g. +66812345678 becomes ********5678
+func maskPhoneNumber(phone string) string {
+	if len(phone) <= 4 {
+		return strings.Repeat("*", len(phone))
+	}
+	return strings.Repeat("*", len(phone)-4) + phone[len(phone)-4:]
+}
+
+// Keep only the first character of the local part and the domain, e.g. m***@gmail.com
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return strings.Repeat("*", len(email))
+	}
+	// The first character may take several bytes, e.g. in a Thai address
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
+}
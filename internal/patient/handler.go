@@ -2,9 +2,14 @@ package patient
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
 	"github.com/gin-gonic/gin"
 )
@@ -13,41 +18,102 @@ import (
 type PatientHandler struct {
 	Service         PatientServiceInterface
 	GetHospitalIDFn func(c *gin.Context) (int, error)
+	GetRoleFn       func(c *gin.Context) pkg.Role
 }
 
 // Just define what struct will do
 type PatientHandlerInterface interface {
 	SearchPatient(c *gin.Context)
+	SearchPatientByBody(c *gin.Context)
+	GetPatientByIdentifier(c *gin.Context)
+	CreatePatient(c *gin.Context)
+	UpdatePatient(c *gin.Context)
+	PatchPatient(c *gin.Context)
+	DeletePatient(c *gin.Context)
 }
 
 func NewHttpPatientHandler(service PatientServiceInterface) *PatientHandler {
 	return &PatientHandler{
 		Service:         service,
-		GetHospitalIDFn: getHospitalID,
+		GetHospitalIDFn: middleware.GetHospitalID,
+		GetRoleFn:       middleware.GetRole,
 	}
 }
 
-/* This API should mocking searching system of Hospital Information Systems (HIS) of Hospital A API:
-Route: GET https://hospital-a.api.co.th/patient/search/{id} */
-
 // SearchPatient godoc
 // @Summary Search for a patient
-// @Description Search for a patient which belongs to the same hospital as the staff member in the system
+// @Description Search for a patient which belongs to the same hospital as the staff member in the system using query parameters
 // @Tags Patient
-// @Accept json
 // @Produce json
-// @Param request body pkg.Patient true "Patient search criteria"
+// @Param id query int false "Patient ID"
+// @Param first_name_th query string false "First name (Thai)"
+// @Param middle_name_th query string false "Middle name (Thai)"
+// @Param last_name_th query string false "Last name (Thai)"
+// @Param first_name_en query string false "First name (English)"
+// @Param middle_name_en query string false "Middle name (English)"
+// @Param last_name_en query string false "Last name (English)"
+// @Param date_of_birth query string false "Date of birth (YYYY-MM-DD)"
+// @Param dob_from query string false "Earliest date of birth, inclusive (YYYY-MM-DD)"
+// @Param dob_to query string false "Latest date of birth, inclusive (YYYY-MM-DD)"
+// @Param min_age query int false "Minimum age in years as of today (Asia/Bangkok)"
+// @Param max_age query int false "Maximum age in years as of today (Asia/Bangkok)"
+// @Param patient_hn query string false "Patient hospital number"
+// @Param national_id query string false "National ID"
+// @Param passport_id query string false "Passport ID"
+// @Param identifier query string false "Any identifier of the patient (HN, national ID, passport or other)"
+// @Param phone_number query string false "Phone number"
+// @Param email query string false "Email"
+// @Param gender query string false "Gender"
+// @Param match[first_name_en] query string false "Match mode of a name, email or phone field, e.g. match[first_name_en]=prefix (exact, insensitive, prefix, contains)"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor of the next page returned by the previous page, with the same sort_by and sort_order"
+// @Param sort_by query string false "Sort key (patient_hn, last_name_en, last_name_th, date_of_birth)"
+// @Param sort_order query string false "Sort order (asc, desc)"
+// @Param include_total query bool false "Include the total number of matching patients"
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Failure 504 {object} map[string]string
 // @Router /patient/search [get]
 func (h *PatientHandler) SearchPatient(c *gin.Context) {
-	var patientSearchRequest pkg.Patient
+	patientSearchRequest, err := parsePatientSearchQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.searchPatient(c, patientSearchRequest)
+}
+
+// SearchPatientByBody godoc
+// @Summary Search for a patient with a JSON body
+// @Description Search for a patient which belongs to the same hospital as the staff member in the system using a JSON body for complex criteria
+// @Tags Patient
+// @Accept json
+// @Produce json
+// @Param request body pkg.PatientSearchRequest true "Patient search criteria"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Failure 504 {object} map[string]string
+// @Router /patient/search [post]
+func (h *PatientHandler) SearchPatientByBody(c *gin.Context) {
+	var patientSearchRequest pkg.PatientSearchRequest
 	if err := c.ShouldBindJSON(&patientSearchRequest); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.searchPatient(c, &patientSearchRequest)
+}
+
+func (h *PatientHandler) searchPatient(c *gin.Context, patientSearchRequest *pkg.PatientSearchRequest) {
 	// Retrieve hospital_id
 	hospitalIDInt, err := h.GetHospitalIDFn(c)
 	if err != nil {
@@ -58,47 +124,444 @@ func (h *PatientHandler) SearchPatient(c *gin.Context) {
 	// Set search criteria to be the same hospital as current staff
 	patientSearchRequest.HospitalID = hospitalIDInt
 
+	// Validate the search criteria, HN formats depend on the hospital
+	patientSearchRequest.Normalize()
+	if err := patientSearchRequest.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Call service
-	patientList, err := h.Service.SearchPatient(&patientSearchRequest)
+	result, err := h.Service.SearchPatient(patientSearchRequest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondPatientError(c, err)
 		return
 	}
 
+	response := gin.H{
+		"message":     "Search successfully.",
+		"data":        h.newPatientResponses(c, result.Patients),
+		"next_cursor": result.NextCursor,
+	}
+	if result.Total != nil {
+		response["total"] = *result.Total
+	}
+
 	// Success searching but not found
-	if len(patientList) == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "No patient found.",
-			"data":    patientList,
-		})
+	if len(result.Patients) == 0 {
+		response["message"] = "No patient found."
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+/* This API should mocking searching system of Hospital Information Systems (HIS) of Hospital A API:
+Route: GET https://hospital-a.api.co.th/patient/search/{id} */
+
+// GetPatientByIdentifier godoc
+// @Summary Get a patient by national ID or passport ID
+// @Description Look up a single patient of the staff member's hospital where {id} is either a national ID or a passport ID
+// @Tags Patient
+// @Produce json
+// @Param id path string true "National ID or passport ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Failure 504 {object} map[string]string
+// @Router /patient/search/{id} [get]
+func (h *PatientHandler) GetPatientByIdentifier(c *gin.Context) {
+	// Either a Thai national ID or a passport number
+	identifier := strings.TrimSpace(c.Param("id"))
+	if !pkg.IsValidThaiNationalID(identifier) {
+		identifier = pkg.NormalizePassportID(identifier)
+		if !pkg.IsValidPassportID(identifier) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "id must be a valid national ID or passport ID"})
+			return
+		}
+	}
+
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	patient, err := h.Service.GetPatientByIdentifier(hospitalIDInt, identifier)
+	if err != nil {
+		respondPatientError(c, err)
 		return
 	}
 
-	// Patience found
+	// Patient found
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Search successfully.",
-		"data":    patientList,
+		"data":    h.newPatientResponse(c, patient),
 	})
 }
 
-func getHospitalID(c *gin.Context) (int, error) {
-	// Retrieve hospital_id from gin.Context
-	hospitalID, exists := c.Get("hospital_id")
-	if !exists {
-		return -1, errors.New("hospital ID not found")
+// CreatePatient godoc
+// @Summary Create a patient
+// @Description Register a new patient in the staff member's hospital. The hospital is always taken from the login token.
+// @Tags Patient
+// @Accept json
+// @Produce json
+// @Param patient body PatientRequest true "Patient details"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Router /patient [post]
+func (h *PatientHandler) CreatePatient(c *gin.Context) {
+	var request PatientRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Type assert to int if necessary
-	hospitalIDStr, ok := hospitalID.(string)
-	if !ok {
-		return -1, errors.New("assertion failed for string")
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	hospitalIDInt, err := strconv.Atoi(hospitalIDStr)
+	// Patients are always created in the same hospital as current staff
+	newPatient := request.ToPatient()
+	newPatient.HospitalID = hospitalIDInt
+
+	// Validate the input body
+	pkg.NormalizePatient(newPatient)
+	if err := pkg.Validate.Struct(newPatient); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	createdPatient, err := h.Service.CreatePatient(newPatient)
 	if err != nil {
-		return -1, errors.New("error converting string to int")
+		respondPatientError(c, err)
+		return
+	}
+
+	// Success creation
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Created successfully",
+		"data":    h.newPatientResponse(c, createdPatient),
+	})
+}
+
+// UpdatePatient godoc
+// @Summary Replace a patient
+// @Description Replace every field of a patient of the staff member's hospital
+// @Tags Patient
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Param patient body PatientRequest true "Patient details"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Router /patient/{id} [put]
+func (h *PatientHandler) UpdatePatient(c *gin.Context) {
+	patientID, err := parsePatientID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request PatientRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.savePatient(c, request.ToPatient(), patientID, hospitalIDInt)
+}
+
+// PatchPatient godoc
+// @Summary Partially update a patient
+// @Description Update only the given fields of a patient of the staff member's hospital
+// @Tags Patient
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Param patient body PatientRequest true "Fields to update"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Router /patient/{id} [patch]
+func (h *PatientHandler) PatchPatient(c *gin.Context) {
+	patientID, err := parsePatientID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Load the current patient, then overwrite only the fields present in the body
+	currentPatient, err := h.Service.GetPatientByID(hospitalIDInt, patientID)
+	if err != nil {
+		respondPatientError(c, err)
+		return
+	}
+	request := NewPatientRequest(currentPatient)
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A changed primary field replaces its former identifier
+	patient := request.ToPatient()
+	pkg.ReplacePrimaryIdentifiers(patient, currentPatient)
+
+	h.savePatient(c, patient, patientID, hospitalIDInt)
+}
+
+// Validate and persist a full patient, pinned to the path ID and the staff member's hospital
+func (h *PatientHandler) savePatient(c *gin.Context, patient *pkg.Patient, patientID int, hospitalID int) {
+	patient.ID = patientID
+	patient.HospitalID = hospitalID
+
+	// Validate the input body
+	pkg.NormalizePatient(patient)
+	if err := pkg.Validate.Struct(patient); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	updatedPatient, err := h.Service.UpdatePatient(patient)
+	if err != nil {
+		respondPatientError(c, err)
+		return
+	}
+
+	// Success update
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Updated successfully",
+		"data":    h.newPatientResponse(c, updatedPatient),
+	})
+}
+
+// DeletePatient godoc
+// @Summary Delete a patient
+// @Description Soft delete a patient of the staff member's hospital so it no longer appears in searches
+// @Tags Patient
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Router /patient/{id} [delete]
+func (h *PatientHandler) DeletePatient(c *gin.Context) {
+	patientID, err := parsePatientID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	if err := h.Service.DeletePatient(hospitalIDInt, patientID); err != nil {
+		respondPatientError(c, err)
+		return
+	}
+
+	// Success deletion
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted successfully"})
+}
+
+// Patient as returned to the staff member, contact details masked unless the role may see them
+func (h *PatientHandler) newPatientResponse(c *gin.Context, patient *pkg.Patient) PatientResponse {
+	response := NewPatientResponse(patient)
+	if !h.GetRoleFn(c).Can(pkg.PermissionViewPatientContact) {
+		response.MaskContactDetails()
+	}
+	return response
+}
+
+func (h *PatientHandler) newPatientResponses(c *gin.Context, patients []pkg.Patient) []PatientResponse {
+	responses := make([]PatientResponse, 0, len(patients))
+	for i := range patients {
+		responses = append(responses, h.newPatientResponse(c, &patients[i]))
+	}
+	return responses
+}
+
+// Map service errors to HTTP status codes
+func respondPatientError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrPatientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrDuplicatePatientHN),
+		errors.Is(err, ErrDuplicateNationalID),
+		errors.Is(err, ErrDuplicatePassportID),
+		errors.Is(err, ErrDuplicatePatientEmail),
+		errors.Is(err, ErrDuplicateIdentifier):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotSupportedByHIS):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrHISCircuitOpen):
+		c.Header("Retry-After", strconv.Itoa(int(HISBreakerOpenTimeout.Seconds())))
+		respondHISError(c, http.StatusServiceUnavailable, ErrHISCircuitOpen, err)
+	case errors.Is(err, ErrHISTimeout):
+		respondHISError(c, http.StatusGatewayTimeout, ErrHISTimeout, err)
+	case errors.Is(err, ErrHISUnavailable):
+		respondHISError(c, http.StatusBadGateway, ErrHISUnavailable, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// Tell staff which hospital's HIS is degraded. The cause is logged, not sent to the client.
+func respondHISError(c *gin.Context, status int, kind error, err error) {
+	log.Printf("HIS error: %v", err)
+
+	body := gin.H{"error": kind.Error()}
+	var hisErr *HISError
+	if errors.As(err, &hisErr) {
+		body["hospital_id"] = hisErr.HospitalID
+	}
+	c.JSON(status, body)
+}
+
+func parsePatientID(c *gin.Context) (int, error) {
+	patientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || patientID <= 0 {
+		return -1, errors.New("patient ID must be a positive integer")
+	}
+	return patientID, nil
+}
+
+// Build patient search criteria from the query string, rejecting malformed typed parameters
+func parsePatientSearchQuery(c *gin.Context) (*pkg.PatientSearchRequest, error) {
+	var request pkg.PatientSearchRequest
+
+	if value := c.Query("id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query parameter %q: must be an integer", "id")
+		}
+		request.ID = id
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query parameter %q: must be an integer", "limit")
+		}
+		request.Limit = limit
+	}
+
+	if value := c.Query("include_total"); value != "" {
+		includeTotal, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query parameter %q: must be true or false", "include_total")
+		}
+		request.IncludeTotal = includeTotal
+	}
+
+	// Checked in a fixed order, so that the error names the same parameter when several are invalid
+	dateParams := []struct {
+		param string
+		field *time.Time
+	}{
+		{"date_of_birth", &request.DateOfBirth},
+		{"dob_from", &request.DateOfBirthFrom},
+		{"dob_to", &request.DateOfBirthTo},
+	}
+	for _, p := range dateParams {
+		if value := c.Query(p.param); value != "" {
+			date, err := pkg.ParseDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid query parameter %q: must be a date in YYYY-MM-DD format", p.param)
+			}
+			*p.field = date
+		}
+	}
+
+	ageParams := []struct {
+		param string
+		field **int
+	}{
+		{"min_age", &request.MinAge},
+		{"max_age", &request.MaxAge},
+	}
+	for _, p := range ageParams {
+		if value := c.Query(p.param); value != "" {
+			age, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid query parameter %q: must be an integer", p.param)
+			}
+			*p.field = &age
+		}
+	}
+
+	stringParams := map[string]*string{
+		"first_name_th":  &request.FirstNameTh,
+		"middle_name_th": &request.MiddleNameTh,
+		"last_name_th":   &request.LastNameTh,
+		"first_name_en":  &request.FirstNameEn,
+		"middle_name_en": &request.MiddleNameEn,
+		"last_name_en":   &request.LastNameEn,
+		"patient_hn":     &request.PatientHN,
+		"identifier":     &request.Identifier,
+		"national_id":    &request.NationalID,
+		"passport_id":    &request.PassportID,
+		"phone_number":   &request.PhoneNumber,
+		"email":          &request.Email,
+		"gender":         &request.Gender,
+		"cursor":         &request.Cursor,
+		"sort_order":     &request.SortOrder,
+	}
+	for param, field := range stringParams {
+		*field = strings.TrimSpace(c.Query(param))
+	}
+
+	request.SortOrder = strings.ToLower(request.SortOrder)
+	request.SortBy = pkg.SortKey(strings.TrimSpace(c.Query("sort_by")))
+
+	// Match modes are given as match[<field>]=<mode>
+	if matchParams := c.QueryMap("match"); len(matchParams) > 0 {
+		request.MatchModes = make(map[string]pkg.MatchMode, len(matchParams))
+		for field, mode := range matchParams {
+			request.MatchModes[field] = pkg.MatchMode(strings.ToLower(mode))
+		}
 	}
 
-	// Got hospital ID
-	return hospitalIDInt, nil
+	return &request, nil
 }
@@ -0,0 +1,231 @@
+package patient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+// Attempts of a HIS lookup failing with a transient error, and the backoff before the first retry
+const (
+	DefaultHISMaxAttempts    = 3
+	DefaultHISRetryBaseDelay = 100 * time.Millisecond
+)
+
+// The circuit breaker of a HIS opens after this many failed lookups in a row
+// and lets a probe lookup through after the open timeout
+const (
+	HISBreakerFailureThreshold = 5
+	HISBreakerOpenTimeout      = 30 * time.Second
+)
+
+// Requests are bounded by the timeout of each hospital, not by the client
+var hisClient = &http.Client{}
+
+// Secondary adapter reading the patients of one hospital from its HIS.
+// A HIS can only look a patient up by national ID or passport ID, and its patients are read-only here.
+type HISPatientRepository struct {
+	HospitalID     int
+	BaseURL        string
+	Timeout        time.Duration // of a lookup, retries included
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	Breaker        *pkg.CircuitBreaker // shared by every lookup in the HIS, nil disables it
+	Client         *http.Client
+}
+
+func NewHISPatientRepository(hospital *pkg.Hospital, breaker *pkg.CircuitBreaker) PatientRepositoryInterface {
+	return &HISPatientRepository{
+		HospitalID:     hospital.ID,
+		BaseURL:        strings.TrimRight(hospital.Settings.HISBaseURL, "/"),
+		Timeout:        hospital.Settings.HISTimeout(),
+		MaxAttempts:    DefaultHISMaxAttempts,
+		RetryBaseDelay: DefaultHISRetryBaseDelay,
+		Breaker:        breaker,
+		Client:         hisClient,
+	}
+}
+
+func (r *HISPatientRepository) SearchPatient(request *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error) {
+	identifier, err := hisLookupIdentifier(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// A lookup has at most one patient, so there is never a second page
+	result := &pkg.PatientSearchResult{Patients: []pkg.Patient{}}
+	if request.Cursor == "" {
+		patient, err := r.GetPatientByIdentifier(request.HospitalID, identifier)
+		if err != nil && !errors.Is(err, ErrPatientNotFound) {
+			return nil, err
+		}
+		if patient != nil {
+			result.Patients = append(result.Patients, *patient)
+		}
+	}
+
+	if request.IncludeTotal {
+		total := int64(len(result.Patients))
+		result.Total = &total
+	}
+	return result, nil
+}
+
+// Look the patient up, retrying transient failures while the timeout allows.
+// A HIS failing too often is not called at all until its circuit breaker lets a probe through.
+func (r *HISPatientRepository) GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error) {
+	if r.Breaker != nil {
+		if err := r.Breaker.Allow(); err != nil {
+			return nil, r.hisError(ErrHISCircuitOpen, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+
+	hisPatient, err := r.lookupWithRetries(ctx, identifier)
+	if r.Breaker != nil {
+		// A patient unknown to the HIS is a healthy answer
+		if err == nil || errors.Is(err, ErrPatientNotFound) {
+			r.Breaker.Success()
+		} else {
+			r.Breaker.Failure()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	patient, err := hisPatient.ToPatient(r.HospitalID)
+	if err != nil {
+		return nil, r.hisError(ErrHISUnavailable, err)
+	}
+	return patient, nil
+}
+
+func (r *HISPatientRepository) lookupWithRetries(ctx context.Context, identifier string) (*pkg.HISPatient, error) {
+	for attempt := 1; ; attempt++ {
+		hisPatient, retryable, err := r.lookup(ctx, identifier)
+		if err == nil || !retryable || attempt >= r.MaxAttempts {
+			return hisPatient, err
+		}
+
+		// Exponential backoff with full jitter, so that the retries of concurrent lookups spread out
+		backoff := r.RetryBaseDelay << (attempt - 1)
+		if backoff > 0 {
+			backoff = rand.N(backoff)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, r.hisError(ErrHISTimeout, err)
+		}
+	}
+}
+
+// One request to the HIS. Network errors and 5xx responses may succeed when retried.
+func (r *HISPatientRepository) lookup(ctx context.Context, identifier string) (*pkg.HISPatient, bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.BaseURL+"/patient/search/"+url.PathEscape(identifier), nil)
+	if err != nil {
+		return nil, false, r.hisError(ErrHISUnavailable, err)
+	}
+
+	response, err := r.Client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, false, r.hisError(ErrHISTimeout, err)
+		}
+		return nil, true, r.hisError(ErrHISUnavailable, err)
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return nil, false, ErrPatientNotFound
+	case response.StatusCode == http.StatusTooManyRequests,
+		response.StatusCode >= 500 && response.StatusCode != http.StatusNotImplemented:
+		return nil, true, r.hisError(ErrHISUnavailable, fmt.Errorf("status %d", response.StatusCode))
+	case response.StatusCode != http.StatusOK:
+		return nil, false, r.hisError(ErrHISUnavailable, fmt.Errorf("status %d", response.StatusCode))
+	}
+
+	var hisPatient pkg.HISPatient
+	if err := json.NewDecoder(response.Body).Decode(&hisPatient); err != nil {
+		if ctx.Err() != nil {
+			return nil, false, r.hisError(ErrHISTimeout, err)
+		}
+		return nil, false, r.hisError(ErrHISUnavailable, fmt.Errorf("invalid payload: %w", err))
+	}
+	return &hisPatient, false, nil
+}
+
+// The URL of a failed request holds the identifier looked up, which must not reach the logs
+func (r *HISPatientRepository) hisError(kind error, cause error) error {
+	var urlErr *url.Error
+	if errors.As(cause, &urlErr) {
+		cause = urlErr.Err
+	}
+	return &HISError{HospitalID: r.HospitalID, Err: kind, Cause: cause}
+}
+
+// Patients of a HIS have no ID in this service
+func (r *HISPatientRepository) GetPatientByID(hospitalID int, id int) (*pkg.Patient, error) {
+	return nil, ErrNotSupportedByHIS
+}
+
+func (r *HISPatientRepository) CreatePatient(patient *pkg.Patient) error {
+	return ErrNotSupportedByHIS
+}
+
+func (r *HISPatientRepository) UpdatePatient(patient *pkg.Patient) error {
+	return ErrNotSupportedByHIS
+}
+
+func (r *HISPatientRepository) DeletePatient(hospitalID int, id int) error {
+	return ErrNotSupportedByHIS
+}
+
+// The only identifier of a search a HIS can serve: exactly one of national_id, passport_id
+// or identifier, without any other filter. A HIS cannot look up an HN, so an identifier
+// is only sent to it when it is a Thai national ID, or a passport ID that cannot be an HN
+// of the hospital.
+func hisLookupIdentifier(request *pkg.PatientSearchRequest) (string, error) {
+	lookup := pkg.PatientFilter{
+		NationalID: request.NationalID,
+		PassportID: request.PassportID,
+		HospitalID: request.HospitalID,
+	}
+
+	var identifiers []string
+	for _, identifier := range []string{request.NationalID, request.PassportID, request.Identifier} {
+		if identifier != "" {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+
+	if len(identifiers) != 1 ||
+		request.PatientFilter != lookup ||
+		!request.DateOfBirthFrom.IsZero() ||
+		!request.DateOfBirthTo.IsZero() {
+		return "", ErrNotSupportedByHIS
+	}
+	if request.Identifier != "" && !isHISIdentifier(request.HospitalID, request.Identifier) {
+		return "", ErrNotSupportedByHIS
+	}
+	return identifiers[0], nil
+}
+
+func isHISIdentifier(hospitalID int, identifier string) bool {
+	if pkg.IsValidThaiNationalID(identifier) {
+		return true
+	}
+	return pkg.IsValidPassportID(identifier) && !pkg.IsValidPatientHN(hospitalID, identifier)
+}
@@ -1,13 +1,23 @@
 package patient
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Secondary port
 type PatientRepositoryInterface interface {
-	SearchPatient(request *pkg.Patient) ([]pkg.Patient, error)
+	SearchPatient(request *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error)
+	GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error)
+	GetPatientByID(hospitalID int, id int) (*pkg.Patient, error)
+	CreatePatient(patient *pkg.Patient) error
+	UpdatePatient(patient *pkg.Patient) error
+	DeletePatient(hospitalID int, id int) error
 }
 
 // Secondary adapter
@@ -20,59 +30,306 @@ func NewGormPatientRepository(db *gorm.DB) PatientRepositoryInterface {
 	return &GormPatientRepository{db: db}
 }
 
-func (r *GormPatientRepository) SearchPatient(request *pkg.Patient) ([]pkg.Patient, error) {
-	var patientList []pkg.Patient
+func (r *GormPatientRepository) SearchPatient(request *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error) {
+	result := &pkg.PatientSearchResult{}
 
-	query := r.db.Table("patients").Where("hospital_id = ?", request.HospitalID)
+	// Count every match across all pages only when requested
+	if request.IncludeTotal {
+		var total int64
+		if err := r.filterPatients(request).Count(&total).Error; err != nil {
+			return nil, err
+		}
+		result.Total = &total
+	}
 
-	// Add optional conditions only if fields are populated
-	if request.ID != 0 {
-		query = query.Where("id = ?", request.ID)
+	sortBy, sortOrder := request.Ordering()
+	sortColumn := sortColumnOf(sortBy)
+
+	comparator, direction := ">", "ASC"
+	if sortOrder == pkg.SortDesc {
+		comparator, direction = "<", "DESC"
 	}
-	if request.FirstNameTh != "" {
-		query = query.Where("first_name_th = ?", request.FirstNameTh)
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = pkg.DefaultPageSize
 	}
-	if request.MiddleNameTh != "" {
-		query = query.Where("middle_name_th = ?", request.MiddleNameTh)
+
+	query := r.filterPatients(request)
+
+	// Keyset pagination: continue strictly after the last row of the previous page
+	if request.Cursor != "" {
+		cursor, err := pkg.DecodePatientCursor(request.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("("+sortColumn+", id) "+comparator+" (?, ?)", cursor.SortValue, cursor.ID)
 	}
-	if request.LastNameTh != "" {
-		query = query.Where("last_name_th = ?", request.LastNameTh)
+
+	// Fetch one extra row to know whether there is a next page
+	var patientList []pkg.Patient
+	if err := query.Order(sortColumn + " " + direction + ", id " + direction).Limit(limit + 1).Find(&patientList).Error; err != nil {
+		return nil, err
 	}
-	if request.FirstNameEn != "" {
-		query = query.Where("first_name_en = ?", request.FirstNameEn)
+
+	if len(patientList) > limit {
+		patientList = patientList[:limit]
+		result.NextCursor = pkg.EncodePatientCursor(&patientList[limit-1], sortBy, sortOrder)
 	}
-	if request.MiddleNameEn != "" {
-		query = query.Where("middle_name_en = ?", request.MiddleNameEn)
+	result.Patients = patientList
+
+	return result, nil
+}
+
+// Build the query of patients matching the search criteria, without ordering or paging
+func (r *GormPatientRepository) filterPatients(request *pkg.PatientSearchRequest) *gorm.DB {
+	query := r.db.Model(&pkg.Patient{}).Where("hospital_id = ?", request.HospitalID)
+
+	// Add optional conditions only if fields are populated
+	if request.ID != 0 {
+		query = query.Where("id = ?", request.ID)
+	}
+
+	// Name, email and phone columns honour the requested match mode
+	textFilters := []struct {
+		column string
+		value  string
+	}{
+		{"first_name_th", request.FirstNameTh},
+		{"middle_name_th", request.MiddleNameTh},
+		{"last_name_th", request.LastNameTh},
+		{"first_name_en", request.FirstNameEn},
+		{"middle_name_en", request.MiddleNameEn},
+		{"last_name_en", request.LastNameEn},
+		{"email", request.Email},
+		{"phone_number", request.PhoneNumber},
 	}
-	if request.LastNameEn != "" {
-		query = query.Where("last_name_en = ?", request.LastNameEn)
+	for _, filter := range textFilters {
+		if filter.value != "" {
+			query = whereMatch(query, filter.column, filter.value, request.MatchModeOf(filter.column))
+		}
 	}
+
+	// Dates of birth are compared as calendar days in the hospital timezone, never as timestamps
 	if !request.DateOfBirth.IsZero() {
-		query = query.Where("date_of_birth = ?", request.DateOfBirth)
+		query = query.Where("date_of_birth = ?", pkg.FormatDate(request.DateOfBirth))
 	}
-	if request.PatientHN != "" {
-		query = query.Where("patient_hn = ?", request.PatientHN)
+	if !request.DateOfBirthFrom.IsZero() {
+		query = query.Where("date_of_birth >= ?", pkg.FormatDate(request.DateOfBirthFrom))
 	}
-	if request.NationalID != "" {
-		query = query.Where("national_id = ?", request.NationalID)
+	if !request.DateOfBirthTo.IsZero() {
+		query = query.Where("date_of_birth <= ?", pkg.FormatDate(request.DateOfBirthTo))
 	}
-	if request.PassportID != "" {
-		query = query.Where("passport_id = ?", request.PassportID)
+
+	// Identifiers are matched against every identifier of the patient, not only the primary ones
+	identifierFilters := []struct {
+		identifierType string
+		value          string
+	}{
+		{pkg.IdentifierHN, request.PatientHN},
+		{pkg.IdentifierNationalID, request.NationalID},
+		{pkg.IdentifierPassport, request.PassportID},
 	}
-	if request.PhoneNumber != "" {
-		query = query.Where("phone_number = ?", request.PhoneNumber)
+	for _, filter := range identifierFilters {
+		if filter.value != "" {
+			query = query.Where(hasIdentifier+" AND patient_identifiers.type = ? AND patient_identifiers.value = ?)", filter.identifierType, filter.value)
+		}
 	}
-	if request.Email != "" {
-		query = query.Where("email = ?", request.Email)
+	if request.Identifier != "" {
+		query = query.Where(hasIdentifier+" AND patient_identifiers.value = ?)", request.Identifier)
 	}
+
 	if request.Gender != "" {
 		query = query.Where("gender = ?", request.Gender)
 	}
 
-	// Execute the query
-	if err := query.Find(&patientList).Error; err != nil {
+	return query
+}
+
+// Nullable name columns are sorted as empty strings so that keyset comparisons never meet NULL
+func sortColumnOf(sortBy pkg.SortKey) string {
+	switch sortBy {
+	case pkg.SortByLastNameEn, pkg.SortByLastNameTh:
+		return "COALESCE(" + string(sortBy) + ", '')"
+	default:
+		return string(sortBy)
+	}
+}
+
+// Opening of the condition that the patient has an identifier, completed by the caller
+const hasIdentifier = "EXISTS (SELECT 1 FROM patient_identifiers WHERE patient_identifiers.patient_id = patients.id"
+
+// Look up a single patient of the hospital by any of its national IDs or passport IDs
+func (r *GormPatientRepository) GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error) {
+	var patient pkg.Patient
+	if err := r.db.Preload("Identifiers").
+		Where("hospital_id = ?", hospitalID).
+		Where(hasIdentifier+" AND patient_identifiers.type IN ? AND patient_identifiers.value = ?)",
+			[]string{pkg.IdentifierNationalID, pkg.IdentifierPassport}, identifier).
+		First(&patient).Error; err != nil {
 		return nil, err
 	}
 
-	return patientList, nil
+	return &patient, nil
 }
+
+func (r *GormPatientRepository) GetPatientByID(hospitalID int, id int) (*pkg.Patient, error) {
+	var patient pkg.Patient
+	if err := r.db.Preload("Identifiers").Where("hospital_id = ?", hospitalID).First(&patient, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &patient, nil
+}
+
+func (r *GormPatientRepository) CreatePatient(patient *pkg.Patient) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkIdentifierConflicts(tx, patient); err != nil {
+			return err
+		}
+
+		// Create to patient database without touching the associated hospital
+		if err := tx.Omit(clause.Associations).Create(patient).Error; err != nil {
+			return err
+		}
+
+		return saveIdentifiers(tx, patient)
+	})
+
+	return translatePatientError(err)
+}
+
+// Replace every column and identifier of an existing, not deleted patient of the same hospital
+func (r *GormPatientRepository) UpdatePatient(patient *pkg.Patient) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkIdentifierConflicts(tx, patient); err != nil {
+			return err
+		}
+
+		result := tx.Model(&pkg.Patient{}).
+			Where("id = ? AND hospital_id = ?", patient.ID, patient.HospitalID).
+			Select("*").
+			Omit("id", "hospital_id", "deleted_at", clause.Associations).
+			Updates(patient)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("patient_id = ?", patient.ID).Delete(&pkg.PatientIdentifier{}).Error; err != nil {
+			return err
+		}
+		return saveIdentifiers(tx, patient)
+	})
+
+	return translatePatientError(err)
+}
+
+// Soft delete: the row is kept with deleted_at set and hidden from every query.
+// Its identifiers are released so that they can be registered again.
+func (r *GormPatientRepository) DeletePatient(hospitalID int, id int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("hospital_id = ?", hospitalID).Delete(&pkg.Patient{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where("patient_id = ?", id).Delete(&pkg.PatientIdentifier{}).Error
+	})
+}
+
+// Report which identifier of the patient is already used by another patient of the hospital
+func checkIdentifierConflicts(tx *gorm.DB, patient *pkg.Patient) error {
+	if len(patient.Identifiers) == 0 {
+		return nil
+	}
+
+	keys := make([][]interface{}, 0, len(patient.Identifiers))
+	for _, identifier := range patient.Identifiers {
+		keys = append(keys, []interface{}{identifier.Type, identifier.Issuer, identifier.Value})
+	}
+
+	var conflict pkg.PatientIdentifier
+	err := tx.Where("hospital_id = ? AND patient_id <> ?", patient.HospitalID, patient.ID).
+		Where("(type, issuer, value) IN ?", keys).
+		Take(&conflict).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch conflict.Type {
+	case pkg.IdentifierHN:
+		return ErrDuplicatePatientHN
+	case pkg.IdentifierNationalID:
+		return ErrDuplicateNationalID
+	case pkg.IdentifierPassport:
+		return ErrDuplicatePassportID
+	default:
+		return ErrDuplicateIdentifier
+	}
+}
+
+func saveIdentifiers(tx *gorm.DB, patient *pkg.Patient) error {
+	if len(patient.Identifiers) == 0 {
+		return nil
+	}
+
+	for i := range patient.Identifiers {
+		patient.Identifiers[i].ID = 0
+		patient.Identifiers[i].PatientID = patient.ID
+		patient.Identifiers[i].HospitalID = patient.HospitalID
+	}
+	return tx.Create(&patient.Identifiers).Error
+}
+
+// Map unique constraint violations of the patients and patient_identifiers tables to domain errors.
+// Identifier conflicts are normally reported by checkIdentifierConflicts, the constraint only
+// catches concurrent writes.
+func translatePatientError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation {
+		return err
+	}
+
+	switch pgErr.ConstraintName {
+	case "uq_patient_identifiers":
+		return ErrDuplicateIdentifier
+	case "patients_email_key":
+		return ErrDuplicatePatientEmail
+	default:
+		return err
+	}
+}
+
+// Postgres SQLSTATE of unique_violation
+const pgUniqueViolation = "23505"
+
+// Add a condition on column according to the match mode.
+// Prefix and contains are case-insensitive and served by the pg_trgm indexes.
+func whereMatch(query *gorm.DB, column string, value string, mode pkg.MatchMode) *gorm.DB {
+	switch mode {
+	case pkg.MatchCaseInsensitive:
+		return query.Where("LOWER("+column+") = LOWER(?)", value)
+	case pkg.MatchPrefix:
+		return query.Where(column+" ILIKE ?", escapeLike(value)+"%")
+	case pkg.MatchContains:
+		return query.Where(column+" ILIKE ?", "%"+escapeLike(value)+"%")
+	default:
+		return query.Where(column+" = ?", value)
+	}
+}
+
+// Escape LIKE wildcards so user input is matched literally
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
@@ -0,0 +1,159 @@
+package patient
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"gorm.io/gorm"
+)
+
+// Source of the settings of a hospital, e.g. the hospital repository
+type HospitalLookup interface {
+	GetHospitalByID(id int) (*pkg.Hospital, error)
+}
+
+// Settings of a hospital are read again after this long, so that instances which did
+// not make a change pick it up
+const HospitalRoutingCacheTTL = time.Minute
+
+// Secondary adapter choosing where the patients of the hospital live:
+// its HIS when the hospital has a HIS base URL, the local database otherwise
+type HospitalRoutingPatientRepository struct {
+	Local        PatientRepositoryInterface
+	Hospitals    HospitalLookup
+	NewHISRepoFn func(hospital *pkg.Hospital, breaker *pkg.CircuitBreaker) PatientRepositoryInterface
+	CacheTTL     time.Duration
+
+	// Circuit breaker of the HIS of each hospital, kept across requests
+	breakersMu sync.Mutex
+	breakers   map[int]*pkg.CircuitBreaker
+
+	// Hospital of each routing, nil for an unknown hospital
+	routesMu sync.RWMutex
+	routes   map[int]hospitalRoute
+}
+
+type hospitalRoute struct {
+	hospital *pkg.Hospital
+	expires  time.Time
+}
+
+func NewHospitalRoutingPatientRepository(local PatientRepositoryInterface, hospitals HospitalLookup) *HospitalRoutingPatientRepository {
+	return &HospitalRoutingPatientRepository{
+		Local:        local,
+		Hospitals:    hospitals,
+		NewHISRepoFn: NewHISPatientRepository,
+		CacheTTL:     HospitalRoutingCacheTTL,
+	}
+}
+
+// Drop the cached routing of a hospital whose settings changed
+func (r *HospitalRoutingPatientRepository) HospitalSettingsChanged(hospital *pkg.Hospital) {
+	r.routesMu.Lock()
+	defer r.routesMu.Unlock()
+	delete(r.routes, hospital.ID)
+}
+
+func (r *HospitalRoutingPatientRepository) SearchPatient(request *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error) {
+	repo, err := r.repositoryOf(request.HospitalID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.SearchPatient(request)
+}
+
+func (r *HospitalRoutingPatientRepository) GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error) {
+	repo, err := r.repositoryOf(hospitalID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetPatientByIdentifier(hospitalID, identifier)
+}
+
+func (r *HospitalRoutingPatientRepository) GetPatientByID(hospitalID int, id int) (*pkg.Patient, error) {
+	repo, err := r.repositoryOf(hospitalID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetPatientByID(hospitalID, id)
+}
+
+func (r *HospitalRoutingPatientRepository) CreatePatient(patient *pkg.Patient) error {
+	repo, err := r.repositoryOf(patient.HospitalID)
+	if err != nil {
+		return err
+	}
+	return repo.CreatePatient(patient)
+}
+
+func (r *HospitalRoutingPatientRepository) UpdatePatient(patient *pkg.Patient) error {
+	repo, err := r.repositoryOf(patient.HospitalID)
+	if err != nil {
+		return err
+	}
+	return repo.UpdatePatient(patient)
+}
+
+func (r *HospitalRoutingPatientRepository) DeletePatient(hospitalID int, id int) error {
+	repo, err := r.repositoryOf(hospitalID)
+	if err != nil {
+		return err
+	}
+	return repo.DeletePatient(hospitalID, id)
+}
+
+// Unknown hospitals have no patients in the local database either
+func (r *HospitalRoutingPatientRepository) repositoryOf(hospitalID int) (PatientRepositoryInterface, error) {
+	hospital, err := r.hospitalOf(hospitalID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hospital == nil || hospital.Settings.HISBaseURL == "" {
+		return r.Local, nil
+	}
+	return r.NewHISRepoFn(hospital, r.breakerOf(hospitalID)), nil
+}
+
+// Cached hospital of the routing, nil when the hospital does not exist
+func (r *HospitalRoutingPatientRepository) hospitalOf(hospitalID int) (*pkg.Hospital, error) {
+	r.routesMu.RLock()
+	route, ok := r.routes[hospitalID]
+	r.routesMu.RUnlock()
+	if ok && time.Now().Before(route.expires) {
+		return route.hospital, nil
+	}
+
+	hospital, err := r.Hospitals.GetHospitalByID(hospitalID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		hospital = nil
+	}
+
+	r.routesMu.Lock()
+	defer r.routesMu.Unlock()
+	if r.routes == nil {
+		r.routes = map[int]hospitalRoute{}
+	}
+	r.routes[hospitalID] = hospitalRoute{hospital: hospital, expires: time.Now().Add(r.CacheTTL)}
+	return hospital, nil
+}
+
+func (r *HospitalRoutingPatientRepository) breakerOf(hospitalID int) *pkg.CircuitBreaker {
+	r.breakersMu.Lock()
+	defer r.breakersMu.Unlock()
+
+	if r.breakers == nil {
+		r.breakers = map[int]*pkg.CircuitBreaker{}
+	}
+	breaker, ok := r.breakers[hospitalID]
+	if !ok {
+		breaker = pkg.NewCircuitBreaker(HISBreakerFailureThreshold, HISBreakerOpenTimeout)
+		r.breakers[hospitalID] = breaker
+	}
+	return breaker
+}
@@ -1,12 +1,56 @@
 package patient
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"gorm.io/gorm"
+)
+
+var ErrPatientNotFound = errors.New("patient not found")
+
+// Patients of hospitals connected to a HIS
+var (
+	ErrNotSupportedByHIS = errors.New("patients of this hospital are managed by its HIS, only lookups by national_id or passport_id are supported")
+	ErrHISUnavailable    = errors.New("the HIS of the hospital is unavailable")
+	ErrHISTimeout        = errors.New("the HIS of the hospital did not answer in time")
+	ErrHISCircuitOpen    = errors.New("the HIS of the hospital is degraded, lookups are suspended for a while")
+)
+
+// Failure of the HIS of a hospital. Err is ErrHISUnavailable, ErrHISTimeout or ErrHISCircuitOpen,
+// Cause is the detail, which is logged but not shown to staff.
+type HISError struct {
+	HospitalID int
+	Err        error
+	Cause      error
+}
+
+func (e *HISError) Error() string {
+	return fmt.Sprintf("hospital %d: %v: %v", e.HospitalID, e.Err, e.Cause)
+}
+
+func (e *HISError) Unwrap() error {
+	return e.Err
+}
+
+// Conflicts with another patient on a unique field
+var (
+	ErrDuplicatePatientHN    = errors.New("a patient with this patient_hn already exists")
+	ErrDuplicateNationalID   = errors.New("a patient with this national_id already exists")
+	ErrDuplicatePassportID   = errors.New("a patient with this passport_id already exists")
+	ErrDuplicatePatientEmail = errors.New("a patient with this email already exists")
+	ErrDuplicateIdentifier   = errors.New("a patient with this identifier already exists")
 )
 
 // Primary port
 type PatientServiceInterface interface {
-	SearchPatient(patientSearchRequest *pkg.Patient) ([]pkg.Patient, error)
+	SearchPatient(patientSearchRequest *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error)
+	GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error)
+	GetPatientByID(hospitalID int, id int) (*pkg.Patient, error)
+	CreatePatient(patient *pkg.Patient) (*pkg.Patient, error)
+	UpdatePatient(patient *pkg.Patient) (*pkg.Patient, error)
+	DeletePatient(hospitalID int, id int) error
 }
 
 type PatientService struct {
@@ -17,13 +61,77 @@ func NewPatientService(repo PatientRepositoryInterface) PatientServiceInterface
 	return &PatientService{repo: repo}
 }
 
-func (s *PatientService) SearchPatient(patientSearchRequest *pkg.Patient) ([]pkg.Patient, error) {
+func (s *PatientService) SearchPatient(patientSearchRequest *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error) {
+	// Enforce the server-side page size limits
+	if patientSearchRequest.Limit <= 0 {
+		patientSearchRequest.Limit = pkg.DefaultPageSize
+	}
+	if patientSearchRequest.Limit > pkg.MaxPageSize {
+		patientSearchRequest.Limit = pkg.MaxPageSize
+	}
+
+	// Turn age filters into a date of birth range as of today in the hospital timezone
+	patientSearchRequest.ApplyAgeBounds(pkg.Today())
+
 	// Retrieve patient list searching
-	patientList, err := s.repo.SearchPatient(patientSearchRequest)
+	result, err := s.repo.SearchPatient(patientSearchRequest)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return patientList, nil
+	return result, nil
+}
+
+func (s *PatientService) GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error) {
+	// Retrieve patient by national ID or passport ID
+	patient, err := s.repo.GetPatientByIdentifier(hospitalID, identifier)
+	if err != nil {
+		return nil, notFoundAsErrPatientNotFound(err)
+	}
+
+	return patient, nil
+}
+
+func (s *PatientService) GetPatientByID(hospitalID int, id int) (*pkg.Patient, error) {
+	patient, err := s.repo.GetPatientByID(hospitalID, id)
+	if err != nil {
+		return nil, notFoundAsErrPatientNotFound(err)
+	}
+
+	return patient, nil
+}
+
+func (s *PatientService) CreatePatient(patient *pkg.Patient) (*pkg.Patient, error) {
+	// ID is always assigned by the database
+	patient.ID = 0
+
+	if err := s.repo.CreatePatient(patient); err != nil {
+		return nil, err
+	}
+
+	return patient, nil
+}
+
+func (s *PatientService) UpdatePatient(patient *pkg.Patient) (*pkg.Patient, error) {
+	if err := s.repo.UpdatePatient(patient); err != nil {
+		return nil, notFoundAsErrPatientNotFound(err)
+	}
+
+	return patient, nil
+}
+
+func (s *PatientService) DeletePatient(hospitalID int, id int) error {
+	if err := s.repo.DeletePatient(hospitalID, id); err != nil {
+		return notFoundAsErrPatientNotFound(err)
+	}
+
+	return nil
+}
+
+func notFoundAsErrPatientNotFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrPatientNotFound
+	}
+	return err
 }
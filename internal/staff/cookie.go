@@ -0,0 +1,59 @@
+package staff
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Attributes of the token cookies, which depend on the deployment
+type CookieConfig struct {
+	Domain   string // empty for a cookie of the host of the request only
+	Secure   bool   // only sent over HTTPS
+	SameSite http.SameSite
+}
+
+// Host-only cookies over HTTP, for local development
+var DefaultCookieConfig = CookieConfig{SameSite: http.SameSiteLaxMode}
+
+// Cookie configuration from COOKIE_DOMAIN, COOKIE_SECURE (true or false) and COOKIE_SAMESITE (lax, strict or none).
+// Empty values keep the defaults.
+func ParseCookieConfig(domain string, secure string, sameSite string) (CookieConfig, error) {
+	config := DefaultCookieConfig
+	config.Domain = domain
+
+	if secure != "" {
+		parsed, err := strconv.ParseBool(secure)
+		if err != nil {
+			return CookieConfig{}, fmt.Errorf("invalid COOKIE_SECURE %q", secure)
+		}
+		config.Secure = parsed
+	}
+
+	switch strings.ToLower(sameSite) {
+	case "", "lax":
+		config.SameSite = http.SameSiteLaxMode
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "none":
+		config.SameSite = http.SameSiteNoneMode
+	default:
+		return CookieConfig{}, fmt.Errorf("invalid COOKIE_SAMESITE %q, expected lax, strict or none", sameSite)
+	}
+
+	// Browsers drop SameSite=None cookies which are not Secure
+	if config.SameSite == http.SameSiteNoneMode && !config.Secure {
+		return CookieConfig{}, fmt.Errorf("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+	}
+
+	return config, nil
+}
+
+// HttpOnly cookie with the configured attributes, a negative maxAge deletes it
+func (cc CookieConfig) set(c *gin.Context, name string, value string, maxAge int, path string) {
+	c.SetSameSite(cc.SameSite)
+	c.SetCookie(name, value, maxAge, path, cc.Domain, cc.Secure, true)
+}
@@ -0,0 +1,212 @@
+package staff
+
+import (
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+// Request body of staff creation by an admin. The new staff member joins the admin's
+// hospital and sets its own password through the returned invitation.
+type CreateStaffRequest struct {
+	Username string   `json:"username" validate:"required"`
+	Role     pkg.Role `json:"role" validate:"required,oneof=admin doctor nurse registration_clerk"`
+	Email    string   `json:"email" validate:"omitempty,email,max=255"` // receives password reset tokens
+}
+
+// Request body of the activation of an invited staff member
+type ActivateStaffRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// Request body of the creation of the first admin of a hospital
+type BootstrapAdminRequest struct {
+	Username   string `json:"username" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+	HospitalID int    `json:"hospital_id" validate:"required"`
+	Email      string `json:"email" validate:"omitempty,email,max=255"`
+}
+
+// Request body of the creation of the super-admin of the platform
+type BootstrapSuperAdminRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	Email    string `json:"email" validate:"omitempty,email,max=255"`
+}
+
+// Request body of staff login
+type SignInRequest struct {
+	Username     string `json:"username" validate:"required"`
+	Password     string `json:"password" validate:"required"`
+	ReturnTokens bool   `json:"return_tokens"` // tokens in the response body instead of cookies
+}
+
+// Request body completing a login with a code of the authenticator app or a recovery code
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required,max=32"`
+	ReturnTokens bool   `json:"return_tokens"` // tokens in the response body instead of cookies
+}
+
+// Request body of the TOTP enrolment of a login whose role has to use MFA
+type LoginTOTPEnrollmentRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+// Request body of the TOTP enrolment of the logged in staff member
+type StartTOTPEnrollmentRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// Request body confirming a TOTP enrolment with a first code of the authenticator app
+type ConfirmTOTPEnrollmentRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+// Request body of a token refresh by a client without cookies
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Request body of a password change by the logged in staff member
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// Request body of a password reset request by a staff member who forgot its password
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// Request body of a password reset with the emailed token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// Tokens returned in the body, to clients which send the access token in an Authorization: Bearer header
+type TokenResponse struct {
+	Message               string `json:"message"`
+	AccessToken           string `json:"access_token"`
+	TokenType             string `json:"token_type"`
+	ExpiresIn             int    `json:"expires_in"` // seconds
+	RefreshToken          string `json:"refresh_token"`
+	RefreshTokenExpiresIn int    `json:"refresh_token_expires_in"` // seconds
+	// Shown once, when a login confirms the TOTP enrolment
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// Login waiting for a code of the authenticator app, completed with the MFA token
+type MFAChallengeResponse struct {
+	Message            string `json:"message"`
+	MFARequired        bool   `json:"mfa_required"`
+	MFAToken           string `json:"mfa_token"`
+	ExpiresIn          int    `json:"expires_in"`          // seconds
+	EnrollmentRequired bool   `json:"enrollment_required"` // the staff member has no authenticator yet
+}
+
+// Secret of a pending TOTP enrolment, the only time it is ever shown
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"` // shown as a QR code for authenticator apps to scan
+}
+
+// Staff member as returned by the API, never carrying the password hash
+type StaffResponse struct {
+	ID         int      `json:"id"`
+	Username   string   `json:"username"`
+	Role       pkg.Role `json:"role"`
+	Email      string   `json:"email"`
+	HospitalID int      `json:"hospital_id"`
+}
+
+// Invitation as returned to the admin, the only time the token is ever shown
+type InvitationResponse struct {
+	Staff     StaffResponse `json:"staff"`
+	Token     string        `json:"token"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+func (r *CreateStaffRequest) ToStaff() *pkg.Staff {
+	return &pkg.Staff{
+		Username: r.Username,
+		Role:     r.Role,
+		Email:    r.Email,
+	}
+}
+
+func (r *BootstrapAdminRequest) ToStaff() *pkg.Staff {
+	return &pkg.Staff{
+		Username:   r.Username,
+		Password:   r.Password,
+		HospitalID: r.HospitalID,
+		Email:      r.Email,
+	}
+}
+
+func (r *BootstrapSuperAdminRequest) ToStaff() *pkg.Staff {
+	return &pkg.Staff{
+		Username: r.Username,
+		Password: r.Password,
+		Email:    r.Email,
+	}
+}
+
+func (r *SignInRequest) ToStaff() *pkg.Staff {
+	return &pkg.Staff{
+		Username: r.Username,
+		Password: r.Password,
+	}
+}
+
+func NewTokenResponse(message string, tokens *TokenPair) TokenResponse {
+	return TokenResponse{
+		Message:               message,
+		AccessToken:           tokens.AccessToken,
+		TokenType:             "Bearer",
+		ExpiresIn:             int(time.Until(tokens.AccessTokenExpiresAt).Seconds()),
+		RefreshToken:          tokens.RefreshToken,
+		RefreshTokenExpiresIn: int(time.Until(tokens.RefreshTokenExpiresAt).Seconds()),
+	}
+}
+
+func NewMFAChallengeResponse(challenge *MFAChallenge) MFAChallengeResponse {
+	message := "Enter the code of your authenticator app"
+	if challenge.EnrollmentRequired {
+		message = "Enrol an authenticator app, then enter its code"
+	}
+	return MFAChallengeResponse{
+		Message:            message,
+		MFARequired:        true,
+		MFAToken:           challenge.Token,
+		ExpiresIn:          int(time.Until(challenge.ExpiresAt).Seconds()),
+		EnrollmentRequired: challenge.EnrollmentRequired,
+	}
+}
+
+func NewTOTPEnrollmentResponse(enrollment *TOTPEnrollment) TOTPEnrollmentResponse {
+	return TOTPEnrollmentResponse{
+		Secret:     enrollment.Secret,
+		OTPAuthURI: enrollment.URI,
+	}
+}
+
+func NewStaffResponse(staff *pkg.Staff) StaffResponse {
+	return StaffResponse{
+		ID:         staff.ID,
+		Username:   staff.Username,
+		Role:       staff.Role,
+		Email:      staff.Email,
+		HospitalID: staff.HospitalID,
+	}
+}
+
+func NewInvitationResponse(invitation *pkg.StaffInvitation) InvitationResponse {
+	return InvitationResponse{
+		Staff:     NewStaffResponse(&invitation.Staff),
+		Token:     invitation.Token,
+		ExpiresAt: invitation.ExpiresAt,
+	}
+}
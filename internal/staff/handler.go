@@ -1,116 +1,985 @@
 package staff
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 )
 
 // Primary adapter
 type StaffHandler struct {
-	service StaffServiceInterface
+	Service         StaffServiceInterface
+	GetHospitalIDFn func(c *gin.Context) (int, error)
+	GetSessionFn    func(c *gin.Context) (*middleware.Session, error)
+	Cookies         CookieConfig
 }
 
 // Just define what struct will do
 type StaffHandlerInterface interface {
 	CreateStaff(c *gin.Context)
+	ActivateStaff(c *gin.Context)
+	BootstrapAdmin(c *gin.Context)
+	BootstrapSuperAdmin(c *gin.Context)
 	SignInStaff(c *gin.Context)
+	RefreshToken(c *gin.Context)
+	SignOutStaff(c *gin.Context)
+	RevokeStaffSessions(c *gin.Context)
+	UnlockStaff(c *gin.Context)
+	ChangePassword(c *gin.Context)
+	RequestPasswordReset(c *gin.Context)
+	ResetPassword(c *gin.Context)
+	VerifyMFA(c *gin.Context)
+	StartLoginTOTPEnrollment(c *gin.Context)
+	StartTOTPEnrollment(c *gin.Context)
+	ConfirmTOTPEnrollment(c *gin.Context)
+	ResetStaffMFA(c *gin.Context)
 }
 
+// Stable error codes of the staff creation, password and MFA endpoints, for clients to branch on instead of the message
+const (
+	CodeInvalidRequest        = "INVALID_REQUEST"
+	CodeInvalidHospital       = "INVALID_HOSPITAL"
+	CodeHospitalNotFound      = "HOSPITAL_NOT_FOUND"
+	CodeUsernameTaken         = "USERNAME_TAKEN"
+	CodeEmailTaken            = "EMAIL_TAKEN"
+	CodeAdminExists           = "ADMIN_EXISTS"
+	CodeSuperAdminExists      = "SUPER_ADMIN_EXISTS"
+	CodeBootstrapDisabled     = "BOOTSTRAP_DISABLED"
+	CodeInvalidBootstrapToken = "INVALID_BOOTSTRAP_TOKEN"
+	CodeWeakPassword          = "WEAK_PASSWORD"
+	CodeMFANotEnrolled        = "MFA_NOT_ENROLLED"
+	CodeMFAAlreadyEnrolled    = "MFA_ALREADY_ENROLLED"
+	CodeInternalError         = "INTERNAL_ERROR"
+)
+
 func NewHttpStaffHandler(service StaffServiceInterface) *StaffHandler {
-	return &StaffHandler{service: service}
+	return &StaffHandler{
+		Service:         service,
+		GetHospitalIDFn: middleware.GetHospitalID,
+		GetSessionFn:    middleware.GetSession,
+		Cookies:         DefaultCookieConfig,
+	}
 }
 
 // CreateStaff godoc
-// @Summary Create a new staff member
-// @Description Create a new hospital staff member with login credentials
+// @Summary Invite a new staff member
+// @Description Create a staff member in the admin's hospital and an invitation, valid for 72 hours, with which the staff member sets its own password
 // @Tags Staff
 // @Accept json
 // @Produce json
-// @Param staff body pkg.Staff true "Staff details"
+// @Param staff body CreateStaffRequest true "Staff details"
 // @Success 201 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
 // @Router /staff/create [post]
 func (h *StaffHandler) CreateStaff(c *gin.Context) {
-	var newStaff pkg.Staff
-	/*if err := c.BindJSON(&newStaff); err != nil {
+	var request CreateStaffRequest
+	/*if err := c.BindJSON(&request); err != nil {
 	    // The error is automatically handled by Gin.
 	    return
 	}*/
-	if err := c.ShouldBindJSON(&newStaff); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
 		return
 	}
 
 	// Validate the input body
-	validate := validator.New()
-	err := validate.Struct(newStaff)
+	err := pkg.Validate.Struct(request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
 		return
 	}
 
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "code": CodeInternalError})
+		return
+	}
+
+	// Staff are always created in the same hospital as the admin
+	newStaff := request.ToStaff()
+	newStaff.HospitalID = hospitalIDInt
+
 	// Call service
-	createdStaff, err := h.service.CreateStaff(&newStaff)
+	invitation, err := h.Service.InviteStaff(newStaff)
+	if err != nil {
+		status, body := staffCreationError(err)
+		c.JSON(status, body)
+		return
+	}
 
-	// Internal service error
+	// Success creation
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Created successfully",
+		"data":    NewInvitationResponse(invitation),
+	})
+}
+
+// ActivateStaff godoc
+// @Summary Activate an invited staff member
+// @Description Set the password of an invited staff member with the token of its invitation. An invitation can be used once.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param activation body ActivateStaffRequest true "Invitation token and new password"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{} "Invalid invitation, or password refused by the policy with code WEAK_PASSWORD and its violations"
+// @Failure 500 {object} map[string]string
+// @Router /staff/activate [post]
+func (h *StaffHandler) ActivateStaff(c *gin.Context) {
+	var request ActivateStaffRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Call service
+	activatedStaff, err := h.Service.ActivateStaff(request.Token, request.Password)
 	if err != nil {
+		var weak *PasswordPolicyError
+		if errors.Is(err, ErrInvalidInvitation) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+			return
+		} else if errors.As(err, &weak) {
+			c.JSON(http.StatusBadRequest, weakPasswordBody(weak))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Success activation
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Activated successfully",
+		"data":    NewStaffResponse(activatedStaff),
+	})
+}
+
+// BootstrapAdmin godoc
+// @Summary Create the first admin of a hospital
+// @Description Create the admin of a hospital which has none yet. Requires the BOOTSTRAP_TOKEN of the deployment in the X-Bootstrap-Token header, and is disabled when it is not configured.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param X-Bootstrap-Token header string true "Bootstrap token of the deployment"
+// @Param admin body BootstrapAdminRequest true "Admin details"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /staff/bootstrap [post]
+func (h *StaffHandler) BootstrapAdmin(c *gin.Context) {
+	var request BootstrapAdminRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Call service
+	admin, err := h.Service.BootstrapAdmin(request.ToStaff(), c.GetHeader("X-Bootstrap-Token"))
+	if err != nil {
+		status, body := staffCreationError(err)
+		c.JSON(status, body)
+		return
+	}
+
 	// Success creation
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Created successfully",
-		"data":    createdStaff,
+		"data":    NewStaffResponse(admin),
 	})
 }
 
+// BootstrapSuperAdmin godoc
+// @Summary Create the super-admin of the platform
+// @Description Create the super-admin, who manages hospitals and belongs to none, when there is none yet. Requires the BOOTSTRAP_TOKEN of the deployment in the X-Bootstrap-Token header, and is disabled when it is not configured.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param X-Bootstrap-Token header string true "Bootstrap token of the deployment"
+// @Param superAdmin body BootstrapSuperAdminRequest true "Super-admin credentials"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /staff/bootstrap/super-admin [post]
+func (h *StaffHandler) BootstrapSuperAdmin(c *gin.Context) {
+	var request BootstrapSuperAdminRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Call service
+	superAdmin, err := h.Service.BootstrapSuperAdmin(request.ToStaff(), c.GetHeader("X-Bootstrap-Token"))
+	if err != nil {
+		status, body := staffCreationError(err)
+		c.JSON(status, body)
+		return
+	}
+
+	// Success creation
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Created successfully",
+		"data":    NewStaffResponse(superAdmin),
+	})
+}
+
+// Status and body of an error of staff invitation or bootstrap.
+// Unexpected errors, e.g. from the database, are not exposed to the client.
+func staffCreationError(err error) (int, gin.H) {
+	var weak *PasswordPolicyError
+	switch {
+	case errors.As(err, &weak):
+		return http.StatusBadRequest, weakPasswordBody(weak)
+	case errors.Is(err, ErrInvalidHospital):
+		return http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidHospital}
+	case errors.Is(err, ErrHospitalNotFound):
+		return http.StatusNotFound, gin.H{"error": err.Error(), "code": CodeHospitalNotFound}
+	case errors.Is(err, ErrDuplicateUsername):
+		return http.StatusConflict, gin.H{"error": err.Error(), "code": CodeUsernameTaken}
+	case errors.Is(err, ErrDuplicateEmail):
+		return http.StatusConflict, gin.H{"error": err.Error(), "code": CodeEmailTaken}
+	case errors.Is(err, ErrAdminExists):
+		return http.StatusConflict, gin.H{"error": err.Error(), "code": CodeAdminExists}
+	case errors.Is(err, ErrSuperAdminExists):
+		return http.StatusConflict, gin.H{"error": err.Error(), "code": CodeSuperAdminExists}
+	case errors.Is(err, ErrBootstrapDisabled):
+		return http.StatusNotFound, gin.H{"error": err.Error(), "code": CodeBootstrapDisabled}
+	case errors.Is(err, ErrInvalidBootstrap):
+		return http.StatusUnauthorized, gin.H{"error": err.Error(), "code": CodeInvalidBootstrapToken}
+	default:
+		return http.StatusInternalServerError, gin.H{"error": "internal server error", "code": CodeInternalError}
+	}
+}
+
+// Body of a password refused by the policy, listing every rule it breaks
+func weakPasswordBody(err *PasswordPolicyError) gin.H {
+	return gin.H{"error": ErrWeakPassword.Error(), "code": CodeWeakPassword, "violations": err.Violations}
+}
+
+// Cookies of the short-lived access token and of the refresh token, which is only sent to the refresh endpoint
+const (
+	accessTokenCookie  = "jwt"
+	refreshTokenCookie = "refresh_token"
+	refreshTokenPath   = "/staff/token"
+)
+
 // SignInStaff godoc
 // @Summary Staff login
-// @Description Authenticates a staff member and returns a JWT token
+// @Description Authenticates a staff member and sets the cookies of a JWT access token, valid for 15 minutes, and of a refresh token.
+// @Description With "return_tokens": true, the tokens are returned in the body instead, for clients sending the access token in an Authorization: Bearer header.
+// @Description Staff with a TOTP authenticator, or whose role has to use one, get 202 with an MFA token instead, valid for 5 minutes, completing the login at /staff/login/mfa.
 // @Tags Staff
 // @Accept json
 // @Produce json
-// @Param credentials body pkg.Staff true "Staff login credentials"
-// @Success 200 {object} map[string]string
+// @Param credentials body SignInRequest true "Staff login credentials"
+// @Success 200 {object} TokenResponse
+// @Success 202 {object} MFAChallengeResponse
 // @Failure 401 {object} map[string]string
+// @Failure 429 {object} map[string]string "Too many failed logins of the username or the client, retry after the Retry-After header"
 // @Failure 500 {object} map[string]string
+// @Failure 503 {object} map[string]string "Too many logins in progress"
 // @Router /staff/login [post]
 func (h *StaffHandler) SignInStaff(c *gin.Context) {
-	var staffInput pkg.Staff
-	if err := c.ShouldBindJSON(&staffInput); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request SignInRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
 		return
 	}
 
 	// Validate the input body
-	validate := validator.New()
-	err := validate.Struct(staffInput)
+	err := pkg.Validate.Struct(request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
 		return
 	}
 
-	// Call service
-	token, err := h.service.SignInStaff(&staffInput)
+	// Call service, failed logins are throttled per client IP too
+	tokens, challenge, err := h.Service.SignInStaff(request.ToStaff(), c.ClientIP())
 
 	// Internal service error
 	if err != nil {
-		if err == ErrUnauthorized {
+		var throttled *LoginThrottledError
+		if errors.Is(err, ErrUnauthorized) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
+		} else if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": ErrLoginThrottled.Error()})
+			return
+		} else if errors.Is(err, ErrLoginBusy) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			// Details could tell apart usernames, they are not exposed
+			log.Printf("staff login failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			return
 		}
 	}
 
+	// The password is right, the login goes on with a code
+	if challenge != nil {
+		c.JSON(http.StatusAccepted, NewMFAChallengeResponse(challenge))
+		return
+	}
+
+	// Success login response, with the tokens in the body for clients without cookies
+	if request.ReturnTokens {
+		c.JSON(http.StatusOK, NewTokenResponse("Login successful", tokens))
+		return
+	}
+
 	// Set the JWT token in a cookie
-	c.SetCookie("jwt", token, 3600, "/", "localhost", false, true)
+	h.setTokenCookies(c, tokens)
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+}
+
+// VerifyMFA godoc
+// @Summary Complete a login with MFA
+// @Description Completes the login of an MFA token with a 6-digit code of the authenticator app, or with a recovery code, which can be used once. Sets the login cookies, or returns the tokens with "return_tokens": true, as the login does.
+// @Description A login enrolling an authenticator confirms the enrolment with its first code and gets its recovery codes, shown once. Wrong codes are throttled like wrong passwords.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param login body MFALoginRequest true "MFA token of the login and code"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string "Invalid or expired MFA token, or wrong code"
+// @Failure 404 {object} map[string]string "MFA is disabled"
+// @Failure 409 {object} map[string]string "The enrolment of the authenticator was not started, code MFA_NOT_ENROLLED"
+// @Failure 429 {object} map[string]string "Too many failed logins of the username or the client, retry after the Retry-After header"
+// @Failure 500 {object} map[string]string
+// @Router /staff/login/mfa [post]
+func (h *StaffHandler) VerifyMFA(c *gin.Context) {
+	var request MFALoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Call service, wrong codes are throttled per client IP too
+	tokens, recoveryCodes, err := h.Service.VerifyMFA(request.MFAToken, request.Code, c.ClientIP())
+	if err != nil {
+		var throttled *LoginThrottledError
+		if errors.Is(err, ErrInvalidMFAToken) || errors.Is(err, ErrInvalidMFACode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		} else if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": ErrLoginThrottled.Error()})
+			return
+		} else if errors.Is(err, ErrMFANotEnrolled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": CodeMFANotEnrolled})
+			return
+		} else if errors.Is(err, ErrMFADisabled) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("MFA login failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
 
-	// Success login response
+	// Success login response, with the tokens in the body for clients without cookies
+	if request.ReturnTokens {
+		response := NewTokenResponse("Login successful", tokens)
+		response.RecoveryCodes = recoveryCodes
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	h.setTokenCookies(c, tokens)
+	if len(recoveryCodes) > 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Login successful", "recovery_codes": recoveryCodes})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
 }
+
+// StartLoginTOTPEnrollment godoc
+// @Summary Enrol an authenticator during the login
+// @Description Generates the TOTP secret of a staff member whose role has to use MFA and who has no authenticator yet, with the MFA token of its login.
+// @Description The otpauth URI is shown as a QR code for the authenticator app to scan; the login is then completed at /staff/login/mfa with a first code.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param enrollment body LoginTOTPEnrollmentRequest true "MFA token of the login"
+// @Success 200 {object} TOTPEnrollmentResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string "Invalid or expired MFA token"
+// @Failure 404 {object} map[string]string "MFA is disabled"
+// @Failure 409 {object} map[string]string "The staff member already has an authenticator, code MFA_ALREADY_ENROLLED"
+// @Failure 500 {object} map[string]string
+// @Router /staff/login/mfa/enroll [post]
+func (h *StaffHandler) StartLoginTOTPEnrollment(c *gin.Context) {
+	var request LoginTOTPEnrollmentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Call service
+	enrollment, err := h.Service.StartLoginTOTPEnrollment(request.MFAToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFAToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		h.totpEnrollmentError(c, err)
+		return
+	}
+
+	// Success enrolment, the secret is never shown again
+	c.JSON(http.StatusOK, NewTOTPEnrollmentResponse(enrollment))
+}
+
+// RefreshToken godoc
+// @Summary Refresh the login tokens
+// @Description Exchanges the refresh token cookie for new access and refresh token cookies. A refresh token can be used once: using it again revokes every token of the login.
+// @Description A refresh token sent in the body is exchanged for tokens returned in the body.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param refresh body RefreshTokenRequest false "Refresh token of clients without cookies"
+// @Success 200 {object} TokenResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /staff/token/refresh [post]
+func (h *StaffHandler) RefreshToken(c *gin.Context) {
+	// Clients without cookies send the refresh token in the body
+	var request RefreshTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+			return
+		}
+	}
+	inBody := request.RefreshToken != ""
+
+	refreshToken := request.RefreshToken
+	if !inBody {
+		refreshToken, _ = c.Cookie(refreshTokenCookie)
+	}
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidRefreshToken.Error()})
+		return
+	}
+
+	// Call service
+	tokens, err := h.Service.RefreshToken(refreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+			if !inBody {
+				h.clearTokenCookies(c)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("token refresh failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Success refresh response, the tokens go back the way the refresh token came
+	if inBody {
+		c.JSON(http.StatusOK, NewTokenResponse("Token refreshed", tokens))
+		return
+	}
+	h.setTokenCookies(c, tokens)
+	c.JSON(http.StatusOK, gin.H{"message": "Token refreshed"})
+}
+
+func (h *StaffHandler) setTokenCookies(c *gin.Context, tokens *TokenPair) {
+	h.Cookies.set(c, accessTokenCookie, tokens.AccessToken, int(time.Until(tokens.AccessTokenExpiresAt).Seconds()), "/")
+	h.Cookies.set(c, refreshTokenCookie, tokens.RefreshToken, int(time.Until(tokens.RefreshTokenExpiresAt).Seconds()), refreshTokenPath)
+}
+
+func (h *StaffHandler) clearTokenCookies(c *gin.Context) {
+	h.Cookies.set(c, accessTokenCookie, "", -1, "/")
+	h.Cookies.set(c, refreshTokenCookie, "", -1, refreshTokenPath)
+}
+
+// SignOutStaff godoc
+// @Summary Staff logout
+// @Description Revokes the JWT token of the request until it expires, and the refresh tokens of the login, and clears their cookies
+// @Tags Staff
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /staff/logout [post]
+func (h *StaffHandler) SignOutStaff(c *gin.Context) {
+	session, err := h.GetSessionFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	if err := h.Service.SignOutStaff(session.TokenID, session.FamilyID, session.ExpiresAt); err != nil {
+		log.Printf("logout failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Clear the JWT token cookies
+	h.clearTokenCookies(c)
+
+	// Success logout response
+	c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
+}
+
+// RevokeStaffSessions godoc
+// @Summary Revoke all sessions of a staff member
+// @Description Revokes every JWT token issued until now to a staff member of the admin's hospital, e.g. when one was stolen. The staff member has to log in again.
+// @Tags Staff
+// @Produce json
+// @Param id path int true "Staff ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /staff/{id}/revoke-sessions [post]
+func (h *StaffHandler) RevokeStaffSessions(c *gin.Context) {
+	staffID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || staffID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "staff ID must be a positive integer", "code": CodeInvalidRequest})
+		return
+	}
+
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	if err := h.Service.RevokeStaffSessions(hospitalIDInt, staffID); err != nil {
+		if errors.Is(err, ErrStaffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("session revocation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Success revocation
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked successfully"})
+}
+
+// UnlockStaff godoc
+// @Summary Unlock a staff member
+// @Description Forgets the failed logins of a staff member of the admin's hospital, ending its lockout. Failed logins of client IPs are kept.
+// @Tags Staff
+// @Produce json
+// @Param id path int true "Staff ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /staff/{id}/unlock [post]
+func (h *StaffHandler) UnlockStaff(c *gin.Context) {
+	staffID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || staffID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "staff ID must be a positive integer", "code": CodeInvalidRequest})
+		return
+	}
+
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	if err := h.Service.UnlockStaff(hospitalIDInt, staffID); err != nil {
+		if errors.Is(err, ErrStaffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("staff unlock failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Success unlock
+	c.JSON(http.StatusOK, gin.H{"message": "Staff unlocked successfully"})
+}
+
+// ChangePassword godoc
+// @Summary Change the password
+// @Description Replaces the password of the logged in staff member after checking its current password. Wrong current passwords are throttled like failed logins.
+// @Description Every other session of the staff member is revoked, the session of the request stays signed in.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param passwords body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{} "Invalid body, or new password refused by the policy with code WEAK_PASSWORD and its violations"
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string "Wrong current password"
+// @Failure 404 {object} map[string]string
+// @Failure 429 {object} map[string]string "Too many wrong passwords, retry after the Retry-After header"
+// @Failure 500 {object} map[string]string
+// @Failure 503 {object} map[string]string "Too many logins in progress"
+// @Router /staff/password [put]
+func (h *StaffHandler) ChangePassword(c *gin.Context) {
+	var request ChangePasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	session, err := h.GetSessionFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service, the session of the request is kept
+	err = h.Service.ChangePassword(session.StaffID, session.FamilyID, request.CurrentPassword, request.NewPassword)
+	if err != nil {
+		var weak *PasswordPolicyError
+		var throttled *LoginThrottledError
+		if errors.As(err, &weak) {
+			c.JSON(http.StatusBadRequest, weakPasswordBody(weak))
+			return
+		} else if errors.Is(err, ErrWrongPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		} else if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": ErrLoginThrottled.Error()})
+			return
+		} else if errors.Is(err, ErrLoginBusy) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		} else if errors.Is(err, ErrStaffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("password change failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Success change
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+// StartTOTPEnrollment godoc
+// @Summary Enrol an authenticator
+// @Description Generates the TOTP secret of the logged in staff member after checking its password. Wrong passwords are throttled like failed logins.
+// @Description The otpauth URI is shown as a QR code for the authenticator app to scan; the enrolment is confirmed at /staff/mfa/totp/confirm with a first code.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param enrollment body StartTOTPEnrollmentRequest true "Password of the staff member"
+// @Success 200 {object} TOTPEnrollmentResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string "Wrong password"
+// @Failure 404 {object} map[string]string "MFA is disabled"
+// @Failure 409 {object} map[string]string "The staff member already has an authenticator, code MFA_ALREADY_ENROLLED"
+// @Failure 429 {object} map[string]string "Too many wrong passwords, retry after the Retry-After header"
+// @Failure 500 {object} map[string]string
+// @Failure 503 {object} map[string]string "Too many logins in progress"
+// @Router /staff/mfa/totp [post]
+func (h *StaffHandler) StartTOTPEnrollment(c *gin.Context) {
+	var request StartTOTPEnrollmentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	session, err := h.GetSessionFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	enrollment, err := h.Service.StartTOTPEnrollment(session.StaffID, request.Password)
+	if err != nil {
+		var throttled *LoginThrottledError
+		if errors.Is(err, ErrWrongPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		} else if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": ErrLoginThrottled.Error()})
+			return
+		} else if errors.Is(err, ErrLoginBusy) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		} else if errors.Is(err, ErrStaffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.totpEnrollmentError(c, err)
+		return
+	}
+
+	// Success enrolment, the secret is never shown again
+	c.JSON(http.StatusOK, NewTOTPEnrollmentResponse(enrollment))
+}
+
+// Respond with the error of a TOTP enrolment common to its endpoints
+func (h *StaffHandler) totpEnrollmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrMFAAlreadyEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": CodeMFAAlreadyEnrolled})
+	case errors.Is(err, ErrMFANotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": CodeMFANotEnrolled})
+	case errors.Is(err, ErrMFADisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		log.Printf("TOTP enrolment failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
+
+// ConfirmTOTPEnrollment godoc
+// @Summary Confirm the enrolment of an authenticator
+// @Description Confirms the pending TOTP enrolment of the logged in staff member with a first code of the authenticator app, and returns its recovery codes, shown once.
+// @Description The next logins of the staff member need a code. Sessions logged in before keep their authentication methods until the next login.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param code body ConfirmTOTPEnrollmentRequest true "Code of the authenticator app"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string "Invalid body or wrong code"
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string "MFA is disabled"
+// @Failure 409 {object} map[string]string "No enrolment is pending (MFA_NOT_ENROLLED), or it is confirmed (MFA_ALREADY_ENROLLED)"
+// @Failure 500 {object} map[string]string
+// @Router /staff/mfa/totp/confirm [post]
+func (h *StaffHandler) ConfirmTOTPEnrollment(c *gin.Context) {
+	var request ConfirmTOTPEnrollmentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	session, err := h.GetSessionFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	recoveryCodes, err := h.Service.ConfirmTOTPEnrollment(session.StaffID, request.Code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+			return
+		}
+		h.totpEnrollmentError(c, err)
+		return
+	}
+
+	// Success confirmation, the recovery codes are never shown again
+	c.JSON(http.StatusOK, gin.H{"message": "Authenticator enrolled successfully", "recovery_codes": recoveryCodes})
+}
+
+// ResetStaffMFA godoc
+// @Summary Reset the MFA of a staff member
+// @Description Removes the authenticator and the recovery codes of a staff member of the admin's hospital, e.g. who lost its phone, and revokes its login tokens and refresh tokens. Staff whose role has to use MFA enrol a new authenticator at their next login.
+// @Tags Staff
+// @Produce json
+// @Param id path int true "Staff ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /staff/{id}/mfa/reset [post]
+func (h *StaffHandler) ResetStaffMFA(c *gin.Context) {
+	staffID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || staffID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "staff ID must be a positive integer", "code": CodeInvalidRequest})
+		return
+	}
+
+	// Retrieve hospital_id
+	hospitalIDInt, err := h.GetHospitalIDFn(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call service
+	if err := h.Service.ResetStaffMFA(hospitalIDInt, staffID); err != nil {
+		if errors.Is(err, ErrStaffNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("MFA reset failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Success reset
+	c.JSON(http.StatusOK, gin.H{"message": "MFA reset successfully"})
+}
+
+// RequestPasswordReset godoc
+// @Summary Request a password reset
+// @Description Emails a password reset token, valid for 30 minutes and usable once, to the staff member of the email.
+// @Description The response is the same whether or not the email belongs to a staff member.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param request body PasswordResetRequest true "Email of the staff member"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string "Password reset is disabled"
+// @Failure 500 {object} map[string]string
+// @Router /staff/password/reset-request [post]
+func (h *StaffHandler) RequestPasswordReset(c *gin.Context) {
+	var request PasswordResetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Call service
+	if err := h.Service.RequestPasswordReset(request.Email); err != nil {
+		if errors.Is(err, ErrPasswordResetDisabled) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("password reset request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Accepted whether or not a token was sent
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email belongs to a staff member, a password reset token was sent to it"})
+}
+
+// ResetPassword godoc
+// @Summary Reset the password
+// @Description Sets a new password with an emailed reset token, which can be used once. Every session of the staff member is revoked, and its lockout after failed logins ends.
+// @Tags Staff
+// @Accept json
+// @Produce json
+// @Param reset body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{} "Invalid token or body, or new password refused by the policy with code WEAK_PASSWORD and its violations"
+// @Failure 500 {object} map[string]string
+// @Router /staff/password/reset [post]
+func (h *StaffHandler) ResetPassword(c *gin.Context) {
+	var request ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Validate the input body
+	if err := pkg.Validate.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+		return
+	}
+
+	// Call service
+	if err := h.Service.ResetPassword(request.Token, request.NewPassword); err != nil {
+		var weak *PasswordPolicyError
+		if errors.As(err, &weak) {
+			c.JSON(http.StatusBadRequest, weakPasswordBody(weak))
+			return
+		} else if errors.Is(err, ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": CodeInvalidRequest})
+			return
+		}
+		log.Printf("password reset failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Success reset
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
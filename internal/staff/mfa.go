@@ -0,0 +1,170 @@
+package staff
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Multi-factor authentication of logins with TOTP authenticator apps
+type MFAConfig struct {
+	RequiredRoles []pkg.Role     // roles of every hospital logging in with TOTP, hospital settings add others
+	Issuer        string         // name of the API in authenticator apps
+	Secrets       *pkg.SecretBox // seals the TOTP secrets stored in the database
+}
+
+const DefaultMFAIssuer = "Hospital API"
+
+// How long the password step of a login waits for its code
+const MFATokenTTL = 5 * time.Minute
+
+// Recovery codes given at enrolment, each logging in once without the authenticator
+const RecoveryCodeCount = 10
+
+// typ claim of the token of a login waiting for its code, which is not a login token
+const mfaTokenType = "mfa_pending"
+
+// Config of MFA_REQUIRED_ROLES, comma-separated roles, MFA_ISSUER and TOTP_ENCRYPTION_KEY
+func ParseMFAConfig(requiredRoles string, issuer string, encryptionKey string) (*MFAConfig, error) {
+	config := &MFAConfig{Issuer: strings.TrimSpace(issuer)}
+	if config.Issuer == "" {
+		config.Issuer = DefaultMFAIssuer
+	}
+
+	for _, role := range strings.Split(requiredRoles, ",") {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			continue
+		}
+		if !pkg.Role(role).IsValid() {
+			return nil, fmt.Errorf("invalid MFA_REQUIRED_ROLES: unknown role %q", role)
+		}
+		config.RequiredRoles = append(config.RequiredRoles, pkg.Role(role))
+	}
+
+	if strings.TrimSpace(encryptionKey) == "" {
+		return nil, errors.New("TOTP_ENCRYPTION_KEY is required")
+	}
+	secrets, err := pkg.ParseSecretBox(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP_ENCRYPTION_KEY: %w", err)
+	}
+	config.Secrets = secrets
+	return config, nil
+}
+
+// Password step of a login passed, the login is completed with a code of the authenticator,
+// which staff without one enrol first
+type MFAChallenge struct {
+	Token              string
+	ExpiresAt          time.Time
+	EnrollmentRequired bool
+}
+
+// Secret of a pending TOTP enrolment, shown once to be added to an authenticator app
+type TOTPEnrollment struct {
+	Secret string
+	URI    string // otpauth:// provisioning URI, shown as a QR code
+}
+
+// Login tokens carry how the login was authenticated
+type LoginSession struct {
+	FamilyID    string   // refresh token family of the login, fid claim
+	AuthMethods []string // amr claim, e.g. pwd, otp and mfa
+	MFARequired bool     // mfa_required claim, whether the staff member has to log in with MFA
+}
+
+// Claims of an MFA token, signed with the key ring like login tokens
+type mfaToken struct {
+	TokenID   string
+	StaffID   int
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func newMFAToken(keys *pkg.KeyRing, staffID int, expiresAt time.Time) (string, error) {
+	tokenID, _, err := pkg.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	return keys.Sign(jwt.MapClaims{
+		"typ":      mfaTokenType,
+		"jti":      tokenID,
+		"iat":      time.Now().Unix(),
+		"exp":      expiresAt.Unix(),
+		"staff_id": staffID,
+		"amr":      []string{pkg.AuthMethodPassword},
+	})
+}
+
+// Claims of a valid MFA token, ErrInvalidMFAToken for login tokens and expired tokens
+func parseMFAToken(keys *pkg.KeyRing, tokenString string) (*mfaToken, error) {
+	token, err := jwt.Parse(tokenString, keys.Keyfunc, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidMFAToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != mfaTokenType {
+		return nil, ErrInvalidMFAToken
+	}
+	tokenID, _ := claims["jti"].(string)
+	staffID, ok := claims["staff_id"].(float64)
+	issuedAt, err := claims.GetIssuedAt()
+	if tokenID == "" || !ok || err != nil || issuedAt == nil {
+		return nil, ErrInvalidMFAToken
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	return &mfaToken{TokenID: tokenID, StaffID: int(staffID), IssuedAt: issuedAt.Time, ExpiresAt: expiresAt.Time}, nil
+}
+
+// Letters and digits of recovery codes, without those read alike (0, 1, l and o)
+const recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// Random recovery codes formatted as xxxxx-xxxxx, 50 bits each, and their stored hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, 10)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+
+		code := make([]byte, len(random))
+		for j, b := range random {
+			code[j] = recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)]
+		}
+		codes[i] = string(code[:5]) + "-" + string(code[5:])
+		hashes[i] = pkg.HashOpaqueToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+// Recovery codes are typed in any case, with or without their dash
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// Codes of the authenticator are digits only, recovery codes have letters
+func isTOTPCode(code string) bool {
+	if len(code) != pkg.TOTPDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package staff
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// bcrypt only hashes the first 72 bytes of a password
+const MaxPasswordBytes = 72
+
+// Rules of the passwords staff set on activation, bootstrap or change. The zero policy only limits the length
+// to MaxPasswordBytes and refuses passwords containing the username.
+type PasswordPolicy struct {
+	MinLength int // in characters
+	// Of lowercase letters, uppercase letters, digits and other characters, e.g. symbols or Thai letters
+	MinCharacterClasses int
+	// A new password may not be the current one or one of the HistorySize-1 before it
+	HistorySize int
+	// Known breached passwords, lowercase
+	Breached map[string]struct{}
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 12, MinCharacterClasses: 3, HistorySize: 5}
+
+// Policy of the deployment from PASSWORD_MIN_LENGTH, PASSWORD_MIN_CHARACTER_CLASSES, PASSWORD_HISTORY and
+// PASSWORD_BREACHED_LIST, the path of a file of breached passwords, one per line. Empty values keep the defaults.
+func ParsePasswordPolicy(minLength, minCharacterClasses, historySize, breachedList string) (*PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy
+
+	settings := []struct {
+		name  string
+		value string
+		field *int
+		max   int
+	}{
+		{"PASSWORD_MIN_LENGTH", minLength, &policy.MinLength, MaxPasswordBytes},
+		{"PASSWORD_MIN_CHARACTER_CLASSES", minCharacterClasses, &policy.MinCharacterClasses, 4},
+		{"PASSWORD_HISTORY", historySize, &policy.HistorySize, 24},
+	}
+	for _, setting := range settings {
+		if setting.value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(setting.value)
+		if err != nil || parsed < 0 || parsed > setting.max {
+			return nil, fmt.Errorf("invalid %s %q: expected an integer from 0 to %d", setting.name, setting.value, setting.max)
+		}
+		*setting.field = parsed
+	}
+
+	if breachedList != "" {
+		breached, err := loadBreachedPasswords(breachedList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PASSWORD_BREACHED_LIST: %w", err)
+		}
+		policy.Breached = breached
+	}
+	return &policy, nil
+}
+
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			breached[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return breached, nil
+}
+
+// Violations of the policy by the password of the staff member, none when it is accepted.
+// Reuse of former passwords is checked by the service, which holds their hashes.
+func (p *PasswordPolicy) Check(username string, password string) []string {
+	var violations []string
+	if length := len([]rune(password)); length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > MaxPasswordBytes {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", MaxPasswordBytes))
+	}
+	if classes := characterClasses(password); classes < p.MinCharacterClasses {
+		violations = append(violations, fmt.Sprintf("must contain at least %d of lowercase letters, uppercase letters, digits and other characters", p.MinCharacterClasses))
+	}
+	// Shorter usernames are found in too many passwords by chance
+	if len([]rune(username)) >= 3 && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, "must not contain the username")
+	}
+	if _, ok := p.Breached[strings.ToLower(password)]; ok {
+		violations = append(violations, "is a known breached password")
+	}
+	return violations
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+// Password refused by the policy, with every rule it breaks. Wraps ErrWeakPassword.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("%v: password %s", ErrWeakPassword, strings.Join(e.Violations, ", "))
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
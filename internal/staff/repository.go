@@ -1,14 +1,47 @@
 package staff
 
 import (
+	"errors"
+	"time"
+
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Secondary port
 type StaffRepositoryInterface interface {
-	CreateStaff(staff *pkg.Staff) error
+	CreateInvitedStaff(staff *pkg.Staff, invitation *pkg.StaffInvitation) error
+	CreateFirstAdmin(staff *pkg.Staff) error
+	CreateFirstSuperAdmin(staff *pkg.Staff) error
 	GetStaffFromUsername(username string) (*pkg.Staff, error)
+	GetStaffFromEmail(email string) (*pkg.Staff, error)
+	GetInvitationByTokenHash(tokenHash string) (*pkg.StaffInvitation, error)
+	ActivateStaff(invitation *pkg.StaffInvitation, hashedPassword string) error
+	HospitalExists(hospitalID int) (bool, error)
+	GetStaffByID(id int) (*pkg.Staff, error)
+	RevokeToken(token *pkg.RevokedToken) error
+	RevokeStaffSessions(revocation *pkg.StaffSessionRevocation) error
+	IsTokenRevoked(tokenID string, staffID int, familyID string, issuedAt time.Time) (bool, error)
+	CreateRefreshToken(token *pkg.RefreshToken) error
+	RotateRefreshToken(tokenHash string, next *pkg.RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	GetLoginFailures(keys ...string) ([]pkg.LoginFailure, error)
+	RecordLoginFailure(key string, window time.Duration) error
+	ClearLoginFailures(key string) error
+	GetPasswordHistory(staffID int, limit int) ([]pkg.PasswordHistory, error)
+	ChangePassword(staff *pkg.Staff, hashedPassword string, keepHistory int, revocation *pkg.StaffSessionRevocation) error
+	CreatePasswordReset(reset *pkg.PasswordReset, cooldown time.Duration) error
+	GetPasswordResetByTokenHash(tokenHash string) (*pkg.PasswordReset, error)
+	ResetPassword(reset *pkg.PasswordReset, hashedPassword string, keepHistory int, revocation *pkg.StaffSessionRevocation) error
+	GetHospital(hospitalID int) (*pkg.Hospital, error)
+	GetStaffTOTP(staffID int) (*pkg.StaffTOTP, error)
+	StartTOTPEnrollment(totp *pkg.StaffTOTP) error
+	ConfirmTOTPEnrollment(staffID int, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(staffID int, step int64) error
+	UseRecoveryCode(staffID int, codeHash string) error
+	DeleteStaffTOTP(staffID int, revocation *pkg.StaffSessionRevocation) error
 }
 
 // Secondary adapter
@@ -21,18 +54,68 @@ func NewGormStaffRepository(db *gorm.DB) StaffRepositoryInterface {
 	return &GormStaffRepository{db: db}
 }
 
-func (r *GormStaffRepository) CreateStaff(staff *pkg.Staff) error {
-	// Create to staff database
-	if result := r.db.Create(&staff); result.Error != nil {
-		return result.Error
-	}
+// Create a staff member without password together with its invitation
+func (r *GormStaffRepository) CreateInvitedStaff(staff *pkg.Staff, invitation *pkg.StaffInvitation) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Create to staff database without touching the associated hospital
+		if err := tx.Omit(clause.Associations).Create(staff).Error; err != nil {
+			return err
+		}
 
-	/*// Preload the associated hospital
-	if err := r.db.Preload("Hospital").First(&staff, staff.ID).Error; err != nil {
-		return err
-	}*/
+		invitation.StaffID = staff.ID
+		return tx.Omit(clause.Associations).Create(invitation).Error
+	})
 
-	return nil
+	return translateStaffError(err)
+}
+
+// Create the admin of a hospital which has none yet. Concurrent bootstraps of the
+// same hospital are serialized by a transaction-level advisory lock on the hospital ID.
+func (r *GormStaffRepository) CreateFirstAdmin(staff *pkg.Staff) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", staff.HospitalID).Error; err != nil {
+			return err
+		}
+
+		var admins int64
+		if err := tx.Model(&pkg.Staff{}).
+			Where("hospital_id = ? AND role = ?", staff.HospitalID, pkg.RoleAdmin).
+			Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins > 0 {
+			return ErrAdminExists
+		}
+
+		return tx.Omit(clause.Associations).Create(staff).Error
+	})
+
+	return translateStaffError(err)
+}
+
+// Advisory lock key of the super-admin bootstrap, hospital IDs start at 1
+const superAdminLockKey = 0
+
+// Create the super-admin of the platform when there is none yet. It belongs to no hospital.
+func (r *GormStaffRepository) CreateFirstSuperAdmin(staff *pkg.Staff) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", superAdminLockKey).Error; err != nil {
+			return err
+		}
+
+		var superAdmins int64
+		if err := tx.Model(&pkg.Staff{}).Where("role = ?", pkg.RoleSuperAdmin).Count(&superAdmins).Error; err != nil {
+			return err
+		}
+		if superAdmins > 0 {
+			return ErrSuperAdminExists
+		}
+
+		// hospital_id is left NULL
+		return tx.Omit(clause.Associations, "HospitalID").Create(staff).Error
+	})
+
+	return translateStaffError(err)
 }
 
 func (r *GormStaffRepository) GetStaffFromUsername(username string) (*pkg.Staff, error) {
@@ -43,3 +126,420 @@ func (r *GormStaffRepository) GetStaffFromUsername(username string) (*pkg.Staff,
 
 	return &staff, nil
 }
+
+func (r *GormStaffRepository) GetStaffFromEmail(email string) (*pkg.Staff, error) {
+	var staff pkg.Staff
+	if err := r.db.Where("email = ?", email).First(&staff).Error; err != nil {
+		return nil, err
+	}
+
+	return &staff, nil
+}
+
+func (r *GormStaffRepository) GetInvitationByTokenHash(tokenHash string) (*pkg.StaffInvitation, error) {
+	var invitation pkg.StaffInvitation
+	if err := r.db.Preload("Staff").Where("token_hash = ?", tokenHash).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// Set the password of an invited staff member and consume the invitation, so it can be used only once
+func (r *GormStaffRepository) ActivateStaff(invitation *pkg.StaffInvitation, hashedPassword string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&pkg.StaffInvitation{}, invitation.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Model(&pkg.Staff{}).Where("id = ?", invitation.StaffID).Update("password", hashedPassword).Error
+	})
+}
+
+func (r *GormStaffRepository) HospitalExists(hospitalID int) (bool, error) {
+	var count int64
+	if err := r.db.Model(&pkg.Hospital{}).Where("id = ?", hospitalID).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *GormStaffRepository) GetStaffByID(id int) (*pkg.Staff, error) {
+	var staff pkg.Staff
+	if err := r.db.Where("id = ?", id).First(&staff).Error; err != nil {
+		return nil, err
+	}
+
+	return &staff, nil
+}
+
+// Revoke a login token until it expires. Expired revocations are cleaned up on the way.
+func (r *GormStaffRepository) RevokeToken(token *pkg.RevokedToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&pkg.RevokedToken{}).Error; err != nil {
+			return err
+		}
+
+		// Logging out twice with the same token is not an error
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error
+	})
+}
+
+// Revoke the login tokens of a staff member issued before the time of the revocation, and its refresh tokens
+func (r *GormStaffRepository) RevokeStaffSessions(revocation *pkg.StaffSessionRevocation) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return revokeStaffSessions(tx, revocation)
+	})
+}
+
+// Replaces the former revocation of the staff member, whose tokens are all older than the new one
+func revokeStaffSessions(tx *gorm.DB, revocation *pkg.StaffSessionRevocation) error {
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "staff_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before", "except_family_id"}),
+	}).Create(revocation).Error; err != nil {
+		return err
+	}
+
+	query := tx.Model(&pkg.RefreshToken{}).Where("staff_id = ? AND revoked_at IS NULL", revocation.StaffID)
+	if revocation.ExceptFamilyID != "" {
+		query = query.Where("family_id <> ?", revocation.ExceptFamilyID)
+	}
+	return query.Update("revoked_at", time.Now()).Error
+}
+
+// Checked on every authenticated request, in a single query
+func (r *GormStaffRepository) IsTokenRevoked(tokenID string, staffID int, familyID string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := r.db.Raw(`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = ?)
+		OR EXISTS (SELECT 1 FROM staff_session_revocations WHERE staff_id = ? AND revoked_before > ?
+			AND (except_family_id = '' OR except_family_id <> ?))`,
+		tokenID, staffID, issuedAt, familyID).Scan(&revoked).Error
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+// Store the first refresh token of a login. Expired tokens of the staff member are cleaned up on the way.
+func (r *GormStaffRepository) CreateRefreshToken(token *pkg.RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("staff_id = ? AND expires_at < ?", token.StaffID, time.Now()).Delete(&pkg.RefreshToken{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(token).Error
+	})
+}
+
+// Exchange a refresh token for the next one of its family, which gets the staff member, family and authentication
+// methods of the old one.
+// The old token is locked so that it is exchanged once: a token exchanged before is reused, e.g. stolen,
+// so its whole family is revoked and ErrRefreshTokenReused returned.
+func (r *GormStaffRepository) RotateRefreshToken(tokenHash string, next *pkg.RefreshToken) error {
+	reused := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var current pkg.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", tokenHash).First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		now := time.Now()
+		if current.UsedAt != nil {
+			// The revocation is committed, so the error is returned after the transaction
+			reused = true
+			return tx.Model(&pkg.RefreshToken{}).
+				Where("family_id = ? AND revoked_at IS NULL", current.FamilyID).
+				Update("revoked_at", now).Error
+		}
+		if current.RevokedAt != nil || !now.Before(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Model(&current).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		next.StaffID = current.StaffID
+		next.FamilyID = current.FamilyID
+		next.AuthMethods = current.AuthMethods
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return err
+	}
+	if reused {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// Revoke the refresh tokens of a login, e.g. on logout
+func (r *GormStaffRepository) RevokeRefreshTokenFamily(familyID string) error {
+	return r.db.Model(&pkg.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// Failed logins of the keys, those without failures are left out
+func (r *GormStaffRepository) GetLoginFailures(keys ...string) ([]pkg.LoginFailure, error) {
+	var failures []pkg.LoginFailure
+	if err := r.db.Where("key IN ?", keys).Find(&failures).Error; err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// Count a failed login of the key, restarting from one when the previous failure is older than the window.
+// The upsert counts concurrent failures once each. Failures of every key older than the window are cleaned up on the way.
+func (r *GormStaffRepository) RecordLoginFailure(key string, window time.Duration) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Where("last_failure_at < ?", now.Add(-window)).Delete(&pkg.LoginFailure{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`INSERT INTO login_failures (key, failures, last_failure_at) VALUES (?, 1, ?)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_failures.last_failure_at >= ? THEN login_failures.failures + 1 ELSE 1 END,
+				last_failure_at = EXCLUDED.last_failure_at`,
+			key, now, now.Add(-window)).Error
+	})
+}
+
+// Forget the failed logins of the key, e.g. after a successful login or an unlock by an admin
+func (r *GormStaffRepository) ClearLoginFailures(key string) error {
+	return r.db.Where("key = ?", key).Delete(&pkg.LoginFailure{}).Error
+}
+
+// Former password hashes of a staff member, the latest first
+func (r *GormStaffRepository) GetPasswordHistory(staffID int, limit int) ([]pkg.PasswordHistory, error) {
+	var history []pkg.PasswordHistory
+	if err := r.db.Where("staff_id = ?", staffID).Order("created_at DESC, id DESC").Limit(limit).Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Replace the password of a staff member and revoke its sessions. staff.Password is the hash the password was
+// checked against: if another change replaced it in the meantime, nothing is changed and gorm.ErrRecordNotFound
+// returned. The replaced hash joins the history, of which the keepHistory latest hashes are kept.
+func (r *GormStaffRepository) ChangePassword(staff *pkg.Staff, hashedPassword string, keepHistory int, revocation *pkg.StaffSessionRevocation) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replacePassword(tx, staff, hashedPassword, keepHistory, revocation)
+	})
+}
+
+func replacePassword(tx *gorm.DB, staff *pkg.Staff, hashedPassword string, keepHistory int, revocation *pkg.StaffSessionRevocation) error {
+	result := tx.Model(&pkg.Staff{}).
+		Where("id = ? AND password = ?", staff.ID, staff.Password).
+		Update("password", hashedPassword)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	if keepHistory > 0 {
+		if err := tx.Create(&pkg.PasswordHistory{
+			StaffID:      staff.ID,
+			PasswordHash: staff.Password,
+			CreatedAt:    time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Exec(`DELETE FROM password_histories WHERE staff_id = ? AND id NOT IN (
+			SELECT id FROM password_histories WHERE staff_id = ? ORDER BY created_at DESC, id DESC LIMIT ?)`,
+		staff.ID, staff.ID, keepHistory).Error; err != nil {
+		return err
+	}
+
+	return revokeStaffSessions(tx, revocation)
+}
+
+// Store the password reset of a staff member, replacing its pending one unless that one was created less than
+// cooldown ago: ErrPasswordResetTooSoon is returned then, so that a staff member is not flooded with emails.
+// Expired resets are cleaned up on the way.
+func (r *GormStaffRepository) CreatePasswordReset(reset *pkg.PasswordReset, cooldown time.Duration) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&pkg.PasswordReset{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Exec(`INSERT INTO password_resets (staff_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (staff_id) DO UPDATE SET
+				token_hash = EXCLUDED.token_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+			WHERE password_resets.created_at < ?`,
+			reset.StaffID, reset.TokenHash, reset.CreatedAt, reset.ExpiresAt, reset.CreatedAt.Add(-cooldown))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPasswordResetTooSoon
+		}
+		return nil
+	})
+}
+
+func (r *GormStaffRepository) GetPasswordResetByTokenHash(tokenHash string) (*pkg.PasswordReset, error) {
+	var reset pkg.PasswordReset
+	if err := r.db.Preload("Staff").Where("token_hash = ?", tokenHash).First(&reset).Error; err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+// Consume the password reset, so it can be used only once, and replace the password as ChangePassword does.
+// gorm.ErrRecordNotFound is returned when the reset was used or the password changed in the meantime.
+func (r *GormStaffRepository) ResetPassword(reset *pkg.PasswordReset, hashedPassword string, keepHistory int, revocation *pkg.StaffSessionRevocation) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&pkg.PasswordReset{}, reset.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return replacePassword(tx, &reset.Staff, hashedPassword, keepHistory, revocation)
+	})
+}
+
+// Hospital of the staff member, whose settings may require MFA
+func (r *GormStaffRepository) GetHospital(hospitalID int) (*pkg.Hospital, error) {
+	var hospital pkg.Hospital
+	if err := r.db.Where("id = ?", hospitalID).First(&hospital).Error; err != nil {
+		return nil, err
+	}
+
+	return &hospital, nil
+}
+
+func (r *GormStaffRepository) GetStaffTOTP(staffID int) (*pkg.StaffTOTP, error) {
+	var totp pkg.StaffTOTP
+	if err := r.db.Where("staff_id = ?", staffID).First(&totp).Error; err != nil {
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// Store the secret of a pending enrolment, replacing the secret of a former pending enrolment.
+// A confirmed authenticator is kept and ErrMFAAlreadyEnrolled returned.
+func (r *GormStaffRepository) StartTOTPEnrollment(totp *pkg.StaffTOTP) error {
+	result := r.db.Exec(`INSERT INTO staff_totps (staff_id, secret, created_at, last_used_step) VALUES (?, ?, ?, 0)
+		ON CONFLICT (staff_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_used_step = 0
+		WHERE staff_totps.confirmed_at IS NULL`,
+		totp.StaffID, totp.Secret, totp.CreatedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFAAlreadyEnrolled
+	}
+	return nil
+}
+
+// Confirm the pending enrolment with the time step of its first code, replacing the recovery codes.
+// gorm.ErrRecordNotFound is returned when no enrolment is pending.
+func (r *GormStaffRepository) ConfirmTOTPEnrollment(staffID int, step int64, recoveryCodeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&pkg.StaffTOTP{}).
+			Where("staff_id = ? AND confirmed_at IS NULL", staffID).
+			Updates(map[string]interface{}{"confirmed_at": time.Now(), "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("staff_id = ?", staffID).Delete(&pkg.StaffRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]pkg.StaffRecoveryCode, len(recoveryCodeHashes))
+		for i, hash := range recoveryCodeHashes {
+			codes[i] = pkg.StaffRecoveryCode{StaffID: staffID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// Record the time step of a used code, so that its code and those before it are refused.
+// gorm.ErrRecordNotFound is returned when a code of this step or a later one was used in the meantime.
+func (r *GormStaffRepository) UseTOTPStep(staffID int, step int64) error {
+	result := r.db.Model(&pkg.StaffTOTP{}).
+		Where("staff_id = ? AND confirmed_at IS NOT NULL AND last_used_step < ?", staffID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Consume a recovery code, gorm.ErrRecordNotFound when the staff member has no such code
+func (r *GormStaffRepository) UseRecoveryCode(staffID int, codeHash string) error {
+	result := r.db.Where("staff_id = ? AND code_hash = ?", staffID, codeHash).Delete(&pkg.StaffRecoveryCode{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Remove the authenticator and the recovery codes of the staff member, who enrols again,
+// and revoke the sessions it logged in with
+func (r *GormStaffRepository) DeleteStaffTOTP(staffID int, revocation *pkg.StaffSessionRevocation) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("staff_id = ?", staffID).Delete(&pkg.StaffRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("staff_id = ?", staffID).Delete(&pkg.StaffTOTP{}).Error; err != nil {
+			return err
+		}
+		return revokeStaffSessions(tx, revocation)
+	})
+}
+
+// Turn constraint violations of the staffs table into domain errors.
+// The hospital may be deleted between the existence check and the insert, which the foreign key catches.
+func translateStaffError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch {
+	case pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == "staffs_username_key":
+		return ErrDuplicateUsername
+	case pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == "staffs_email_key":
+		return ErrDuplicateEmail
+	case pgErr.Code == pgForeignKeyViolation && pgErr.ConstraintName == "staffs_hospital_id_fkey":
+		return ErrHospitalNotFound
+	default:
+		return err
+	}
+}
+
+// Postgres SQLSTATE of unique_violation and foreign_key_violation
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
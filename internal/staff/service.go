@@ -1,8 +1,13 @@
 package staff
 
 import (
+	"crypto/subtle"
 	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
@@ -11,7 +16,74 @@ import (
 	"gorm.io/gorm"
 )
 
-var ErrUnauthorized = errors.New("unauthorization. Username or password is wrong")
+var (
+	ErrUnauthorized      = errors.New("unauthorization. Username or password is wrong")
+	ErrInvalidInvitation = errors.New("invitation is invalid, already used or expired")
+	ErrBootstrapDisabled = errors.New("bootstrap is disabled")
+	ErrInvalidBootstrap  = errors.New("invalid bootstrap token")
+	ErrAdminExists       = errors.New("the hospital already has an admin")
+	ErrSuperAdminExists  = errors.New("the platform already has a super-admin")
+	ErrInvalidHospital   = errors.New("hospital_id must be a positive integer")
+	ErrHospitalNotFound  = errors.New("hospital not found")
+	ErrDuplicateUsername = errors.New("username is already taken")
+	ErrDuplicateEmail    = errors.New("email is already taken")
+	ErrStaffNotFound     = errors.New("staff not found")
+
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid, revoked or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, every session of this login is revoked")
+
+	ErrLoginThrottled = errors.New("too many failed login attempts")
+	ErrLoginBusy      = errors.New("too many logins in progress, try again later")
+
+	ErrWeakPassword  = errors.New("password does not meet the password policy")
+	ErrWrongPassword = errors.New("current password is wrong")
+
+	ErrPasswordResetDisabled = errors.New("password reset is disabled")
+	ErrInvalidResetToken     = errors.New("password reset token is invalid, already used or expired")
+	ErrPasswordResetTooSoon  = errors.New("a password reset was requested moments ago")
+
+	ErrMFADisabled        = errors.New("multi-factor authentication is disabled")
+	ErrInvalidMFAToken    = errors.New("MFA token is invalid or expired")
+	ErrInvalidMFACode     = errors.New("MFA code is invalid or already used")
+	ErrMFANotEnrolled     = errors.New("no TOTP authenticator is being enrolled")
+	ErrMFAAlreadyEnrolled = errors.New("a TOTP authenticator is already enrolled")
+)
+
+// How long an invited staff member has to set a password
+const InvitationTTL = 72 * time.Hour
+
+// How long an emailed password reset token is valid, and how often a staff member can be sent one
+const (
+	PasswordResetTTL      = 30 * time.Minute
+	PasswordResetCooldown = time.Minute
+)
+
+// Access tokens are short-lived and renewed with the refresh token, which lasts a shift and more
+// as long as it is used: every refresh extends it
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// Tokens of a login or a refresh
+type TokenPair struct {
+	AccessToken           string
+	AccessTokenExpiresAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+// bcrypt cost of the stored passwords, and of the dummy hash compared for unknown usernames
+const PasswordHashCost = bcrypt.DefaultCost
+
+// Hash of a random password with the cost of the stored ones
+func NewDummyPasswordHash() ([]byte, error) {
+	password, _, err := pkg.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+}
 
 // Additional interface to mock for testing
 // PasswordHasher is an interface that wraps bcrypt.CompareHashAndPassword.
@@ -30,85 +102,992 @@ func (b *BcryptHasher) CompareHashAndPassword(hashedPassword []byte, password []
 
 // Primary port
 type StaffServiceInterface interface {
-	CreateStaff(staff *pkg.Staff) (*pkg.Staff, error)
-	SignInStaff(staff *pkg.Staff) (string, error)
+	InviteStaff(staff *pkg.Staff) (*pkg.StaffInvitation, error)
+	ActivateStaff(token string, password string) (*pkg.Staff, error)
+	BootstrapAdmin(staff *pkg.Staff, bootstrapToken string) (*pkg.Staff, error)
+	BootstrapSuperAdmin(staff *pkg.Staff, bootstrapToken string) (*pkg.Staff, error)
+	SignInStaff(staff *pkg.Staff, clientIP string) (*TokenPair, *MFAChallenge, error)
+	VerifyMFA(mfaToken string, code string, clientIP string) (*TokenPair, []string, error)
+	StartLoginTOTPEnrollment(mfaToken string) (*TOTPEnrollment, error)
+	RefreshToken(refreshToken string) (*TokenPair, error)
+	SignOutStaff(tokenID string, familyID string, expiresAt time.Time) error
+	RevokeStaffSessions(hospitalID int, staffID int) error
+	UnlockStaff(hospitalID int, staffID int) error
+	ChangePassword(staffID int, familyID string, currentPassword string, newPassword string) error
+	RequestPasswordReset(email string) error
+	ResetPassword(token string, newPassword string) error
+	StartTOTPEnrollment(staffID int, password string) (*TOTPEnrollment, error)
+	ConfirmTOTPEnrollment(staffID int, code string) ([]string, error)
+	ResetStaffMFA(hospitalID int, staffID int) error
 }
 
 type StaffService struct {
-	Repo            StaffRepositoryInterface
-	PasswordHasher  PasswordHasher
-	CreateTokenFunc func(staff *pkg.Staff) (string, error)
+	Repo           StaffRepositoryInterface
+	PasswordHasher PasswordHasher
+	// Compared for unknown usernames, made with the service so that the first login costs the same as the others
+	DummyPasswordHash []byte
+	CreateTokenFunc   func(staff *pkg.Staff, session LoginSession, expiresAt time.Time) (string, error)
+	BootstrapToken    string          // empty disables bootstrapping
+	PasswordPolicy    *PasswordPolicy // nil applies the zero policy
+
+	// Signs the MFA tokens of logins waiting for their code
+	Keys *pkg.KeyRing
+	// TOTP logins, nil disables MFA
+	MFA *MFAConfig
+
+	// Sends password reset tokens, nil disables password resets
+	Mailer pkg.Mailer
+	// Page of the client resetting passwords, linked in the email with the token in the token query parameter
+	PasswordResetURL string
+
+	// Failed logins throttled per username and per client IP
+	UsernameThrottle LoginThrottlePolicy
+	IPThrottle       LoginThrottlePolicy
 }
 
-func NewStaffService(repo StaffRepositoryInterface) StaffServiceInterface {
+// Login tokens are signed with the active key of the key ring
+func NewStaffService(repo StaffRepositoryInterface, keys *pkg.KeyRing, passwordPolicy *PasswordPolicy, mailer pkg.Mailer, mfa *MFAConfig) (StaffServiceInterface, error) {
+	dummyPasswordHash, err := NewDummyPasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
 	return &StaffService{
-		Repo:            repo,
-		PasswordHasher:  &BcryptHasher{},
-		CreateTokenFunc: createToken,
+		Repo:              repo,
+		PasswordHasher:    NewBoundedHasher(&BcryptHasher{}, DefaultPasswordHashWorkers()),
+		DummyPasswordHash: dummyPasswordHash,
+		CreateTokenFunc:   tokenCreator(keys),
+		BootstrapToken:    os.Getenv("BOOTSTRAP_TOKEN"),
+		PasswordPolicy:    passwordPolicy,
+		Keys:              keys,
+		MFA:               mfa,
+		Mailer:            mailer,
+		PasswordResetURL:  os.Getenv("PASSWORD_RESET_URL"),
+		UsernameThrottle:  UsernameThrottlePolicy,
+		IPThrottle:        IPThrottlePolicy,
+	}, nil
+}
+
+// Create a staff member without password and the invitation it activates its account with
+func (s *StaffService) InviteStaff(staff *pkg.Staff) (*pkg.StaffInvitation, error) {
+	if err := s.checkHospital(staff.HospitalID); err != nil {
+		return nil, err
+	}
+
+	token, tokenHash, err := pkg.NewOpaqueToken()
+	if err != nil {
+		return nil, err
 	}
+
+	// The password is only ever set by the invited staff member
+	staff.ID = 0
+	staff.Password = ""
+	staff.Email = normalizeEmail(staff.Email)
+
+	invitation := &pkg.StaffInvitation{
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(InvitationTTL),
+		Token:     token,
+	}
+	if err := s.Repo.CreateInvitedStaff(staff, invitation); err != nil {
+		return nil, err
+	}
+
+	invitation.Staff = *staff
+	return invitation, nil
 }
 
-func (s *StaffService) CreateStaff(staff *pkg.Staff) (*pkg.Staff, error) {
+// Set the password of an invited staff member, consuming the invitation
+func (s *StaffService) ActivateStaff(token string, password string) (*pkg.Staff, error) {
+	invitation, err := s.Repo.GetInvitationByTokenHash(pkg.HashOpaqueToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrInvalidInvitation
+	}
+	if err := s.checkPasswordPolicy(invitation.Staff.Username, password); err != nil {
+		return nil, err
+	}
+
 	// encrypt password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(staff.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
 	if err != nil {
 		return nil, err
 	}
 
-	// re-assign user password before saving in database
+	// Another request may have used the invitation in the meantime
+	if err := s.Repo.ActivateStaff(invitation, string(hashedPassword)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+
+	invitation.Staff.Password = string(hashedPassword)
+	return &invitation.Staff, nil
+}
+
+// Create the first admin of a hospital, authorized by the bootstrap token of the deployment
+func (s *StaffService) BootstrapAdmin(staff *pkg.Staff, bootstrapToken string) (*pkg.Staff, error) {
+	if err := s.checkBootstrapToken(bootstrapToken); err != nil {
+		return nil, err
+	}
+	if err := s.checkHospital(staff.HospitalID); err != nil {
+		return nil, err
+	}
+	if err := s.checkPasswordPolicy(staff.Username, staff.Password); err != nil {
+		return nil, err
+	}
+
+	// encrypt password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(staff.Password), PasswordHashCost)
+	if err != nil {
+		return nil, err
+	}
+
+	staff.ID = 0
+	staff.Password = string(hashedPassword)
+	staff.Role = pkg.RoleAdmin
+	staff.Email = normalizeEmail(staff.Email)
+
+	if err := s.Repo.CreateFirstAdmin(staff); err != nil {
+		return nil, err
+	}
+
+	return staff, nil
+}
+
+// Create the super-admin of the platform, who manages hospitals, authorized by the bootstrap token of the deployment
+func (s *StaffService) BootstrapSuperAdmin(staff *pkg.Staff, bootstrapToken string) (*pkg.Staff, error) {
+	if err := s.checkBootstrapToken(bootstrapToken); err != nil {
+		return nil, err
+	}
+	if err := s.checkPasswordPolicy(staff.Username, staff.Password); err != nil {
+		return nil, err
+	}
+
+	// encrypt password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(staff.Password), PasswordHashCost)
+	if err != nil {
+		return nil, err
+	}
+
+	staff.ID = 0
 	staff.Password = string(hashedPassword)
+	staff.Role = pkg.RoleSuperAdmin
+	staff.HospitalID = 0
+	staff.Email = normalizeEmail(staff.Email)
 
-	if err := s.Repo.CreateStaff(staff); err != nil {
+	if err := s.Repo.CreateFirstSuperAdmin(staff); err != nil {
 		return nil, err
 	}
 
 	return staff, nil
 }
 
-func (s *StaffService) SignInStaff(staff *pkg.Staff) (string, error) {
+func (s *StaffService) checkBootstrapToken(bootstrapToken string) error {
+	if s.BootstrapToken == "" {
+		return ErrBootstrapDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(bootstrapToken), []byte(s.BootstrapToken)) != 1 {
+		return ErrInvalidBootstrap
+	}
+	return nil
+}
+
+// PasswordPolicyError listing the rules the password breaks, nil when it is accepted
+func (s *StaffService) checkPasswordPolicy(username string, password string) error {
+	policy := s.PasswordPolicy
+	if policy == nil {
+		policy = &PasswordPolicy{}
+	}
+	if violations := policy.Check(username, password); len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Staff can only be created in an existing hospital
+func (s *StaffService) checkHospital(hospitalID int) error {
+	if hospitalID <= 0 {
+		return ErrInvalidHospital
+	}
+
+	exists, err := s.Repo.HospitalExists(hospitalID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrHospitalNotFound
+	}
+
+	return nil
+}
+
+// Usernames and clients failing too often are refused before their password is checked,
+// until the delay of their last failure passed. Staff with a TOTP authenticator, or whose role has to use one,
+// get an MFAChallenge instead of the login tokens.
+func (s *StaffService) SignInStaff(staff *pkg.Staff, clientIP string) (*TokenPair, *MFAChallenge, error) {
+	throttleKeys := s.loginThrottleKeys(staff.Username, clientIP)
+	if err := s.checkLoginThrottle(throttleKeys); err != nil {
+		return nil, nil, err
+	}
+
 	// Retrieve user by email
 	selectedStaffByEmail, err := s.Repo.GetStaffFromUsername(staff.Username)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+	// Invited staff cannot log in before setting their password
+	canSignIn := err == nil && selectedStaffByEmail.Password != ""
+
+	// Unknown usernames and invited staff are compared with a dummy hash: they take as long as
+	// a wrong password, so that timing does not tell which usernames exist
+	hashedPassword := s.DummyPasswordHash
+	if canSignIn {
+		hashedPassword = []byte(selectedStaffByEmail.Password)
+	}
+
+	// Compare the provided password with the hash stored in the database
+	if err := s.PasswordHasher.CompareHashAndPassword(hashedPassword, []byte(staff.Password)); err != nil || !canSignIn {
+		if errors.Is(err, ErrLoginBusy) {
+			return nil, nil, err
+		}
+		// Unknown usernames are throttled too, so that they do not stand out
+		return nil, nil, s.loginFailed(throttleKeys)
+	}
+
+	// The failures of the client are kept, so that a valid login does not reset the guesses of other usernames
+	if s.UsernameThrottle.enabled() {
+		if err := s.Repo.ClearLoginFailures(usernameThrottleKey(staff.Username)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	challenge, err := s.mfaChallenge(selectedStaffByEmail)
+	if err != nil || challenge != nil {
+		return nil, challenge, err
+	}
+
+	// Success Sign In
+	tokens, err := s.startLogin(selectedStaffByEmail, LoginSession{AuthMethods: []string{pkg.AuthMethodPassword}})
+	return tokens, nil, err
+}
+
+// Challenge of the staff member who has a TOTP authenticator, or whose role has to enrol one, nil otherwise
+func (s *StaffService) mfaChallenge(staff *pkg.Staff) (*MFAChallenge, error) {
+	if s.MFA == nil {
+		return nil, nil
+	}
+
+	totp, err := s.Repo.GetStaffTOTP(staff.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	enrolled := err == nil && totp.ConfirmedAt != nil
+	if !enrolled {
+		required, err := s.mfaRequired(staff)
+		if err != nil || !required {
+			return nil, err
+		}
+	}
+
+	expiresAt := time.Now().Add(MFATokenTTL)
+	token, err := newMFAToken(s.Keys, staff.ID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAChallenge{Token: token, ExpiresAt: expiresAt, EnrollmentRequired: !enrolled}, nil
+}
+
+// Whether the role of the staff member has to log in with MFA, everywhere or in its hospital
+func (s *StaffService) mfaRequired(staff *pkg.Staff) (bool, error) {
+	if s.MFA == nil {
+		return false, nil
+	}
+	if slices.Contains(s.MFA.RequiredRoles, staff.Role) {
+		return true, nil
+	}
+	// The super-admin belongs to no hospital
+	if staff.HospitalID <= 0 {
+		return false, nil
+	}
+
+	hospital, err := s.Repo.GetHospital(staff.HospitalID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", ErrUnauthorized
-		} else {
-			return "", err
+			return false, nil
 		}
+		return false, err
 	}
+	return slices.Contains(hospital.Settings.MFARequiredRoles, staff.Role), nil
+}
 
-	// Compare the provided password with the hash stored in the database
-	if err := s.PasswordHasher.CompareHashAndPassword([]byte(selectedStaffByEmail.Password), []byte(staff.Password)); err != nil {
-		return "", ErrUnauthorized
+// Start a new family of refresh tokens for the authenticated staff member, and its first tokens
+func (s *StaffService) startLogin(staff *pkg.Staff, session LoginSession) (*TokenPair, error) {
+	familyID, _, err := pkg.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshTokenHash, err := pkg.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	stored := &pkg.RefreshToken{
+		StaffID:     staff.ID,
+		FamilyID:    familyID,
+		TokenHash:   refreshTokenHash,
+		ExpiresAt:   time.Now().Add(RefreshTokenTTL),
+		AuthMethods: strings.Join(session.AuthMethods, " "),
+	}
+	if err := s.Repo.CreateRefreshToken(stored); err != nil {
+		return nil, err
+	}
+
+	return s.newTokenPair(staff, refreshToken, stored, session.MFARequired)
+}
+
+// Complete the login of an MFA token with a code of the authenticator or a recovery code. A pending
+// enrolment is confirmed by its first code, and the recovery codes are returned with the tokens.
+// Wrong codes are throttled like wrong passwords.
+func (s *StaffService) VerifyMFA(mfaToken string, code string, clientIP string) (*TokenPair, []string, error) {
+	pending, staff, err := s.mfaTokenStaff(mfaToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	throttleKeys := s.loginThrottleKeys(staff.Username, clientIP)
+	if err := s.checkLoginThrottle(throttleKeys); err != nil {
+		return nil, nil, err
+	}
+
+	totp, err := s.Repo.GetStaffTOTP(staff.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrMFANotEnrolled
+		}
+		return nil, nil, err
+	}
+
+	var recoveryCodes []string
+	if totp.ConfirmedAt == nil {
+		recoveryCodes, err = s.confirmTOTP(totp, code)
+	} else {
+		err = s.useMFACode(totp, code)
+	}
+	if errors.Is(err, ErrInvalidMFACode) {
+		if err := s.loginFailed(throttleKeys); !errors.Is(err, ErrUnauthorized) {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidMFACode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.UsernameThrottle.enabled() {
+		if err := s.Repo.ClearLoginFailures(usernameThrottleKey(staff.Username)); err != nil {
+			return nil, nil, err
+		}
+	}
+	// The MFA token completes a single login
+	if err := s.Repo.RevokeToken(&pkg.RevokedToken{TokenID: pending.TokenID, ExpiresAt: pending.ExpiresAt}); err != nil {
+		return nil, nil, err
+	}
+
+	required, err := s.mfaRequired(staff)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokens, err := s.startLogin(staff, LoginSession{
+		AuthMethods: []string{pkg.AuthMethodPassword, pkg.AuthMethodOTP, pkg.AuthMethodMFA},
+		MFARequired: required,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, recoveryCodes, nil
+}
+
+// Start the TOTP enrolment of a login whose role has to use MFA, before its first code
+func (s *StaffService) StartLoginTOTPEnrollment(mfaToken string) (*TOTPEnrollment, error) {
+	_, staff, err := s.mfaTokenStaff(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startTOTPEnrollment(staff)
+}
+
+// Pending MFA token and its staff member. Tokens revoked with the sessions of the staff member,
+// or already used, are invalid.
+func (s *StaffService) mfaTokenStaff(mfaToken string) (*mfaToken, *pkg.Staff, error) {
+	if s.MFA == nil {
+		return nil, nil, ErrMFADisabled
+	}
+
+	pending, err := parseMFAToken(s.Keys, mfaToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	revoked, err := s.Repo.IsTokenRevoked(pending.TokenID, pending.StaffID, "", pending.IssuedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, ErrInvalidMFAToken
+	}
+
+	staff, err := s.Repo.GetStaffByID(pending.StaffID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidMFAToken
+		}
+		return nil, nil, err
+	}
+	return pending, staff, nil
+}
+
+// Start the TOTP enrolment of the logged in staff member, after checking its password as ChangePassword does.
+// The enrolment is confirmed by ConfirmTOTPEnrollment; the next logins need a code.
+func (s *StaffService) StartTOTPEnrollment(staffID int, password string) (*TOTPEnrollment, error) {
+	if s.MFA == nil {
+		return nil, ErrMFADisabled
+	}
+
+	staff, err := s.Repo.GetStaffByID(staffID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrStaffNotFound
+		}
+		return nil, err
+	}
+	// A stolen session cannot bind the account to another authenticator
+	if err := s.checkCurrentPassword(staff, password); err != nil {
+		return nil, err
+	}
+
+	return s.startTOTPEnrollment(staff)
+}
+
+// New secret replacing the one of a pending enrolment. Staff with a confirmed authenticator get ErrMFAAlreadyEnrolled.
+func (s *StaffService) startTOTPEnrollment(staff *pkg.Staff) (*TOTPEnrollment, error) {
+	secret, err := pkg.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.MFA.Secrets.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Repo.StartTOTPEnrollment(&pkg.StaffTOTP{StaffID: staff.ID, Secret: sealed, CreatedAt: time.Now()}); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    pkg.TOTPProvisioningURI(s.MFA.Issuer, staff.Username, secret),
+	}, nil
+}
+
+// Confirm the pending TOTP enrolment of the logged in staff member with a first code, returning its recovery codes
+func (s *StaffService) ConfirmTOTPEnrollment(staffID int, code string) ([]string, error) {
+	if s.MFA == nil {
+		return nil, ErrMFADisabled
+	}
+
+	totp, err := s.Repo.GetStaffTOTP(staffID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, err
+	}
+	if totp.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	return s.confirmTOTP(totp, code)
+}
+
+// Confirm the pending enrolment with a code of its secret, replacing the recovery codes.
+// ErrInvalidMFACode is returned for a wrong code.
+func (s *StaffService) confirmTOTP(totp *pkg.StaffTOTP, code string) ([]string, error) {
+	secret, err := s.MFA.Secrets.Open(totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := pkg.VerifyTOTP(secret, code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	// Another request may have confirmed the enrolment in the meantime
+	if err := s.Repo.ConfirmTOTPEnrollment(totp.StaffID, step, hashes); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidMFACode
+		}
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Consume a code of the confirmed authenticator, or a recovery code. ErrInvalidMFACode is returned
+// for a wrong code, and for a code of the authenticator used before.
+func (s *StaffService) useMFACode(totp *pkg.StaffTOTP, code string) error {
+	var err error
+	if isTOTPCode(code) {
+		secret, openErr := s.MFA.Secrets.Open(totp.Secret)
+		if openErr != nil {
+			return openErr
+		}
+		step, ok := pkg.VerifyTOTP(secret, code, time.Now(), totp.LastUsedStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		err = s.Repo.UseTOTPStep(totp.StaffID, step)
+	} else {
+		err = s.Repo.UseRecoveryCode(totp.StaffID, pkg.HashOpaqueToken(normalizeRecoveryCode(code)))
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidMFACode
+	}
+	return err
+}
+
+// Remove the authenticator of a staff member of the admin's hospital who lost it and its recovery codes,
+// and revoke every login token issued until now, as whoever holds the authenticator may be logged in.
+// Whose role has to use MFA enrols again at the next login.
+func (s *StaffService) ResetStaffMFA(hospitalID int, staffID int) error {
+	staff, err := s.Repo.GetStaffByID(staffID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrStaffNotFound
+		}
+		return err
+	}
+	// Staff of other hospitals are not disclosed
+	if staff.HospitalID != hospitalID {
+		return ErrStaffNotFound
+	}
+
+	return s.Repo.DeleteStaffTOTP(staffID, &pkg.StaffSessionRevocation{
+		StaffID:       staffID,
+		RevokedBefore: sessionRevocationCutoff(),
+	})
+}
+
+// Exchange a refresh token for new access and refresh tokens. A refresh token can be used once.
+func (s *StaffService) RefreshToken(refreshToken string) (*TokenPair, error) {
+	nextToken, nextTokenHash, err := pkg.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	next := &pkg.RefreshToken{
+		TokenHash: nextTokenHash,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := s.Repo.RotateRefreshToken(pkg.HashOpaqueToken(refreshToken), next); err != nil {
+		return nil, err
+	}
+
+	// The access token carries the current role and hospital of the staff member, and whether they require MFA:
+	// a login without MFA then loses access to the routes requiring it
+	staff, err := s.Repo.GetStaffByID(next.StaffID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := s.mfaRequired(staff)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newTokenPair(staff, nextToken, next, required)
+}
+
+func (s *StaffService) newTokenPair(staff *pkg.Staff, refreshToken string, stored *pkg.RefreshToken, mfaRequired bool) (*TokenPair, error) {
+	session := LoginSession{
+		FamilyID:    stored.FamilyID,
+		AuthMethods: strings.Fields(stored.AuthMethods),
+		MFARequired: mfaRequired,
+	}
+	// Logins before MFA used a password
+	if len(session.AuthMethods) == 0 {
+		session.AuthMethods = []string{pkg.AuthMethodPassword}
 	}
 
 	// Create JWT token for the authenticated staff
-	token, err := s.CreateTokenFunc(selectedStaffByEmail)
+	accessTokenExpiresAt := time.Now().Add(AccessTokenTTL)
+	accessToken, err := s.CreateTokenFunc(staff, session, accessTokenExpiresAt)
 	if err != nil {
-		return "", errors.New("error creating token")
+		return nil, errors.New("error creating token")
 	}
 
-	// Success Sign In
-	return token, nil
+	return &TokenPair{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessTokenExpiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+// Revoke the login token, so that it cannot be used anymore even if it was stolen, and the refresh tokens of the login
+func (s *StaffService) SignOutStaff(tokenID string, familyID string, expiresAt time.Time) error {
+	if err := s.Repo.RevokeToken(&pkg.RevokedToken{TokenID: tokenID, ExpiresAt: expiresAt}); err != nil {
+		return err
+	}
+
+	// Tokens issued before refresh tokens belong to no family
+	if familyID == "" {
+		return nil
+	}
+	return s.Repo.RevokeRefreshTokenFamily(familyID)
 }
 
-func createToken(staff *pkg.Staff) (string, error) {
-	// Create the Claims
-	claims := jwt.MapClaims{}
-	claims["authorized"] = true
-	claims["staff_id"] = staff.ID
-	claims["staff_name"] = staff.Username
-	claims["staff_hospital_id"] = staff.HospitalID
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix() // Token expiration 1 hour from now
+// Revoke every login token of a staff member of the admin's hospital issued until now
+func (s *StaffService) RevokeStaffSessions(hospitalID int, staffID int) error {
+	staff, err := s.Repo.GetStaffByID(staffID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrStaffNotFound
+		}
+		return err
+	}
+	// Staff of other hospitals are not disclosed
+	if staff.HospitalID != hospitalID {
+		return ErrStaffNotFound
+	}
+
+	return s.Repo.RevokeStaffSessions(&pkg.StaffSessionRevocation{
+		StaffID:       staffID,
+		RevokedBefore: sessionRevocationCutoff(),
+	})
+}
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// Time before which the sessions of a revocation were issued. Tokens carry their issue time
+// in seconds: those issued during the current second are revoked too.
+func sessionRevocationCutoff() time.Time {
+	return time.Now().Truncate(time.Second).Add(time.Second)
+}
 
-	// Generate encoded token and send it in response. (t is token)
-	jwtSecret := os.Getenv("JWT_SECRET")
-	tokenString, err := token.SignedString([]byte(jwtSecret)) // <- Secret key (keep this safe!)
+// Forget the failed logins of a staff member of the admin's hospital, ending its lockout
+func (s *StaffService) UnlockStaff(hospitalID int, staffID int) error {
+	staff, err := s.Repo.GetStaffByID(staffID)
 	if err != nil {
-		return "", err
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrStaffNotFound
+		}
+		return err
+	}
+	// Staff of other hospitals are not disclosed
+	if staff.HospitalID != hospitalID {
+		return ErrStaffNotFound
+	}
+
+	return s.Repo.ClearLoginFailures(usernameThrottleKey(staff.Username))
+}
+
+// Replace the password of the staff member after checking its current password, which is throttled like a login.
+// Every other session of the staff member is revoked, the login familyID of the change stays signed in.
+func (s *StaffService) ChangePassword(staffID int, familyID string, currentPassword string, newPassword string) error {
+	staff, err := s.Repo.GetStaffByID(staffID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrStaffNotFound
+		}
+		return err
 	}
 
-	return tokenString, nil
+	if err := s.checkCurrentPassword(staff, currentPassword); err != nil {
+		return err
+	}
+
+	if err := s.checkPasswordPolicy(staff.Username, newPassword); err != nil {
+		return err
+	}
+	if err := s.checkPasswordReuse(staff, newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), PasswordHashCost)
+	if err != nil {
+		return err
+	}
+
+	revocation := &pkg.StaffSessionRevocation{
+		StaffID:        staff.ID,
+		RevokedBefore:  sessionRevocationCutoff(),
+		ExceptFamilyID: familyID,
+	}
+	// Another change may have replaced the password since it was checked
+	if err := s.Repo.ChangePassword(staff, string(hashedPassword), s.passwordHistoryKept(), revocation); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWrongPassword
+		}
+		return err
+	}
+	return nil
+}
+
+// ErrWrongPassword unless the password is the current one of the logged in staff member.
+// A stolen session does not get more guesses than the login form.
+func (s *StaffService) checkCurrentPassword(staff *pkg.Staff, password string) error {
+	throttleKeys := s.loginThrottleKeys(staff.Username, "")
+	if err := s.checkLoginThrottle(throttleKeys); err != nil {
+		return err
+	}
+	if err := s.PasswordHasher.CompareHashAndPassword([]byte(staff.Password), []byte(password)); err != nil {
+		if errors.Is(err, ErrLoginBusy) {
+			return err
+		}
+		if err := s.loginFailed(throttleKeys); !errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// Former passwords kept in the history, the current one becoming the latest of them
+func (s *StaffService) passwordHistoryKept() int {
+	if s.PasswordPolicy == nil {
+		return 0
+	}
+	return max(s.PasswordPolicy.HistorySize-1, 0)
+}
+
+// Email a single-use password reset token to the staff member of the email. Unknown emails, staff who have not
+// set a password yet and repeated requests within PasswordResetCooldown get no email but no error either, so that
+// the response does not tell which emails belong to staff.
+func (s *StaffService) RequestPasswordReset(email string) error {
+	if s.Mailer == nil {
+		return ErrPasswordResetDisabled
+	}
+
+	staff, err := s.Repo.GetStaffFromEmail(normalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	// Invited staff set their password with their invitation
+	if staff.Password == "" {
+		return nil
+	}
+
+	token, tokenHash, err := pkg.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	reset := &pkg.PasswordReset{
+		StaffID:   staff.ID,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(PasswordResetTTL),
+	}
+	if err := s.Repo.CreatePasswordReset(reset, PasswordResetCooldown); err != nil {
+		if errors.Is(err, ErrPasswordResetTooSoon) {
+			return nil
+		}
+		return err
+	}
+
+	subject, body := s.passwordResetEmail(staff, token)
+	return s.Mailer.Send(staff.Email, subject, body)
+}
+
+func (s *StaffService) passwordResetEmail(staff *pkg.Staff, token string) (string, string) {
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"A password reset was requested for your account. Use this token within %d minutes to set a new password:\n\n%s\n\n",
+		staff.Username, int(PasswordResetTTL.Minutes()), token)
+	if link, err := url.Parse(s.PasswordResetURL); err == nil && s.PasswordResetURL != "" {
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		body += "Or open " + link.String() + "\n\n"
+	}
+	body += "If you did not request it, ignore this email: your password stays unchanged.\n"
+	return "Reset your password", body
+}
+
+// Set a new password with an emailed reset token, consuming it. Every session of the staff member is revoked
+// and the lockout of its username, if any, ends.
+func (s *StaffService) ResetPassword(token string, newPassword string) error {
+	reset, err := s.Repo.GetPasswordResetByTokenHash(pkg.HashOpaqueToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if time.Now().After(reset.ExpiresAt) || reset.Staff.Password == "" {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.checkPasswordPolicy(reset.Staff.Username, newPassword); err != nil {
+		return err
+	}
+	if err := s.checkPasswordReuse(&reset.Staff, newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), PasswordHashCost)
+	if err != nil {
+		return err
+	}
+
+	revocation := &pkg.StaffSessionRevocation{
+		StaffID:       reset.StaffID,
+		RevokedBefore: sessionRevocationCutoff(),
+	}
+	// Another request may have used the reset or changed the password in the meantime
+	if err := s.Repo.ResetPassword(reset, string(hashedPassword), s.passwordHistoryKept(), revocation); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if s.UsernameThrottle.enabled() {
+		return s.Repo.ClearLoginFailures(usernameThrottleKey(reset.Staff.Username))
+	}
+	return nil
+}
+
+// Emails are compared lowercase
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// The new password may not be the current one or one of the former ones the policy remembers
+func (s *StaffService) checkPasswordReuse(staff *pkg.Staff, newPassword string) error {
+	if s.PasswordPolicy == nil || s.PasswordPolicy.HistorySize == 0 {
+		return nil
+	}
+
+	hashes := []string{staff.Password}
+	if s.PasswordPolicy.HistorySize > 1 {
+		history, err := s.Repo.GetPasswordHistory(staff.ID, s.PasswordPolicy.HistorySize-1)
+		if err != nil {
+			return err
+		}
+		for _, former := range history {
+			hashes = append(hashes, former.PasswordHash)
+		}
+	}
+
+	for _, hash := range hashes {
+		err := s.PasswordHasher.CompareHashAndPassword([]byte(hash), []byte(newPassword))
+		if err == nil {
+			return &PasswordPolicyError{Violations: []string{
+				fmt.Sprintf("must not be one of the last %d passwords", s.PasswordPolicy.HistorySize),
+			}}
+		}
+		if errors.Is(err, ErrLoginBusy) {
+			return err
+		}
+	}
+	return nil
+}
+
+func usernameThrottleKey(username string) string {
+	return "username:" + username
+}
+
+// Failures of a login are counted under a key for the username and one for the client
+type loginThrottleKey struct {
+	key    string
+	policy LoginThrottlePolicy
+}
+
+// Failure keys of a login, without those of disabled policies
+func (s *StaffService) loginThrottleKeys(username string, clientIP string) []loginThrottleKey {
+	var keys []loginThrottleKey
+	if s.UsernameThrottle.enabled() {
+		keys = append(keys, loginThrottleKey{key: usernameThrottleKey(username), policy: s.UsernameThrottle})
+	}
+	if s.IPThrottle.enabled() && clientIP != "" {
+		keys = append(keys, loginThrottleKey{key: "ip:" + clientIP, policy: s.IPThrottle})
+	}
+	return keys
+}
+
+func (s *StaffService) checkLoginThrottle(keys []loginThrottleKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.key
+	}
+	failures, err := s.Repo.GetLoginFailures(names...)
+	if err != nil {
+		return err
+	}
+
+	// The latest of the retry times of the username and the client
+	var retryAt time.Time
+	for i := range failures {
+		for _, key := range keys {
+			if key.key != failures[i].Key {
+				continue
+			}
+			if at := key.policy.retryAt(&failures[i]); at.After(retryAt) {
+				retryAt = at
+			}
+		}
+	}
+	if wait := time.Until(retryAt); wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Count the failed login against the username and the client, ErrUnauthorized once counted
+func (s *StaffService) loginFailed(keys []loginThrottleKey) error {
+	for _, key := range keys {
+		if err := s.Repo.RecordLoginFailure(key.key, LoginFailureWindow); err != nil {
+			return err
+		}
+	}
+	return ErrUnauthorized
+}
+
+func tokenCreator(keys *pkg.KeyRing) func(staff *pkg.Staff, session LoginSession, expiresAt time.Time) (string, error) {
+	return func(staff *pkg.Staff, session LoginSession, expiresAt time.Time) (string, error) {
+		// ID of the token, with which it is revoked
+		tokenID, _, err := pkg.NewOpaqueToken()
+		if err != nil {
+			return "", err
+		}
+
+		// Create the Claims
+		claims := jwt.MapClaims{}
+		claims["jti"] = tokenID
+		claims["iat"] = time.Now().Unix()
+		claims["authorized"] = true
+		claims["staff_id"] = staff.ID
+		claims["staff_name"] = staff.Username
+		claims["staff_role"] = staff.Role
+		claims["staff_hospital_id"] = staff.HospitalID
+		claims["fid"] = session.FamilyID // refresh token family of the login
+		claims["amr"] = session.AuthMethods
+		claims["mfa_required"] = session.MFARequired
+		claims["exp"] = expiresAt.Unix()
+
+		// Signed with the active key, named in the kid header
+		return keys.Sign(claims)
+	}
 }
@@ -0,0 +1,105 @@
+package staff
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+)
+
+// Failed logins are forgotten this long after the last one, which is also how long a lockout lasts
+const LoginFailureWindow = 15 * time.Minute
+
+// Wait imposed after the first failure beyond the free attempts, doubled by every further failure
+const (
+	LoginBaseDelay = time.Second
+	LoginMaxDelay  = 30 * time.Second
+)
+
+// How long a login waits for a free password hash worker before being refused
+const PasswordHashQueueTimeout = 2 * time.Second
+
+// How many failed logins in a row are tolerated before delays, and before a lockout.
+// The zero policy disables throttling.
+type LoginThrottlePolicy struct {
+	FreeAttempts     int
+	LockoutThreshold int
+}
+
+var (
+	// A staff member mistyping its password is not slowed down, a guesser is locked out quickly
+	UsernameThrottlePolicy = LoginThrottlePolicy{FreeAttempts: 3, LockoutThreshold: 10}
+	// Clients behind a shared address, e.g. the network of a hospital, get more attempts
+	IPThrottlePolicy = LoginThrottlePolicy{FreeAttempts: 20, LockoutThreshold: 100}
+)
+
+func (p LoginThrottlePolicy) enabled() bool {
+	return p.LockoutThreshold > 0
+}
+
+// Time from which the next login is allowed after the failures, zero when it is allowed now
+func (p LoginThrottlePolicy) retryAt(failure *pkg.LoginFailure) time.Time {
+	if !p.enabled() || failure == nil || failure.Failures < p.FreeAttempts {
+		return time.Time{}
+	}
+	if failure.Failures >= p.LockoutThreshold {
+		return failure.LastFailureAt.Add(LoginFailureWindow)
+	}
+
+	delay := LoginBaseDelay
+	for i := p.FreeAttempts; i < failure.Failures && delay < LoginMaxDelay; i++ {
+		delay *= 2
+	}
+	return failure.LastFailureAt.Add(min(delay, LoginMaxDelay))
+}
+
+// Login refused before checking the password, as the username or the client failed too often.
+// Wraps ErrLoginThrottled.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrLoginThrottled, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// PasswordHasher running at most as many comparisons at a time as it has workers, so that a login flood
+// leaves CPUs to the other requests. Logins waiting longer than QueueTimeout get ErrLoginBusy.
+type BoundedHasher struct {
+	Next         PasswordHasher
+	QueueTimeout time.Duration
+
+	workers chan struct{}
+}
+
+func NewBoundedHasher(next PasswordHasher, workers int) *BoundedHasher {
+	return &BoundedHasher{
+		Next:         next,
+		QueueTimeout: PasswordHashQueueTimeout,
+		workers:      make(chan struct{}, max(workers, 1)),
+	}
+}
+
+// Half of the CPUs, at least one
+func DefaultPasswordHashWorkers() int {
+	return max(runtime.GOMAXPROCS(0)/2, 1)
+}
+
+func (h *BoundedHasher) CompareHashAndPassword(hashedPassword []byte, password []byte) error {
+	timer := time.NewTimer(h.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case h.workers <- struct{}{}:
+	case <-timer.C:
+		return ErrLoginBusy
+	}
+	defer func() { <-h.workers }()
+
+	return h.Next.CompareHashAndPassword(hashedPassword, password)
+}
@@ -6,13 +6,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	_ "github.com/Peeranut-Kit/health_api_assignment/docs" // Import Swagger docs
+	"github.com/Peeranut-Kit/health_api_assignment/internal/hospital"
 	"github.com/Peeranut-Kit/health_api_assignment/internal/patient"
 	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
 	"github.com/Peeranut-Kit/health_api_assignment/middleware"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -43,19 +47,82 @@ func main() {
 
 	log.Println("Database connected successfully")
 
+	// Per hospital HN formats for patient validation
+	if err := pkg.LoadPatientHNPatterns(os.Getenv("PATIENT_HN_PATTERNS")); err != nil {
+		panic(fmt.Sprintf("Failed to load patient HN patterns: %v", err))
+	}
+
+	// Keys signing the login tokens
+	keys, err := pkg.ParseKeyRing(os.Getenv("JWT_SIGNING_KEYS"), os.Getenv("JWT_ACTIVE_KEY_ID"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load the JWT signing keys: %v", err))
+	}
+
+	// Rules of the passwords staff set
+	passwordPolicy, err := staff.ParsePasswordPolicy(os.Getenv("PASSWORD_MIN_LENGTH"), os.Getenv("PASSWORD_MIN_CHARACTER_CLASSES"),
+		os.Getenv("PASSWORD_HISTORY"), os.Getenv("PASSWORD_BREACHED_LIST"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load the password policy: %v", err))
+	}
+
+	// Mail server sending password reset tokens
+	mailer, err := initMailer()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize the mailer: %v", err))
+	}
+
+	// TOTP authenticators of the logins, required of the roles of MFA_REQUIRED_ROLES and of the hospital settings
+	mfa, err := staff.ParseMFAConfig(os.Getenv("MFA_REQUIRED_ROLES"), os.Getenv("MFA_ISSUER"), os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load the MFA settings: %v", err))
+	}
+
 	// Gin Framework
 	r := gin.Default()
+	// Failed logins are throttled per client IP, which only the trusted proxies may forward
+	r.RemoteIPHeaders = []string{"X-Real-IP"}
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		panic(fmt.Sprintf("Invalid TRUSTED_PROXIES: %v", err))
+	}
 
 	// Dependency Injection
-	patientRepo := patient.NewGormPatientRepository(db)
+	hospitalRepo := hospital.NewGormHospitalRepository(db)
+	// Patients of hospitals with a HIS base URL are read from their HIS
+	routingPatientRepo := patient.NewHospitalRoutingPatientRepository(patient.NewGormPatientRepository(db), hospitalRepo)
+	var patientRepo patient.PatientRepositoryInterface = routingPatientRepo
+	// Patient lookups and searches are cached unless PATIENT_CACHE_TTL is 0
+	patientCache, patientCacheTTL, err := initPatientCache()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize the patient cache: %v", err))
+	}
+	if patientCache != nil {
+		patientRepo = patient.NewCachingPatientRepository(patientRepo, patientCache, patientCacheTTL)
+	}
 	staffRepo := staff.NewGormStaffRepository(db)
 
+	// A hospital update drops its cached routing
+	hospitalService := hospital.NewHospitalService(hospitalRepo, routingPatientRepo)
 	patientService := patient.NewPatientService(patientRepo)
-	staffService := staff.NewStaffService(staffRepo)
+	staffService, err := staff.NewStaffService(staffRepo, keys, passwordPolicy, mailer, mfa)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to initialize the staff service: %v", err))
+	}
 
+	hospitalHandler := hospital.NewHttpHospitalHandler(hospitalService)
 	patientHandler := patient.NewHttpPatientHandler(patientService)
 	staffHandler := staff.NewHttpStaffHandler(staffService)
 
+	// Attributes of the login token cookies of the deployment
+	staffHandler.Cookies, err = staff.ParseCookieConfig(os.Getenv("COOKIE_DOMAIN"), os.Getenv("COOKIE_SECURE"), os.Getenv("COOKIE_SAMESITE"))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load cookie settings: %v", err))
+	}
+
+	// HN patterns from the hospital settings override PATIENT_HN_PATTERNS
+	if err := hospitalService.LoadPatientHNPatterns(); err != nil {
+		panic(fmt.Sprintf("Failed to load hospital settings: %v", err))
+	}
+
 	// Swagger endpoint
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -67,6 +134,13 @@ func main() {
 		})
 	})
 
+	// Public keys verifying the login tokens, for other services
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		// A new key is published at least this long before it signs, so that cached copies know it
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keys.JWKS())
+	})
+
 	// test nginx reverse proxy endpoint
 	r.GET("/ping", func(c *gin.Context) {
 		hostname, _ := os.Hostname()
@@ -77,13 +151,66 @@ func main() {
 		})
 	})
 
-	// API to create a new hospital staff member
-	r.POST("/staff/create", staffHandler.CreateStaff)
+	// APIs require a login, then a role granted the permission of the action
+	// Login tokens signed by an unknown key, or revoked by logout or by an admin are rejected
+	auth := middleware.AuthRequired(keys, staffRepo)
+	canManageHospitals := middleware.RequirePermission(pkg.PermissionManageHospitals)
+	canManageStaff := middleware.RequirePermission(pkg.PermissionManageStaff)
+	// Sensitive APIs reject password-only logins of staff who have to use MFA
+	requireMFA := middleware.RequireMFA()
+
+	// APIs for the platform super-admin to manage hospitals
+	r.POST("/hospital", auth, requireMFA, canManageHospitals, hospitalHandler.CreateHospital)
+	r.GET("/hospital", auth, requireMFA, canManageHospitals, hospitalHandler.ListHospitals)
+	r.PUT("/hospital/:id", auth, requireMFA, canManageHospitals, hospitalHandler.UpdateHospital)
+
+	// API for an admin to invite a new staff member of its hospital
+	r.POST("/staff/create", auth, requireMFA, canManageStaff, staffHandler.CreateStaff)
+	// API for an invited staff member to set its password
+	r.POST("/staff/activate", staffHandler.ActivateStaff)
+	// API to create the first admin of a hospital with the bootstrap token
+	r.POST("/staff/bootstrap", staffHandler.BootstrapAdmin)
+	// API to create the super-admin of the platform with the bootstrap token
+	r.POST("/staff/bootstrap/super-admin", staffHandler.BootstrapSuperAdmin)
 	// API for staff login
 	r.POST("/staff/login", staffHandler.SignInStaff)
+	// APIs completing a login with a code of the authenticator, enrolling one first if the role has to use MFA
+	r.POST("/staff/login/mfa", staffHandler.VerifyMFA)
+	r.POST("/staff/login/mfa/enroll", staffHandler.StartLoginTOTPEnrollment)
+	// API to exchange the refresh token for new login tokens
+	r.POST("/staff/token/refresh", staffHandler.RefreshToken)
+	// API for staff logout, revoking the login token
+	r.POST("/staff/logout", auth, staffHandler.SignOutStaff)
+	// API for an admin to revoke every session of a staff member of its hospital
+	r.POST("/staff/:id/revoke-sessions", auth, requireMFA, canManageStaff, staffHandler.RevokeStaffSessions)
+	// API for an admin to end the lockout of a staff member of its hospital after failed logins
+	r.POST("/staff/:id/unlock", auth, requireMFA, canManageStaff, staffHandler.UnlockStaff)
+	// API for an admin to remove the authenticator of a staff member of its hospital, e.g. a lost phone
+	r.POST("/staff/:id/mfa/reset", auth, requireMFA, canManageStaff, staffHandler.ResetStaffMFA)
+	// APIs for staff to enrol an authenticator, confirmed with its first code
+	r.POST("/staff/mfa/totp", auth, staffHandler.StartTOTPEnrollment)
+	r.POST("/staff/mfa/totp/confirm", auth, staffHandler.ConfirmTOTPEnrollment)
+	// API for staff to change its password, revoking its other sessions
+	r.PUT("/staff/password", auth, staffHandler.ChangePassword)
+	// APIs for staff who forgot their password to get a reset token by email, and to set a new password with it
+	r.POST("/staff/password/reset-request", staffHandler.RequestPasswordReset)
+	r.POST("/staff/password/reset", staffHandler.ResetPassword)
+
+	canRead := middleware.RequirePermission(pkg.PermissionReadPatient)
+	canWrite := middleware.RequirePermission(pkg.PermissionWritePatient)
+	canDelete := middleware.RequirePermission(pkg.PermissionDeletePatient)
 
 	// API to search for a patient
-	r.GET("/patient/search", middleware.AuthRequiredMiddleware, patientHandler.SearchPatient)
+	r.GET("/patient/search", auth, requireMFA, canRead, patientHandler.SearchPatient)
+	// API to search for a patient with a JSON body for complex criteria
+	r.POST("/patient/search", auth, requireMFA, canRead, patientHandler.SearchPatientByBody)
+	// API to look up a patient by national ID or passport ID
+	r.GET("/patient/search/:id", auth, requireMFA, canRead, patientHandler.GetPatientByIdentifier)
+	// APIs to manage patients of the staff member's hospital
+	r.POST("/patient", auth, requireMFA, canWrite, patientHandler.CreatePatient)
+	r.PUT("/patient/:id", auth, requireMFA, canWrite, patientHandler.UpdatePatient)
+	r.PATCH("/patient/:id", auth, requireMFA, canWrite, patientHandler.PatchPatient)
+	r.DELETE("/patient/:id", auth, requireMFA, canDelete, patientHandler.DeletePatient)
 
 	r.Run(":" + os.Getenv("PORT")) // listen and serve on port 8080
 }
@@ -117,6 +244,66 @@ func initDatabase() (*gorm.DB, error) {
 	return db, nil
 }
 
+// Redis when REDIS_URL is set, so that every API instance sees the invalidations of the others,
+// in-process LRU of PATIENT_CACHE_SIZE entries otherwise
+func initPatientCache() (pkg.Cache, time.Duration, error) {
+	ttl := patient.DefaultPatientCacheTTL
+	if value := os.Getenv("PATIENT_CACHE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid PATIENT_CACHE_TTL: %w", err)
+		}
+		ttl = parsed
+	}
+	if ttl <= 0 {
+		return nil, 0, nil
+	}
+
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		cache, err := pkg.NewRedisCache(redisURL)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return cache, ttl, nil
+	}
+
+	size := 10000
+	if value := os.Getenv("PATIENT_CACHE_SIZE"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, 0, fmt.Errorf("invalid PATIENT_CACHE_SIZE %q", value)
+		}
+		size = parsed
+	}
+	return pkg.NewLRUCache(size), ttl, nil
+}
+
+// Mailer of SMTP_ADDR, sending in the background. Password resets are disabled without SMTP_ADDR.
+func initMailer() (pkg.Mailer, error) {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+
+	mailer, err := pkg.NewSMTPMailer(addr, os.Getenv("SMTP_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	if err != nil {
+		return nil, err
+	}
+	return &pkg.AsyncMailer{Next: mailer}, nil
+}
+
+// Addresses or CIDRs of the reverse proxies in front of the API, e.g. the Docker network of NGINX,
+// separated by commas. Without any, the client IP is the address of the connection.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func gracefulShutdown() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
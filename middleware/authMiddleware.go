@@ -1,62 +1,160 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Middleware to check if the user is authenticated using JWT
-func AuthRequiredMiddleware(c *gin.Context) {
-	// Retrieve JWT token from the cookie
-	tokenString, err := c.Cookie("jwt")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		c.Abort()
-		return
+// Store of revoked login tokens, e.g. the staff repository
+type RevocationChecker interface {
+	// Whether the token was revoked, alone by logout or with the sessions of the staff member,
+	// which may spare the login familyID
+	IsTokenRevoked(tokenID string, staffID int, familyID string, issuedAt time.Time) (bool, error)
+}
+
+// Middleware to check if the user is authenticated using JWT signed by a key of the ring, and that
+// the token was not revoked. The token is read from an Authorization: Bearer header, or else from the jwt cookie.
+func AuthRequired(keys *pkg.KeyRing, revocations RevocationChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := tokenOf(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		// Parse the JWT token, verified with the key named by its kid header
+		token, err := jwt.Parse(tokenString, keys.Keyfunc, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}))
+
+		if err != nil || !token.Valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		claim, ok := token.Claims.(jwt.MapClaims)
+		// Tokens of other types, e.g. of a login waiting for its MFA code, are no login tokens
+		if ok && claim["typ"] != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		if !ok || claim["staff_hospital_id"] == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Hospital ID not found in token"})
+			c.Abort()
+			return
+		}
+
+		// Tokens without an ID cannot be revoked, so they are not accepted
+		session, ok := sessionOf(claim)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		revoked, err := revocations.IsTokenRevoked(session.TokenID, session.StaffID, session.FamilyID, session.IssuedAt)
+		if err != nil {
+			log.Printf("token revocation check failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		// Convert hospital_id to string
+		var hospitalIDStr string
+		if id, ok := claim["staff_hospital_id"].(string); ok {
+			hospitalIDStr = id
+		} else if idFloat, ok := claim["staff_hospital_id"].(float64); ok {
+			hospitalIDStr = strconv.FormatFloat(idFloat, 'f', 0, 64) // Convert float64 to string
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid hospital_id format"})
+			c.Abort()
+			return
+		}
+
+		// Set hospital_id in gin.Context
+		c.Set("hospital_id", hospitalIDStr)
+		c.Set("session", session)
+
+		// Tokens issued before roles existed carry none and are granted no permission
+		role, _ := claim["staff_role"].(string)
+		c.Set("staff_role", pkg.Role(role))
+
+		// Proceed to the next handler
+		c.Next()
 	}
+}
 
-	// Parse the JWT token
-	secretKey := os.Getenv("JWT_SECRET")
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, err
+// Login token of the request. A malformed Authorization header is not ignored in favour of the cookie.
+func tokenOf(c *gin.Context) (string, bool) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		token = strings.TrimSpace(token)
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", false
 		}
-		return []byte(secretKey), nil
-	})
+		return token, true
+	}
 
-	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		c.Abort()
-		return
+	// Retrieve JWT token from the cookie
+	token, err := c.Cookie("jwt")
+	if err != nil || token == "" {
+		return "", false
 	}
+	return token, true
+}
 
-	claim, ok := token.Claims.(jwt.MapClaims)
-	if !ok || claim["staff_hospital_id"] == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Hospital ID not found in token"})
-		c.Abort()
-		return
+// Session of the claims of a login token, false when a claim is missing
+func sessionOf(claim jwt.MapClaims) (*Session, bool) {
+	tokenID, _ := claim["jti"].(string)
+	familyID, _ := claim["fid"].(string)
+	staffID, ok := claim["staff_id"].(float64)
+	if tokenID == "" || !ok {
+		return nil, false
 	}
 
-	// Convert hospital_id to string
-	var hospitalIDStr string
-	if id, ok := claim["staff_hospital_id"].(string); ok {
-		hospitalIDStr = id
-	} else if idFloat, ok := claim["staff_hospital_id"].(float64); ok {
-		hospitalIDStr = strconv.FormatFloat(idFloat, 'f', 0, 64) // Convert float64 to string
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid hospital_id format"})
-		c.Abort()
-		return
+	issuedAt, err := claim.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return nil, false
+	}
+	expiresAt, err := claim.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return nil, false
 	}
 
-	// Set hospital_id in gin.Context
-	c.Set("hospital_id", hospitalIDStr)
+	// Tokens issued before MFA carry no amr, and require MFA until renewed
+	var authMethods []string
+	if amr, ok := claim["amr"].([]interface{}); ok {
+		for _, method := range amr {
+			if method, ok := method.(string); ok {
+				authMethods = append(authMethods, method)
+			}
+		}
+	}
+	mfaRequired, ok := claim["mfa_required"].(bool)
+	if !ok {
+		mfaRequired = true
+	}
 
-	// Proceed to the next handler
-	c.Next()
+	return &Session{
+		TokenID:     tokenID,
+		FamilyID:    familyID,
+		StaffID:     int(staffID),
+		IssuedAt:    issuedAt.Time,
+		ExpiresAt:   expiresAt.Time,
+		AuthMethods: authMethods,
+		MFARequired: mfaRequired,
+	}, true
 }
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// Hospital of the authenticated staff member, set by AuthRequired
+func GetHospitalID(c *gin.Context) (int, error) {
+	// Retrieve hospital_id from gin.Context
+	hospitalID, exists := c.Get("hospital_id")
+	if !exists {
+		return -1, errors.New("hospital ID not found")
+	}
+
+	// Type assert to int if necessary
+	hospitalIDStr, ok := hospitalID.(string)
+	if !ok {
+		return -1, errors.New("assertion failed for string")
+	}
+
+	hospitalIDInt, err := strconv.Atoi(hospitalIDStr)
+	if err != nil {
+		return -1, errors.New("error converting string to int")
+	}
+
+	// Got hospital ID
+	return hospitalIDInt, nil
+}
+
+// Role of the authenticated staff member, empty when there is none
+func GetRole(c *gin.Context) pkg.Role {
+	role, _ := c.Get("staff_role")
+	staffRole, _ := role.(pkg.Role)
+	return staffRole
+}
+
+// Login token of the authenticated staff member
+type Session struct {
+	TokenID   string
+	FamilyID  string // of the refresh tokens of the login, empty for tokens issued before refresh tokens
+	StaffID   int
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	AuthMethods []string // amr claim, how the login was authenticated
+	MFARequired bool     // whether the staff member has to log in with MFA
+}
+
+// Session of the authenticated staff member, set by AuthRequired
+func GetSession(c *gin.Context) (*Session, error) {
+	value, exists := c.Get("session")
+	if !exists {
+		return nil, errors.New("session not found")
+	}
+
+	session, ok := value.(*Session)
+	if !ok {
+		return nil, errors.New("assertion failed for session")
+	}
+	return session, nil
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware to only let staff whose role is granted the permission through.
+// Must run after AuthRequired, which puts the role of the token in gin.Context.
+func RequirePermission(permission pkg.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetRole(c).Can(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		// Proceed to the next handler
+		c.Next()
+	}
+}
+
+// Error code of the routes refusing logins without MFA, for clients to ask for a login with a code
+const CodeMFARequired = "MFA_REQUIRED"
+
+// Middleware for sensitive routes, e.g. those showing national IDs and contact details: staff who have to
+// log in with MFA, by their role or hospital, are refused when the login token has no mfa in its amr claim.
+// Must run after AuthRequired.
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, err := GetSession(c)
+		if err != nil || (session.MFARequired && !slices.Contains(session.AuthMethods, pkg.AuthMethodMFA)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This route requires a login with multi-factor authentication", "code": CodeMFARequired})
+			c.Abort()
+			return
+		}
+
+		// Proceed to the next handler
+		c.Next()
+	}
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	_ "time/tzdata" // Embed the timezone database, the alpine runtime image has none
+)
+
+// Layout of date-only values such as dates of birth
+const DateLayout = "2006-01-02"
+
+// Timezone of the hospitals, used to decide which calendar day "today" and a date of birth are
+var HospitalLocation = mustLoadLocation("Asia/Bangkok")
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+// Calendar date of t in the hospital timezone, for comparison against DATE columns
+func FormatDate(t time.Time) string {
+	return t.In(HospitalLocation).Format(DateLayout)
+}
+
+// Parse a YYYY-MM-DD date as midnight in the hospital timezone
+func ParseDate(value string) (time.Time, error) {
+	return time.ParseInLocation(DateLayout, value, HospitalLocation)
+}
+
+// Today's date as midnight in the hospital timezone
+func Today() time.Time {
+	now := time.Now().In(HospitalLocation)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, HospitalLocation)
+}
+
+// Calendar date read and written as YYYY-MM-DD in JSON, the day of the hospital timezone
+// as FormatDate and ParseDate. The zero date is null.
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(FormatDate(d.Time))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.New("invalid date: must be a YYYY-MM-DD string")
+	}
+	if value == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+
+	parsed, err := ParseDate(value)
+	if err != nil {
+		return fmt.Errorf("invalid date %q: must be YYYY-MM-DD", value)
+	}
+	d.Time = parsed
+	return nil
+}
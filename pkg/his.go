@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timeout of a HIS lookup, retries included, of hospitals which do not set one
+const DefaultHISTimeout = 5 * time.Second
+
+// Longest HIS timeout a hospital may set
+const MaxHISTimeout = 30 * time.Second
+
+func (s HospitalSettings) HISTimeout() time.Duration {
+	if s.HISTimeoutMs <= 0 {
+		return DefaultHISTimeout
+	}
+	return time.Duration(s.HISTimeoutMs) * time.Millisecond
+}
+
+// Patient as returned by the search endpoint of a Hospital Information System (HIS):
+// GET {his_base_url}/patient/search/{id} where {id} is a national ID or a passport ID
+type HISPatient struct {
+	FirstNameTh  string `json:"first_name_th"`
+	MiddleNameTh string `json:"middle_name_th"`
+	LastNameTh   string `json:"last_name_th"`
+	FirstNameEn  string `json:"first_name_en"`
+	MiddleNameEn string `json:"middle_name_en"`
+	LastNameEn   string `json:"last_name_en"`
+	DateOfBirth  string `json:"date_of_birth"` // YYYY-MM-DD
+	PatientHN    string `json:"patient_hn"`
+	NationalID   string `json:"national_id"`
+	PassportID   string `json:"passport_id"`
+	PhoneNumber  string `json:"phone_number"`
+	Email        string `json:"email"`
+	Gender       string `json:"gender"`
+}
+
+func NewHISPatient(patient *Patient) HISPatient {
+	return HISPatient{
+		FirstNameTh:  patient.FirstNameTh,
+		MiddleNameTh: patient.MiddleNameTh,
+		LastNameTh:   patient.LastNameTh,
+		FirstNameEn:  patient.FirstNameEn,
+		MiddleNameEn: patient.MiddleNameEn,
+		LastNameEn:   patient.LastNameEn,
+		DateOfBirth:  FormatDate(patient.DateOfBirth),
+		PatientHN:    patient.PatientHN,
+		NationalID:   patient.NationalID,
+		PassportID:   patient.PassportID,
+		PhoneNumber:  patient.PhoneNumber,
+		Email:        patient.Email,
+		Gender:       patient.Gender,
+	}
+}
+
+// Patient of the hospital in its stored form. It has no ID, the HIS is the source of truth.
+func (p *HISPatient) ToPatient(hospitalID int) (*Patient, error) {
+	dateOfBirth, err := ParseDate(p.DateOfBirth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date_of_birth %q from HIS: %w", p.DateOfBirth, err)
+	}
+
+	patient := &Patient{
+		FirstNameTh:  p.FirstNameTh,
+		MiddleNameTh: p.MiddleNameTh,
+		LastNameTh:   p.LastNameTh,
+		FirstNameEn:  p.FirstNameEn,
+		MiddleNameEn: p.MiddleNameEn,
+		LastNameEn:   p.LastNameEn,
+		DateOfBirth:  dateOfBirth,
+		PatientHN:    p.PatientHN,
+		NationalID:   p.NationalID,
+		PassportID:   p.PassportID,
+		PhoneNumber:  p.PhoneNumber,
+		Email:        p.Email,
+		Gender:       p.Gender,
+		HospitalID:   hospitalID,
+	}
+	NormalizePatient(patient)
+	return patient, nil
+}
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Ed25519 key signing login tokens, named by the kid header of the tokens it signs
+type SigningKey struct {
+	ID         string
+	PrivateKey ed25519.PrivateKey
+}
+
+// Signing key with a random private key, e.g. for the next rotation
+func GenerateSigningKey(id string) (*SigningKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, PrivateKey: privateKey}, nil
+}
+
+// Entry of the key in JWT_SIGNING_KEYS: <kid>=<base64url seed of the private key>
+func (k *SigningKey) String() string {
+	return k.ID + "=" + base64.RawURLEncoding.EncodeToString(k.PrivateKey.Seed())
+}
+
+// Keys accepted for login tokens. The active key signs new tokens; the others only verify
+// tokens they signed, so that a key is introduced before it signs and retired after its last
+// token expired. Every key is published in the JWKS for other services.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+func NewKeyRing(activeID string, keys ...*SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("signing key without ID")
+		}
+		if _, ok := ring.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key %q", key.ID)
+		}
+		ring.keys[key.ID] = key
+	}
+
+	// The only key is active without naming it
+	if activeID == "" && len(keys) == 1 {
+		activeID = keys[0].ID
+	}
+	active, ok := ring.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found", activeID)
+	}
+	ring.active = active
+	return ring, nil
+}
+
+// Key ring of JWT_SIGNING_KEYS, e.g. 2025-01=<seed>;2025-07=<seed>, signing with the activeID key
+func ParseKeyRing(config string, activeID string) (*KeyRing, error) {
+	var keys []*SigningKey
+	for _, entry := range strings.Split(config, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		id, seed, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid signing key entry: expected <kid>=<seed>")
+		}
+		id = strings.TrimSpace(id)
+		// The seed is not part of the errors, which may be logged
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(seed))
+		if err != nil || len(data) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid signing key %q: expected the base64url %d-byte Ed25519 seed", id, ed25519.SeedSize)
+		}
+		keys = append(keys, &SigningKey{ID: id, PrivateKey: ed25519.NewKeyFromSeed(data)})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing key")
+	}
+	return NewKeyRing(strings.TrimSpace(activeID), keys...)
+}
+
+// ID of the key signing new tokens
+func (r *KeyRing) ActiveKeyID() string {
+	return r.active.ID
+}
+
+// Token of the claims signed with the active key, named in its kid header
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = r.active.ID
+	return token.SignedString(r.active.PrivateKey)
+}
+
+// jwt.Keyfunc verifying tokens with the key named by their kid header. Tokens of other
+// algorithms, such as the former HS256 tokens, and of unknown keys are rejected.
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodEd25519); !ok {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	id, _ := token.Header["kid"].(string)
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+	return key.PrivateKey.Public(), nil
+}
+
+// Public key in the JSON Web Key format (RFC 8037)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Public keys of the ring, sorted by ID
+func (r *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for id, key := range r.keys {
+		set.Keys = append(set.Keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key.PrivateKey.Public().(ed25519.PublicKey)),
+			KeyID:     id,
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodEdDSA.Alg(),
+		})
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
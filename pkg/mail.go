@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sender of plain text emails, e.g. SMTPMailer
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// Mailer sending through an SMTP server, with STARTTLS when the server offers it
+type SMTPMailer struct {
+	Addr string // host:port
+	From string
+	Auth smtp.Auth // nil for servers without authentication, e.g. a local MailHog
+}
+
+// Mailer of SMTP_ADDR sending as SMTP_FROM, authenticated with SMTP_USERNAME and SMTP_PASSWORD when a username is given.
+// The credentials are only sent over TLS, or to a server on localhost.
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_ADDR %q: expected host:port", addr)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM %q: %w", from, err)
+	}
+
+	mailer := &SMTPMailer{Addr: addr, From: sender.Address}
+	if username != "" {
+		mailer.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer, nil
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", to, err)
+	}
+	// The subject becomes a header line
+	if strings.ContainsAny(subject, "\r\n") {
+		return errors.New("invalid subject: line break")
+	}
+
+	messageID, err := newMessageID(m.From)
+	if err != nil {
+		return err
+	}
+	headers := []string{
+		"From: " + m.From,
+		"To: " + recipient.Address,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	// SMTP lines end with CRLF
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{recipient.Address}, []byte(message))
+}
+
+func newMessageID(from string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	domain := from[strings.LastIndex(from, "@")+1:]
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">", nil
+}
+
+// Mailer returning before the email is sent, so that requests neither wait for the mail server nor
+// take longer when an email is sent. Failures are logged.
+type AsyncMailer struct {
+	Next Mailer
+}
+
+func (m *AsyncMailer) Send(to string, subject string, body string) error {
+	go func() {
+		if err := m.Next.Send(to, subject, body); err != nil {
+			log.Printf("sending email failed: %v", err)
+		}
+	}()
+	return nil
+}
@@ -2,38 +2,160 @@ package pkg
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Hospital struct {
-	ID       int       `gorm:"primaryKey" json:"id"`
-	Name     string    `gorm:"size:255" json:"name"`
-	Patients []Patient `gorm:"foreignKey:HospitalID" json:"patients"`
-	Staffs   []Staff   `gorm:"foreignKey:HospitalID" json:"staffs"`
+	ID       int              `gorm:"primaryKey" json:"id"`
+	Name     string           `gorm:"size:255" json:"name"`
+	Code     *string          `gorm:"size:5;unique" json:"code"` // e.g. Thai MOPH 5-digit hospital code, NULL for hospitals created before codes
+	Settings HospitalSettings `gorm:"type:jsonb;serializer:json;not null" json:"settings"`
+	Patients []Patient        `gorm:"foreignKey:HospitalID" json:"patients"`
+	Staffs   []Staff          `gorm:"foreignKey:HospitalID" json:"staffs"`
+}
+
+// Per hospital configuration, stored as JSON so that settings can be added without migrations
+type HospitalSettings struct {
+	PatientHNPattern string `json:"patient_hn_pattern,omitempty"` // overrides PATIENT_HN_PATTERNS of the hospital
+	HISBaseURL       string `json:"his_base_url,omitempty"`       // patients are read from this HIS instead of the local database
+	HISTimeoutMs     int    `json:"his_timeout_ms,omitempty"`     // timeout of a HIS lookup, retries included (default 5000)
+	MFARequiredRoles []Role `json:"mfa_required_roles,omitempty"` // roles logging in with TOTP, in addition to MFA_REQUIRED_ROLES
 }
 
 type Patient struct {
-	ID           int       `gorm:"primaryKey" json:"id"`
-	FirstNameTh  string    `gorm:"size:255" json:"first_name_th"`
-	MiddleNameTh string    `gorm:"size:255" json:"middle_name_th"`
-	LastNameTh   string    `gorm:"size:255" json:"last_name_th"`
-	FirstNameEn  string    `gorm:"size:255" json:"first_name_en"`
-	MiddleNameEn string    `gorm:"size:255" json:"middle_name_en"`
-	LastNameEn   string    `gorm:"size:255" json:"last_name_en"`
-	DateOfBirth  time.Time `json:"date_of_birth"`
-	PatientHN    string    `gorm:"size:50;not null;unique" json:"patient_hn"`
-	NationalID   string    `gorm:"size:50;not null;unique" json:"national_id"`
-	PassportID   string    `gorm:"size:50;not null;unique" json:"passport_id"`
-	PhoneNumber  string    `gorm:"size:50;not null" json:"phone_number"`
-	Email        string    `gorm:"size:255;unique" json:"email"`
-	Gender       string    `gorm:"size:1" json:"gender"`
-	HospitalID   int       `json:"hospital_id"`
-	Hospital     Hospital  `gorm:"foreignKey:HospitalID" json:"hospital"`
+	ID           int                 `gorm:"primaryKey" json:"id"`
+	FirstNameTh  string              `gorm:"size:255" json:"first_name_th"`
+	MiddleNameTh string              `gorm:"size:255" json:"middle_name_th"`
+	LastNameTh   string              `gorm:"size:255" json:"last_name_th"`
+	FirstNameEn  string              `gorm:"size:255" json:"first_name_en"`
+	MiddleNameEn string              `gorm:"size:255" json:"middle_name_en"`
+	LastNameEn   string              `gorm:"size:255" json:"last_name_en"`
+	DateOfBirth  time.Time           `json:"date_of_birth" validate:"required"`
+	PatientHN    string              `gorm:"size:50;not null" json:"patient_hn" validate:"required,patient_hn"`
+	NationalID   string              `gorm:"size:50" json:"national_id" validate:"required_without=PassportID,omitempty,thai_national_id"`
+	PassportID   string              `gorm:"size:50" json:"passport_id" validate:"required_without=NationalID,omitempty,passport"`
+	PhoneNumber  string              `gorm:"size:50;not null" json:"phone_number" validate:"required,e164"`
+	Email        string              `gorm:"size:255" json:"email"`
+	Gender       string              `gorm:"size:1" json:"gender" validate:"omitempty,gender"`
+	HospitalID   int                 `json:"hospital_id"`
+	Hospital     Hospital            `gorm:"foreignKey:HospitalID" json:"hospital"`
+	Identifiers  []PatientIdentifier `gorm:"foreignKey:PatientID" json:"identifiers" validate:"dive"`
+	DeletedAt    gorm.DeletedAt      `gorm:"index" json:"-" swaggerignore:"true"`
+}
+
+// Identifier types of patient_identifiers
+const (
+	IdentifierHN         = "hn"
+	IdentifierNationalID = "national_id"
+	IdentifierPassport   = "passport"
+	IdentifierOther      = "other"
+)
+
+// Issuer of national IDs entered through Patient.NationalID
+const IssuerThailand = "TH"
+
+// One of possibly many identifiers of a patient (HNs, national IDs of any country,
+// passports). Unique per hospital, type and issuer. PatientHN, NationalID and
+// PassportID of Patient are the primary identifier of their type.
+type PatientIdentifier struct {
+	ID         int    `gorm:"primaryKey" json:"-"`
+	PatientID  int    `gorm:"not null" json:"-"`
+	HospitalID int    `json:"-"`
+	Type       string `gorm:"size:20;not null" json:"type" validate:"required,oneof=hn national_id passport other"`
+	Value      string `gorm:"size:50;not null" json:"value" validate:"required,max=50"`
+	Issuer     string `gorm:"size:50;not null" json:"issuer" validate:"max=50"`
 }
 
 type Staff struct {
 	ID         int      `gorm:"primaryKey" json:"id"`
 	Username   string   `gorm:"size:255;not null;unique" json:"username" validate:"required"`
 	Password   string   `gorm:"size:255;not null" json:"password" validate:"required"`
+	Role       Role     `gorm:"size:30;not null" json:"role"`
+	Email      string   `gorm:"size:255;not null" json:"email"` // lowercase, empty when unknown
 	HospitalID int      `json:"hospital_id"`
 	Hospital   Hospital `gorm:"foreignKey:HospitalID" json:"hospital"`
 }
+
+// Login token revoked before it expires, e.g. by logout. The row is useless once the token has expired.
+type RevokedToken struct {
+	TokenID   string    `gorm:"primaryKey;size:64"` // jti claim
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// Login tokens of the staff member issued before RevokedBefore are revoked, except those of the
+// login ExceptFamilyID, e.g. the session in which the staff member changed its password
+type StaffSessionRevocation struct {
+	StaffID        int       `gorm:"primaryKey;autoIncrement:false"`
+	RevokedBefore  time.Time `gorm:"not null"`
+	ExceptFamilyID string    `gorm:"size:64;not null"` // empty revokes every login
+}
+
+// Pending password reset of a staff member, at most one per staff member.
+// Only the hash of the emailed token is stored; the row is deleted when the token is used.
+type PasswordReset struct {
+	ID        int       `gorm:"primaryKey"`
+	StaffID   int       `gorm:"not null;unique"`
+	Staff     Staff     `gorm:"foreignKey:StaffID"`
+	TokenHash string    `gorm:"size:64;not null;unique"`
+	CreatedAt time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// Former password hash of a staff member, refused when set again
+type PasswordHistory struct {
+	ID           int       `gorm:"primaryKey"`
+	StaffID      int       `gorm:"not null"`
+	PasswordHash string    `gorm:"size:255;not null"`
+	CreatedAt    time.Time `gorm:"not null"` // when the password was replaced
+}
+
+// Refresh token of a login, stored hashed. Every refresh replaces it with a new token of the
+// same family: UsedAt is set on the old one, and using it again revokes the whole family.
+type RefreshToken struct {
+	ID        int        `gorm:"primaryKey"`
+	StaffID   int        `gorm:"not null"`
+	FamilyID  string     `gorm:"size:64;not null"` // shared by the tokens of a login
+	TokenHash string     `gorm:"size:64;not null;unique"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // set when the token was exchanged for a new one
+	RevokedAt *time.Time
+	// Authentication methods of the login, the amr claim of its access tokens separated by spaces
+	AuthMethods string `gorm:"size:50;not null"`
+}
+
+// TOTP authenticator of a staff member, at most one. The secret is sealed with TOTP_ENCRYPTION_KEY.
+// The enrolment is pending until a first code confirms that the authenticator app holds the secret.
+type StaffTOTP struct {
+	StaffID      int        `gorm:"primaryKey;autoIncrement:false"`
+	Secret       string     `gorm:"size:255;not null"`
+	CreatedAt    time.Time  `gorm:"not null"`
+	ConfirmedAt  *time.Time // nil while the enrolment is pending
+	LastUsedStep int64      `gorm:"not null"` // time step of the last code used, codes of earlier steps are refused
+}
+
+// Single-use code logging in a staff member who lost its authenticator, stored hashed
+type StaffRecoveryCode struct {
+	ID       int    `gorm:"primaryKey"`
+	StaffID  int    `gorm:"not null"`
+	CodeHash string `gorm:"size:64;not null"`
+}
+
+// Failed logins of a username or of a client IP, counted while they follow each other within
+// the failure window. Key is username:<username> or ip:<address>.
+type LoginFailure struct {
+	Key           string    `gorm:"primaryKey;size:320"`
+	Failures      int       `gorm:"not null"`
+	LastFailureAt time.Time `gorm:"not null"`
+}
+
+// Pending invitation of a staff member who has not set a password yet.
+// Only the hash of the token is stored, Token is set right after creation.
+type StaffInvitation struct {
+	ID        int       `gorm:"primaryKey" json:"-"`
+	StaffID   int       `gorm:"not null;unique" json:"-"`
+	Staff     Staff     `gorm:"foreignKey:StaffID" json:"-"`
+	TokenHash string    `gorm:"size:64;not null;unique" json:"-"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+	Token     string    `gorm:"-" json:"-"`
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys deleted per command by DeletePrefix
+const redisDeleteBatch = 100
+
+// Cache in Redis, shared by every API instance so that a write on one instance invalidates them all
+type RedisCache struct {
+	Client *redis.Client
+}
+
+// Redis cache from a URL such as redis://localhost:6379/0
+func NewRedisCache(redisURL string) (*RedisCache, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisCache{Client: redis.NewClient(options)}, nil
+}
+
+func (c *RedisCache) Get(key string) ([]byte, bool, error) {
+	value, err := c.Client.Get(context.Background(), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) error {
+	return c.Client.Set(context.Background(), key, value, ttl).Err()
+}
+
+// Keys are scanned, not listed with KEYS, so that Redis is not blocked
+func (c *RedisCache) DeletePrefix(prefix string) error {
+	ctx := context.Background()
+
+	var keys []string
+	iterator := c.Client.Scan(ctx, 0, prefix+"*", redisDeleteBatch).Iterator()
+	for iterator.Next(ctx) {
+		keys = append(keys, iterator.Val())
+		if len(keys) == redisDeleteBatch {
+			if err := c.Client.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return c.Client.Unlink(ctx, keys...).Err()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package pkg
+
+// Role of a staff member within its hospital
+type Role string
+
+const (
+	RoleAdmin             Role = "admin"
+	RoleDoctor            Role = "doctor"
+	RoleNurse             Role = "nurse"
+	RoleRegistrationClerk Role = "registration_clerk"
+	RoleSuperAdmin        Role = "super_admin" // platform operator, belongs to no hospital
+)
+
+// Action on the API which is granted to some roles only
+type Permission string
+
+const (
+	PermissionManageHospitals    Permission = "hospital:manage"
+	PermissionManageStaff        Permission = "staff:manage"
+	PermissionReadPatient        Permission = "patient:read"
+	PermissionWritePatient       Permission = "patient:write"
+	PermissionDeletePatient      Permission = "patient:delete"
+	PermissionViewPatientContact Permission = "patient:contact" // unmasked phone number and email
+)
+
+// Permissions granted to each role. Only clinical roles see full patient contact details.
+var rolePermissions = map[Role][]Permission{
+	RoleSuperAdmin: {
+		PermissionManageHospitals,
+	},
+	RoleAdmin: {
+		PermissionManageStaff,
+		PermissionReadPatient,
+		PermissionWritePatient,
+		PermissionDeletePatient,
+	},
+	RoleDoctor: {
+		PermissionReadPatient,
+		PermissionViewPatientContact,
+	},
+	RoleNurse: {
+		PermissionReadPatient,
+		PermissionViewPatientContact,
+	},
+	RoleRegistrationClerk: {
+		PermissionReadPatient,
+		PermissionWritePatient,
+	},
+}
+
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Whether the role is granted the permission. Unknown roles are granted nothing.
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
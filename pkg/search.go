@@ -0,0 +1,282 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How a text field of the patient search criteria is compared against the database
+type MatchMode string
+
+const (
+	MatchExact           MatchMode = "exact"       // column = value
+	MatchCaseInsensitive MatchMode = "insensitive" // lower(column) = lower(value)
+	MatchPrefix          MatchMode = "prefix"      // column ILIKE 'value%'
+	MatchContains        MatchMode = "contains"    // column ILIKE '%value%'
+)
+
+// Patient columns which accept a match mode other than exact
+var MatchableFields = map[string]bool{
+	"first_name_th":  true,
+	"middle_name_th": true,
+	"last_name_th":   true,
+	"first_name_en":  true,
+	"middle_name_en": true,
+	"last_name_en":   true,
+	"email":          true,
+	"phone_number":   true,
+}
+
+func (m MatchMode) IsValid() bool {
+	switch m {
+	case MatchExact, MatchCaseInsensitive, MatchPrefix, MatchContains:
+		return true
+	}
+	return false
+}
+
+// Patient columns a search can filter on. HospitalID is never read from the client,
+// it is always the staff member's hospital.
+type PatientFilter struct {
+	ID           int       `json:"id"`
+	FirstNameTh  string    `json:"first_name_th"`
+	MiddleNameTh string    `json:"middle_name_th"`
+	LastNameTh   string    `json:"last_name_th"`
+	FirstNameEn  string    `json:"first_name_en"`
+	MiddleNameEn string    `json:"middle_name_en"`
+	LastNameEn   string    `json:"last_name_en"`
+	DateOfBirth  time.Time `json:"date_of_birth"`
+	PatientHN    string    `json:"patient_hn"`
+	NationalID   string    `json:"national_id"`
+	PassportID   string    `json:"passport_id"`
+	PhoneNumber  string    `json:"phone_number"`
+	Email        string    `json:"email"`
+	Gender       string    `json:"gender"`
+	HospitalID   int       `json:"-"`
+}
+
+// Patient search criteria. Populated fields of the embedded PatientFilter are filters,
+// PatientHN, NationalID, PassportID and Identifier match any identifier of the patient.
+// MatchModes selects how each matchable field is compared (exact when absent).
+// DateOfBirthFrom/To and MinAge/MaxAge bound the date of birth, inclusive, as calendar days.
+// Results are paged with Limit and the opaque Cursor returned by the previous page.
+type PatientSearchRequest struct {
+	PatientFilter
+	Identifier      string               `json:"identifier,omitempty"`
+	MatchModes      map[string]MatchMode `json:"match,omitempty"`
+	DateOfBirthFrom time.Time            `json:"dob_from"`
+	DateOfBirthTo   time.Time            `json:"dob_to"`
+	MinAge          *int                 `json:"min_age,omitempty"`
+	MaxAge          *int                 `json:"max_age,omitempty"`
+	Limit           int                  `json:"limit,omitempty"`
+	Cursor          string               `json:"cursor,omitempty"`
+	SortBy          SortKey              `json:"sort_by,omitempty"`
+	SortOrder       string               `json:"sort_order,omitempty"`
+	IncludeTotal    bool                 `json:"include_total,omitempty"`
+}
+
+// Match mode of a column, defaulting to exact
+func (r *PatientSearchRequest) MatchModeOf(column string) MatchMode {
+	if mode, ok := r.MatchModes[column]; ok {
+		return mode
+	}
+	return MatchExact
+}
+
+// Bring the identifier criteria to their stored form. Phone numbers are only
+// normalized when compared from the start (not in contains mode).
+func (r *PatientSearchRequest) Normalize() {
+	r.PassportID = NormalizePassportID(r.PassportID)
+	r.Gender = strings.ToUpper(r.Gender)
+	if r.MatchModeOf("phone_number") != MatchContains {
+		r.PhoneNumber = NormalizePhoneNumber(r.PhoneNumber)
+	}
+}
+
+// Reject malformed identifiers, match modes, sort keys, page sizes and cursors
+func (r *PatientSearchRequest) Validate() error {
+	if err := r.ValidateMatchModes(); err != nil {
+		return err
+	}
+	if err := r.validateIdentifiers(); err != nil {
+		return err
+	}
+	if !r.DateOfBirthFrom.IsZero() && !r.DateOfBirthTo.IsZero() && FormatDate(r.DateOfBirthFrom) > FormatDate(r.DateOfBirthTo) {
+		return errors.New("dob_from must not be after dob_to")
+	}
+	if (r.MinAge != nil && *r.MinAge < 0) || (r.MaxAge != nil && *r.MaxAge < 0) {
+		return errors.New("min_age and max_age must not be negative")
+	}
+	if r.MinAge != nil && r.MaxAge != nil && *r.MinAge > *r.MaxAge {
+		return errors.New("min_age must not be greater than max_age")
+	}
+	if r.SortBy != "" && !r.SortBy.IsValid() {
+		return fmt.Errorf("invalid sort key %q: must be one of patient_hn, last_name_en, last_name_th, date_of_birth", r.SortBy)
+	}
+	if r.SortOrder != "" && r.SortOrder != SortAsc && r.SortOrder != SortDesc {
+		return fmt.Errorf("invalid sort order %q: must be asc or desc", r.SortOrder)
+	}
+	if r.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	if r.Cursor != "" {
+		cursor, err := DecodePatientCursor(r.Cursor)
+		if err != nil {
+			return err
+		}
+		// A cursor only continues the ordering of the page it was returned with
+		if sortBy, sortOrder := r.Ordering(); cursor.SortBy != sortBy || cursor.SortOrder != sortOrder {
+			return ErrInvalidCursor
+		}
+	}
+	return nil
+}
+
+// Sort key and direction of the results, patient_hn ascending when absent
+func (r *PatientSearchRequest) Ordering() (SortKey, string) {
+	sortBy, sortOrder := r.SortBy, r.SortOrder
+	if sortBy == "" {
+		sortBy = SortByHN
+	}
+	if sortOrder == "" {
+		sortOrder = SortAsc
+	}
+	return sortBy, sortOrder
+}
+
+// Identifiers are always matched exactly, so a malformed one can never match
+func (r *PatientSearchRequest) validateIdentifiers() error {
+	if r.NationalID != "" && !IsValidThaiNationalID(r.NationalID) {
+		return errors.New("national_id must be 13 digits with a valid check digit")
+	}
+	if r.PassportID != "" && !IsValidPassportID(r.PassportID) {
+		return errors.New("passport_id must be 6-9 letters or digits")
+	}
+	if r.PatientHN != "" && !IsValidPatientHN(r.HospitalID, r.PatientHN) {
+		return errors.New("patient_hn does not match the HN format of the hospital")
+	}
+	if r.Gender != "" && !IsValidGender(r.Gender) {
+		return errors.New("gender must be one of M, F, O, U")
+	}
+	if mode := r.MatchModeOf("phone_number"); r.PhoneNumber != "" && (mode == MatchExact || mode == MatchCaseInsensitive) {
+		if err := Validate.Var(r.PhoneNumber, "e164"); err != nil {
+			return errors.New("phone_number must be a valid phone number")
+		}
+	}
+	return nil
+}
+
+// Narrow the date of birth range to patients aged between MinAge and MaxAge on the given day
+func (r *PatientSearchRequest) ApplyAgeBounds(today time.Time) {
+	if r.MinAge != nil {
+		// Born on or before this day to have reached MinAge
+		latest := today.AddDate(-*r.MinAge, 0, 0)
+		if r.DateOfBirthTo.IsZero() || latest.Before(r.DateOfBirthTo) {
+			r.DateOfBirthTo = latest
+		}
+	}
+	if r.MaxAge != nil {
+		// Born after the day of turning MaxAge+1 years old
+		earliest := today.AddDate(-(*r.MaxAge + 1), 0, 1)
+		if r.DateOfBirthFrom.IsZero() || earliest.After(r.DateOfBirthFrom) {
+			r.DateOfBirthFrom = earliest
+		}
+	}
+}
+
+// Reject match modes on unknown or non-matchable fields and unknown modes
+func (r *PatientSearchRequest) ValidateMatchModes() error {
+	for field, mode := range r.MatchModes {
+		if !MatchableFields[field] {
+			return fmt.Errorf("field %q does not support match modes", field)
+		}
+		if !mode.IsValid() {
+			return fmt.Errorf("invalid match mode %q for field %q: must be one of exact, insensitive, prefix, contains", mode, field)
+		}
+	}
+	return nil
+}
+
+// Page size limits of patient search, enforced by the service
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Column the patient search results are ordered by. Ties are broken by patient ID.
+type SortKey string
+
+const (
+	SortByHN          SortKey = "patient_hn"
+	SortByLastNameEn  SortKey = "last_name_en"
+	SortByLastNameTh  SortKey = "last_name_th"
+	SortByDateOfBirth SortKey = "date_of_birth"
+)
+
+// Sort directions of patient search results
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+func (k SortKey) IsValid() bool {
+	switch k {
+	case SortByHN, SortByLastNameEn, SortByLastNameTh, SortByDateOfBirth:
+		return true
+	}
+	return false
+}
+
+// Sort value of a patient for this key, as stored in a cursor
+func (k SortKey) valueOf(patient *Patient) string {
+	switch k {
+	case SortByLastNameEn:
+		return patient.LastNameEn
+	case SortByLastNameTh:
+		return patient.LastNameTh
+	case SortByDateOfBirth:
+		return FormatDate(patient.DateOfBirth)
+	default:
+		return patient.PatientHN
+	}
+}
+
+// Position of the last patient of a page, used for keyset pagination, with the ordering of the page
+type PatientCursor struct {
+	SortValue string  `json:"v"`
+	ID        int     `json:"id"`
+	SortBy    SortKey `json:"s"`
+	SortOrder string  `json:"o"`
+}
+
+// Build the opaque cursor pointing after the given patient in this ordering
+func EncodePatientCursor(patient *Patient, sortBy SortKey, sortOrder string) string {
+	data, _ := json.Marshal(PatientCursor{SortValue: sortBy.valueOf(patient), ID: patient.ID, SortBy: sortBy, SortOrder: sortOrder})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodePatientCursor(cursor string) (*PatientCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var decoded PatientCursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &decoded, nil
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// One page of patient search results
+type PatientSearchResult struct {
+	Patients   []Patient
+	NextCursor string // empty on the last page
+	Total      *int64 // only counted when requested
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Encrypts secrets the API has to read back, e.g. TOTP secrets, so that a leaked database
+// does not reveal them. AES-256-GCM with a random nonce per secret.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// Box of a base64 32-byte key, e.g. generated with openssl rand -base64 32
+func ParseSecretBox(encodedKey string) (*SecretBox, error) {
+	encodedKey = strings.TrimSpace(encodedKey)
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(encodedKey)
+	}
+	// The key is not part of the errors, which may be logged
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("expected a base64 32-byte key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Encrypted secret, base64url of the nonce followed by the ciphertext
+func (b *SecretBox) Seal(secret string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b.aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// Secret of Seal, an error when it was sealed with another key or altered
+func (b *SecretBox) Open(sealed string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < b.aead.NonceSize() {
+		return "", errors.New("invalid sealed secret")
+	}
+
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	secret, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("invalid sealed secret")
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Random single-use token handed to a client (invitation, ...). Only its hash is stored,
+// so a leaked database does not reveal usable tokens.
+func NewOpaqueToken() (token string, tokenHash string, err error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(data)
+	return token, HashOpaqueToken(token), nil
+}
+
+// Stored form of an opaque token
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authentication methods of a login, in the amr claim of its tokens (RFC 8176)
+const (
+	AuthMethodPassword = "pwd"
+	AuthMethodOTP      = "otp" // TOTP or recovery code
+	AuthMethodMFA      = "mfa" // more than one factor
+)
@@ -0,0 +1,324 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Gender codes stored in patients.gender
+const (
+	GenderMale    = "M"
+	GenderFemale  = "F"
+	GenderOther   = "O"
+	GenderUnknown = "U"
+)
+
+// Shared validator with the custom tags of the domain registered:
+//   - thai_national_id: 13 digits with a valid mod-11 check digit
+//   - passport: ICAO style document number, 6-9 upper case letters or digits
+//   - patient_hn: HN pattern of the hospital in the sibling HospitalID field
+//   - gender: one of the gender codes
+//
+// Values of PatientIdentifier are checked against the format of their type.
+//
+// Phone numbers use the built-in e164 tag after NormalizePhoneNumber.
+var Validate = newValidator()
+
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterValidation("thai_national_id", func(fl validator.FieldLevel) bool {
+		return IsValidThaiNationalID(fl.Field().String())
+	})
+	validate.RegisterValidation("passport", func(fl validator.FieldLevel) bool {
+		return IsValidPassportID(fl.Field().String())
+	})
+	validate.RegisterValidation("patient_hn", func(fl validator.FieldLevel) bool {
+		hospitalID := 0
+		if field := reflect.Indirect(fl.Parent()).FieldByName("HospitalID"); field.IsValid() {
+			hospitalID = int(field.Int())
+		}
+		return IsValidPatientHN(hospitalID, fl.Field().String())
+	})
+	validate.RegisterValidation("gender", func(fl validator.FieldLevel) bool {
+		return IsValidGender(fl.Field().String())
+	})
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		identifier := sl.Current().Interface().(PatientIdentifier)
+		if !IsValidIdentifierValue(identifier) {
+			sl.ReportError(identifier.Value, "Value", "Value", "identifier_value", identifier.Type)
+		}
+	}, PatientIdentifier{})
+	return validate
+}
+
+// Thai national ID: 13 digits, the last one being the mod-11 checksum of the first 12
+func IsValidThaiNationalID(id string) bool {
+	if len(id) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(id[i]-'0') * (13 - i)
+		}
+	}
+
+	return (11-sum%11)%10 == int(id[12]-'0')
+}
+
+var passportPattern = regexp.MustCompile(`^[A-Z0-9]{6,9}$`)
+
+func IsValidPassportID(id string) bool {
+	return passportPattern.MatchString(id)
+}
+
+// Upper case and strip spaces, as passport numbers are often typed from the MRZ
+func NormalizePassportID(id string) string {
+	return strings.ToUpper(strings.ReplaceAll(id, " ", ""))
+}
+
+func IsValidGender(gender string) bool {
+	switch gender {
+	case GenderMale, GenderFemale, GenderOther, GenderUnknown:
+		return true
+	}
+	return false
+}
+
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+// Best-effort conversion of a phone number to E.164. Thai local numbers (leading 0)
+// get the +66 country code, the 00 international prefix becomes +.
+// The result still has to pass the e164 tag.
+func NormalizePhoneNumber(phone string) string {
+	phone = phoneSeparators.Replace(strings.TrimSpace(phone))
+	switch {
+	case phone == "", strings.HasPrefix(phone, "+"):
+		return phone
+	case strings.HasPrefix(phone, "00"):
+		return "+" + phone[2:]
+	case strings.HasPrefix(phone, "0"):
+		return "+66" + phone[1:]
+	default:
+		return phone
+	}
+}
+
+// Bring identifiers and phone number of a patient to their canonical stored form
+func NormalizePatient(patient *Patient) {
+	patient.PatientHN = strings.TrimSpace(patient.PatientHN)
+	patient.NationalID = strings.TrimSpace(patient.NationalID)
+	patient.PassportID = NormalizePassportID(patient.PassportID)
+	patient.PhoneNumber = NormalizePhoneNumber(patient.PhoneNumber)
+	patient.Gender = strings.ToUpper(strings.TrimSpace(patient.Gender))
+	SyncPatientIdentifiers(patient)
+}
+
+// Keep the primary identifier fields and the identifier list of a patient consistent:
+// primary values are added to the list, and an empty primary field is filled from
+// the first listed identifier of its type. Thai national IDs are the only national
+// IDs used as primary.
+func SyncPatientIdentifiers(patient *Patient) {
+	identifiers := make([]PatientIdentifier, 0, len(patient.Identifiers)+3)
+	seen := map[PatientIdentifier]bool{}
+	add := func(identifier PatientIdentifier) {
+		identifier.ID = 0
+		identifier.PatientID = patient.ID
+		identifier.HospitalID = patient.HospitalID
+		key := PatientIdentifier{Type: identifier.Type, Issuer: identifier.Issuer, Value: identifier.Value}
+		if !seen[key] {
+			seen[key] = true
+			identifiers = append(identifiers, identifier)
+		}
+	}
+
+	for _, identifier := range patient.Identifiers {
+		identifier.Type = strings.ToLower(strings.TrimSpace(identifier.Type))
+		identifier.Issuer = strings.ToUpper(strings.TrimSpace(identifier.Issuer))
+		identifier.Value = strings.TrimSpace(identifier.Value)
+		if identifier.Type == IdentifierPassport {
+			identifier.Value = NormalizePassportID(identifier.Value)
+		}
+		if identifier.Type == IdentifierNationalID && identifier.Issuer == "" {
+			identifier.Issuer = IssuerThailand
+		}
+		add(identifier)
+	}
+
+	primaries := []struct {
+		field          *string
+		identifierType string
+	}{
+		{&patient.PatientHN, IdentifierHN},
+		{&patient.NationalID, IdentifierNationalID},
+		{&patient.PassportID, IdentifierPassport},
+	}
+	for _, primary := range primaries {
+		isPrimaryCandidate := func(identifier PatientIdentifier) bool {
+			return identifier.Type == primary.identifierType &&
+				(primary.identifierType != IdentifierNationalID || identifier.Issuer == IssuerThailand)
+		}
+
+		if *primary.field == "" {
+			for _, identifier := range identifiers {
+				if isPrimaryCandidate(identifier) {
+					*primary.field = identifier.Value
+					break
+				}
+			}
+			continue
+		}
+
+		listed := false
+		for _, identifier := range identifiers {
+			if isPrimaryCandidate(identifier) && identifier.Value == *primary.field {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			issuer := ""
+			if primary.identifierType == IdentifierNationalID {
+				issuer = IssuerThailand
+			}
+			add(PatientIdentifier{Type: primary.identifierType, Value: *primary.field, Issuer: issuer})
+		}
+	}
+
+	patient.Identifiers = identifiers
+}
+
+// Drop from the identifier list the former primary identifiers whose primary field
+// changed, so that an update replaces a primary value instead of adding to it.
+// Has to run before SyncPatientIdentifiers adds the new primary values.
+func ReplacePrimaryIdentifiers(patient *Patient, former *Patient) {
+	primaries := []struct {
+		value, formerValue string
+		identifierType     string
+	}{
+		{strings.TrimSpace(patient.PatientHN), former.PatientHN, IdentifierHN},
+		{strings.TrimSpace(patient.NationalID), former.NationalID, IdentifierNationalID},
+		{NormalizePassportID(patient.PassportID), former.PassportID, IdentifierPassport},
+	}
+
+	identifiers := make([]PatientIdentifier, 0, len(patient.Identifiers))
+	for _, identifier := range patient.Identifiers {
+		replaced := false
+		for _, primary := range primaries {
+			if primary.formerValue != "" && primary.value != primary.formerValue &&
+				strings.ToLower(strings.TrimSpace(identifier.Type)) == primary.identifierType &&
+				strings.TrimSpace(identifier.Value) == primary.formerValue &&
+				(primary.identifierType != IdentifierNationalID || strings.ToUpper(strings.TrimSpace(identifier.Issuer)) == IssuerThailand) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	patient.Identifiers = identifiers
+}
+
+// Value of an identifier in the format of its type. Only Thai national IDs have a known format.
+func IsValidIdentifierValue(identifier PatientIdentifier) bool {
+	switch identifier.Type {
+	case IdentifierNationalID:
+		return identifier.Issuer != IssuerThailand || IsValidThaiNationalID(identifier.Value)
+	case IdentifierPassport:
+		return IsValidPassportID(identifier.Value)
+	case IdentifierHN:
+		return IsValidPatientHN(identifier.HospitalID, identifier.Value)
+	default:
+		return true
+	}
+}
+
+// Hospital number patterns, configured per hospital by PATIENT_HN_PATTERNS and
+// overridden by the settings of the hospital
+var (
+	DefaultPatientHNPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,50}$`)
+
+	patientHNPatternsMu         sync.RWMutex
+	patientHNPatterns           = map[int]*regexp.Regexp{}
+	configuredPatientHNPatterns = map[int]*regexp.Regexp{}
+)
+
+func compilePatientHNPattern(hospitalID int, pattern string) (*regexp.Regexp, error) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HN pattern of hospital %d: %w", hospitalID, err)
+	}
+	return compiled, nil
+}
+
+// Override the configured HN pattern of a hospital
+func SetPatientHNPattern(hospitalID int, pattern string) error {
+	compiled, err := compilePatientHNPattern(hospitalID, pattern)
+	if err != nil {
+		return err
+	}
+
+	patientHNPatternsMu.Lock()
+	defer patientHNPatternsMu.Unlock()
+	patientHNPatterns[hospitalID] = compiled
+	return nil
+}
+
+// Drop the override of a hospital, back to its configured HN pattern or the default
+func ClearPatientHNPattern(hospitalID int) {
+	patientHNPatternsMu.Lock()
+	defer patientHNPatternsMu.Unlock()
+	delete(patientHNPatterns, hospitalID)
+}
+
+// Load HN patterns from configuration such as "1=^HN[0-9]{6}$;2=^[0-9]{9}$"
+func LoadPatientHNPatterns(config string) error {
+	for _, entry := range strings.Split(config, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		hospital, pattern, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid HN pattern entry %q: expected <hospital_id>=<pattern>", entry)
+		}
+		hospitalID, err := strconv.Atoi(strings.TrimSpace(hospital))
+		if err != nil {
+			return fmt.Errorf("invalid HN pattern entry %q: hospital ID must be an integer", entry)
+		}
+		compiled, err := compilePatientHNPattern(hospitalID, strings.TrimSpace(pattern))
+		if err != nil {
+			return err
+		}
+
+		patientHNPatternsMu.Lock()
+		configuredPatientHNPatterns[hospitalID] = compiled
+		patientHNPatternsMu.Unlock()
+	}
+	return nil
+}
+
+func IsValidPatientHN(hospitalID int, hn string) bool {
+	patientHNPatternsMu.RLock()
+	pattern, ok := patientHNPatterns[hospitalID]
+	if !ok {
+		pattern, ok = configuredPatientHNPatterns[hospitalID]
+	}
+	patientHNPatternsMu.RUnlock()
+
+	if !ok {
+		pattern = DefaultPatientHNPattern
+	}
+	return pattern.MatchString(hn)
+}
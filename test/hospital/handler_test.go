@@ -0,0 +1,224 @@
+package hospital_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/hospital"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Mock HospitalService
+type MockHospitalService struct {
+	mock.Mock
+}
+
+func (m *MockHospitalService) CreateHospital(hospital *pkg.Hospital) (*pkg.Hospital, error) {
+	args := m.Called(hospital)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Hospital), args.Error(1)
+}
+
+func (m *MockHospitalService) ListHospitals() ([]pkg.Hospital, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]pkg.Hospital), args.Error(1)
+}
+
+func (m *MockHospitalService) UpdateHospital(hospital *pkg.Hospital) (*pkg.Hospital, error) {
+	args := m.Called(hospital)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Hospital), args.Error(1)
+}
+
+func (m *MockHospitalService) LoadPatientHNPatterns() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func TestHospitalHandler_CreateHospital(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockHospitalService)
+	handler := hospital.NewHttpHospitalHandler(mockService)
+
+	r := gin.Default()
+	r.POST("/hospital", handler.CreateHospital)
+
+	// Test case: Successful hospital creation
+	t.Run("successful hospital creation", func(t *testing.T) {
+		body := `{"name": "Test Hospital", "code": "13781", "settings": {"patient_hn_pattern": "^HN[0-9]{6}$"}}`
+		code := "13781"
+		expectedHospital := &pkg.Hospital{
+			Name:     "Test Hospital",
+			Code:     &code,
+			Settings: pkg.HospitalSettings{PatientHNPattern: "^HN[0-9]{6}$"},
+		}
+		createdHospital := *expectedHospital
+		createdHospital.ID = 1
+
+		mockService.On("CreateHospital", expectedHospital).Return(&createdHospital, nil)
+
+		req := httptest.NewRequest("POST", "/hospital", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, float64(1), data["id"])
+		assert.Equal(t, "13781", data["code"])
+		assert.Equal(t, "^HN[0-9]{6}$", data["settings"].(map[string]interface{})["patient_hn_pattern"])
+		assert.NotContains(t, data, "patients")
+		assert.NotContains(t, data, "staffs")
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - missing name or code that is not 5 digits
+	t.Run("failed hospital creation (invalid name or code)", func(t *testing.T) {
+		for _, body := range []string{
+			`{"code": "13781"}`,
+			`{"name": "Test Hospital"}`,
+			`{"name": "Test Hospital", "code": "1378"}`,
+			`{"name": "Test Hospital", "code": "1378A"}`,
+		} {
+			req := httptest.NewRequest("POST", "/hospital", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+
+	// Test case: Failed - service errors map to status codes
+	t.Run("failed hospital creation", func(t *testing.T) {
+		expectedCodes := map[error]int{
+			hospital.ErrInvalidHNPattern:      http.StatusBadRequest,
+			hospital.ErrDuplicateHospitalCode: http.StatusConflict,
+			errors.New("service error"):       http.StatusInternalServerError,
+		}
+		for serviceErr, expectedCode := range expectedCodes {
+			// Reset expectations for this test case
+			mockService.ExpectedCalls = nil
+			mockService.On("CreateHospital", mock.AnythingOfType("*pkg.Hospital")).Return(nil, serviceErr)
+
+			req := httptest.NewRequest("POST", "/hospital", bytes.NewBufferString(`{"name": "Test Hospital", "code": "13781"}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, expectedCode, w.Code, serviceErr.Error())
+		}
+	})
+}
+
+func TestHospitalHandler_ListHospitals(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockHospitalService)
+	handler := hospital.NewHttpHospitalHandler(mockService)
+
+	r := gin.Default()
+	r.GET("/hospital", handler.ListHospitals)
+
+	t.Run("successful hospital listing", func(t *testing.T) {
+		mockService.On("ListHospitals").Return([]pkg.Hospital{{ID: 1, Name: "Hospital A"}, {ID: 2, Name: "Hospital B"}}, nil)
+
+		req := httptest.NewRequest("GET", "/hospital", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, response["data"], 2)
+		// Hospitals created before codes have none
+		assert.Nil(t, response["data"].([]interface{})[0].(map[string]interface{})["code"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestHospitalHandler_UpdateHospital(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockHospitalService)
+	handler := hospital.NewHttpHospitalHandler(mockService)
+
+	r := gin.Default()
+	r.PUT("/hospital/:id", handler.UpdateHospital)
+
+	body := `{"name": "Renamed Hospital", "code": "13781"}`
+
+	// Test case: Successful update of the hospital of the path
+	t.Run("successful hospital update", func(t *testing.T) {
+		code := "13781"
+		expectedHospital := &pkg.Hospital{ID: 3, Name: "Renamed Hospital", Code: &code}
+
+		mockService.On("UpdateHospital", expectedHospital).Return(expectedHospital, nil)
+
+		req := httptest.NewRequest("PUT", "/hospital/3", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - invalid hospital ID
+	t.Run("failed hospital update (invalid ID)", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/hospital/abc", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case: Failed - unknown hospital
+	t.Run("failed hospital update (not found)", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("UpdateHospital", mock.AnythingOfType("*pkg.Hospital")).Return(nil, hospital.ErrHospitalNotFound)
+
+		req := httptest.NewRequest("PUT", "/hospital/99", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,157 @@
+package hospital_test
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Peeranut-Kit/health_api_assignment/internal/hospital"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newMockHospitalRepository(t *testing.T) (hospital.HospitalRepositoryInterface, sqlmock.Sqlmock) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	return hospital.NewGormHospitalRepository(gormDB), mock
+}
+
+func TestGormHospitalRepository_CreateHospital(t *testing.T) {
+	repo, mock := newMockHospitalRepository(t)
+
+	// Success case
+	t.Run("successful hospital creation", func(t *testing.T) {
+		code := "13781"
+		newHospital := pkg.Hospital{Name: "Test Hospital", Code: &code, Settings: pkg.HospitalSettings{PatientHNPattern: "^HN[0-9]{6}$"}}
+
+		// Settings are stored as JSON
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "hospitals" \("name","code","settings"\)`).
+			WithArgs("Test Hospital", "13781", `{"patient_hn_pattern":"^HN[0-9]{6}$"}`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		err := repo.CreateHospital(&newHospital)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, newHospital.ID)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the code is used by another hospital
+	t.Run("duplicate hospital code", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "hospitals"`).
+			WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "hospitals_code_key"})
+		mock.ExpectRollback()
+
+		err := repo.CreateHospital(&pkg.Hospital{Name: "Other Hospital"})
+
+		assert.ErrorIs(t, err, hospital.ErrDuplicateHospitalCode)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormHospitalRepository_ListHospitals(t *testing.T) {
+	repo, mock := newMockHospitalRepository(t)
+
+	t.Run("successful hospital listing", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"id", "name", "code", "settings"}).
+			AddRow(1, "Hospital A", "13781", `{"patient_hn_pattern":"^HN[0-9]{6}$"}`).
+			AddRow(2, "Hospital B", nil, `{}`)
+		mock.ExpectQuery(`SELECT \* FROM "hospitals" ORDER BY id`).WillReturnRows(rows)
+
+		hospitals, err := repo.ListHospitals()
+
+		assert.NoError(t, err)
+		assert.Len(t, hospitals, 2)
+		assert.Equal(t, "13781", *hospitals[0].Code)
+		assert.Equal(t, "^HN[0-9]{6}$", hospitals[0].Settings.PatientHNPattern)
+		assert.Nil(t, hospitals[1].Code)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormHospitalRepository_UpdateHospital(t *testing.T) {
+	repo, mock := newMockHospitalRepository(t)
+
+	// Success case
+	t.Run("successful hospital update", func(t *testing.T) {
+		code := "13781"
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "hospitals" SET "name"=\$1,"code"=\$2,"settings"=\$3 WHERE id = \$4`).
+			WithArgs("Renamed Hospital", "13781", `{}`, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.UpdateHospital(&pkg.Hospital{ID: 1, Name: "Renamed Hospital", Code: &code})
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - unknown hospital
+	t.Run("hospital not found", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "hospitals"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		err := repo.UpdateHospital(&pkg.Hospital{ID: 99, Name: "Unknown Hospital"})
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormHospitalRepository_GetHospitalByID(t *testing.T) {
+	repo, mock := newMockHospitalRepository(t)
+
+	t.Run("successful hospital retrieving", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"id", "name", "code", "settings"}).
+			AddRow(1, "Hospital A", "13781", `{"his_base_url":"http://his.hospital-a.co.th"}`)
+		mock.ExpectQuery(`SELECT \* FROM "hospitals" WHERE id = \$1 ORDER BY "hospitals"."id" LIMIT \$2`).
+			WithArgs(1, 1).
+			WillReturnRows(rows)
+
+		result, err := repo.GetHospitalByID(1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "http://his.hospital-a.co.th", result.Settings.HISBaseURL)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("hospital not found", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \* FROM "hospitals"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		result, err := repo.GetHospitalByID(99)
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.Nil(t, result)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,243 @@
+package hospital_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/hospital"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type mockHospitalRepo struct {
+	mock.Mock
+}
+
+func (m *mockHospitalRepo) CreateHospital(hospital *pkg.Hospital) error {
+	args := m.Called(hospital)
+	return args.Error(0)
+}
+
+func (m *mockHospitalRepo) ListHospitals() ([]pkg.Hospital, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]pkg.Hospital), args.Error(1)
+}
+
+func (m *mockHospitalRepo) GetHospitalByID(id int) (*pkg.Hospital, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Hospital), args.Error(1)
+}
+
+func (m *mockHospitalRepo) UpdateHospital(hospital *pkg.Hospital) error {
+	args := m.Called(hospital)
+	return args.Error(0)
+}
+
+func TestHospitalService_CreateHospital(t *testing.T) {
+	mockRepo := new(mockHospitalRepo)
+	service := hospital.NewHospitalService(mockRepo)
+
+	// Test case: Successful creation, the HN pattern of the settings applies to the hospital's patients
+	t.Run("successful hospital creation", func(t *testing.T) {
+		code := "13781"
+		inputHospital := &pkg.Hospital{
+			ID:       7,
+			Name:     "Test Hospital",
+			Code:     &code,
+			Settings: pkg.HospitalSettings{PatientHNPattern: `^HN[0-9]{6}$`},
+		}
+
+		mockRepo.On("CreateHospital", inputHospital).Return(nil).Run(func(args mock.Arguments) {
+			// ID is assigned by the database
+			assert.Zero(t, args.Get(0).(*pkg.Hospital).ID)
+			args.Get(0).(*pkg.Hospital).ID = 101
+		})
+
+		createdHospital, err := service.CreateHospital(inputHospital)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 101, createdHospital.ID)
+		assert.True(t, pkg.IsValidPatientHN(101, "HN123456"))
+		assert.False(t, pkg.IsValidPatientHN(101, "123456"))
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - invalid HN pattern is rejected before the repository
+	t.Run("invalid HN pattern", func(t *testing.T) {
+		mockRepo.Calls = nil
+
+		_, err := service.CreateHospital(&pkg.Hospital{Name: "Test Hospital", Settings: pkg.HospitalSettings{PatientHNPattern: `^HN[0-9`}})
+
+		assert.ErrorIs(t, err, hospital.ErrInvalidHNPattern)
+		mockRepo.AssertNotCalled(t, "CreateHospital", mock.Anything)
+	})
+
+	// Test case: Failed - HIS base URL which is not an absolute http(s) URL
+	t.Run("invalid HIS base URL", func(t *testing.T) {
+		mockRepo.Calls = nil
+
+		for _, hisBaseURL := range []string{"his.hospital-a.co.th", "ftp://his.hospital-a.co.th", "http://"} {
+			_, err := service.CreateHospital(&pkg.Hospital{Name: "Test Hospital", Settings: pkg.HospitalSettings{HISBaseURL: hisBaseURL}})
+
+			assert.ErrorIs(t, err, hospital.ErrInvalidHISBaseURL, hisBaseURL)
+		}
+		mockRepo.AssertNotCalled(t, "CreateHospital", mock.Anything)
+	})
+
+	// Test case: Failed - HIS timeout out of range
+	t.Run("invalid HIS timeout", func(t *testing.T) {
+		for _, timeoutMs := range []int{-1, int(pkg.MaxHISTimeout.Milliseconds()) + 1} {
+			_, err := service.CreateHospital(&pkg.Hospital{Name: "Test Hospital", Settings: pkg.HospitalSettings{HISBaseURL: "http://his.hospital-a.co.th", HISTimeoutMs: timeoutMs}})
+
+			assert.ErrorIs(t, err, hospital.ErrInvalidHISTimeout, timeoutMs)
+		}
+		mockRepo.AssertNotCalled(t, "CreateHospital", mock.Anything)
+	})
+
+	// Test case: Failed - MFA required of a role that is not a hospital staff role
+	t.Run("error invalid MFA required roles", func(t *testing.T) {
+		mockRepo.ExpectedCalls = nil
+		for _, role := range []pkg.Role{"surgeon", pkg.RoleSuperAdmin} {
+			_, err := service.CreateHospital(&pkg.Hospital{Name: "Test Hospital", Settings: pkg.HospitalSettings{MFARequiredRoles: []pkg.Role{pkg.RoleDoctor, role}}})
+
+			assert.ErrorIs(t, err, hospital.ErrInvalidMFARoles, role)
+		}
+		mockRepo.AssertNotCalled(t, "CreateHospital", mock.Anything)
+	})
+
+	// Test case: Failed - repository error
+	t.Run("error hospital repository creation", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("CreateHospital", mock.AnythingOfType("*pkg.Hospital")).Return(hospital.ErrDuplicateHospitalCode)
+
+		createdHospital, err := service.CreateHospital(&pkg.Hospital{Name: "Test Hospital"})
+
+		assert.ErrorIs(t, err, hospital.ErrDuplicateHospitalCode)
+		assert.Nil(t, createdHospital)
+	})
+}
+
+func TestHospitalService_UpdateHospital(t *testing.T) {
+	mockRepo := new(mockHospitalRepo)
+	service := hospital.NewHospitalService(mockRepo)
+
+	// Test case: Successful update
+	t.Run("successful hospital update", func(t *testing.T) {
+		inputHospital := &pkg.Hospital{ID: 1, Name: "Renamed Hospital"}
+
+		mockRepo.On("UpdateHospital", inputHospital).Return(nil)
+
+		updatedHospital, err := service.UpdateHospital(inputHospital)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Renamed Hospital", updatedHospital.Name)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - unknown hospital
+	t.Run("hospital not found", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("UpdateHospital", mock.AnythingOfType("*pkg.Hospital")).Return(gorm.ErrRecordNotFound)
+
+		_, err := service.UpdateHospital(&pkg.Hospital{ID: 99, Name: "Unknown Hospital"})
+
+		assert.ErrorIs(t, err, hospital.ErrHospitalNotFound)
+	})
+
+	// Test case: Successful update clearing the HN pattern, back to the configured one
+	t.Run("successful clearing of the HN pattern", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("UpdateHospital", mock.AnythingOfType("*pkg.Hospital")).Return(nil)
+		assert.NoError(t, pkg.LoadPatientHNPatterns(`12=^[A-Z]{2}[0-9]{4}$`))
+
+		_, err := service.UpdateHospital(&pkg.Hospital{ID: 11, Name: "Hospital", Settings: pkg.HospitalSettings{PatientHNPattern: `^[0-9]{9}$`}})
+		assert.NoError(t, err)
+		assert.False(t, pkg.IsValidPatientHN(11, "HN-1"))
+
+		_, err = service.UpdateHospital(&pkg.Hospital{ID: 11, Name: "Hospital"})
+		assert.NoError(t, err)
+		assert.True(t, pkg.IsValidPatientHN(11, "HN-1"))
+
+		// A hospital of PATIENT_HN_PATTERNS gets the configured pattern again
+		_, err = service.UpdateHospital(&pkg.Hospital{ID: 12, Name: "Hospital", Settings: pkg.HospitalSettings{PatientHNPattern: `^[0-9]{9}$`}})
+		assert.NoError(t, err)
+		assert.False(t, pkg.IsValidPatientHN(12, "HN0001"))
+
+		_, err = service.UpdateHospital(&pkg.Hospital{ID: 12, Name: "Hospital"})
+		assert.NoError(t, err)
+		assert.True(t, pkg.IsValidPatientHN(12, "HN0001"))
+		assert.False(t, pkg.IsValidPatientHN(12, "123456789"))
+	})
+}
+
+func TestHospitalService_LoadPatientHNPatterns(t *testing.T) {
+	mockRepo := new(mockHospitalRepo)
+	service := hospital.NewHospitalService(mockRepo)
+
+	t.Run("successful loading", func(t *testing.T) {
+		mockRepo.On("ListHospitals").Return([]pkg.Hospital{
+			{ID: 201, Settings: pkg.HospitalSettings{PatientHNPattern: `^[0-9]{9}$`}},
+			{ID: 202},
+		}, nil)
+
+		err := service.LoadPatientHNPatterns()
+
+		assert.NoError(t, err)
+		assert.True(t, pkg.IsValidPatientHN(201, "123456789"))
+		assert.False(t, pkg.IsValidPatientHN(201, "HN-1"))
+		// Hospitals without a pattern keep the default
+		assert.True(t, pkg.IsValidPatientHN(202, "HN-1"))
+	})
+
+	t.Run("error hospital repository lookup", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("ListHospitals").Return(nil, errors.New("database error"))
+
+		assert.Error(t, service.LoadPatientHNPatterns())
+	})
+}
+
+type mockSettingsObserver struct {
+	mock.Mock
+}
+
+func (m *mockSettingsObserver) HospitalSettingsChanged(hospital *pkg.Hospital) {
+	m.Called(hospital)
+}
+
+// Tests that observers are told about created and updated hospitals only
+func TestHospitalService_SettingsObserver(t *testing.T) {
+	mockRepo := new(mockHospitalRepo)
+	observer := new(mockSettingsObserver)
+	service := hospital.NewHospitalService(mockRepo, observer)
+
+	updatedHospital := &pkg.Hospital{ID: 1, Name: "Hospital", Settings: pkg.HospitalSettings{HISBaseURL: "http://his.hospital-a.co.th"}}
+	mockRepo.On("UpdateHospital", updatedHospital).Return(nil)
+	observer.On("HospitalSettingsChanged", updatedHospital).Return()
+
+	_, err := service.UpdateHospital(updatedHospital)
+	assert.NoError(t, err)
+	observer.AssertExpectations(t)
+
+	// Failed updates change nothing
+	mockRepo.On("UpdateHospital", mock.AnythingOfType("*pkg.Hospital")).Return(gorm.ErrRecordNotFound)
+	_, err = service.UpdateHospital(&pkg.Hospital{ID: 99, Name: "Unknown Hospital"})
+	assert.Error(t, err)
+	observer.AssertNumberOfCalls(t, "HospitalSettingsChanged", 1)
+}
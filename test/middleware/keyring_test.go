@@ -0,0 +1,185 @@
+package middleware_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func loginClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"jti":               "token-rotation",
+		"staff_id":          1,
+		"staff_hospital_id": 1,
+		"staff_role":        pkg.RoleNurse,
+		"iat":               time.Now().Unix(),
+		"exp":               time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// Status of GET /session with the token in an Authorization: Bearer header
+func sessionStatus(keys *pkg.KeyRing, token string) int {
+	r := gin.New()
+	r.GET("/session", middleware.AuthRequired(keys, &fakeRevocations{}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/session", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+// Tests a key rotation: the next key is introduced, then activated, then the former key is retired
+func TestKeyRing_Rotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	oldKey, err := pkg.GenerateSigningKey("2025-01")
+	assert.NoError(t, err)
+	newKey, err := pkg.GenerateSigningKey("2025-07")
+	assert.NoError(t, err)
+
+	before, err := pkg.NewKeyRing("2025-01", oldKey)
+	assert.NoError(t, err)
+	introduced, err := pkg.NewKeyRing("2025-01", oldKey, newKey)
+	assert.NoError(t, err)
+	activated, err := pkg.NewKeyRing("2025-07", oldKey, newKey)
+	assert.NoError(t, err)
+	retired, err := pkg.NewKeyRing("2025-07", newKey)
+	assert.NoError(t, err)
+
+	oldToken, err := before.Sign(loginClaims())
+	assert.NoError(t, err)
+	newToken, err := activated.Sign(loginClaims())
+	assert.NoError(t, err)
+
+	// Test case: tokens name their key in the kid header
+	t.Run("kid header", func(t *testing.T) {
+		token, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "2025-07", token.Header["kid"])
+		assert.Equal(t, "EdDSA", token.Header["alg"])
+	})
+
+	// Test case: instances not yet activating the new key already accept its tokens
+	t.Run("overlap", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, sessionStatus(introduced, oldToken))
+		assert.Equal(t, http.StatusOK, sessionStatus(introduced, newToken))
+		assert.Equal(t, http.StatusOK, sessionStatus(activated, oldToken))
+		assert.Equal(t, http.StatusOK, sessionStatus(activated, newToken))
+	})
+
+	// Test case: Failed - tokens of a retired or unknown key
+	t.Run("retired key", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, sessionStatus(retired, newToken))
+		assert.Equal(t, http.StatusUnauthorized, sessionStatus(retired, oldToken))
+		assert.Equal(t, http.StatusUnauthorized, sessionStatus(before, newToken))
+	})
+}
+
+// Tests that tokens of other algorithms or without a known kid are rejected
+func TestKeyRing_RejectedTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Test case: Failed - former tokens signed with a shared HMAC secret
+	t.Run("HS256 token", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, loginClaims())
+		token.Header["kid"] = testKeys.ActiveKeyID()
+		tokenString, err := token.SignedString([]byte("test_secret"))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, sessionStatus(testKeys, tokenString))
+	})
+
+	t.Run("unsigned token", func(t *testing.T) {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodNone, loginClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, sessionStatus(testKeys, tokenString))
+	})
+
+	t.Run("token without kid", func(t *testing.T) {
+		key, err := pkg.GenerateSigningKey("test-1")
+		assert.NoError(t, err)
+		keys, err := pkg.NewKeyRing("", key)
+		assert.NoError(t, err)
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, loginClaims()).SignedString(key.PrivateKey)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, sessionStatus(keys, tokenString))
+	})
+}
+
+// Tests the public keys published for other services
+func TestKeyRing_JWKS(t *testing.T) {
+	first, err := pkg.GenerateSigningKey("b")
+	assert.NoError(t, err)
+	second, err := pkg.GenerateSigningKey("a")
+	assert.NoError(t, err)
+	keys, err := pkg.NewKeyRing("b", first, second)
+	assert.NoError(t, err)
+
+	set := keys.JWKS()
+
+	assert.Len(t, set.Keys, 2)
+	assert.Equal(t, "a", set.Keys[0].KeyID)
+	assert.Equal(t, pkg.JWK{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         base64.RawURLEncoding.EncodeToString(first.PrivateKey.Public().(ed25519.PublicKey)),
+		KeyID:     "b",
+		Use:       "sig",
+		Algorithm: "EdDSA",
+	}, set.Keys[1])
+}
+
+func TestParseKeyRing(t *testing.T) {
+	first, err := pkg.GenerateSigningKey("2025-01")
+	assert.NoError(t, err)
+	second, err := pkg.GenerateSigningKey("2025-07")
+	assert.NoError(t, err)
+
+	// Test case: entries printed by cmd/jwtkey
+	t.Run("successful parse", func(t *testing.T) {
+		keys, err := pkg.ParseKeyRing(first.String()+"; "+second.String()+";", "2025-07")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "2025-07", keys.ActiveKeyID())
+		assert.Len(t, keys.JWKS().Keys, 2)
+	})
+
+	t.Run("single key active by default", func(t *testing.T) {
+		keys, err := pkg.ParseKeyRing(first.String(), "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "2025-01", keys.ActiveKeyID())
+	})
+
+	// Test case: Failed - invalid configurations
+	t.Run("invalid key rings", func(t *testing.T) {
+		configs := map[string][2]string{
+			"no key":               {"", ""},
+			"missing seed":         {"2025-01", ""},
+			"invalid seed":         {"2025-01=not-a-seed", ""},
+			"duplicate key":        {first.String() + ";" + first.String(), "2025-01"},
+			"active key unknown":   {first.String(), "2025-07"},
+			"active key not named": {first.String() + ";" + second.String(), ""},
+		}
+		for name, config := range configs {
+			_, err := pkg.ParseKeyRing(config[0], config[1])
+
+			assert.Error(t, err, name)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// Tests RequireMFA behind AuthRequired with the amr and mfa_required claims of the login tokens
+func TestRequireMFA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/patient/search/:id", middleware.AuthRequired(testKeys, &fakeRevocations{}), middleware.RequireMFA(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	status := func(amr []string, mfaRequired *bool) (int, string) {
+		claims := loginClaims()
+		if amr != nil {
+			claims["amr"] = amr
+		}
+		if mfaRequired != nil {
+			claims["mfa_required"] = *mfaRequired
+		}
+		token, err := testKeys.Sign(claims)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/patient/search/1234567890123", nil)
+		req.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+	required, notRequired := true, false
+
+	// Test case: staff who have to use MFA pass with an MFA login only
+	t.Run("MFA required", func(t *testing.T) {
+		code, _ := status([]string{pkg.AuthMethodPassword, pkg.AuthMethodOTP, pkg.AuthMethodMFA}, &required)
+		assert.Equal(t, http.StatusOK, code)
+
+		code, body := status([]string{pkg.AuthMethodPassword}, &required)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.JSONEq(t, `{"error": "This route requires a login with multi-factor authentication", "code": "MFA_REQUIRED"}`, body)
+	})
+
+	// Test case: password logins pass when MFA is not required of the staff member
+	t.Run("MFA not required", func(t *testing.T) {
+		code, _ := status([]string{pkg.AuthMethodPassword}, &notRequired)
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	// Test case: Failed - tokens issued before MFA carry neither claim and are rejected
+	t.Run("token without MFA claims", func(t *testing.T) {
+		code, _ := status(nil, nil)
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+}
+
+// Tests that the token of a login waiting for its MFA code is not a login token
+func TestAuthRequired_MFAPendingToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	claims := loginClaims()
+	claims["typ"] = "mfa_pending"
+	claims["amr"] = []string{pkg.AuthMethodPassword}
+	token, err := testKeys.Sign(claims)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, sessionStatus(testKeys, token))
+}
@@ -0,0 +1,189 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// Key ring of the tests, signing with the test-1 key
+var testKeys = newTestKeyRing("test-1")
+
+func newTestKeyRing(activeID string) *pkg.KeyRing {
+	key, err := pkg.GenerateSigningKey(activeID)
+	if err != nil {
+		panic(err)
+	}
+	keys, err := pkg.NewKeyRing(activeID, key)
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+// Revocation store in memory
+type fakeRevocations struct {
+	tokenIDs map[string]bool
+}
+
+func (f *fakeRevocations) IsTokenRevoked(tokenID string, staffID int, familyID string, issuedAt time.Time) (bool, error) {
+	return f.tokenIDs[tokenID], nil
+}
+
+// Sign a login token as createToken does, without a role claim when role is empty
+func signToken(t *testing.T, role pkg.Role) string {
+	return signTokenWithID(t, role, "token-"+string(role))
+}
+
+func signTokenWithID(t *testing.T, role pkg.Role, tokenID string) string {
+	claims := jwt.MapClaims{
+		"staff_id":          1,
+		"staff_hospital_id": 1,
+		"iat":               time.Now().Unix(),
+		"exp":               time.Now().Add(time.Hour).Unix(),
+	}
+	if tokenID != "" {
+		claims["jti"] = tokenID
+	}
+	if role != "" {
+		claims["staff_role"] = role
+	}
+
+	token, err := testKeys.Sign(claims)
+	assert.NoError(t, err)
+	return token
+}
+
+// Tests RequirePermission behind AuthRequired
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.DELETE("/patient/:id", middleware.AuthRequired(testKeys, &fakeRevocations{}), middleware.RequirePermission(pkg.PermissionDeletePatient), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"role": middleware.GetRole(c)})
+	})
+
+	// Test case: only roles granted the permission pass, tokens without a role are forbidden
+	expectedCodes := map[pkg.Role]int{
+		pkg.RoleAdmin:             http.StatusOK,
+		pkg.RoleDoctor:            http.StatusForbidden,
+		pkg.RoleNurse:             http.StatusForbidden,
+		pkg.RoleRegistrationClerk: http.StatusForbidden,
+		pkg.RoleSuperAdmin:        http.StatusForbidden,
+		"":                        http.StatusForbidden,
+	}
+	for role, expectedCode := range expectedCodes {
+		req := httptest.NewRequest("DELETE", "/patient/7", nil)
+		req.AddCookie(&http.Cookie{Name: "jwt", Value: signToken(t, role)})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, expectedCode, w.Code, role)
+	}
+
+	// Test case: not logged in is still unauthorized rather than forbidden
+	t.Run("missing token", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/patient/7", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+// Tests that AuthRequired rejects revoked tokens and tokens which cannot be revoked
+func TestAuthRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	revocations := &fakeRevocations{tokenIDs: map[string]bool{"revoked": true}}
+	r := gin.New()
+	r.GET("/session", middleware.AuthRequired(testKeys, revocations), func(c *gin.Context) {
+		session, err := middleware.GetSession(c)
+		assert.NoError(t, err)
+		c.JSON(http.StatusOK, gin.H{"token_id": session.TokenID, "staff_id": session.StaffID})
+	})
+
+	expectedCodes := map[string]int{
+		"valid":   http.StatusOK,
+		"revoked": http.StatusUnauthorized,
+		"":        http.StatusUnauthorized, // issued before logout existed
+	}
+	for tokenID, expectedCode := range expectedCodes {
+		req := httptest.NewRequest("GET", "/session", nil)
+		req.AddCookie(&http.Cookie{Name: "jwt", Value: signTokenWithID(t, pkg.RoleNurse, tokenID)})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, expectedCode, w.Code, tokenID)
+		if expectedCode == http.StatusOK {
+			assert.JSONEq(t, `{"token_id": "valid", "staff_id": 1}`, w.Body.String())
+		}
+	}
+}
+
+// Tests that AuthRequired accepts the token in an Authorization: Bearer header as well as in the cookie
+func TestAuthRequired_BearerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/session", middleware.AuthRequired(testKeys, &fakeRevocations{}), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"role": middleware.GetRole(c)})
+	})
+	token := signToken(t, pkg.RoleNurse)
+
+	expectedCodes := map[string]int{
+		"Bearer " + token:    http.StatusOK,
+		"bearer " + token:    http.StatusOK,
+		"Basic " + token:     http.StatusUnauthorized,
+		"Bearer ":            http.StatusUnauthorized,
+		"Bearer not-a-token": http.StatusUnauthorized,
+		token:                http.StatusUnauthorized,
+	}
+	for header, expectedCode := range expectedCodes {
+		req := httptest.NewRequest("GET", "/session", nil)
+		req.Header.Set("Authorization", header)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, expectedCode, w.Code, header)
+	}
+
+	// Test case: a malformed header is not ignored in favour of a valid cookie
+	t.Run("malformed header with cookie", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/session", nil)
+		req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		req.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+// Tests the permissions granted to each role
+func TestRoleCan(t *testing.T) {
+	assert.True(t, pkg.RoleAdmin.Can(pkg.PermissionManageStaff))
+	assert.False(t, pkg.RoleAdmin.Can(pkg.PermissionViewPatientContact))
+	assert.True(t, pkg.RoleDoctor.Can(pkg.PermissionViewPatientContact))
+	assert.True(t, pkg.RoleNurse.Can(pkg.PermissionReadPatient))
+	assert.False(t, pkg.RoleNurse.Can(pkg.PermissionWritePatient))
+	assert.True(t, pkg.RoleRegistrationClerk.Can(pkg.PermissionWritePatient))
+	assert.False(t, pkg.RoleRegistrationClerk.Can(pkg.PermissionDeletePatient))
+	assert.False(t, pkg.Role("superuser").Can(pkg.PermissionReadPatient))
+	// Only the super-admin manages hospitals, and it sees no patient
+	assert.True(t, pkg.RoleSuperAdmin.Can(pkg.PermissionManageHospitals))
+	assert.False(t, pkg.RoleAdmin.Can(pkg.PermissionManageHospitals))
+	assert.False(t, pkg.RoleSuperAdmin.Can(pkg.PermissionReadPatient))
+}
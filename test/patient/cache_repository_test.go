@@ -0,0 +1,186 @@
+package patient
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/patient"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// The decorator behaves the same with the in-process and the Redis cache
+func patientCaches(t *testing.T) map[string]pkg.Cache {
+	redisServer := miniredis.RunT(t)
+	redisCache, err := pkg.NewRedisCache("redis://" + redisServer.Addr())
+	if err != nil {
+		t.Fatalf("Failed to connect to the test Redis: %v", err)
+	}
+
+	return map[string]pkg.Cache{
+		"LRU":   pkg.NewLRUCache(100),
+		"Redis": redisCache,
+	}
+}
+
+func TestCachingPatientRepository(t *testing.T) {
+	dateOfBirth := time.Date(1985, 4, 12, 0, 0, 0, 0, time.UTC)
+	storedPatient := &pkg.Patient{
+		ID:          1,
+		FirstNameEn: "Somchai",
+		DateOfBirth: dateOfBirth,
+		PatientHN:   "HN000001",
+		NationalID:  "1101700230708",
+		HospitalID:  1,
+		Identifiers: []pkg.PatientIdentifier{{ID: 7, PatientID: 1, HospitalID: 1, Type: pkg.IdentifierHN, Value: "HN000001"}},
+	}
+
+	for name, cache := range patientCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			mockNext := new(mockPatientRepo)
+			repo := patient.NewCachingPatientRepository(mockNext, cache, time.Minute)
+
+			// Success case - repeated lookups are read from the cache, hidden fields included
+			t.Run("cached lookup", func(t *testing.T) {
+				mockNext.On("GetPatientByIdentifier", 1, "1101700230708").Return(storedPatient, nil).Once()
+
+				for i := 0; i < 3; i++ {
+					result, err := repo.GetPatientByIdentifier(1, "1101700230708")
+
+					assert.NoError(t, err)
+					assert.Equal(t, storedPatient, result)
+				}
+				mockNext.AssertNumberOfCalls(t, "GetPatientByIdentifier", 1)
+			})
+
+			t.Run("cached search", func(t *testing.T) {
+				request := &pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{FirstNameEn: "Somchai", HospitalID: 1}}
+				mockNext.On("SearchPatient", request).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{*storedPatient}}, nil).Once()
+
+				for i := 0; i < 2; i++ {
+					result, err := repo.SearchPatient(&pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{FirstNameEn: "Somchai", HospitalID: 1}})
+
+					assert.NoError(t, err)
+					assert.Len(t, result.Patients, 1)
+				}
+				mockNext.AssertNumberOfCalls(t, "SearchPatient", 1)
+			})
+
+			// Keys are per hospital
+			t.Run("other hospital not cached", func(t *testing.T) {
+				mockNext.On("GetPatientByIdentifier", 2, "1101700230708").Return(nil, gorm.ErrRecordNotFound).Twice()
+
+				for i := 0; i < 2; i++ {
+					result, err := repo.GetPatientByIdentifier(2, "1101700230708")
+
+					assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+					assert.Nil(t, result)
+				}
+				// Unknown patients are not cached
+				mockNext.AssertNumberOfCalls(t, "GetPatientByIdentifier", 3)
+			})
+
+			// A write drops the entries of the hospital
+			t.Run("invalidation on update", func(t *testing.T) {
+				updatedPatient := *storedPatient
+				updatedPatient.FirstNameEn = "Somsak"
+				mockNext.On("UpdatePatient", &updatedPatient).Return(nil).Once()
+				mockNext.On("GetPatientByIdentifier", 1, "1101700230708").Return(&updatedPatient, nil).Once()
+
+				assert.NoError(t, repo.UpdatePatient(&updatedPatient))
+				result, err := repo.GetPatientByIdentifier(1, "1101700230708")
+
+				assert.NoError(t, err)
+				assert.Equal(t, "Somsak", result.FirstNameEn)
+				mockNext.AssertNumberOfCalls(t, "GetPatientByIdentifier", 4)
+			})
+
+			t.Run("invalidation on create and delete", func(t *testing.T) {
+				request := &pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{FirstNameEn: "Somchai", HospitalID: 1}}
+				mockNext.On("SearchPatient", request).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{}}, nil).Twice()
+				mockNext.On("CreatePatient", mock.AnythingOfType("*pkg.Patient")).Return(nil).Once()
+				mockNext.On("DeletePatient", 1, 1).Return(nil).Once()
+
+				assert.NoError(t, repo.CreatePatient(&pkg.Patient{HospitalID: 1}))
+				result, err := repo.SearchPatient(request)
+				assert.NoError(t, err)
+				assert.NotNil(t, result.Patients)
+
+				// Empty results are cached too
+				result, err = repo.SearchPatient(request)
+				assert.NoError(t, err)
+				assert.NotNil(t, result.Patients)
+				mockNext.AssertNumberOfCalls(t, "SearchPatient", 2)
+
+				assert.NoError(t, repo.DeletePatient(1, 1))
+				_, err = repo.SearchPatient(request)
+				assert.NoError(t, err)
+				mockNext.AssertNumberOfCalls(t, "SearchPatient", 3)
+			})
+
+			// Failure case - an unreadable entry is read again from the repository, and logged without its identifier
+			t.Run("corrupt entry logged without the identifier", func(t *testing.T) {
+				assert.NoError(t, cache.Set("patient:1:identifier:GB1234567", []byte("not gob"), time.Minute))
+				mockNext.On("GetPatientByIdentifier", 1, "GB1234567").Return(storedPatient, nil).Once()
+
+				var logged bytes.Buffer
+				log.SetOutput(&logged)
+				defer log.SetOutput(os.Stderr)
+
+				result, err := repo.GetPatientByIdentifier(1, "GB1234567")
+
+				assert.NoError(t, err)
+				assert.Equal(t, storedPatient, result)
+				assert.Contains(t, logged.String(), "patient cache error: patient:1:")
+				assert.NotContains(t, logged.String(), "GB1234567")
+			})
+		})
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	cache := pkg.NewLRUCache(2)
+	cache.Now = func() time.Time { return now }
+
+	// The least recently used entry is evicted
+	t.Run("eviction", func(t *testing.T) {
+		cache.Set("a", []byte("1"), time.Minute)
+		cache.Set("b", []byte("2"), time.Minute)
+		cache.Get("a")
+		cache.Set("c", []byte("3"), time.Minute)
+
+		_, ok, _ := cache.Get("b")
+		assert.False(t, ok)
+		value, ok, _ := cache.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("1"), value)
+		assert.Equal(t, 2, cache.Len())
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		cache.Set("d", []byte("4"), time.Second)
+		now = now.Add(time.Second)
+
+		_, ok, _ := cache.Get("d")
+		assert.False(t, ok)
+	})
+
+	t.Run("delete by prefix", func(t *testing.T) {
+		cache.Set("patient:1:a", []byte("1"), time.Minute)
+		cache.Set("patient:2:a", []byte("2"), time.Minute)
+
+		assert.NoError(t, cache.DeletePrefix("patient:1:"))
+
+		_, ok, _ := cache.Get("patient:1:a")
+		assert.False(t, ok)
+		_, ok, _ = cache.Get("patient:2:a")
+		assert.True(t, ok)
+	})
+}
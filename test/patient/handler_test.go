@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/Peeranut-Kit/health_api_assignment/internal/patient"
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
@@ -20,14 +24,53 @@ type MockPatientService struct {
 	mock.Mock
 }
 
-func (m *MockPatientService) SearchPatient(patientSearchRequest *pkg.Patient) ([]pkg.Patient, error) {
+func (m *MockPatientService) SearchPatient(patientSearchRequest *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error) {
 	args := m.Called(patientSearchRequest)
 	if args.Get(0) == nil {
 		// If the first return value is nil, avoid type assertion and return nil
 		return nil, args.Error(1)
 	}
 	// Type assertion if not nil
-	return args.Get(0).([]pkg.Patient), args.Error(1)
+	return args.Get(0).(*pkg.PatientSearchResult), args.Error(1)
+}
+
+func (m *MockPatientService) GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error) {
+	args := m.Called(hospitalID, identifier)
+	if args.Get(0) == nil {
+		// If the first return value is nil, avoid type assertion and return nil
+		return nil, args.Error(1)
+	}
+	// Type assertion if not nil
+	return args.Get(0).(*pkg.Patient), args.Error(1)
+}
+
+func (m *MockPatientService) GetPatientByID(hospitalID int, id int) (*pkg.Patient, error) {
+	args := m.Called(hospitalID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Patient), args.Error(1)
+}
+
+func (m *MockPatientService) CreatePatient(patient *pkg.Patient) (*pkg.Patient, error) {
+	args := m.Called(patient)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Patient), args.Error(1)
+}
+
+func (m *MockPatientService) UpdatePatient(patient *pkg.Patient) (*pkg.Patient, error) {
+	args := m.Called(patient)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Patient), args.Error(1)
+}
+
+func (m *MockPatientService) DeletePatient(hospitalID int, id int) error {
+	args := m.Called(hospitalID, id)
+	return args.Error(0)
 }
 
 // Mock returning hospitalID as 1 without JWT cookie
@@ -35,6 +78,13 @@ func mockGetHospitalID(c *gin.Context) (int, error) {
 	return 1, nil
 }
 
+// Mock returning the given role without JWT cookie
+func mockGetRole(role pkg.Role) func(c *gin.Context) pkg.Role {
+	return func(c *gin.Context) pkg.Role {
+		return role
+	}
+}
+
 // Tests the SearchPatient handler of HttpPatientHandler
 func TestPatientHandler_SearchPatient(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -43,6 +93,7 @@ func TestPatientHandler_SearchPatient(t *testing.T) {
 	handler := &patient.PatientHandler{
 		Service:         mockService,
 		GetHospitalIDFn: mockGetHospitalID,
+		GetRoleFn:       mockGetRole(pkg.RoleDoctor),
 	}
 
 	r := gin.Default()
@@ -50,17 +101,25 @@ func TestPatientHandler_SearchPatient(t *testing.T) {
 
 	// Test case: Successful patient searching
 	t.Run("successful patient searching", func(t *testing.T) {
-		// mock input body request
-		inputPatientSearchRequest := pkg.Patient{
-			PatientHN: "654350968",
-		}
+		// expected search criteria parsed from query string
+		expectedSearchRequest := pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{
+			PatientHN:   "654350968",
+			FirstNameEn: "John",
+			ID:          1,
+			DateOfBirth: time.Date(1997, 7, 31, 0, 0, 0, 0, pkg.HospitalLocation),
+			HospitalID:  1,
+		}}
 
 		// mock SearchPatient
-		mockService.On("SearchPatient", mock.AnythingOfType("*pkg.Patient")).Return([]pkg.Patient{{ID: 1, FirstNameEn: "John"}}, nil)
+		mockService.On("SearchPatient", &expectedSearchRequest).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{{
+			ID:          1,
+			FirstNameEn: "John",
+			HospitalID:  1,
+			Hospital:    pkg.Hospital{ID: 1, Name: "Hospital A"},
+			Identifiers: []pkg.PatientIdentifier{{ID: 3, PatientID: 1, HospitalID: 1, Type: pkg.IdentifierHN, Value: "654350968"}},
+		}}}, nil)
 
-		body, _ := json.Marshal(inputPatientSearchRequest)
-		req := httptest.NewRequest("GET", "/patient/search", bytes.NewBufferString(string(body)))
-		req.Header.Set("Content-Type", "application/json")
+		req := httptest.NewRequest("GET", "/patient/search?patient_hn=654350968&first_name_en=John&id=1&date_of_birth=1997-07-31", nil)
 
 		w := httptest.NewRecorder()
 		r.ServeHTTP(w, req)
@@ -72,23 +131,350 @@ func TestPatientHandler_SearchPatient(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, "Search successfully.", response["message"])
 
+		// Only the patient's own fields are serialized, never the nested hospital or internal keys
+		data := response["data"].([]interface{})
+		assert.Len(t, data, 1)
+		foundPatient := data[0].(map[string]interface{})
+		assert.Equal(t, "John", foundPatient["first_name_en"])
+		assert.Equal(t, float64(1), foundPatient["hospital_id"])
+		assertNoKeys(t, foundPatient, "hospital", "patients", "staffs", "deleted_at", "DeletedAt")
+		identifier := foundPatient["identifiers"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"type": "hn", "value": "654350968", "issuer": ""}, identifier)
+
 		// Verify expectations
 		mockService.AssertExpectations(t)
 	})
 
 	// Test case: Successful patient searching but not found
 	t.Run("patient searching not found", func(t *testing.T) {
-		// mock input body request
-		inputPatientSearchRequest := pkg.Patient{
-			PatientHN: "82",
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("SearchPatient", mock.AnythingOfType("*pkg.PatientSearchRequest")).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{}}, nil)
+
+		req := httptest.NewRequest("GET", "/patient/search?patient_hn=82", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "No patient found.", response["message"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Successful patient searching with match modes
+	t.Run("successful patient searching with match modes", func(t *testing.T) {
+		// expected search criteria parsed from query string
+		expectedSearchRequest := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{
+				FirstNameEn: "Som",
+				HospitalID:  1,
+			},
+			MatchModes: map[string]pkg.MatchMode{"first_name_en": pkg.MatchPrefix},
+		}
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("SearchPatient", &expectedSearchRequest).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{{ID: 1, FirstNameEn: "Somchai"}}}, nil)
+
+		req := httptest.NewRequest("GET", "/patient/search?first_name_en=Som&match[first_name_en]=PREFIX", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - Unknown match mode
+	t.Run("failed searching (invalid match mode)", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/patient/search?first_name_en=Som&match[first_name_en]=fuzzy", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, response["error"], "invalid match mode")
+	})
+
+	// Test case: Failed - Match mode on a field which is always exact
+	t.Run("failed searching (match mode on non-matchable field)", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/patient/search?national_id=123&match[national_id]=prefix", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case: Successful patient searching with paging parameters
+	t.Run("successful patient searching with pagination", func(t *testing.T) {
+		cursor := pkg.EncodePatientCursor(&pkg.Patient{ID: 3, PatientHN: "HN0003"}, pkg.SortByHN, pkg.SortDesc)
+		nextCursor := pkg.EncodePatientCursor(&pkg.Patient{ID: 5, PatientHN: "HN0005"}, pkg.SortByHN, pkg.SortDesc)
+		total := int64(12)
+
+		// expected search criteria parsed from query string
+		expectedSearchRequest := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{Gender: "F", HospitalID: 1},
+			Limit:         2,
+			Cursor:        cursor,
+			SortBy:        pkg.SortByHN,
+			SortOrder:     pkg.SortDesc,
+			IncludeTotal:  true,
+		}
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("SearchPatient", &expectedSearchRequest).Return(&pkg.PatientSearchResult{
+			Patients:   []pkg.Patient{{ID: 4}, {ID: 5}},
+			NextCursor: nextCursor,
+			Total:      &total,
+		}, nil)
+
+		req := httptest.NewRequest("GET", "/patient/search?gender=F&limit=2&cursor="+cursor+"&sort_by=patient_hn&sort_order=DESC&include_total=true", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, nextCursor, response["next_cursor"])
+		assert.Equal(t, float64(12), response["total"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - Invalid paging parameters
+	t.Run("failed searching (invalid paging parameters)", func(t *testing.T) {
+		for _, query := range []string{"limit=ten", "limit=-1", "cursor=not-a-cursor", "sort_by=first_name_en", "sort_order=up", "include_total=maybe"} {
+			req := httptest.NewRequest("GET", "/patient/search?"+query, nil)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
+	// Test case: Failed - Cursor of a page with another ordering
+	t.Run("failed searching (cursor of another ordering)", func(t *testing.T) {
+		cursor := pkg.EncodePatientCursor(&pkg.Patient{ID: 3, PatientHN: "HN0003", LastNameEn: "Adams"}, pkg.SortByHN, pkg.SortAsc)
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.Calls = nil
+
+		for _, query := range []string{"sort_by=last_name_en", "sort_order=desc"} {
+			req := httptest.NewRequest("GET", "/patient/search?cursor="+cursor+"&"+query, nil)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var response map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.Equal(t, pkg.ErrInvalidCursor.Error(), response["error"], query)
+		}
+
+		// The cursor still continues its own ordering
+		mockService.On("SearchPatient", mock.AnythingOfType("*pkg.PatientSearchRequest")).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{}}, nil)
+
+		req := httptest.NewRequest("GET", "/patient/search?cursor="+cursor+"&sort_by=patient_hn&sort_order=asc", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertNumberOfCalls(t, "SearchPatient", 1)
+	})
+
+	// Test case: Successful patient searching by date of birth range and age
+	t.Run("successful patient searching by date of birth range and age", func(t *testing.T) {
+		minAge, maxAge := 0, 5
+
+		// expected search criteria parsed from query string
+		expectedSearchRequest := pkg.PatientSearchRequest{
+			PatientFilter:   pkg.PatientFilter{HospitalID: 1},
+			DateOfBirthFrom: time.Date(2020, 1, 1, 0, 0, 0, 0, pkg.HospitalLocation),
+			DateOfBirthTo:   time.Date(2024, 12, 31, 0, 0, 0, 0, pkg.HospitalLocation),
+			MinAge:          &minAge,
+			MaxAge:          &maxAge,
 		}
 
 		// Reset expectations for this test case
 		mockService.ExpectedCalls = nil
-		mockService.On("SearchPatient", mock.AnythingOfType("*pkg.Patient")).Return([]pkg.Patient{}, nil)
+		mockService.On("SearchPatient", &expectedSearchRequest).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{}}, nil)
+
+		req := httptest.NewRequest("GET", "/patient/search?dob_from=2020-01-01&dob_to=2024-12-31&min_age=0&max_age=5", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - Invalid date of birth range and age parameters
+	t.Run("failed searching (invalid date of birth range and age parameters)", func(t *testing.T) {
+		for _, query := range []string{"dob_from=2020-13-01", "dob_to=yesterday", "dob_from=2024-01-01&dob_to=2020-01-01", "min_age=abc", "max_age=-1", "min_age=40&max_age=30"} {
+			req := httptest.NewRequest("GET", "/patient/search?"+query, nil)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
+	// Test case: Failed - several invalid parameters, the error always names the first one checked
+	t.Run("failed searching (several invalid parameters)", func(t *testing.T) {
+		for range 20 {
+			req := httptest.NewRequest("GET", "/patient/search?max_age=old&min_age=young&dob_to=later&dob_from=soon&date_of_birth=never", nil)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), `invalid query parameter \"date_of_birth\"`)
+		}
+	})
+
+	// Test case: Successful patient searching normalizes phone number and passport ID
+	t.Run("successful patient searching with normalized identifiers", func(t *testing.T) {
+		// expected search criteria parsed from query string
+		expectedSearchRequest := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{
+				PhoneNumber: "+66912345678",
+				PassportID:  "AA1234567",
+				HospitalID:  1,
+			},
+		}
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("SearchPatient", &expectedSearchRequest).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{}}, nil)
+
+		req := httptest.NewRequest("GET", "/patient/search?phone_number=091-234-5678&passport_id=aa1234567", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - Malformed identifiers can never match
+	t.Run("failed searching (invalid identifiers)", func(t *testing.T) {
+		for _, query := range []string{"national_id=1234567890123", "passport_id=P-1", "gender=X", "phone_number=abc", "patient_hn=HN%20001"} {
+			req := httptest.NewRequest("GET", "/patient/search?"+query, nil)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+
+	// Test case: Failed - Invalid integer query parameter
+	t.Run("failed searching (invalid id query parameter)", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/patient/search?id=abc", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, response["error"], `"id"`)
+	})
+
+	// Test case: Failed - Invalid date query parameter
+	t.Run("failed searching (invalid date_of_birth query parameter)", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/patient/search?date_of_birth=31/07/1997", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, response["error"], `"date_of_birth"`)
+	})
+
+	// Test case: Patient service Failed - service returns an error
+	t.Run("patient service error", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("SearchPatient", mock.AnythingOfType("*pkg.PatientSearchRequest")).Return(nil, errors.New("service error"))
+
+		req := httptest.NewRequest("GET", "/patient/search?patient_hn=654350968", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "service error", response["error"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+}
+
+// Tests the SearchPatientByBody handler of HttpPatientHandler
+func TestPatientHandler_SearchPatientByBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockPatientService)
+	handler := &patient.PatientHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+		GetRoleFn:       mockGetRole(pkg.RoleDoctor),
+	}
+
+	r := gin.Default()
+	r.POST("/patient/search", handler.SearchPatientByBody)
+
+	// Test case: Successful patient searching
+	t.Run("successful patient searching", func(t *testing.T) {
+		// mock input body request
+		inputPatientSearchRequest := pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{
+			PatientHN: "654350968",
+		}}
+
+		// mock SearchPatient
+		mockService.On("SearchPatient", mock.AnythingOfType("*pkg.PatientSearchRequest")).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{{ID: 1, FirstNameEn: "John"}}}, nil)
 
 		body, _ := json.Marshal(inputPatientSearchRequest)
-		req := httptest.NewRequest("GET", "/patient/search", bytes.NewBufferString(string(body)))
+		req := httptest.NewRequest("POST", "/patient/search", bytes.NewBufferString(string(body)))
 		req.Header.Set("Content-Type", "application/json")
 
 		w := httptest.NewRecorder()
@@ -99,19 +485,19 @@ func TestPatientHandler_SearchPatient(t *testing.T) {
 
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, w.Code)
-		assert.Equal(t, "No patient found.", response["message"])
+		assert.Equal(t, "Search successfully.", response["message"])
 
 		// Verify expectations
 		mockService.AssertExpectations(t)
 	})
 
 	// Test case: Failed - Invalid request body format (wrong struct format)
-	t.Run("failed searching login (invalid request body format)", func(t *testing.T) {
+	t.Run("failed searching (invalid request body format)", func(t *testing.T) {
 		// mock input body request
 		inputPatientSearchRequest := "This is random string that should trigger EOF error"
 
 		body, _ := json.Marshal(inputPatientSearchRequest)
-		req := httptest.NewRequest("GET", "/patient/search", bytes.NewBufferString(string(body)))
+		req := httptest.NewRequest("POST", "/patient/search", bytes.NewBufferString(string(body)))
 		req.Header.Set("Content-Type", "application/json")
 
 		w := httptest.NewRecorder()
@@ -123,21 +509,27 @@ func TestPatientHandler_SearchPatient(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
+}
 
-	// Test case: Patient service Failed - service returns an error
-	t.Run("patient service error", func(t *testing.T) {
-		// mock input body request
-		inputPatientSearchRequest := pkg.Patient{
-			PatientHN: "654350968",
-		}
+// Tests the GetPatientByIdentifier handler of HttpPatientHandler
+func TestPatientHandler_GetPatientByIdentifier(t *testing.T) {
+	gin.SetMode(gin.TestMode)
 
-		// Reset expectations for this test case
-		mockService.ExpectedCalls = nil
-		mockService.On("SearchPatient", mock.AnythingOfType("*pkg.Patient")).Return(nil, errors.New("service error"))
+	mockService := new(MockPatientService)
+	handler := &patient.PatientHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+		GetRoleFn:       mockGetRole(pkg.RoleDoctor),
+	}
 
-		body, _ := json.Marshal(inputPatientSearchRequest)
-		req := httptest.NewRequest("GET", "/patient/search", bytes.NewBufferString(string(body)))
-		req.Header.Set("Content-Type", "application/json")
+	r := gin.Default()
+	r.GET("/patient/search/:id", handler.GetPatientByIdentifier)
+
+	// Test case: Successful patient lookup
+	t.Run("successful patient lookup", func(t *testing.T) {
+		mockService.On("GetPatientByIdentifier", 1, "1234567890121").Return(&pkg.Patient{ID: 1, NationalID: "1234567890121"}, nil)
+
+		req := httptest.NewRequest("GET", "/patient/search/1234567890121", nil)
 
 		w := httptest.NewRecorder()
 		r.ServeHTTP(w, req)
@@ -146,8 +538,605 @@ func TestPatientHandler_SearchPatient(t *testing.T) {
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 
 		assert.NoError(t, err)
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
-		assert.Equal(t, "service error", response["error"])
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "Search successfully.", response["message"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Contact details are masked for non-clinical roles
+	t.Run("successful patient lookup with masked contact details", func(t *testing.T) {
+		foundPatient := &pkg.Patient{ID: 1, NationalID: "1234567890121", PhoneNumber: "+66912345678", Email: "max.pk@gmail.com"}
+		expectedContacts := map[pkg.Role][2]string{
+			pkg.RoleDoctor:            {"+66912345678", "max.pk@gmail.com"},
+			pkg.RoleNurse:             {"+66912345678", "max.pk@gmail.com"},
+			pkg.RoleAdmin:             {"********5678", "m***@gmail.com"},
+			pkg.RoleRegistrationClerk: {"********5678", "m***@gmail.com"},
+		}
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("GetPatientByIdentifier", 1, "1234567890121").Return(foundPatient, nil)
+
+		for role, contacts := range expectedContacts {
+			roleHandler := *handler
+			roleHandler.GetRoleFn = mockGetRole(role)
+			roleRouter := gin.New()
+			roleRouter.GET("/patient/search/:id", roleHandler.GetPatientByIdentifier)
+
+			req := httptest.NewRequest("GET", "/patient/search/1234567890121", nil)
+
+			w := httptest.NewRecorder()
+			roleRouter.ServeHTTP(w, req)
+
+			var response map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, w.Code)
+			data := response["data"].(map[string]interface{})
+			assert.Equal(t, contacts[0], data["phone_number"], role)
+			assert.Equal(t, contacts[1], data["email"], role)
+		}
+	})
+
+	// Test case: The first character of a masked email is kept whole when it takes several bytes
+	t.Run("successful patient lookup with a masked non-ASCII email", func(t *testing.T) {
+		foundPatient := &pkg.Patient{ID: 1, NationalID: "1234567890121", PhoneNumber: "+66912345678", Email: "สมชาย@example.co.th"}
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("GetPatientByIdentifier", 1, "1234567890121").Return(foundPatient, nil)
+
+		roleHandler := *handler
+		roleHandler.GetRoleFn = mockGetRole(pkg.RoleAdmin)
+		roleRouter := gin.New()
+		roleRouter.GET("/patient/search/:id", roleHandler.GetPatientByIdentifier)
+
+		req := httptest.NewRequest("GET", "/patient/search/1234567890121", nil)
+
+		w := httptest.NewRecorder()
+		roleRouter.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, "ส***@example.co.th", data["email"])
+	})
+
+	// Test case: Failed - patient not found
+	t.Run("patient lookup not found", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("GetPatientByIdentifier", 1, "AA0000000").Return(nil, patient.ErrPatientNotFound)
+
+		req := httptest.NewRequest("GET", "/patient/search/AA0000000", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, patient.ErrPatientNotFound.Error(), response["error"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - neither a national ID nor a passport ID
+	t.Run("patient lookup invalid identifier", func(t *testing.T) {
+		for _, identifier := range []string{"1234567890123", "12-34", "PASSPORT-NUMBER"} {
+			req := httptest.NewRequest("GET", "/patient/search/"+identifier, nil)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, identifier)
+		}
+	})
+
+	// Test case: Patient service Failed - service returns an error
+	t.Run("patient service error", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("GetPatientByIdentifier", 1, "1234567890121").Return(nil, errors.New("service error"))
+
+		req := httptest.NewRequest("GET", "/patient/search/1234567890121", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "service error", response["error"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - the hospital's HIS cannot serve the request or is down
+	t.Run("HIS error", func(t *testing.T) {
+		expectedCodes := map[error]int{
+			patient.ErrNotSupportedByHIS: http.StatusNotImplemented,
+			&patient.HISError{HospitalID: 1, Err: patient.ErrHISUnavailable, Cause: fmt.Errorf("status 500")}:        http.StatusBadGateway,
+			&patient.HISError{HospitalID: 1, Err: patient.ErrHISCircuitOpen, Cause: pkg.ErrCircuitOpen}:              http.StatusServiceUnavailable,
+			&patient.HISError{HospitalID: 1, Err: patient.ErrHISTimeout, Cause: fmt.Errorf("status 500 too slowly")}: http.StatusGatewayTimeout,
+		}
+		for serviceErr, expectedCode := range expectedCodes {
+			// Reset expectations for this test case
+			mockService.ExpectedCalls = nil
+			mockService.On("GetPatientByIdentifier", 1, "1234567890121").Return(nil, serviceErr)
+
+			req := httptest.NewRequest("GET", "/patient/search/1234567890121", nil)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, expectedCode, w.Code, serviceErr.Error())
+			// Details of the HIS failure are not exposed
+			assert.NotContains(t, w.Body.String(), "status 500")
+
+			// Staff are told which hospital has a degraded HIS
+			if expectedCode != http.StatusNotImplemented {
+				var response map[string]interface{}
+				json.Unmarshal(w.Body.Bytes(), &response)
+				assert.Equal(t, float64(1), response["hospital_id"])
+			}
+			if expectedCode == http.StatusServiceUnavailable {
+				assert.Equal(t, "30", w.Header().Get("Retry-After"))
+			}
+		}
+	})
+
+	// Test case: Failed - the logged HIS failure never contains the identifier looked up
+	t.Run("HIS error logged without the identifier", func(t *testing.T) {
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+		hospital := &pkg.Hospital{ID: 1, Settings: pkg.HospitalSettings{HISBaseURL: unreachable.URL}}
+		_, hisErr := patient.NewHISPatientRepository(hospital, nil).GetPatientByIdentifier(1, "1234567890121")
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("GetPatientByIdentifier", 1, "1234567890121").Return(nil, hisErr)
+
+		var logged bytes.Buffer
+		log.SetOutput(&logged)
+		defer log.SetOutput(os.Stderr)
+
+		req := httptest.NewRequest("GET", "/patient/search/1234567890121", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Contains(t, logged.String(), "hospital 1")
+		assert.NotContains(t, logged.String(), "1234567890121")
+	})
+}
+
+// Assert that none of the keys is present in a JSON object
+func assertNoKeys(t *testing.T, object map[string]interface{}, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		assert.NotContains(t, object, key)
+	}
+}
+
+// Valid patient body for write requests
+func newPatientBody(hospitalID int) pkg.Patient {
+	return pkg.Patient{
+		FirstNameEn: "John",
+		DateOfBirth: time.Date(1997, 7, 31, 0, 0, 0, 0, pkg.HospitalLocation),
+		PatientHN:   "HN0001",
+		NationalID:  "1234567890121",
+		PassportID:  "AA1234567",
+		PhoneNumber: "+66912345678",
+		HospitalID:  hospitalID,
+	}
+}
+
+// JSON body of a write request for the patient
+func newPatientRequestBody(p pkg.Patient) []byte {
+	body, _ := json.Marshal(patient.NewPatientRequest(&p))
+	return body
+}
+
+// Tests the CreatePatient handler of HttpPatientHandler
+func TestPatientHandler_CreatePatient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockPatientService)
+	handler := &patient.PatientHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+		GetRoleFn:       mockGetRole(pkg.RoleDoctor),
+	}
+
+	r := gin.Default()
+	r.POST("/patient", handler.CreatePatient)
+
+	// Test case: Successful patient creation in the staff member's hospital
+	t.Run("successful patient creation", func(t *testing.T) {
+		// hospital in the body is ignored in favour of the token's hospital
+		body := newPatientRequestBody(newPatientBody(2))
+		expectedPatient := newPatientBody(1)
+
+		mockService.On("CreatePatient", mock.MatchedBy(func(p *pkg.Patient) bool {
+			return p.HospitalID == expectedPatient.HospitalID && p.PatientHN == expectedPatient.PatientHN
+		})).Return(&expectedPatient, nil)
+
+		req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "Created successfully", response["message"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Successful creation with a date-only date of birth, the same day as searches
+	t.Run("successful patient creation with a date of birth", func(t *testing.T) {
+		body := []byte(`{"first_name_en": "John", "date_of_birth": "1990-05-01", "patient_hn": "HN0001", "national_id": "1234567890121", "phone_number": "+66912345678"}`)
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("CreatePatient", mock.MatchedBy(func(p *pkg.Patient) bool {
+			return pkg.FormatDate(p.DateOfBirth) == "1990-05-01"
+		})).Return(&pkg.Patient{ID: 1, DateOfBirth: time.Date(1990, 5, 1, 0, 0, 0, 0, time.UTC), HospitalID: 1}, nil)
+
+		req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "1990-05-01", response["data"].(map[string]interface{})["date_of_birth"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - date of birth with a time of day
+	t.Run("failed patient creation (date of birth not a date)", func(t *testing.T) {
+		for _, dateOfBirth := range []string{`"1990-05-01T00:00:00Z"`, `"01/05/1990"`, `19900501`} {
+			body := []byte(`{"first_name_en": "John", "date_of_birth": ` + dateOfBirth + `, "patient_hn": "HN0001", "national_id": "1234567890121", "phone_number": "+66912345678"}`)
+			req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, dateOfBirth)
+			assert.Contains(t, w.Body.String(), "YYYY-MM-DD", dateOfBirth)
+		}
+	})
+
+	// Test case: Failed - missing required fields
+	t.Run("failed patient creation (missing required fields)", func(t *testing.T) {
+		body := newPatientRequestBody(pkg.Patient{FirstNameEn: "John"})
+		req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case: Failed - malformed identifiers, phone number and gender
+	t.Run("failed patient creation (invalid field formats)", func(t *testing.T) {
+		invalidPatients := map[string]func(p *pkg.Patient){
+			"national ID checksum": func(p *pkg.Patient) { p.NationalID = "1234567890123" },
+			"passport format":      func(p *pkg.Patient) { p.PassportID = "P-1234" },
+			"patient HN format":    func(p *pkg.Patient) { p.PatientHN = "HN 0001" },
+			"phone number":         func(p *pkg.Patient) { p.PhoneNumber = "call me" },
+			"gender code":          func(p *pkg.Patient) { p.Gender = "X" },
+		}
+		for name, invalidate := range invalidPatients {
+			invalidPatient := newPatientBody(1)
+			invalidate(&invalidPatient)
+
+			body := newPatientRequestBody(invalidPatient)
+			req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		}
+	})
+
+	// Test case: Failed - HN not matching the pattern configured for the hospital
+	t.Run("failed patient creation (hospital HN pattern)", func(t *testing.T) {
+		assert.NoError(t, pkg.LoadPatientHNPatterns(`1=^HN[0-9]{6}$`))
+		defer pkg.SetPatientHNPattern(1, pkg.DefaultPatientHNPattern.String())
+
+		body := newPatientRequestBody(newPatientBody(1)) // HN0001 has only 4 digits
+		req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, response["error"], "patient_hn")
+	})
+
+	// Test case: Successful creation with several identifiers, primary fields filled from the list
+	t.Run("successful patient creation with identifiers", func(t *testing.T) {
+		newPatient := newPatientBody(1)
+		newPatient.NationalID = ""
+		newPatient.PassportID = ""
+		newPatient.Identifiers = []pkg.PatientIdentifier{
+			{Type: "passport", Value: "aa 1234567", Issuer: "gb"},
+			{Type: "national_id", Value: "1234567890121"},
+			{Type: "national_id", Value: "LA-998877", Issuer: "LA"},
+			{Type: "other", Value: "MRN-42", Issuer: "SIRIRAJ"},
+		}
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("CreatePatient", mock.MatchedBy(func(p *pkg.Patient) bool {
+			return p.NationalID == "1234567890121" && p.PassportID == "AA1234567" &&
+				len(p.Identifiers) == 5 && // the four listed plus the HN
+				p.Identifiers[0].Issuer == "GB" && p.Identifiers[1].Issuer == pkg.IssuerThailand
+		})).Return(&newPatient, nil)
+
+		body := newPatientRequestBody(newPatient)
+		req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - identifiers in the list are validated against their type
+	t.Run("failed patient creation (invalid identifiers)", func(t *testing.T) {
+		invalidIdentifiers := map[string]pkg.PatientIdentifier{
+			"thai national ID checksum": {Type: "national_id", Value: "1234567890123", Issuer: "TH"},
+			"passport format":           {Type: "passport", Value: "P-1234"},
+			"unknown type":              {Type: "licence", Value: "12345"},
+			"missing value":             {Type: "other"},
+		}
+		for name, identifier := range invalidIdentifiers {
+			invalidPatient := newPatientBody(1)
+			invalidPatient.Identifiers = []pkg.PatientIdentifier{identifier}
+
+			body := newPatientRequestBody(invalidPatient)
+			req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		}
+	})
+
+	// Test case: Failed - duplicate unique field
+	t.Run("failed patient creation (duplicate national ID)", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("CreatePatient", mock.AnythingOfType("*pkg.Patient")).Return(nil, patient.ErrDuplicateNationalID)
+
+		body := newPatientRequestBody(newPatientBody(1))
+		req := httptest.NewRequest("POST", "/patient", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, patient.ErrDuplicateNationalID.Error(), response["error"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+}
+
+// Tests the UpdatePatient and PatchPatient handlers of HttpPatientHandler
+func TestPatientHandler_UpdatePatient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockPatientService)
+	handler := &patient.PatientHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+		GetRoleFn:       mockGetRole(pkg.RoleDoctor),
+	}
+
+	r := gin.Default()
+	r.PUT("/patient/:id", handler.UpdatePatient)
+	r.PATCH("/patient/:id", handler.PatchPatient)
+
+	// Test case: Successful full replacement pinned to the path ID
+	t.Run("successful patient update", func(t *testing.T) {
+		expectedPatient := newPatientBody(1)
+		expectedPatient.ID = 7
+		pkg.SyncPatientIdentifiers(&expectedPatient)
+
+		mockService.On("UpdatePatient", &expectedPatient).Return(&expectedPatient, nil)
+
+		body := newPatientRequestBody(newPatientBody(1))
+		req := httptest.NewRequest("PUT", "/patient/7", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Successful partial update keeps fields absent from the body
+	t.Run("successful patient patch", func(t *testing.T) {
+		currentPatient := newPatientBody(1)
+		currentPatient.ID = 7
+		pkg.SyncPatientIdentifiers(&currentPatient)
+		expectedPatient := currentPatient
+		expectedPatient.PhoneNumber = "+66899999999" // normalized to E.164
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("GetPatientByID", 1, 7).Return(&currentPatient, nil)
+		mockService.On("UpdatePatient", &expectedPatient).Return(&expectedPatient, nil)
+
+		req := httptest.NewRequest("PATCH", "/patient/7", bytes.NewBufferString(`{"phone_number": "0899999999"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Successful patch of a primary field replaces its former identifier
+	t.Run("successful patient patch of the HN", func(t *testing.T) {
+		currentPatient := newPatientBody(1)
+		currentPatient.ID = 7
+		pkg.SyncPatientIdentifiers(&currentPatient)
+		expectedPatient := currentPatient
+		expectedPatient.PatientHN = "HN0002"
+		expectedPatient.Identifiers = []pkg.PatientIdentifier{
+			{PatientID: 7, HospitalID: 1, Type: pkg.IdentifierNationalID, Issuer: pkg.IssuerThailand, Value: "1234567890121"},
+			{PatientID: 7, HospitalID: 1, Type: pkg.IdentifierPassport, Value: "AA1234567"},
+			{PatientID: 7, HospitalID: 1, Type: pkg.IdentifierHN, Value: "HN0002"},
+		}
+
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.Calls = nil
+		mockService.On("GetPatientByID", 1, 7).Return(&currentPatient, nil)
+		mockService.On("UpdatePatient", &expectedPatient).Return(&expectedPatient, nil)
+
+		req := httptest.NewRequest("PATCH", "/patient/7", bytes.NewBufferString(`{"patient_hn": "HN0002"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations: the old HN is no longer an identifier of the patient
+		mockService.AssertExpectations(t)
+		updated := mockService.Calls[1].Arguments.Get(0).(*pkg.Patient)
+		for _, identifier := range updated.Identifiers {
+			assert.NotEqual(t, "HN0001", identifier.Value)
+		}
+	})
+
+	// Test case: Failed - patient does not exist in the staff member's hospital
+	t.Run("failed patient patch (not found)", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("GetPatientByID", 1, 8).Return(nil, patient.ErrPatientNotFound)
+
+		req := httptest.NewRequest("PATCH", "/patient/8", bytes.NewBufferString(`{"phone_number": "0899999999"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - invalid patient ID
+	t.Run("failed patient update (invalid ID)", func(t *testing.T) {
+		body := newPatientRequestBody(newPatientBody(1))
+		req := httptest.NewRequest("PUT", "/patient/abc", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// Tests the DeletePatient handler of HttpPatientHandler
+func TestPatientHandler_DeletePatient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockPatientService)
+	handler := &patient.PatientHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+		GetRoleFn:       mockGetRole(pkg.RoleDoctor),
+	}
+
+	r := gin.Default()
+	r.DELETE("/patient/:id", handler.DeletePatient)
+
+	// Test case: Successful patient deletion
+	t.Run("successful patient deletion", func(t *testing.T) {
+		mockService.On("DeletePatient", 1, 7).Return(nil)
+
+		req := httptest.NewRequest("DELETE", "/patient/7", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - patient not found
+	t.Run("failed patient deletion (not found)", func(t *testing.T) {
+		mockService.On("DeletePatient", 1, 8).Return(patient.ErrPatientNotFound)
+
+		req := httptest.NewRequest("DELETE", "/patient/8", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
 
 		// Verify expectations
 		mockService.AssertExpectations(t)
@@ -0,0 +1,369 @@
+package patient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/fakehis"
+	"github.com/Peeranut-Kit/health_api_assignment/internal/patient"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// HIS repository of hospital 3 retrying without noticeable delay
+func newHISRepository(baseURL string, breaker *pkg.CircuitBreaker) *patient.HISPatientRepository {
+	hospital := &pkg.Hospital{ID: 3, Settings: pkg.HospitalSettings{HISBaseURL: baseURL, HISTimeoutMs: 500}}
+	repo := patient.NewHISPatientRepository(hospital, breaker).(*patient.HISPatientRepository)
+	repo.RetryBaseDelay = time.Millisecond
+	return repo
+}
+
+// HIS answering with the given statuses in turn, then with the sample patient
+func newFlakyHIS(statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	his := fakehis.New(fakehis.SamplePatients()...).Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		if call <= len(statuses) {
+			w.WriteHeader(statuses[call-1])
+			return
+		}
+		his.ServeHTTP(w, r)
+	}))
+	return server, &calls
+}
+
+func TestHISPatientRepository_GetPatientByIdentifier(t *testing.T) {
+	his := fakehis.New(fakehis.SamplePatients()...)
+	server := his.NewServer()
+	defer server.Close()
+
+	repo := newHISRepository(server.URL, nil)
+
+	// Success case - the HIS payload is mapped to the stored form of a patient of the hospital
+	t.Run("successful lookup by national ID", func(t *testing.T) {
+		result, err := repo.GetPatientByIdentifier(3, "1101700230708")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Somchai", result.FirstNameEn)
+		assert.Equal(t, "HN000001", result.PatientHN)
+		assert.Equal(t, "1985-04-12", pkg.FormatDate(result.DateOfBirth))
+		assert.Equal(t, "+66812345678", result.PhoneNumber)
+		assert.Equal(t, 3, result.HospitalID)
+		assert.Contains(t, result.Identifiers, pkg.PatientIdentifier{HospitalID: 3, Type: pkg.IdentifierNationalID, Value: "1101700230708", Issuer: pkg.IssuerThailand})
+	})
+
+	t.Run("successful lookup by passport ID", func(t *testing.T) {
+		result, err := repo.GetPatientByIdentifier(3, "GB1234567")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Emily", result.FirstNameEn)
+	})
+
+	// Failure case - unknown patient
+	t.Run("patient not found", func(t *testing.T) {
+		result, err := repo.GetPatientByIdentifier(3, "1234567890121")
+
+		assert.ErrorIs(t, err, patient.ErrPatientNotFound)
+		assert.Nil(t, result)
+	})
+
+	// Failure case - HIS errors, unreachable HIS and malformed payloads
+	t.Run("HIS unavailable", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer failing.Close()
+		malformed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"first_name_en": "Somchai", "date_of_birth": "12/04/1985"}`))
+		}))
+		defer malformed.Close()
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+
+		for _, baseURL := range []string{failing.URL, malformed.URL, unreachable.URL} {
+			_, err := newHISRepository(baseURL, nil).GetPatientByIdentifier(3, "1101700230708")
+
+			assert.ErrorIs(t, err, patient.ErrHISUnavailable, baseURL)
+			var hisErr *patient.HISError
+			assert.ErrorAs(t, err, &hisErr)
+			assert.Equal(t, 3, hisErr.HospitalID)
+		}
+	})
+}
+
+func TestHISPatientRepository_Resilience(t *testing.T) {
+	// Success case - transient failures are retried
+	t.Run("successful lookup after retries", func(t *testing.T) {
+		server, calls := newFlakyHIS(http.StatusServiceUnavailable, http.StatusBadGateway)
+		defer server.Close()
+
+		result, err := newHISRepository(server.URL, nil).GetPatientByIdentifier(3, "1101700230708")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Somchai", result.FirstNameEn)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	// Failure case - retries are bounded
+	t.Run("HIS still failing after the last attempt", func(t *testing.T) {
+		server, calls := newFlakyHIS(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+		defer server.Close()
+
+		_, err := newHISRepository(server.URL, nil).GetPatientByIdentifier(3, "1101700230708")
+
+		assert.ErrorIs(t, err, patient.ErrHISUnavailable)
+		assert.Equal(t, int32(patient.DefaultHISMaxAttempts), calls.Load())
+	})
+
+	// Failure case - answers which would not change are not retried
+	t.Run("no retry of unknown patients and client errors", func(t *testing.T) {
+		server, calls := newFlakyHIS(http.StatusBadRequest)
+		defer server.Close()
+		repo := newHISRepository(server.URL, nil)
+
+		_, err := repo.GetPatientByIdentifier(3, "1101700230708")
+		assert.ErrorIs(t, err, patient.ErrHISUnavailable)
+		assert.Equal(t, int32(1), calls.Load())
+
+		_, err = repo.GetPatientByIdentifier(3, "1234567890121")
+		assert.ErrorIs(t, err, patient.ErrPatientNotFound)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	// Failure case - the timeout of the hospital bounds the lookup, retries included
+	t.Run("HIS timeout", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		defer slow.Close()
+		repo := newHISRepository(slow.URL, nil)
+		repo.Timeout = 50 * time.Millisecond
+
+		start := time.Now()
+		_, err := repo.GetPatientByIdentifier(3, "1101700230708")
+
+		assert.ErrorIs(t, err, patient.ErrHISTimeout)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	// The circuit breaker opens after failed lookups, then lets a probe through
+	t.Run("circuit breaker", func(t *testing.T) {
+		now := time.Now()
+		breaker := pkg.NewCircuitBreaker(2, time.Minute)
+		breaker.Now = func() time.Time { return now }
+
+		// Two failed lookups of three attempts each, then the sample patient
+		server, calls := newFlakyHIS(500, 500, 500, 500, 500, 500)
+		defer server.Close()
+		repo := newHISRepository(server.URL, breaker)
+
+		for i := 0; i < 2; i++ {
+			_, err := repo.GetPatientByIdentifier(3, "1101700230708")
+			assert.ErrorIs(t, err, patient.ErrHISUnavailable)
+		}
+		assert.Equal(t, pkg.BreakerOpen, breaker.State())
+
+		// The HIS is not called while the breaker is open
+		_, err := repo.GetPatientByIdentifier(3, "1101700230708")
+		assert.ErrorIs(t, err, patient.ErrHISCircuitOpen)
+		assert.Equal(t, int32(6), calls.Load())
+
+		// A probe closes it again once the open timeout has passed
+		now = now.Add(time.Minute)
+		result, err := repo.GetPatientByIdentifier(3, "1101700230708")
+		assert.NoError(t, err)
+		assert.Equal(t, "Somchai", result.FirstNameEn)
+		assert.Equal(t, pkg.BreakerClosed, breaker.State())
+	})
+
+	t.Run("failed probe reopens the circuit breaker", func(t *testing.T) {
+		now := time.Now()
+		breaker := pkg.NewCircuitBreaker(1, time.Minute)
+		breaker.Now = func() time.Time { return now }
+
+		server, _ := newFlakyHIS(500, 500, 500, 500, 500, 500)
+		defer server.Close()
+		repo := newHISRepository(server.URL, breaker)
+
+		repo.GetPatientByIdentifier(3, "1101700230708")
+		assert.Equal(t, pkg.BreakerOpen, breaker.State())
+
+		now = now.Add(time.Minute)
+		_, err := repo.GetPatientByIdentifier(3, "1101700230708")
+		assert.ErrorIs(t, err, patient.ErrHISUnavailable)
+		assert.Equal(t, pkg.BreakerOpen, breaker.State())
+
+		_, err = repo.GetPatientByIdentifier(3, "1101700230708")
+		assert.ErrorIs(t, err, patient.ErrHISCircuitOpen)
+	})
+}
+
+func TestHISPatientRepository_SearchPatient(t *testing.T) {
+	his := fakehis.New(fakehis.SamplePatients()...)
+	server := his.NewServer()
+	defer server.Close()
+
+	repo := newHISRepository(server.URL, nil)
+
+	// Success case - a search by a single identifier is a lookup
+	t.Run("successful search by identifier", func(t *testing.T) {
+		request := &pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{PassportID: "GB1234567", HospitalID: 3},
+			IncludeTotal:  true,
+		}
+
+		result, err := repo.SearchPatient(request)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Patients, 1)
+		assert.Equal(t, int64(1), *result.Total)
+		assert.Empty(t, result.NextCursor)
+	})
+
+	t.Run("no patient found", func(t *testing.T) {
+		request := &pkg.PatientSearchRequest{Identifier: "1234567890121", PatientFilter: pkg.PatientFilter{HospitalID: 3}}
+
+		result, err := repo.SearchPatient(request)
+
+		assert.NoError(t, err)
+		assert.Empty(t, result.Patients)
+		assert.NotNil(t, result.Patients)
+	})
+
+	// Success case - a passport ID that is not an HN of the hospital is sent to the HIS
+	t.Run("successful search by passport identifier", func(t *testing.T) {
+		assert.NoError(t, pkg.SetPatientHNPattern(3, `^HN[0-9]{6}$`))
+		defer pkg.ClearPatientHNPattern(3)
+		request := &pkg.PatientSearchRequest{Identifier: "GB1234567", PatientFilter: pkg.PatientFilter{HospitalID: 3}}
+
+		result, err := repo.SearchPatient(request)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Patients, 1)
+	})
+
+	// Failure case - a HIS cannot look an HN up
+	t.Run("search by HN identifier not supported by HIS", func(t *testing.T) {
+		request := &pkg.PatientSearchRequest{Identifier: "HN0001", PatientFilter: pkg.PatientFilter{HospitalID: 3}}
+
+		_, err := repo.SearchPatient(request)
+
+		assert.ErrorIs(t, err, patient.ErrNotSupportedByHIS)
+	})
+
+	// Failure case - a HIS can neither filter on other fields nor be written to
+	t.Run("search not supported by HIS", func(t *testing.T) {
+		for _, request := range []*pkg.PatientSearchRequest{
+			{PatientFilter: pkg.PatientFilter{FirstNameEn: "Somchai", HospitalID: 3}},
+			{PatientFilter: pkg.PatientFilter{NationalID: "1101700230708", FirstNameEn: "Somchai", HospitalID: 3}},
+			{PatientFilter: pkg.PatientFilter{NationalID: "1101700230708", PassportID: "GB1234567", HospitalID: 3}},
+		} {
+			_, err := repo.SearchPatient(request)
+
+			assert.ErrorIs(t, err, patient.ErrNotSupportedByHIS)
+		}
+
+		assert.ErrorIs(t, repo.CreatePatient(&pkg.Patient{HospitalID: 3}), patient.ErrNotSupportedByHIS)
+		assert.ErrorIs(t, repo.UpdatePatient(&pkg.Patient{ID: 1, HospitalID: 3}), patient.ErrNotSupportedByHIS)
+		assert.ErrorIs(t, repo.DeletePatient(3, 1), patient.ErrNotSupportedByHIS)
+	})
+}
+
+type mockHospitalLookup struct {
+	mock.Mock
+}
+
+func (m *mockHospitalLookup) GetHospitalByID(id int) (*pkg.Hospital, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Hospital), args.Error(1)
+}
+
+func TestHospitalRoutingPatientRepository(t *testing.T) {
+	his := fakehis.New(fakehis.SamplePatients()...)
+	server := his.NewServer()
+	defer server.Close()
+
+	mockLocal := new(mockPatientRepo)
+	mockHospitals := new(mockHospitalLookup)
+	repo := patient.NewHospitalRoutingPatientRepository(mockLocal, mockHospitals)
+
+	mockHospitals.On("GetHospitalByID", 1).Return(&pkg.Hospital{ID: 1}, nil)
+	mockHospitals.On("GetHospitalByID", 2).Return(nil, gorm.ErrRecordNotFound)
+	mockHospitals.On("GetHospitalByID", 3).Return(&pkg.Hospital{ID: 3, Settings: pkg.HospitalSettings{HISBaseURL: server.URL}}, nil)
+
+	// Hospitals without HIS, or unknown ones, use the local database
+	t.Run("local database", func(t *testing.T) {
+		localPatient := &pkg.Patient{ID: 1, HospitalID: 1}
+		mockLocal.On("GetPatientByIdentifier", 1, "1101700230708").Return(localPatient, nil)
+		mockLocal.On("DeletePatient", 2, 5).Return(gorm.ErrRecordNotFound)
+
+		result, err := repo.GetPatientByIdentifier(1, "1101700230708")
+		assert.NoError(t, err)
+		assert.Equal(t, localPatient, result)
+
+		assert.ErrorIs(t, repo.DeletePatient(2, 5), gorm.ErrRecordNotFound)
+
+		mockLocal.AssertExpectations(t)
+	})
+
+	// Hospitals with a HIS base URL use their HIS
+	t.Run("HIS of the hospital", func(t *testing.T) {
+		mockLocal.Calls = nil
+
+		result, err := repo.GetPatientByIdentifier(3, "1101700230708")
+		assert.NoError(t, err)
+		assert.Equal(t, "Somchai", result.FirstNameEn)
+		assert.Equal(t, 3, result.HospitalID)
+
+		assert.ErrorIs(t, repo.CreatePatient(&pkg.Patient{HospitalID: 3}), patient.ErrNotSupportedByHIS)
+
+		mockLocal.AssertNotCalled(t, "GetPatientByIdentifier", mock.Anything, mock.Anything)
+		mockLocal.AssertNotCalled(t, "CreatePatient", mock.Anything)
+	})
+}
+
+// Tests that the routing of a hospital is cached until its settings change
+func TestHospitalRoutingPatientRepository_Cache(t *testing.T) {
+	his := fakehis.New(fakehis.SamplePatients()...)
+	server := his.NewServer()
+	defer server.Close()
+
+	mockLocal := new(mockPatientRepo)
+	mockHospitals := new(mockHospitalLookup)
+	repo := patient.NewHospitalRoutingPatientRepository(mockLocal, mockHospitals)
+
+	localPatient := &pkg.Patient{ID: 1, HospitalID: 4}
+	mockLocal.On("GetPatientByIdentifier", 4, "1101700230708").Return(localPatient, nil)
+	mockHospitals.On("GetHospitalByID", 4).Return(&pkg.Hospital{ID: 4}, nil).Once()
+
+	// The hospital is read once for several requests
+	for i := 0; i < 3; i++ {
+		result, err := repo.GetPatientByIdentifier(4, "1101700230708")
+		assert.NoError(t, err)
+		assert.Equal(t, localPatient, result)
+	}
+	mockHospitals.AssertNumberOfCalls(t, "GetHospitalByID", 1)
+
+	// A change of its settings routes the next request to its new HIS
+	connected := &pkg.Hospital{ID: 4, Settings: pkg.HospitalSettings{HISBaseURL: server.URL}}
+	mockHospitals.On("GetHospitalByID", 4).Return(connected, nil).Once()
+	repo.HospitalSettingsChanged(connected)
+
+	result, err := repo.GetPatientByIdentifier(4, "1101700230708")
+	assert.NoError(t, err)
+	assert.Equal(t, "Somchai", result.FirstNameEn)
+	mockHospitals.AssertNumberOfCalls(t, "GetHospitalByID", 2)
+	mockLocal.AssertNumberOfCalls(t, "GetPatientByIdentifier", 3)
+}
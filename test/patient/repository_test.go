@@ -8,6 +8,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Peeranut-Kit/health_api_assignment/internal/patient"
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -36,10 +37,10 @@ func TestGormPatientRepository_SearchPatient(t *testing.T) {
 	// Success case
 	t.Run("successful patient searching", func(t *testing.T) {
 		// Mock input
-		inputPatient := pkg.Patient{
+		inputPatient := pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{
 			PatientHN:  "654350968",
 			HospitalID: 1,
-		}
+		}}
 
 		// Setup expectations
 		mock.ExpectQuery("SELECT").WillReturnRows(
@@ -53,10 +54,141 @@ func TestGormPatientRepository_SearchPatient(t *testing.T) {
 				),
 		)
 
-		patientList, err := repo.SearchPatient(&inputPatient)
+		result, err := repo.SearchPatient(&inputPatient)
 
 		assert.NoError(t, err) // err == nil
-		assert.NotEmpty(t, patientList)
+		assert.NotEmpty(t, result.Patients)
+		assert.Empty(t, result.NextCursor) // single page
+		assert.Nil(t, result.Total)        // total not requested
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - match modes on name, email and phone columns
+	t.Run("successful patient searching with match modes", func(t *testing.T) {
+		// Mock input
+		inputPatient := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{
+				FirstNameEn: "som_chai",
+				LastNameEn:  "kit",
+				Email:       "MAX.PK@gmail.com",
+				PhoneNumber: "0912345678",
+				HospitalID:  1,
+			},
+			MatchModes: map[string]pkg.MatchMode{
+				"first_name_en": pkg.MatchPrefix,
+				"last_name_en":  pkg.MatchContains,
+				"email":         pkg.MatchCaseInsensitive,
+			},
+		}
+
+		// Setup expectations: wildcards in input are escaped, unspecified fields stay exact
+		mock.ExpectQuery(`WHERE hospital_id = \$1 AND first_name_en ILIKE \$2 AND last_name_en ILIKE \$3 AND LOWER\(email\) = LOWER\(\$4\) AND phone_number = \$5`).
+			WithArgs(1, `som\_chai%`, "%kit%", "MAX.PK@gmail.com", "0912345678", pkg.DefaultPageSize+1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "first_name_en", "hospital_id"}).AddRow(1, "Som_chai", 1))
+
+		result, err := repo.SearchPatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Patients, 1)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - dates of birth compared as calendar days in the hospital timezone
+	t.Run("successful patient searching by date of birth range", func(t *testing.T) {
+		// Mock input: 17:00 UTC is already the next day in Bangkok
+		inputPatient := pkg.PatientSearchRequest{
+			PatientFilter:   pkg.PatientFilter{DateOfBirth: time.Date(1997, 7, 30, 17, 0, 0, 0, time.UTC), HospitalID: 1},
+			DateOfBirthFrom: time.Date(1990, 1, 1, 0, 0, 0, 0, pkg.HospitalLocation),
+			DateOfBirthTo:   time.Date(1999, 12, 31, 0, 0, 0, 0, pkg.HospitalLocation),
+		}
+
+		// Setup expectations
+		mock.ExpectQuery(`WHERE hospital_id = \$1 AND date_of_birth = \$2 AND date_of_birth >= \$3 AND date_of_birth <= \$4`).
+			WithArgs(1, "1997-07-31", "1990-01-01", "1999-12-31", pkg.DefaultPageSize+1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "date_of_birth"}).AddRow(1, time.Date(1997, 7, 31, 0, 0, 0, 0, time.UTC)))
+
+		result, err := repo.SearchPatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Patients, 1)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - first page with total count and a next cursor
+	t.Run("successful patient searching first page", func(t *testing.T) {
+		// Mock input
+		inputPatient := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{Gender: "M", HospitalID: 1},
+			Limit:         2,
+			SortBy:        pkg.SortByLastNameEn,
+			IncludeTotal:  true,
+		}
+
+		// Setup expectations: count, then one row more than the page size
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "patients" WHERE hospital_id = \$1 AND gender = \$2 AND "patients"."deleted_at" IS NULL`).
+			WithArgs(1, "M").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+		mock.ExpectQuery(`ORDER BY COALESCE\(last_name_en, ''\) ASC, id ASC LIMIT \$3`).
+			WithArgs(1, "M", 3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "last_name_en"}).
+				AddRow(4, "Adams").
+				AddRow(2, "Brown").
+				AddRow(7, "Clark"))
+
+		result, err := repo.SearchPatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Patients, 2)
+		assert.Equal(t, int64(5), *result.Total)
+		assert.Equal(t, pkg.EncodePatientCursor(&pkg.Patient{ID: 2, LastNameEn: "Brown"}, pkg.SortByLastNameEn, pkg.SortAsc), result.NextCursor)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - following page continues after the cursor in descending order
+	t.Run("successful patient searching next page", func(t *testing.T) {
+		// Mock input
+		inputPatient := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{HospitalID: 1},
+			Limit:         2,
+			Cursor:        pkg.EncodePatientCursor(&pkg.Patient{ID: 9, PatientHN: "HN0009"}, pkg.SortByHN, pkg.SortDesc),
+			SortOrder:     pkg.SortDesc,
+		}
+
+		// Setup expectations
+		mock.ExpectQuery(`WHERE hospital_id = \$1 AND \(patient_hn, id\) < \(\$2, \$3\) AND "patients"."deleted_at" IS NULL ORDER BY patient_hn DESC, id DESC LIMIT \$4`).
+			WithArgs(1, "HN0009", 9, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "patient_hn"}).AddRow(8, "HN0008"))
+
+		result, err := repo.SearchPatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Patients, 1)
+		assert.Empty(t, result.NextCursor) // last page
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - identifiers match any identifier of the patient, not only the primary ones
+	t.Run("successful patient searching by identifier", func(t *testing.T) {
+		// Mock input
+		inputPatient := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{PassportID: "AA1234567", HospitalID: 1},
+			Identifier:    "MRN-42",
+		}
+
+		// Setup expectations
+		mock.ExpectQuery(`WHERE hospital_id = \$1 AND \(EXISTS \(SELECT 1 FROM patient_identifiers WHERE patient_identifiers.patient_id = patients.id AND patient_identifiers.type = \$2 AND patient_identifiers.value = \$3\)\) AND \(EXISTS \(SELECT 1 FROM patient_identifiers WHERE patient_identifiers.patient_id = patients.id AND patient_identifiers.value = \$4\)\)`).
+			WithArgs(1, pkg.IdentifierPassport, "AA1234567", "MRN-42", pkg.DefaultPageSize+1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "hospital_id"}).AddRow(1, 1))
+
+		result, err := repo.SearchPatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Patients, 1)
 		// Ensure all expectations were met
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -64,18 +196,356 @@ func TestGormPatientRepository_SearchPatient(t *testing.T) {
 	// Failure case
 	t.Run("failed patient searching", func(t *testing.T) {
 		// Mock input
-		inputPatient := pkg.Patient{
+		inputPatient := pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{
 			PatientHN:  "-7",
 			HospitalID: 1,
-		}
+		}}
 
 		// Setup expectations
 		mock.ExpectQuery("SELECT").WillReturnError(errors.New("database error"))
 
-		patientList, err := repo.SearchPatient(&inputPatient)
+		result, err := repo.SearchPatient(&inputPatient)
+
+		assert.Error(t, err)    // err happens and not nil
+		assert.Empty(t, result) // result is empty
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormPatientRepository_GetPatientByIdentifier(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := patient.NewGormPatientRepository(gormDB)
+
+	// Success case
+	t.Run("successful patient lookup", func(t *testing.T) {
+		// Setup expectations
+		mock.ExpectQuery(`SELECT \* FROM "patients" WHERE hospital_id = \$1 AND \(EXISTS \(SELECT 1 FROM patient_identifiers WHERE patient_identifiers.patient_id = patients.id AND patient_identifiers.type IN \(\$2,\$3\) AND patient_identifiers.value = \$4\)\) AND "patients"."deleted_at" IS NULL`).
+			WithArgs(1, pkg.IdentifierNationalID, pkg.IdentifierPassport, "1234567890123", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "national_id", "hospital_id"}).AddRow(1, "1234567890123", 1))
+		mock.ExpectQuery(`SELECT \* FROM "patient_identifiers" WHERE "patient_identifiers"."patient_id" = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "patient_id", "hospital_id", "type", "value", "issuer"}).
+				AddRow(1, 1, 1, pkg.IdentifierNationalID, "1234567890123", pkg.IssuerThailand).
+				AddRow(2, 1, 1, pkg.IdentifierNationalID, "9876543210987", "LA"))
+
+		foundPatient, err := repo.GetPatientByIdentifier(1, "1234567890123")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "1234567890123", foundPatient.NationalID)
+		assert.Len(t, foundPatient.Identifiers, 2)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case
+	t.Run("patient not found", func(t *testing.T) {
+		// Setup expectations
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		foundPatient, err := repo.GetPatientByIdentifier(1, "P000000000")
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.Nil(t, foundPatient)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormPatientRepository_CreatePatient(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := patient.NewGormPatientRepository(gormDB)
+
+	// Success case
+	t.Run("successful patient creation", func(t *testing.T) {
+		newPatient := pkg.Patient{PatientHN: "HN0001", HospitalID: 1}
+		pkg.SyncPatientIdentifiers(&newPatient)
+
+		// Setup expectations: conflict check, patient, then its identifiers
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "patient_identifiers" WHERE \(hospital_id = \$1 AND patient_id <> \$2\) AND \(type, issuer, value\) IN \(\(\$3,\$4,\$5\)\)`).
+			WithArgs(1, 0, pkg.IdentifierHN, "", "HN0001", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO "patients"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(`INSERT INTO "patient_identifiers" \("patient_id","hospital_id","type","value","issuer"\)`).
+			WithArgs(1, 1, pkg.IdentifierHN, "HN0001", "").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectCommit()
+
+		err := repo.CreatePatient(&newPatient)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, newPatient.ID)
+		assert.Equal(t, 1, newPatient.Identifiers[0].PatientID)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - identifier already used by another patient of the hospital
+	t.Run("duplicate patient HN", func(t *testing.T) {
+		newPatient := pkg.Patient{PatientHN: "HN0001", HospitalID: 1}
+		pkg.SyncPatientIdentifiers(&newPatient)
+
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "patient_identifiers"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "patient_id", "type", "value"}).AddRow(3, 2, pkg.IdentifierHN, "HN0001"))
+		mock.ExpectRollback()
+
+		err := repo.CreatePatient(&newPatient)
+
+		assert.ErrorIs(t, err, patient.ErrDuplicatePatientHN)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - unique constraint violation of a concurrent write is mapped to a domain error
+	t.Run("duplicate identifier on insert", func(t *testing.T) {
+		newPatient := pkg.Patient{HospitalID: 1, Identifiers: []pkg.PatientIdentifier{{Type: pkg.IdentifierOther, Value: "MRN-42"}}}
+
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "patient_identifiers"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO "patients"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectQuery(`INSERT INTO "patient_identifiers"`).
+			WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "uq_patient_identifiers"})
+		mock.ExpectRollback()
+
+		err := repo.CreatePatient(&newPatient)
+
+		assert.ErrorIs(t, err, patient.ErrDuplicateIdentifier)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - patients without an email do not conflict with each other
+	t.Run("successful creation of two patients without an email", func(t *testing.T) {
+		for i, hn := range []string{"HN0002", "HN0003"} {
+			newPatient := pkg.Patient{PatientHN: hn, HospitalID: 1}
+			pkg.SyncPatientIdentifiers(&newPatient)
+
+			// Setup expectations
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM "patient_identifiers"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`INSERT INTO "patients" .*"email".*`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(i + 2))
+			mock.ExpectQuery(`INSERT INTO "patient_identifiers"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(i + 11))
+			mock.ExpectCommit()
+
+			err := repo.CreatePatient(&newPatient)
+
+			assert.NoError(t, err)
+			assert.Equal(t, "", newPatient.Email)
+		}
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the email is used by another live patient of the hospital
+	t.Run("duplicate email on insert", func(t *testing.T) {
+		newPatient := pkg.Patient{PatientHN: "HN0004", Email: "somchai@example.com", HospitalID: 1}
+		pkg.SyncPatientIdentifiers(&newPatient)
+
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "patient_identifiers"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO "patients"`).
+			WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "patients_email_key"})
+		mock.ExpectRollback()
+
+		err := repo.CreatePatient(&newPatient)
+
+		assert.ErrorIs(t, err, patient.ErrDuplicatePatientEmail)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormPatientRepository_UpdatePatient(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := patient.NewGormPatientRepository(gormDB)
+
+	// Success case
+	t.Run("successful patient update", func(t *testing.T) {
+		updatedPatient := pkg.Patient{ID: 7, PatientHN: "HN0007", HospitalID: 1}
+
+		// Setup expectations: scoped to the hospital and never touching deleted rows
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "patients" SET .* WHERE \(id = \$\d+ AND hospital_id = \$\d+\) AND "patients"."deleted_at" IS NULL`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		// Identifiers are replaced as a whole
+		mock.ExpectExec(`DELETE FROM "patient_identifiers" WHERE patient_id = \$1`).
+			WithArgs(7).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		err := repo.UpdatePatient(&updatedPatient)
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - no row of this hospital
+	t.Run("patient to update not found", func(t *testing.T) {
+		updatedPatient := pkg.Patient{ID: 8, PatientHN: "HN0008", HospitalID: 1}
+
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "patients"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.UpdatePatient(&updatedPatient)
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - a changed HN replaces the old one, which is free for another patient
+	t.Run("successful HN change releases the old HN", func(t *testing.T) {
+		current := pkg.Patient{ID: 7, PatientHN: "HN0001", HospitalID: 1}
+		pkg.SyncPatientIdentifiers(&current)
+		updatedPatient := current
+		updatedPatient.PatientHN = "HN0002"
+		pkg.ReplacePrimaryIdentifiers(&updatedPatient, &current)
+		pkg.SyncPatientIdentifiers(&updatedPatient)
+
+		// Setup expectations: only the new HN is checked and stored again
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "patient_identifiers" WHERE \(hospital_id = \$1 AND patient_id <> \$2\) AND \(type, issuer, value\) IN \(\(\$3,\$4,\$5\)\)`).
+			WithArgs(1, 7, pkg.IdentifierHN, "", "HN0002", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(`UPDATE "patients"`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM "patient_identifiers" WHERE patient_id = \$1`).
+			WithArgs(7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "patient_identifiers" \("patient_id","hospital_id","type","value","issuer"\) VALUES \(\$1,\$2,\$3,\$4,\$5\) RETURNING "id"`).
+			WithArgs(7, 1, pkg.IdentifierHN, "HN0002", "").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+		mock.ExpectCommit()
+
+		err := repo.UpdatePatient(&updatedPatient)
+		assert.NoError(t, err)
+
+		// The old HN no longer matches a patient and registers for another one
+		newPatient := pkg.Patient{PatientHN: "HN0001", HospitalID: 1}
+		pkg.SyncPatientIdentifiers(&newPatient)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "patient_identifiers"`).
+			WithArgs(1, 0, pkg.IdentifierHN, "", "HN0001", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`INSERT INTO "patients"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+		mock.ExpectQuery(`INSERT INTO "patient_identifiers"`).
+			WithArgs(8, 1, pkg.IdentifierHN, "HN0001", "").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		mock.ExpectCommit()
+
+		err = repo.CreatePatient(&newPatient)
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormPatientRepository_DeletePatient(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := patient.NewGormPatientRepository(gormDB)
+
+	// Success case - soft delete sets deleted_at instead of removing the row
+	t.Run("successful patient deletion", func(t *testing.T) {
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "patients" SET "deleted_at"=\$1 WHERE hospital_id = \$2 AND "patients"."id" = \$3 AND "patients"."deleted_at" IS NULL`).
+			WithArgs(sqlmock.AnyArg(), 1, 7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		// Identifiers are released for re-use
+		mock.ExpectExec(`DELETE FROM "patient_identifiers" WHERE patient_id = \$1`).
+			WithArgs(7).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.DeletePatient(1, 7)
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - already deleted or another hospital's patient
+	t.Run("patient to delete not found", func(t *testing.T) {
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "patients" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.DeletePatient(1, 8)
 
-		assert.Error(t, err)         // err happens and not nil
-		assert.Empty(t, patientList) // patientList is empty
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
 		// Ensure all expectations were met
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -8,20 +8,54 @@ import (
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 type mockPatientRepo struct {
 	mock.Mock
 }
 
-func (m *mockPatientRepo) SearchPatient(request *pkg.Patient) ([]pkg.Patient, error) {
+func (m *mockPatientRepo) SearchPatient(request *pkg.PatientSearchRequest) (*pkg.PatientSearchResult, error) {
 	args := m.Called(request)
 	if args.Get(0) == nil {
 		// If the first return value is nil, avoid type assertion and return nil
 		return nil, args.Error(1)
 	}
 	// Type assertion if not nil
-	return args.Get(0).([]pkg.Patient), args.Error(1)
+	return args.Get(0).(*pkg.PatientSearchResult), args.Error(1)
+}
+
+func (m *mockPatientRepo) GetPatientByIdentifier(hospitalID int, identifier string) (*pkg.Patient, error) {
+	args := m.Called(hospitalID, identifier)
+	if args.Get(0) == nil {
+		// If the first return value is nil, avoid type assertion and return nil
+		return nil, args.Error(1)
+	}
+	// Type assertion if not nil
+	return args.Get(0).(*pkg.Patient), args.Error(1)
+}
+
+func (m *mockPatientRepo) GetPatientByID(hospitalID int, id int) (*pkg.Patient, error) {
+	args := m.Called(hospitalID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Patient), args.Error(1)
+}
+
+func (m *mockPatientRepo) CreatePatient(patient *pkg.Patient) error {
+	args := m.Called(patient)
+	return args.Error(0)
+}
+
+func (m *mockPatientRepo) UpdatePatient(patient *pkg.Patient) error {
+	args := m.Called(patient)
+	return args.Error(0)
+}
+
+func (m *mockPatientRepo) DeletePatient(hospitalID int, id int) error {
+	args := m.Called(hospitalID, id)
+	return args.Error(0)
 }
 
 func TestPatientService_SearchPatient(t *testing.T) {
@@ -31,29 +65,73 @@ func TestPatientService_SearchPatient(t *testing.T) {
 	// Test case: Successful patient searching
 	t.Run("successful patient searching", func(t *testing.T) {
 		// mock input body request
-		inputPatient := pkg.Patient{
+		inputPatient := pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{
 			PatientHN:  "654350968",
 			HospitalID: 1,
-		}
+		}}
 
-		mockRepo.On("SearchPatient", &inputPatient).Return([]pkg.Patient{{ID: 1, FirstNameEn: "John"}}, nil)
+		mockRepo.On("SearchPatient", &inputPatient).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{{ID: 1, FirstNameEn: "John"}}}, nil)
 
-		paientList, err := service.SearchPatient(&inputPatient)
+		result, err := service.SearchPatient(&inputPatient)
 
 		assert.NoError(t, err)
-		assert.NotEmpty(t, paientList)
+		assert.NotEmpty(t, result.Patients)
+		assert.Equal(t, pkg.DefaultPageSize, inputPatient.Limit) // default page size applied
 		
 		// Verify expectations
 		mockRepo.AssertExpectations(t)
 	})
 
+	// Test case: Page size above the maximum is capped
+	t.Run("page size is capped", func(t *testing.T) {
+		// mock input body request
+		inputPatient := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{Gender: "F", HospitalID: 1},
+			Limit:   5000,
+		}
+
+		mockRepo.On("SearchPatient", &inputPatient).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{}}, nil)
+
+		_, err := service.SearchPatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Equal(t, pkg.MaxPageSize, inputPatient.Limit)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Age filters become a date of birth range
+	t.Run("age filters applied", func(t *testing.T) {
+		minAge, maxAge := 18, 30
+
+		// mock input body request
+		inputPatient := pkg.PatientSearchRequest{
+			PatientFilter: pkg.PatientFilter{HospitalID: 1},
+			MinAge:  &minAge,
+			MaxAge:  &maxAge,
+		}
+
+		mockRepo.On("SearchPatient", &inputPatient).Return(&pkg.PatientSearchResult{Patients: []pkg.Patient{}}, nil)
+
+		_, err := service.SearchPatient(&inputPatient)
+
+		today := pkg.Today()
+		assert.NoError(t, err)
+		assert.Equal(t, pkg.FormatDate(today.AddDate(-18, 0, 0)), pkg.FormatDate(inputPatient.DateOfBirthTo))
+		assert.Equal(t, pkg.FormatDate(today.AddDate(-31, 0, 1)), pkg.FormatDate(inputPatient.DateOfBirthFrom))
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
 	// Test case: Failed - patient searching error
 	t.Run("error patient repository searching", func(t *testing.T) {
 		// mock input body request
-		inputPatient := pkg.Patient{
+		inputPatient := pkg.PatientSearchRequest{PatientFilter: pkg.PatientFilter{
 			PatientHN:  "-7",
 			HospitalID: 1,
-		}
+		}}
 
 		mockRepo.On("SearchPatient", &inputPatient).Return(nil, errors.New("database error"))
 
@@ -66,3 +144,140 @@ func TestPatientService_SearchPatient(t *testing.T) {
 		mockRepo.AssertExpectations(t)
 	})
 }
+
+func TestPatientService_GetPatientByIdentifier(t *testing.T) {
+	mockRepo := new(mockPatientRepo)
+	service := patient.NewPatientService(mockRepo)
+
+	// Test case: Successful patient lookup
+	t.Run("successful patient lookup", func(t *testing.T) {
+		mockRepo.On("GetPatientByIdentifier", 1, "1234567890123").Return(&pkg.Patient{ID: 1, NationalID: "1234567890123"}, nil)
+
+		foundPatient, err := service.GetPatientByIdentifier(1, "1234567890123")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "1234567890123", foundPatient.NationalID)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - record not found is mapped to ErrPatientNotFound
+	t.Run("patient not found", func(t *testing.T) {
+		mockRepo.On("GetPatientByIdentifier", 1, "P000000000").Return(nil, gorm.ErrRecordNotFound)
+
+		foundPatient, err := service.GetPatientByIdentifier(1, "P000000000")
+
+		assert.Nil(t, foundPatient)
+		assert.ErrorIs(t, err, patient.ErrPatientNotFound)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - patient lookup error
+	t.Run("error patient repository lookup", func(t *testing.T) {
+		mockRepo.On("GetPatientByIdentifier", 2, "1234567890123").Return(nil, errors.New("database error"))
+
+		_, err := service.GetPatientByIdentifier(2, "1234567890123")
+
+		assert.EqualError(t, err, "database error")
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestPatientService_CreatePatient(t *testing.T) {
+	mockRepo := new(mockPatientRepo)
+	service := patient.NewPatientService(mockRepo)
+
+	// Test case: Successful patient creation ignores a client supplied ID
+	t.Run("successful patient creation", func(t *testing.T) {
+		inputPatient := pkg.Patient{ID: 99, PatientHN: "HN0001", HospitalID: 1}
+
+		mockRepo.On("CreatePatient", &inputPatient).Return(nil)
+
+		createdPatient, err := service.CreatePatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, createdPatient.ID)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - duplicate patient HN
+	t.Run("duplicate patient creation", func(t *testing.T) {
+		inputPatient := pkg.Patient{PatientHN: "HN0002", HospitalID: 1}
+
+		mockRepo.On("CreatePatient", &inputPatient).Return(patient.ErrDuplicatePatientHN)
+
+		createdPatient, err := service.CreatePatient(&inputPatient)
+
+		assert.ErrorIs(t, err, patient.ErrDuplicatePatientHN)
+		assert.Nil(t, createdPatient)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestPatientService_UpdatePatient(t *testing.T) {
+	mockRepo := new(mockPatientRepo)
+	service := patient.NewPatientService(mockRepo)
+
+	// Test case: Successful patient update
+	t.Run("successful patient update", func(t *testing.T) {
+		inputPatient := pkg.Patient{ID: 1, PatientHN: "HN0001", HospitalID: 1}
+
+		mockRepo.On("UpdatePatient", &inputPatient).Return(nil)
+
+		updatedPatient, err := service.UpdatePatient(&inputPatient)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "HN0001", updatedPatient.PatientHN)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - patient of another hospital or deleted
+	t.Run("patient to update not found", func(t *testing.T) {
+		inputPatient := pkg.Patient{ID: 2, PatientHN: "HN0002", HospitalID: 1}
+
+		mockRepo.On("UpdatePatient", &inputPatient).Return(gorm.ErrRecordNotFound)
+
+		_, err := service.UpdatePatient(&inputPatient)
+
+		assert.ErrorIs(t, err, patient.ErrPatientNotFound)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestPatientService_DeletePatient(t *testing.T) {
+	mockRepo := new(mockPatientRepo)
+	service := patient.NewPatientService(mockRepo)
+
+	// Test case: Successful patient deletion
+	t.Run("successful patient deletion", func(t *testing.T) {
+		mockRepo.On("DeletePatient", 1, 1).Return(nil)
+
+		assert.NoError(t, service.DeletePatient(1, 1))
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - patient already deleted
+	t.Run("patient to delete not found", func(t *testing.T) {
+		mockRepo.On("DeletePatient", 1, 2).Return(gorm.ErrRecordNotFound)
+
+		assert.ErrorIs(t, service.DeletePatient(1, 2), patient.ErrPatientNotFound)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+}
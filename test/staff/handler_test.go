@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -20,19 +22,124 @@ type MockStaffService struct {
 	mock.Mock
 }
 
-func (m *MockStaffService) CreateStaff(staff *pkg.Staff) (*pkg.Staff, error) {
+func (m *MockStaffService) InviteStaff(staff *pkg.Staff) (*pkg.StaffInvitation, error) {
 	args := m.Called(staff)
 	if args.Get(0) == nil {
 		// If the first return value is nil, avoid type assertion and return nil
 		return nil, args.Error(1)
 	}
 	// Type assertion if not nil
+	return args.Get(0).(*pkg.StaffInvitation), args.Error(1)
+}
+
+func (m *MockStaffService) ActivateStaff(token string, password string) (*pkg.Staff, error) {
+	args := m.Called(token, password)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	return args.Get(0).(*pkg.Staff), args.Error(1)
 }
 
-func (m *MockStaffService) SignInStaff(staff *pkg.Staff) (string, error) {
-	args := m.Called(staff)
-	return args.String(0), args.Error(1)
+func (m *MockStaffService) BootstrapAdmin(staff *pkg.Staff, bootstrapToken string) (*pkg.Staff, error) {
+	args := m.Called(staff, bootstrapToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Staff), args.Error(1)
+}
+
+func (m *MockStaffService) BootstrapSuperAdmin(staff *pkg.Staff, bootstrapToken string) (*pkg.Staff, error) {
+	args := m.Called(staff, bootstrapToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Staff), args.Error(1)
+}
+
+func (m *MockStaffService) SignInStaff(credentials *pkg.Staff, clientIP string) (*staff.TokenPair, *staff.MFAChallenge, error) {
+	args := m.Called(credentials, clientIP)
+	tokens, _ := args.Get(0).(*staff.TokenPair)
+	challenge, _ := args.Get(1).(*staff.MFAChallenge)
+	return tokens, challenge, args.Error(2)
+}
+
+func (m *MockStaffService) RefreshToken(refreshToken string) (*staff.TokenPair, error) {
+	args := m.Called(refreshToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*staff.TokenPair), args.Error(1)
+}
+
+func (m *MockStaffService) SignOutStaff(tokenID string, familyID string, expiresAt time.Time) error {
+	args := m.Called(tokenID, familyID, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockStaffService) RevokeStaffSessions(hospitalID int, staffID int) error {
+	args := m.Called(hospitalID, staffID)
+	return args.Error(0)
+}
+
+func (m *MockStaffService) UnlockStaff(hospitalID int, staffID int) error {
+	args := m.Called(hospitalID, staffID)
+	return args.Error(0)
+}
+
+func (m *MockStaffService) ChangePassword(staffID int, familyID string, currentPassword string, newPassword string) error {
+	args := m.Called(staffID, familyID, currentPassword, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockStaffService) RequestPasswordReset(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *MockStaffService) ResetPassword(token string, newPassword string) error {
+	args := m.Called(token, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockStaffService) VerifyMFA(mfaToken string, code string, clientIP string) (*staff.TokenPair, []string, error) {
+	args := m.Called(mfaToken, code, clientIP)
+	tokens, _ := args.Get(0).(*staff.TokenPair)
+	recoveryCodes, _ := args.Get(1).([]string)
+	return tokens, recoveryCodes, args.Error(2)
+}
+
+func (m *MockStaffService) StartLoginTOTPEnrollment(mfaToken string) (*staff.TOTPEnrollment, error) {
+	args := m.Called(mfaToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*staff.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockStaffService) StartTOTPEnrollment(staffID int, password string) (*staff.TOTPEnrollment, error) {
+	args := m.Called(staffID, password)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*staff.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockStaffService) ConfirmTOTPEnrollment(staffID int, code string) ([]string, error) {
+	args := m.Called(staffID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockStaffService) ResetStaffMFA(hospitalID int, staffID int) error {
+	args := m.Called(hospitalID, staffID)
+	return args.Error(0)
+}
+
+// Mock returning hospitalID of the admin as 1 without JWT cookie
+func mockGetHospitalID(c *gin.Context) (int, error) {
+	return 1, nil
 }
 
 // Test the CreateStaff handler of HttpStaffrHandler
@@ -40,24 +147,33 @@ func TestStaffHandler_CreateStaff(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	mockService := new(MockStaffService)
-	handler := staff.NewHttpStaffHandler(mockService)
+	handler := &staff.StaffHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+	}
 
 	r := gin.Default()
 	r.POST("/staff/create", handler.CreateStaff)
 
-	// Test case: Successful staff creation
+	// Test case: Successful staff invitation in the admin's hospital
 	t.Run("successful staff creation", func(t *testing.T) {
-		// mock input body request
-		inputStaff := pkg.Staff{
+		// hospital and password in the body are ignored
+		body := `{"username": "test_user", "password": "secure_password", "role": "doctor", "hospital_id": 2}`
+		expectedStaff := pkg.Staff{
 			Username:   "test_user",
-			Password:   "secure_password",
+			Role:       pkg.RoleDoctor,
 			HospitalID: 1,
 		}
+		invitation := &pkg.StaffInvitation{
+			Staff:     pkg.Staff{ID: 5, Username: "test_user", Role: pkg.RoleDoctor, HospitalID: 1, Password: "should_never_leak"},
+			TokenHash: "hash_should_never_leak",
+			Token:     "invitation_token",
+			ExpiresAt: time.Now().Add(staff.InvitationTTL),
+		}
 
-		mockService.On("CreateStaff", mock.AnythingOfType("*pkg.Staff")).Return(&inputStaff, nil)
+		mockService.On("InviteStaff", &expectedStaff).Return(invitation, nil)
 
-		body, _ := json.Marshal(inputStaff)
-		req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(string(body)))
+		req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(body))
 		req.Header.Set("Content-Type", "application/json")
 
 		w := httptest.NewRecorder()
@@ -69,19 +185,57 @@ func TestStaffHandler_CreateStaff(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusCreated, w.Code)
 		assert.Equal(t, "Created successfully", response["message"])
-		
-		mockService.AssertCalled(t, "CreateStaff", &inputStaff)
+
+		// The invitation token is returned once, the password (hash) and nested hospital never are
+		data := response["data"].(map[string]interface{})
+		assert.Equal(t, "invitation_token", data["token"])
+		createdStaff := data["staff"].(map[string]interface{})
+		assert.Equal(t, "test_user", createdStaff["username"])
+		assert.Equal(t, float64(1), createdStaff["hospital_id"])
+		assert.Equal(t, "doctor", createdStaff["role"])
+		assert.NotContains(t, createdStaff, "password")
+		assert.NotContains(t, createdStaff, "hospital")
+		assert.NotContains(t, w.Body.String(), "should_never_leak")
+
 		// Verify expectations
 		mockService.AssertExpectations(t)
 	})
 
 	// Test case: Invalid request body format (wrong struct format)
 	t.Run("failed staff creation (invalid request body format)", func(t *testing.T) {
-		// mock input body request
-		inputStaff := "This is random string that should trigger EOF error"
+		req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(`"This is random string that should trigger EOF error"`))
+		req.Header.Set("Content-Type", "application/json")
 
-		body, _ := json.Marshal(inputStaff)
-		req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(string(body)))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case: Failed - missing username, missing or unknown role
+	t.Run("failed staff creation (invalid username or role)", func(t *testing.T) {
+		for _, body := range []string{
+			`{"username": "", "role": "doctor"}`,
+			`{"username": "test_user"}`,
+			`{"username": "test_user", "role": "superuser"}`,
+		} {
+			req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+
+	// Test case: Staff service returns error
+	t.Run("staff service error", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("InviteStaff", mock.AnythingOfType("*pkg.Staff")).Return(nil, errors.New("service error"))
+
+		req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(`{"username": "test_user", "role": "nurse"}`))
 		req.Header.Set("Content-Type", "application/json")
 
 		w := httptest.NewRecorder()
@@ -90,21 +244,64 @@ func TestStaffHandler_CreateStaff(t *testing.T) {
 		var response map[string]interface{}
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 
+		// Database errors are not exposed to the client
 		assert.NoError(t, err)
-		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "internal server error", response["error"])
+		assert.Equal(t, staff.CodeInternalError, response["code"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
 	})
 
-	// Test case: Failed - Invalidated request body (username or password is empty)
-	t.Run("failed staff creation (username or password is empty)", func(t *testing.T) {
-		// mock input body request
-		inputStaff := pkg.Staff{
-			Username:   "",
-			Password:   "",
-			HospitalID: 1,
+	// Test case: Failed - domain errors map to status codes and stable error codes
+	t.Run("failed staff creation (hospital or username)", func(t *testing.T) {
+		expected := []struct {
+			err    error
+			status int
+			code   string
+		}{
+			{staff.ErrInvalidHospital, http.StatusBadRequest, staff.CodeInvalidHospital},
+			{staff.ErrHospitalNotFound, http.StatusNotFound, staff.CodeHospitalNotFound},
+			{staff.ErrDuplicateUsername, http.StatusConflict, staff.CodeUsernameTaken},
 		}
+		for _, e := range expected {
+			// Reset expectations for this test case
+			mockService.ExpectedCalls = nil
+			mockService.On("InviteStaff", mock.AnythingOfType("*pkg.Staff")).Return(nil, e.err)
 
-		body, _ := json.Marshal(inputStaff)
-		req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(string(body)))
+			req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(`{"username": "test_user", "role": "nurse"}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var response map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+
+			assert.NoError(t, err)
+			assert.Equal(t, e.status, w.Code, e.err.Error())
+			assert.Equal(t, e.code, response["code"], e.err.Error())
+		}
+	})
+}
+
+// Test the ActivateStaff handler of HttpStaffrHandler
+func TestStaffHandler_ActivateStaff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := staff.NewHttpStaffHandler(mockService)
+
+	r := gin.Default()
+	r.POST("/staff/activate", handler.ActivateStaff)
+
+	// Test case: Successful activation
+	t.Run("successful staff activation", func(t *testing.T) {
+		mockService.On("ActivateStaff", "invitation_token", "secure_password").
+			Return(&pkg.Staff{ID: 5, Username: "test_user", Password: "hashed_password", Role: pkg.RoleNurse, HospitalID: 1}, nil)
+
+		req := httptest.NewRequest("POST", "/staff/activate", bytes.NewBufferString(`{"token": "invitation_token", "password": "secure_password"}`))
 		req.Header.Set("Content-Type", "application/json")
 
 		w := httptest.NewRecorder()
@@ -114,24 +311,37 @@ func TestStaffHandler_CreateStaff(t *testing.T) {
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 
 		assert.NoError(t, err)
-		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "test_user", response["data"].(map[string]interface{})["username"])
+		assert.NotContains(t, w.Body.String(), "hashed_password")
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
 	})
 
-	// Test case: Staff service returns error
-	t.Run("staff service error", func(t *testing.T) {
-		// mock input body request
-		inputStaff := pkg.Staff{
-			Username:   "test_user",
-			Password:   "secure_password",
-			HospitalID: 1,
-		}
+	// Test case: Failed - missing token or password
+	t.Run("failed staff activation (token or password is empty)", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/staff/activate", bytes.NewBufferString(`{"token": "invitation_token"}`))
+		req.Header.Set("Content-Type", "application/json")
 
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+	})
+
+	// Test case: Failed - unknown, used or expired invitation
+	t.Run("failed staff activation (invalid invitation)", func(t *testing.T) {
 		// Reset expectations for this test case
 		mockService.ExpectedCalls = nil
-		mockService.On("CreateStaff", mock.AnythingOfType("*pkg.Staff")).Return(nil, errors.New("service error"))
-		
-		body, _ := json.Marshal(inputStaff)
-		req := httptest.NewRequest("POST", "/staff/create", bytes.NewBufferString(string(body)))
+		mockService.On("ActivateStaff", "used_token", "secure_password").Return(nil, staff.ErrInvalidInvitation)
+
+		req := httptest.NewRequest("POST", "/staff/activate", bytes.NewBufferString(`{"token": "used_token", "password": "secure_password"}`))
 		req.Header.Set("Content-Type", "application/json")
 
 		w := httptest.NewRecorder()
@@ -141,13 +351,146 @@ func TestStaffHandler_CreateStaff(t *testing.T) {
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 
 		assert.NoError(t, err)
-		assert.Equal(t, http.StatusInternalServerError, w.Code)
-		assert.Equal(t, "service error", response["error"])
-		
-		mockService.AssertCalled(t, "CreateStaff", &inputStaff)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.ErrInvalidInvitation.Error(), response["error"])
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+}
+
+// Test the BootstrapAdmin handler of HttpStaffrHandler
+func TestStaffHandler_BootstrapAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := staff.NewHttpStaffHandler(mockService)
+
+	r := gin.Default()
+	r.POST("/staff/bootstrap", handler.BootstrapAdmin)
+
+	body := `{"username": "admin", "password": "secure_password", "hospital_id": 1}`
+	inputStaff := &pkg.Staff{Username: "admin", Password: "secure_password", HospitalID: 1}
+
+	// Test case: Successful creation of the first admin
+	t.Run("successful admin bootstrap", func(t *testing.T) {
+		mockService.On("BootstrapAdmin", inputStaff, "bootstrap_token").
+			Return(&pkg.Staff{ID: 1, Username: "admin", Password: "hashed_password", Role: pkg.RoleAdmin, HospitalID: 1}, nil)
+
+		req := httptest.NewRequest("POST", "/staff/bootstrap", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Bootstrap-Token", "bootstrap_token")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "admin", response["data"].(map[string]interface{})["role"])
+		assert.NotContains(t, w.Body.String(), "hashed_password")
+
+		// Verify expectations
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - service errors map to status codes
+	t.Run("failed admin bootstrap", func(t *testing.T) {
+		expectedCodes := map[error]int{
+			staff.ErrBootstrapDisabled:  http.StatusNotFound,
+			staff.ErrInvalidBootstrap:   http.StatusUnauthorized,
+			staff.ErrAdminExists:        http.StatusConflict,
+			staff.ErrHospitalNotFound:   http.StatusNotFound,
+			staff.ErrDuplicateUsername:  http.StatusConflict,
+			errors.New("service error"): http.StatusInternalServerError,
+		}
+		for serviceErr, expectedCode := range expectedCodes {
+			// Reset expectations for this test case
+			mockService.ExpectedCalls = nil
+			mockService.On("BootstrapAdmin", inputStaff, "").Return(nil, serviceErr)
+
+			req := httptest.NewRequest("POST", "/staff/bootstrap", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, expectedCode, w.Code, serviceErr.Error())
+		}
+	})
+
+	// Test case: Failed - missing hospital
+	t.Run("failed admin bootstrap (hospital is missing)", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/staff/bootstrap", bytes.NewBufferString(`{"username": "admin", "password": "secure_password"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// Test the BootstrapSuperAdmin handler of HttpStaffrHandler
+func TestStaffHandler_BootstrapSuperAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := staff.NewHttpStaffHandler(mockService)
+
+	r := gin.Default()
+	r.POST("/staff/bootstrap/super-admin", handler.BootstrapSuperAdmin)
+
+	body := `{"username": "root", "password": "secure_password"}`
+	inputStaff := &pkg.Staff{Username: "root", Password: "secure_password"}
+
+	// Test case: Successful creation of the super-admin
+	t.Run("successful super-admin bootstrap", func(t *testing.T) {
+		mockService.On("BootstrapSuperAdmin", inputStaff, "bootstrap_token").
+			Return(&pkg.Staff{ID: 1, Username: "root", Password: "hashed_password", Role: pkg.RoleSuperAdmin}, nil)
+
+		req := httptest.NewRequest("POST", "/staff/bootstrap/super-admin", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Bootstrap-Token", "bootstrap_token")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "super_admin", response["data"].(map[string]interface{})["role"])
+		assert.NotContains(t, w.Body.String(), "hashed_password")
+
 		// Verify expectations
 		mockService.AssertExpectations(t)
 	})
+
+	// Test case: Failed - a super-admin already exists
+	t.Run("failed super-admin bootstrap (already exists)", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("BootstrapSuperAdmin", inputStaff, "bootstrap_token").Return(nil, staff.ErrSuperAdminExists)
+
+		req := httptest.NewRequest("POST", "/staff/bootstrap/super-admin", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Bootstrap-Token", "bootstrap_token")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, staff.CodeSuperAdminExists, response["code"])
+	})
 }
 
 // Test the SignInStaff handler of HttpStaffrHandler
@@ -168,7 +511,12 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 			Password: "secure_password",
 		}
 
-		mockService.On("SignInStaff", mock.AnythingOfType("*pkg.Staff")).Return("token", nil)
+		mockService.On("SignInStaff", mock.AnythingOfType("*pkg.Staff"), mock.Anything).Return(&staff.TokenPair{
+			AccessToken:           "access-token",
+			AccessTokenExpiresAt:  time.Now().Add(staff.AccessTokenTTL),
+			RefreshToken:          "refresh-token",
+			RefreshTokenExpiresAt: time.Now().Add(staff.RefreshTokenTTL),
+		}, nil, nil)
 
 		body, _ := json.Marshal(inputStaff)
 		req := httptest.NewRequest("POST", "/staff/login", bytes.NewBufferString(string(body)))
@@ -183,8 +531,18 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, "Login successful", response["message"])
-		
-		mockService.AssertCalled(t, "SignInStaff", &inputStaff)
+		// The access token cookie expires with the token, the refresh token is only sent to the refresh endpoint
+		cookies := map[string]*http.Cookie{}
+		for _, cookie := range w.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		assert.Equal(t, "access-token", cookies["jwt"].Value)
+		assert.InDelta(t, staff.AccessTokenTTL.Seconds(), cookies["jwt"].MaxAge, 1)
+		assert.Equal(t, "refresh-token", cookies["refresh_token"].Value)
+		assert.Equal(t, "/staff/token", cookies["refresh_token"].Path)
+		assert.True(t, cookies["refresh_token"].HttpOnly)
+
+		mockService.AssertCalled(t, "SignInStaff", &inputStaff, "192.0.2.1")
 		// Verify expectations
 		mockService.AssertExpectations(t)
 	})
@@ -206,6 +564,7 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
 	})
 
 	// Test case: Failed - Invalidated request body (username or password is empty)
@@ -228,6 +587,7 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
 	})
 
 	// Test case: Failed - Staff service returns an unauthorized error
@@ -240,7 +600,7 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 
 		// Reset expectations for this test case
 		mockService.ExpectedCalls = nil
-		mockService.On("SignInStaff", mock.AnythingOfType("*pkg.Staff")).Return("", staff.ErrUnauthorized)
+		mockService.On("SignInStaff", mock.AnythingOfType("*pkg.Staff"), mock.Anything).Return(nil, nil, staff.ErrUnauthorized)
 
 		body, _ := json.Marshal(inputStaff)
 		req := httptest.NewRequest("POST", "/staff/login", bytes.NewBufferString(string(body)))
@@ -255,7 +615,7 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
 
-		mockService.AssertCalled(t, "SignInStaff", &inputStaff)
+		mockService.AssertCalled(t, "SignInStaff", &inputStaff, "192.0.2.1")
 		// Verify expectations
 		mockService.AssertExpectations(t)
 	})
@@ -270,7 +630,7 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 
 		// Reset expectations for this test case
 		mockService.ExpectedCalls = nil
-		mockService.On("SignInStaff", mock.AnythingOfType("*pkg.Staff")).Return("", errors.New("service error"))
+		mockService.On("SignInStaff", mock.AnythingOfType("*pkg.Staff"), mock.Anything).Return(nil, nil, errors.New("service error"))
 
 		body, _ := json.Marshal(inputStaff)
 		req := httptest.NewRequest("POST", "/staff/login", bytes.NewBufferString(string(body)))
@@ -284,10 +644,402 @@ func TestStaffHandler_SignInStaff(t *testing.T) {
 
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusInternalServerError, w.Code)
-		assert.Equal(t, "service error", response["error"])
-		
-		mockService.AssertCalled(t, "SignInStaff", &inputStaff)
+		assert.Equal(t, "internal server error", response["error"])
+
+		mockService.AssertCalled(t, "SignInStaff", &inputStaff, "192.0.2.1")
 		// Verify expectations
 		mockService.AssertExpectations(t)
 	})
 }
+
+func TestStaffHandler_SignOutStaff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	expiresAt := time.Now().Add(time.Hour)
+	handler := &staff.StaffHandler{
+		Service: mockService,
+		GetSessionFn: func(c *gin.Context) (*middleware.Session, error) {
+			return &middleware.Session{TokenID: "token-id", FamilyID: "family-id", StaffID: 1, ExpiresAt: expiresAt}, nil
+		},
+	}
+
+	r := gin.Default()
+	r.POST("/staff/logout", handler.SignOutStaff)
+
+	// Test case: the token is revoked and its cookie cleared
+	t.Run("successful staff logout", func(t *testing.T) {
+		mockService.On("SignOutStaff", "token-id", "family-id", expiresAt).Return(nil)
+
+		req := httptest.NewRequest("POST", "/staff/logout", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 2)
+		for _, cookie := range cookies {
+			assert.Empty(t, cookie.Value, cookie.Name)
+			assert.Negative(t, cookie.MaxAge, cookie.Name)
+		}
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - the revocation cannot be stored, the token stays usable
+	t.Run("error service", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("SignOutStaff", "token-id", "family-id", expiresAt).Return(errors.New("database error"))
+
+		req := httptest.NewRequest("POST", "/staff/logout", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotContains(t, w.Body.String(), "database error")
+	})
+}
+
+func TestStaffHandler_RevokeStaffSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := &staff.StaffHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+	}
+
+	r := gin.Default()
+	r.POST("/staff/:id/revoke-sessions", handler.RevokeStaffSessions)
+
+	// Test case: sessions of staff of the admin's hospital are revoked
+	t.Run("successful session revocation", func(t *testing.T) {
+		mockService.On("RevokeStaffSessions", 1, 5).Return(nil)
+
+		req := httptest.NewRequest("POST", "/staff/5/revoke-sessions", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - invalid staff ID
+	t.Run("invalid staff ID", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/staff/abc/revoke-sessions", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case: Failed - unknown staff or staff of another hospital
+	t.Run("staff not found", func(t *testing.T) {
+		mockService.On("RevokeStaffSessions", 1, 6).Return(staff.ErrStaffNotFound)
+
+		req := httptest.NewRequest("POST", "/staff/6/revoke-sessions", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestStaffHandler_RefreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := staff.NewHttpStaffHandler(mockService)
+
+	r := gin.Default()
+	r.POST("/staff/token/refresh", handler.RefreshToken)
+
+	// Test case: the refresh token cookie is exchanged for new token cookies
+	t.Run("successful token refresh", func(t *testing.T) {
+		mockService.On("RefreshToken", "refresh-token").Return(&staff.TokenPair{
+			AccessToken:           "new-access-token",
+			AccessTokenExpiresAt:  time.Now().Add(staff.AccessTokenTTL),
+			RefreshToken:          "new-refresh-token",
+			RefreshTokenExpiresAt: time.Now().Add(staff.RefreshTokenTTL),
+		}, nil)
+
+		req := httptest.NewRequest("POST", "/staff/token/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-token"})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		cookies := map[string]string{}
+		for _, cookie := range w.Result().Cookies() {
+			cookies[cookie.Name] = cookie.Value
+		}
+		assert.Equal(t, map[string]string{"jwt": "new-access-token", "refresh_token": "new-refresh-token"}, cookies)
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - no refresh token
+	t.Run("missing refresh token", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/staff/token/refresh", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	// Test case: Failed - invalid or reused refresh token, the cookies are cleared
+	t.Run("invalid or reused refresh token", func(t *testing.T) {
+		for _, serviceErr := range []error{staff.ErrInvalidRefreshToken, staff.ErrRefreshTokenReused} {
+			// Reset expectations for this test case
+			mockService.ExpectedCalls = nil
+			mockService.On("RefreshToken", "refresh-token").Return(nil, serviceErr)
+
+			req := httptest.NewRequest("POST", "/staff/token/refresh", nil)
+			req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-token"})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Body.String(), serviceErr.Error())
+			for _, cookie := range w.Result().Cookies() {
+				assert.Empty(t, cookie.Value, cookie.Name)
+			}
+		}
+	})
+
+	// Test case: Failed - service error is not exposed
+	t.Run("error service", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockService.ExpectedCalls = nil
+		mockService.On("RefreshToken", "refresh-token").Return(nil, errors.New("database error"))
+
+		req := httptest.NewRequest("POST", "/staff/token/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-token"})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotContains(t, w.Body.String(), "database error")
+	})
+}
+
+func TestStaffHandler_TokensInBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := staff.NewHttpStaffHandler(mockService)
+
+	r := gin.Default()
+	r.POST("/staff/login", handler.SignInStaff)
+	r.POST("/staff/token/refresh", handler.RefreshToken)
+
+	tokens := &staff.TokenPair{
+		AccessToken:           "access-token",
+		AccessTokenExpiresAt:  time.Now().Add(staff.AccessTokenTTL),
+		RefreshToken:          "refresh-token",
+		RefreshTokenExpiresAt: time.Now().Add(staff.RefreshTokenTTL),
+	}
+
+	// Test case: clients without cookies get the tokens in the body, and no cookie
+	t.Run("login with tokens in body", func(t *testing.T) {
+		mockService.On("SignInStaff", &pkg.Staff{Username: "test_user", Password: "secure_password"}, mock.Anything).Return(tokens, nil, nil)
+
+		req := httptest.NewRequest("POST", "/staff/login", bytes.NewBufferString(`{"username": "test_user", "password": "secure_password", "return_tokens": true}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response staff.TokenResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "access-token", response.AccessToken)
+		assert.Equal(t, "Bearer", response.TokenType)
+		assert.InDelta(t, staff.AccessTokenTTL.Seconds(), response.ExpiresIn, 1)
+		assert.Equal(t, "refresh-token", response.RefreshToken)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	// Test case: a refresh token sent in the body is exchanged for tokens in the body
+	t.Run("refresh with tokens in body", func(t *testing.T) {
+		mockService.On("RefreshToken", "refresh-token").Return(tokens, nil)
+
+		req := httptest.NewRequest("POST", "/staff/token/refresh", bytes.NewBufferString(`{"refresh_token": "refresh-token"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var response staff.TokenResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "access-token", response.AccessToken)
+		assert.Empty(t, w.Result().Cookies())
+	})
+}
+
+func TestStaffHandler_CookieConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := staff.NewHttpStaffHandler(mockService)
+	handler.Cookies = staff.CookieConfig{Domain: "hospital.example.com", Secure: true, SameSite: http.SameSiteStrictMode}
+
+	r := gin.Default()
+	r.POST("/staff/login", handler.SignInStaff)
+
+	// Test case: the token cookies carry the configured attributes
+	t.Run("configured cookie attributes", func(t *testing.T) {
+		mockService.On("SignInStaff", mock.AnythingOfType("*pkg.Staff"), mock.Anything).Return(&staff.TokenPair{
+			AccessToken:           "access-token",
+			AccessTokenExpiresAt:  time.Now().Add(staff.AccessTokenTTL),
+			RefreshToken:          "refresh-token",
+			RefreshTokenExpiresAt: time.Now().Add(staff.RefreshTokenTTL),
+		}, nil, nil)
+
+		req := httptest.NewRequest("POST", "/staff/login", bytes.NewBufferString(`{"username": "test_user", "password": "secure_password"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 2)
+		for _, cookie := range cookies {
+			assert.Equal(t, "hospital.example.com", cookie.Domain, cookie.Name)
+			assert.True(t, cookie.Secure, cookie.Name)
+			assert.True(t, cookie.HttpOnly, cookie.Name)
+			assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite, cookie.Name)
+		}
+	})
+}
+
+func TestParseCookieConfig(t *testing.T) {
+	// Test case: defaults for local development
+	t.Run("default cookie config", func(t *testing.T) {
+		config, err := staff.ParseCookieConfig("", "", "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, staff.DefaultCookieConfig, config)
+		assert.Equal(t, http.SameSiteLaxMode, config.SameSite)
+	})
+
+	t.Run("configured cookie config", func(t *testing.T) {
+		config, err := staff.ParseCookieConfig("hospital.example.com", "true", "None")
+
+		assert.NoError(t, err)
+		assert.Equal(t, staff.CookieConfig{Domain: "hospital.example.com", Secure: true, SameSite: http.SameSiteNoneMode}, config)
+	})
+
+	// Test case: Failed - invalid values, and SameSite=None without Secure
+	t.Run("invalid cookie config", func(t *testing.T) {
+		for _, values := range [][3]string{
+			{"", "maybe", ""},
+			{"", "", "sometimes"},
+			{"", "false", "none"},
+		} {
+			_, err := staff.ParseCookieConfig(values[0], values[1], values[2])
+
+			assert.Error(t, err, values)
+		}
+	})
+}
+
+func TestStaffHandler_SignInStaffThrottled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := staff.NewHttpStaffHandler(mockService)
+
+	r := gin.Default()
+	r.POST("/staff/login", handler.SignInStaff)
+
+	login := func(username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/staff/login", bytes.NewBufferString(`{"username": "`+username+`", "password": "secure_password"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case: Failed - too many failed logins, with the wait in seconds rounded up
+	t.Run("login throttled", func(t *testing.T) {
+		mockService.On("SignInStaff", &pkg.Staff{Username: "locked_user", Password: "secure_password"}, "192.0.2.1").
+			Return(nil, nil, &staff.LoginThrottledError{RetryAfter: 1500 * time.Millisecond})
+
+		w := login("locked_user")
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), staff.ErrLoginThrottled.Error())
+	})
+
+	// Test case: Failed - every password hash worker is busy
+	t.Run("login busy", func(t *testing.T) {
+		mockService.On("SignInStaff", &pkg.Staff{Username: "busy_user", Password: "secure_password"}, "192.0.2.1").
+			Return(nil, nil, staff.ErrLoginBusy)
+
+		w := login("busy_user")
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+}
+
+func TestStaffHandler_UnlockStaff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := &staff.StaffHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+	}
+
+	r := gin.Default()
+	r.POST("/staff/:id/unlock", handler.UnlockStaff)
+
+	// Test case: staff of the admin's hospital are unlocked
+	t.Run("successful unlock", func(t *testing.T) {
+		mockService.On("UnlockStaff", 1, 5).Return(nil)
+
+		req := httptest.NewRequest("POST", "/staff/5/unlock", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - invalid staff ID
+	t.Run("invalid staff ID", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/staff/0/unlock", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// Test case: Failed - unknown staff or staff of another hospital
+	t.Run("staff not found", func(t *testing.T) {
+		mockService.On("UnlockStaff", 1, 6).Return(staff.ErrStaffNotFound)
+
+		req := httptest.NewRequest("POST", "/staff/6/unlock", nil)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
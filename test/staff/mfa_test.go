@@ -0,0 +1,851 @@
+package staff_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Base64 32-byte key sealing the TOTP secrets of the tests
+const testTOTPEncryptionKey = "MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE="
+
+// Tests the codes against the SHA1 test vectors of RFC 6238, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	// Base32 of the ASCII secret 12345678901234567890
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	expectedCodes := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expectedCode := range expectedCodes {
+		code, err := pkg.TOTPCode(secret, pkg.TOTPStep(time.Unix(unix, 0)))
+
+		assert.NoError(t, err)
+		assert.Equal(t, expectedCode, code, unix)
+	}
+
+	// Test case: codes of the adjacent steps are accepted for clock drift, a used step is not
+	t.Run("verify codes", func(t *testing.T) {
+		at := time.Unix(1111111111, 0)
+		step := pkg.TOTPStep(at)
+		previous, _ := pkg.TOTPCode(secret, step-1)
+
+		verifiedStep, ok := pkg.VerifyTOTP(secret, "050471", at, 0)
+		assert.True(t, ok)
+		assert.Equal(t, step, verifiedStep)
+
+		verifiedStep, ok = pkg.VerifyTOTP(secret, previous, at, 0)
+		assert.True(t, ok)
+		assert.Equal(t, step-1, verifiedStep)
+
+		_, ok = pkg.VerifyTOTP(secret, "050471", at, step)
+		assert.False(t, ok)
+		_, ok = pkg.VerifyTOTP(secret, "050471", at.Add(2*pkg.TOTPPeriod), 0)
+		assert.False(t, ok)
+		_, ok = pkg.VerifyTOTP(secret, "50471", at, 0)
+		assert.False(t, ok)
+	})
+
+	// Test case: the provisioning URI carries the secret and the issuer
+	t.Run("provisioning URI", func(t *testing.T) {
+		uri, err := url.Parse(pkg.TOTPProvisioningURI("Hospital API", "test_user", secret))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "otpauth", uri.Scheme)
+		assert.Equal(t, "totp", uri.Host)
+		assert.Equal(t, "/Hospital API:test_user", uri.Path)
+		assert.Equal(t, secret, uri.Query().Get("secret"))
+		assert.Equal(t, "Hospital API", uri.Query().Get("issuer"))
+		assert.Equal(t, "6", uri.Query().Get("digits"))
+		assert.Equal(t, "30", uri.Query().Get("period"))
+	})
+}
+
+func TestSecretBox(t *testing.T) {
+	box, err := pkg.ParseSecretBox(testTOTPEncryptionKey)
+	assert.NoError(t, err)
+
+	// Test case: secrets are read back, each sealed with its own nonce
+	t.Run("seal and open", func(t *testing.T) {
+		sealed, err := box.Seal("totp-secret")
+		assert.NoError(t, err)
+		assert.NotContains(t, sealed, "totp-secret")
+		other, _ := box.Seal("totp-secret")
+		assert.NotEqual(t, sealed, other)
+
+		secret, err := box.Open(sealed)
+		assert.NoError(t, err)
+		assert.Equal(t, "totp-secret", secret)
+	})
+
+	// Test case: Failed - another key or an altered secret
+	t.Run("failed open", func(t *testing.T) {
+		sealed, _ := box.Seal("totp-secret")
+		otherBox, err := pkg.ParseSecretBox("QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWY=")
+		assert.NoError(t, err)
+
+		_, err = otherBox.Open(sealed)
+		assert.Error(t, err)
+		_, err = box.Open(sealed[:len(sealed)-2] + "AA")
+		assert.Error(t, err)
+	})
+
+	// Test case: Failed - keys which are not 32 bytes of base64
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", "not base64!", "MDEyMzQ1Njc4OTAxMjM0NTY3ODkw"} {
+			_, err := pkg.ParseSecretBox(key)
+			assert.Error(t, err, key)
+		}
+	})
+}
+
+func TestParseMFAConfig(t *testing.T) {
+	config, err := staff.ParseMFAConfig(" doctor, nurse ,", "", testTOTPEncryptionKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []pkg.Role{pkg.RoleDoctor, pkg.RoleNurse}, config.RequiredRoles)
+	assert.Equal(t, staff.DefaultMFAIssuer, config.Issuer)
+
+	config, err = staff.ParseMFAConfig("", "Hospital A", testTOTPEncryptionKey)
+	assert.NoError(t, err)
+	assert.Empty(t, config.RequiredRoles)
+	assert.Equal(t, "Hospital A", config.Issuer)
+
+	// Test case: Failed - unknown roles, and a missing or invalid encryption key
+	_, err = staff.ParseMFAConfig("surgeon", "", testTOTPEncryptionKey)
+	assert.Error(t, err)
+	_, err = staff.ParseMFAConfig("", "", "")
+	assert.Error(t, err)
+	_, err = staff.ParseMFAConfig("", "", "short")
+	assert.Error(t, err)
+}
+
+// Service of the MFA tests throttling logins, requiring MFA of admins everywhere, and keeping
+// the last session given to CreateTokenFunc
+func newMFAService(t *testing.T, mockRepo *mockStaffRepo, mockHasher *MockPasswordHasher, session *staff.LoginSession) *staff.StaffService {
+	config, err := staff.ParseMFAConfig("admin", "", testTOTPEncryptionKey)
+	assert.NoError(t, err)
+
+	service := newThrottledService(mockRepo, mockHasher)
+	service.Keys = newTestKeyRing(t)
+	service.MFA = config
+	service.CreateTokenFunc = func(staff *pkg.Staff, loginSession staff.LoginSession, expiresAt time.Time) (string, error) {
+		*session = loginSession
+		return "mockTokenString", nil
+	}
+	return service
+}
+
+// Authenticator enrolled with a new secret, sealed as stored
+func newTestTOTP(t *testing.T, service *staff.StaffService, staffID int, confirmed bool) (*pkg.StaffTOTP, string) {
+	secret, err := pkg.NewTOTPSecret()
+	assert.NoError(t, err)
+	sealed, err := service.MFA.Secrets.Seal(secret)
+	assert.NoError(t, err)
+
+	totp := &pkg.StaffTOTP{StaffID: staffID, Secret: sealed, CreatedAt: time.Now()}
+	if confirmed {
+		confirmedAt := time.Now()
+		totp.ConfirmedAt = &confirmedAt
+	}
+	return totp, secret
+}
+
+func currentTOTPCode(t *testing.T, secret string) string {
+	code, err := pkg.TOTPCode(secret, pkg.TOTPStep(time.Now()))
+	assert.NoError(t, err)
+	return code
+}
+
+func TestStaffService_SignInStaffMFA(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	mockHasher := new(MockPasswordHasher)
+	var session staff.LoginSession
+	service := newMFAService(t, mockRepo, mockHasher, &session)
+	credentials := &pkg.Staff{Username: "test_user", Password: "secure_password"}
+
+	// The password of the staff member is right
+	login := func(stored *pkg.Staff) {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockHasher.ExpectedCalls = nil
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return(nil, nil)
+		mockRepo.On("GetStaffFromUsername", "test_user").Return(stored, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("secure_password")).Return(nil)
+		mockRepo.On("ClearLoginFailures", "username:test_user").Return(nil)
+	}
+
+	// Test case: staff with an authenticator get an MFA token instead of the login tokens
+	t.Run("enrolled staff", func(t *testing.T) {
+		login(&pkg.Staff{ID: 5, Username: "test_user", Password: "hash", Role: pkg.RoleNurse, HospitalID: 1})
+		totp, _ := newTestTOTP(t, service, 5, true)
+		mockRepo.On("GetStaffTOTP", 5).Return(totp, nil)
+
+		tokens, challenge, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.Nil(t, tokens)
+		assert.NotEmpty(t, challenge.Token)
+		assert.False(t, challenge.EnrollmentRequired)
+		assert.WithinDuration(t, time.Now().Add(staff.MFATokenTTL), challenge.ExpiresAt, time.Second)
+		mockRepo.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+	})
+
+	// Test case: staff whose role has to use MFA, everywhere or in their hospital, enrol at login
+	t.Run("enrolment required", func(t *testing.T) {
+		login(&pkg.Staff{ID: 5, Username: "test_user", Password: "hash", Role: pkg.RoleAdmin, HospitalID: 1})
+		mockRepo.On("GetStaffTOTP", 5).Return(nil, gorm.ErrRecordNotFound)
+
+		_, challenge, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.True(t, challenge.EnrollmentRequired)
+		mockRepo.AssertNotCalled(t, "GetHospital", mock.Anything)
+
+		login(&pkg.Staff{ID: 5, Username: "test_user", Password: "hash", Role: pkg.RoleDoctor, HospitalID: 1})
+		mockRepo.On("GetStaffTOTP", 5).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("GetHospital", 1).Return(&pkg.Hospital{ID: 1, Settings: pkg.HospitalSettings{MFARequiredRoles: []pkg.Role{pkg.RoleDoctor}}}, nil)
+
+		_, challenge, err = service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.True(t, challenge.EnrollmentRequired)
+	})
+
+	// Test case: staff who need no MFA log in with their password, a pending enrolment is ignored
+	t.Run("password login", func(t *testing.T) {
+		login(&pkg.Staff{ID: 5, Username: "test_user", Password: "hash", Role: pkg.RoleNurse, HospitalID: 1})
+		totp, _ := newTestTOTP(t, service, 5, false)
+		mockRepo.On("GetStaffTOTP", 5).Return(totp, nil)
+		mockRepo.On("GetHospital", 1).Return(&pkg.Hospital{ID: 1, Settings: pkg.HospitalSettings{MFARequiredRoles: []pkg.Role{pkg.RoleDoctor}}}, nil)
+		mockRepo.On("CreateRefreshToken", mock.AnythingOfType("*pkg.RefreshToken")).Return(nil)
+
+		tokens, challenge, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.Nil(t, challenge)
+		assert.Equal(t, "mockTokenString", tokens.AccessToken)
+		stored := mockRepo.Calls[len(mockRepo.Calls)-1].Arguments.Get(0).(*pkg.RefreshToken)
+		assert.Equal(t, "pwd", stored.AuthMethods)
+		assert.Equal(t, []string{"pwd"}, session.AuthMethods)
+		assert.False(t, session.MFARequired)
+	})
+
+	// Test case: without MFA config, every login is a password login
+	t.Run("MFA disabled", func(t *testing.T) {
+		login(&pkg.Staff{ID: 5, Username: "test_user", Password: "hash", Role: pkg.RoleAdmin, HospitalID: 1})
+		mockRepo.On("CreateRefreshToken", mock.AnythingOfType("*pkg.RefreshToken")).Return(nil)
+		disabled := newThrottledService(mockRepo, mockHasher)
+
+		tokens, challenge, err := disabled.SignInStaff(credentials, "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.Nil(t, challenge)
+		assert.NotNil(t, tokens)
+		mockRepo.AssertNotCalled(t, "GetStaffTOTP", mock.Anything)
+	})
+}
+
+func TestStaffService_VerifyMFA(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	mockHasher := new(MockPasswordHasher)
+	var session staff.LoginSession
+	service := newMFAService(t, mockRepo, mockHasher, &session)
+	member := &pkg.Staff{ID: 5, Username: "test_user", Password: "hash", Role: pkg.RoleNurse, HospitalID: 1}
+
+	// MFA token of a login with the right password in a hospital requiring MFA of the roles,
+	// and the repository calls of the code step
+	pendingLogin := func(totp *pkg.StaffTOTP, requiredRoles ...pkg.Role) string {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockHasher.ExpectedCalls = nil
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return(nil, nil)
+		mockRepo.On("GetStaffFromUsername", "test_user").Return(member, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("secure_password")).Return(nil)
+		mockRepo.On("ClearLoginFailures", "username:test_user").Return(nil)
+		mockRepo.On("GetStaffTOTP", 5).Return(totp, nil)
+		mockRepo.On("GetHospital", 1).Return(&pkg.Hospital{ID: 1, Settings: pkg.HospitalSettings{MFARequiredRoles: requiredRoles}}, nil)
+
+		_, challenge, err := service.SignInStaff(&pkg.Staff{Username: "test_user", Password: "secure_password"}, "192.0.2.1")
+		assert.NoError(t, err)
+
+		mockRepo.On("IsTokenRevoked", mock.AnythingOfType("string"), 5, "", mock.AnythingOfType("time.Time")).Return(false, nil)
+		mockRepo.On("GetStaffByID", 5).Return(member, nil)
+		mockRepo.On("RevokeToken", mock.AnythingOfType("*pkg.RevokedToken")).Return(nil)
+		mockRepo.On("CreateRefreshToken", mock.AnythingOfType("*pkg.RefreshToken")).Return(nil)
+		return challenge.Token
+	}
+
+	// Test case: a code of the authenticator completes the login, its step cannot be used again
+	t.Run("successful TOTP code", func(t *testing.T) {
+		totp, secret := newTestTOTP(t, service, 5, true)
+		mfaToken := pendingLogin(totp)
+		mockRepo.On("UseTOTPStep", 5, mock.AnythingOfType("int64")).Return(nil)
+
+		tokens, recoveryCodes, err := service.VerifyMFA(mfaToken, currentTOTPCode(t, secret), "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "mockTokenString", tokens.AccessToken)
+		assert.Nil(t, recoveryCodes)
+		assert.Equal(t, []string{"pwd", "otp", "mfa"}, session.AuthMethods)
+		stored := mockRepo.Calls[len(mockRepo.Calls)-1].Arguments.Get(0).(*pkg.RefreshToken)
+		assert.Equal(t, "pwd otp mfa", stored.AuthMethods)
+
+		// The MFA token completes a single login
+		revoked := findCall(mockRepo, "RevokeToken").Arguments.Get(0).(*pkg.RevokedToken)
+		assert.Equal(t, findCall(mockRepo, "IsTokenRevoked").Arguments.String(0), revoked.TokenID)
+		assert.WithinDuration(t, time.Now().Add(staff.MFATokenTTL), revoked.ExpiresAt, time.Second)
+	})
+
+	// Test case: a recovery code is accepted in any case, with or without its dash
+	t.Run("successful recovery code", func(t *testing.T) {
+		totp, _ := newTestTOTP(t, service, 5, true)
+		mfaToken := pendingLogin(totp)
+		mockRepo.On("UseRecoveryCode", 5, pkg.HashOpaqueToken("abcde23456")).Return(nil)
+
+		tokens, _, err := service.VerifyMFA(mfaToken, "ABCDE-23456", "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.NotNil(t, tokens)
+	})
+
+	// Test case: the first code of a pending enrolment confirms it and returns the recovery codes
+	t.Run("successful enrolment", func(t *testing.T) {
+		totp, secret := newTestTOTP(t, service, 5, false)
+		mfaToken := pendingLogin(totp, pkg.RoleNurse)
+		mockRepo.On("ConfirmTOTPEnrollment", 5, mock.AnythingOfType("int64"), mock.AnythingOfType("[]string")).Return(nil)
+
+		tokens, recoveryCodes, err := service.VerifyMFA(mfaToken, currentTOTPCode(t, secret), "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.NotNil(t, tokens)
+		assert.Len(t, recoveryCodes, staff.RecoveryCodeCount)
+		hashes := findCall(mockRepo, "ConfirmTOTPEnrollment").Arguments.Get(2).([]string)
+		assert.Regexp(t, `^[a-z2-9]{5}-[a-z2-9]{5}$`, recoveryCodes[0])
+		assert.Equal(t, pkg.HashOpaqueToken(strings.ReplaceAll(recoveryCodes[0], "-", "")), hashes[0])
+	})
+
+	// Test case: Failed - wrong and replayed codes count as failed logins
+	t.Run("wrong codes", func(t *testing.T) {
+		totp, secret := newTestTOTP(t, service, 5, true)
+		mfaToken := pendingLogin(totp)
+		mockRepo.On("RecordLoginFailure", mock.AnythingOfType("string"), staff.LoginFailureWindow).Return(nil)
+		mockRepo.On("UseTOTPStep", 5, mock.AnythingOfType("int64")).Return(gorm.ErrRecordNotFound)
+		mockRepo.On("UseRecoveryCode", 5, mock.AnythingOfType("string")).Return(gorm.ErrRecordNotFound)
+
+		for _, code := range []string{"000000x", currentTOTPCode(t, secret), "abcde-23456"} {
+			tokens, _, err := service.VerifyMFA(mfaToken, code, "192.0.2.1")
+
+			assert.ErrorIs(t, err, staff.ErrInvalidMFACode, code)
+			assert.Nil(t, tokens)
+		}
+		mockRepo.AssertNumberOfCalls(t, "RecordLoginFailure", 6)
+		mockRepo.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+	})
+
+	// Test case: Failed - throttled usernames get no more guesses
+	t.Run("throttled", func(t *testing.T) {
+		totp, secret := newTestTOTP(t, service, 5, true)
+		mfaToken := pendingLogin(totp)
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockRepo.On("IsTokenRevoked", mock.AnythingOfType("string"), 5, "", mock.AnythingOfType("time.Time")).Return(false, nil)
+		mockRepo.On("GetStaffByID", 5).Return(member, nil)
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{
+			{Key: "username:test_user", Failures: 9, LastFailureAt: time.Now()},
+		}, nil)
+
+		_, _, err := service.VerifyMFA(mfaToken, currentTOTPCode(t, secret), "192.0.2.1")
+
+		var throttled *staff.LoginThrottledError
+		assert.ErrorAs(t, err, &throttled)
+		mockRepo.AssertNotCalled(t, "GetStaffTOTP", mock.Anything)
+	})
+
+	// Test case: Failed - used or revoked MFA tokens, login tokens and garbage are invalid
+	t.Run("invalid MFA tokens", func(t *testing.T) {
+		totp, secret := newTestTOTP(t, service, 5, true)
+		mfaToken := pendingLogin(totp)
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("IsTokenRevoked", mock.AnythingOfType("string"), 5, "", mock.AnythingOfType("time.Time")).Return(true, nil)
+
+		_, _, err := service.VerifyMFA(mfaToken, currentTOTPCode(t, secret), "192.0.2.1")
+		assert.ErrorIs(t, err, staff.ErrInvalidMFAToken)
+
+		loginToken, err := service.Keys.Sign(jwt.MapClaims{"jti": "login", "staff_id": 5, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()})
+		assert.NoError(t, err)
+		for _, token := range []string{loginToken, "garbage", mfaToken + "x"} {
+			_, _, err = service.VerifyMFA(token, "123456", "192.0.2.1")
+			assert.ErrorIs(t, err, staff.ErrInvalidMFAToken)
+		}
+	})
+}
+
+// Last call of the method to the mock
+func findCall(m *mockStaffRepo, method string) mock.Call {
+	for i := len(m.Calls) - 1; i >= 0; i-- {
+		if m.Calls[i].Method == method {
+			return m.Calls[i]
+		}
+	}
+	panic("no call to " + method)
+}
+
+func TestStaffService_TOTPEnrollment(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	mockHasher := new(MockPasswordHasher)
+	var session staff.LoginSession
+	service := newMFAService(t, mockRepo, mockHasher, &session)
+	member := &pkg.Staff{ID: 5, Username: "test_user", Password: "hash", Role: pkg.RoleNurse, HospitalID: 1}
+
+	reset := func() {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockHasher.ExpectedCalls = nil
+		mockHasher.Calls = nil
+	}
+
+	// Test case: the secret is shown once and stored sealed
+	t.Run("successful enrolment start", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetStaffByID", 5).Return(member, nil)
+		mockRepo.On("GetLoginFailures", []string{"username:test_user"}).Return(nil, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("secure_password")).Return(nil)
+		mockRepo.On("StartTOTPEnrollment", mock.AnythingOfType("*pkg.StaffTOTP")).Return(nil)
+
+		enrollment, err := service.StartTOTPEnrollment(5, "secure_password")
+
+		assert.NoError(t, err)
+		assert.Len(t, enrollment.Secret, 32)
+		assert.Contains(t, enrollment.URI, "otpauth://totp/Hospital%20API:test_user?")
+		assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+		stored := findCall(mockRepo, "StartTOTPEnrollment").Arguments.Get(0).(*pkg.StaffTOTP)
+		assert.Equal(t, 5, stored.StaffID)
+		assert.NotContains(t, stored.Secret, enrollment.Secret)
+		secret, err := service.MFA.Secrets.Open(stored.Secret)
+		assert.NoError(t, err)
+		assert.Equal(t, enrollment.Secret, secret)
+	})
+
+	// Test case: Failed - a wrong password counts as a failed login, enrolled staff keep their authenticator
+	t.Run("failed enrolment start", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetStaffByID", 5).Return(member, nil)
+		mockRepo.On("GetLoginFailures", []string{"username:test_user"}).Return(nil, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("wrong_password")).Return(errors.New("mismatch"))
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("secure_password")).Return(nil)
+		mockRepo.On("RecordLoginFailure", "username:test_user", staff.LoginFailureWindow).Return(nil)
+		mockRepo.On("StartTOTPEnrollment", mock.AnythingOfType("*pkg.StaffTOTP")).Return(staff.ErrMFAAlreadyEnrolled)
+
+		_, err := service.StartTOTPEnrollment(5, "wrong_password")
+		assert.ErrorIs(t, err, staff.ErrWrongPassword)
+		mockRepo.AssertNumberOfCalls(t, "StartTOTPEnrollment", 0)
+
+		_, err = service.StartTOTPEnrollment(5, "secure_password")
+		assert.ErrorIs(t, err, staff.ErrMFAAlreadyEnrolled)
+
+		disabled := newThrottledService(mockRepo, mockHasher)
+		_, err = disabled.StartTOTPEnrollment(5, "secure_password")
+		assert.ErrorIs(t, err, staff.ErrMFADisabled)
+	})
+
+	// Test case: the first code confirms the enrolment and returns the recovery codes
+	t.Run("successful confirmation", func(t *testing.T) {
+		reset()
+		totp, secret := newTestTOTP(t, service, 5, false)
+		mockRepo.On("GetStaffTOTP", 5).Return(totp, nil)
+		mockRepo.On("ConfirmTOTPEnrollment", 5, pkg.TOTPStep(time.Now()), mock.AnythingOfType("[]string")).Return(nil)
+
+		recoveryCodes, err := service.ConfirmTOTPEnrollment(5, currentTOTPCode(t, secret))
+
+		assert.NoError(t, err)
+		assert.Len(t, recoveryCodes, staff.RecoveryCodeCount)
+	})
+
+	// Test case: Failed - wrong code, no pending enrolment, or an authenticator already confirmed
+	t.Run("failed confirmation", func(t *testing.T) {
+		reset()
+		pending, _ := newTestTOTP(t, service, 5, false)
+		confirmed, secret := newTestTOTP(t, service, 6, true)
+		mockRepo.On("GetStaffTOTP", 5).Return(pending, nil)
+		mockRepo.On("GetStaffTOTP", 6).Return(confirmed, nil)
+		mockRepo.On("GetStaffTOTP", 7).Return(nil, gorm.ErrRecordNotFound)
+
+		_, err := service.ConfirmTOTPEnrollment(5, "123456x")
+		assert.ErrorIs(t, err, staff.ErrInvalidMFACode)
+		_, err = service.ConfirmTOTPEnrollment(6, currentTOTPCode(t, secret))
+		assert.ErrorIs(t, err, staff.ErrMFAAlreadyEnrolled)
+		_, err = service.ConfirmTOTPEnrollment(7, "123456")
+		assert.ErrorIs(t, err, staff.ErrMFANotEnrolled)
+		mockRepo.AssertNotCalled(t, "ConfirmTOTPEnrollment", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	// Test case: admins reset the authenticator of staff of their hospital only, revoking its sessions
+	t.Run("reset MFA", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetStaffByID", 5).Return(member, nil)
+		mockRepo.On("DeleteStaffTOTP", 5, mock.AnythingOfType("*pkg.StaffSessionRevocation")).Return(nil)
+		// Login tokens carry their issue time in seconds
+		issuedAt := time.Now().Truncate(time.Second)
+
+		assert.NoError(t, service.ResetStaffMFA(1, 5))
+		assert.ErrorIs(t, service.ResetStaffMFA(2, 5), staff.ErrStaffNotFound)
+		mockRepo.AssertNumberOfCalls(t, "DeleteStaffTOTP", 1)
+
+		// A token issued before the reset is revoked, even within the same second, and no family is spared
+		revocation := findCall(mockRepo, "DeleteStaffTOTP").Arguments.Get(1).(*pkg.StaffSessionRevocation)
+		assert.Equal(t, 5, revocation.StaffID)
+		assert.Empty(t, revocation.ExceptFamilyID)
+		assert.True(t, revocation.RevokedBefore.After(issuedAt))
+	})
+}
+
+// Tests that refreshed login tokens keep the authentication methods of the login
+func TestStaffService_RefreshTokenMFA(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	var session staff.LoginSession
+	service := newMFAService(t, mockRepo, new(MockPasswordHasher), &session)
+
+	mockRepo.On("RotateRefreshToken", pkg.HashOpaqueToken("refresh-token"), mock.AnythingOfType("*pkg.RefreshToken")).
+		Run(func(args mock.Arguments) {
+			next := args.Get(1).(*pkg.RefreshToken)
+			next.StaffID = 5
+			next.FamilyID = "family-id"
+			next.AuthMethods = "pwd otp mfa"
+		}).Return(nil)
+	mockRepo.On("GetStaffByID", 5).Return(&pkg.Staff{ID: 5, Role: pkg.RoleAdmin, HospitalID: 1}, nil)
+
+	_, err := service.RefreshToken("refresh-token")
+
+	assert.NoError(t, err)
+	assert.Equal(t, staff.LoginSession{FamilyID: "family-id", AuthMethods: []string{"pwd", "otp", "mfa"}, MFARequired: true}, session)
+}
+
+func TestStaffHandler_MFA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := &staff.StaffHandler{
+		Service:         mockService,
+		GetHospitalIDFn: mockGetHospitalID,
+		GetSessionFn: func(c *gin.Context) (*middleware.Session, error) {
+			return &middleware.Session{StaffID: 5, FamilyID: "family-id"}, nil
+		},
+	}
+
+	r := gin.Default()
+	r.POST("/staff/login", handler.SignInStaff)
+	r.POST("/staff/login/mfa", handler.VerifyMFA)
+	r.POST("/staff/login/mfa/enroll", handler.StartLoginTOTPEnrollment)
+	r.POST("/staff/mfa/totp", handler.StartTOTPEnrollment)
+	r.POST("/staff/mfa/totp/confirm", handler.ConfirmTOTPEnrollment)
+	r.POST("/staff/:id/mfa/reset", handler.ResetStaffMFA)
+
+	post := func(path string, body map[string]interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+		jsonValue, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+	tokens := &staff.TokenPair{
+		AccessToken:           "access-token",
+		AccessTokenExpiresAt:  time.Now().Add(staff.AccessTokenTTL),
+		RefreshToken:          "refresh-token",
+		RefreshTokenExpiresAt: time.Now().Add(staff.RefreshTokenTTL),
+	}
+
+	// Test case: the password step of an MFA login gets 202 and no cookie
+	t.Run("login waiting for its code", func(t *testing.T) {
+		challenge := &staff.MFAChallenge{Token: "mfa-token", ExpiresAt: time.Now().Add(staff.MFATokenTTL), EnrollmentRequired: true}
+		mockService.On("SignInStaff", &pkg.Staff{Username: "test_user", Password: "secure_password"}, "192.0.2.1").Return(nil, challenge, nil).Once()
+
+		w, response := post("/staff/login", map[string]interface{}{"username": "test_user", "password": "secure_password"})
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "mfa-token", response["mfa_token"])
+		assert.Equal(t, true, response["mfa_required"])
+		assert.Equal(t, true, response["enrollment_required"])
+		assert.InDelta(t, staff.MFATokenTTL.Seconds(), response["expires_in"], 2)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	// Test case: the code sets the login cookies, the recovery codes of an enrolment are returned once
+	t.Run("successful MFA login", func(t *testing.T) {
+		mockService.On("VerifyMFA", "mfa-token", "123456", "192.0.2.1").Return(tokens, []string{"abcde-23456"}, nil).Once()
+
+		w, response := post("/staff/login/mfa", map[string]interface{}{"mfa_token": "mfa-token", "code": "123456"})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []interface{}{"abcde-23456"}, response["recovery_codes"])
+		assert.Len(t, w.Result().Cookies(), 2)
+
+		mockService.On("VerifyMFA", "mfa-token", "654321", "192.0.2.1").Return(tokens, nil, nil).Once()
+
+		w, response = post("/staff/login/mfa", map[string]interface{}{"mfa_token": "mfa-token", "code": "654321", "return_tokens": true})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "access-token", response["access_token"])
+		assert.NotContains(t, response, "recovery_codes")
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	// Test case: Failed - invalid token or code, throttled, not enrolled
+	t.Run("failed MFA logins", func(t *testing.T) {
+		expectedCodes := map[error]int{
+			staff.ErrInvalidMFAToken: http.StatusUnauthorized,
+			staff.ErrInvalidMFACode:  http.StatusUnauthorized,
+			&staff.LoginThrottledError{RetryAfter: 1500 * time.Millisecond}: http.StatusTooManyRequests,
+			staff.ErrMFANotEnrolled:      http.StatusConflict,
+			staff.ErrMFADisabled:         http.StatusNotFound,
+			errors.New("database error"): http.StatusInternalServerError,
+		}
+		for err, expectedCode := range expectedCodes {
+			mockService.On("VerifyMFA", "mfa-token", "000000", "192.0.2.1").Return(nil, nil, err).Once()
+
+			w, _ := post("/staff/login/mfa", map[string]interface{}{"mfa_token": "mfa-token", "code": "000000"})
+
+			assert.Equal(t, expectedCode, w.Code, err.Error())
+			assert.NotContains(t, w.Body.String(), "database error")
+			if expectedCode == http.StatusTooManyRequests {
+				assert.Equal(t, "2", w.Header().Get("Retry-After"))
+			}
+		}
+
+		w, response := post("/staff/login/mfa", map[string]interface{}{"mfa_token": "mfa-token"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+	})
+
+	// Test case: the secret of a login enrolment is returned with its provisioning URI
+	t.Run("login enrolment", func(t *testing.T) {
+		enrollment := &staff.TOTPEnrollment{Secret: "SECRET", URI: "otpauth://totp/Hospital%20API:test_user?secret=SECRET"}
+		mockService.On("StartLoginTOTPEnrollment", "mfa-token").Return(enrollment, nil).Once()
+
+		w, response := post("/staff/login/mfa/enroll", map[string]interface{}{"mfa_token": "mfa-token"})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "SECRET", response["secret"])
+		assert.Equal(t, enrollment.URI, response["otpauth_uri"])
+
+		mockService.On("StartLoginTOTPEnrollment", "used-token").Return(nil, staff.ErrInvalidMFAToken).Once()
+		w, _ = post("/staff/login/mfa/enroll", map[string]interface{}{"mfa_token": "used-token"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		mockService.On("StartLoginTOTPEnrollment", "enrolled-token").Return(nil, staff.ErrMFAAlreadyEnrolled).Once()
+		w, response = post("/staff/login/mfa/enroll", map[string]interface{}{"mfa_token": "enrolled-token"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, staff.CodeMFAAlreadyEnrolled, response["code"])
+
+		w, response = post("/staff/login/mfa/enroll", map[string]interface{}{})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+	})
+
+	// Test case: logged in staff enrol with their password and confirm with a first code
+	t.Run("logged in enrolment", func(t *testing.T) {
+		mockService.On("StartTOTPEnrollment", 5, "secure_password").Return(&staff.TOTPEnrollment{Secret: "SECRET", URI: "otpauth://totp"}, nil).Once()
+		w, _ := post("/staff/mfa/totp", map[string]interface{}{"password": "secure_password"})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w, response := post("/staff/mfa/totp", map[string]interface{}{})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+
+		mockService.On("StartTOTPEnrollment", 5, "wrong_password").Return(nil, staff.ErrWrongPassword).Once()
+		w, _ = post("/staff/mfa/totp", map[string]interface{}{"password": "wrong_password"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		mockService.On("ConfirmTOTPEnrollment", 5, "123456").Return([]string{"abcde-23456"}, nil).Once()
+		w, response = post("/staff/mfa/totp/confirm", map[string]interface{}{"code": "123456"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []interface{}{"abcde-23456"}, response["recovery_codes"])
+
+		mockService.On("ConfirmTOTPEnrollment", 5, "000000").Return(nil, staff.ErrInvalidMFACode).Once()
+		w, response = post("/staff/mfa/totp/confirm", map[string]interface{}{"code": "000000"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+
+		w, response = post("/staff/mfa/totp/confirm", map[string]interface{}{})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+
+		mockService.On("ConfirmTOTPEnrollment", 5, "111111").Return(nil, staff.ErrMFANotEnrolled).Once()
+		w, response = post("/staff/mfa/totp/confirm", map[string]interface{}{"code": "111111"})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, staff.CodeMFANotEnrolled, response["code"])
+	})
+
+	// Test case: admins reset the MFA of staff of their hospital
+	t.Run("reset MFA", func(t *testing.T) {
+		mockService.On("ResetStaffMFA", 1, 5).Return(nil).Once()
+		w, _ := post("/staff/5/mfa/reset", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		mockService.On("ResetStaffMFA", 1, 6).Return(staff.ErrStaffNotFound).Once()
+		w, _ = post("/staff/6/mfa/reset", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w, response := post("/staff/abc/mfa/reset", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeInvalidRequest, response["code"])
+	})
+}
+
+func TestGormStaffRepository_TOTP(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+	createdAt := time.Now()
+
+	// Success case - a pending enrolment replaces the secret of the former one
+	t.Run("successful enrolment start", func(t *testing.T) {
+		mock.ExpectExec(`INSERT INTO staff_totps \(staff_id, secret, created_at, last_used_step\) VALUES \(\$1, \$2, \$3, 0\)\s+ON CONFLICT \(staff_id\) DO UPDATE SET[\s\S]+WHERE staff_totps.confirmed_at IS NULL`).
+			WithArgs(5, "sealed-secret", createdAt).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := repo.StartTOTPEnrollment(&pkg.StaffTOTP{StaffID: 5, Secret: "sealed-secret", CreatedAt: createdAt})
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - a confirmed authenticator is kept
+	t.Run("already enrolled", func(t *testing.T) {
+		mock.ExpectExec(`INSERT INTO staff_totps`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		err := repo.StartTOTPEnrollment(&pkg.StaffTOTP{StaffID: 5, Secret: "sealed-secret", CreatedAt: createdAt})
+
+		assert.ErrorIs(t, err, staff.ErrMFAAlreadyEnrolled)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - the enrolment is confirmed with the step of its code, the recovery codes are replaced
+	t.Run("successful confirmation", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "staff_totps" SET "confirmed_at"=\$1,"last_used_step"=\$2 WHERE staff_id = \$3 AND confirmed_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), int64(100), 5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM "staff_recovery_codes" WHERE staff_id = \$1`).
+			WithArgs(5).
+			WillReturnResult(sqlmock.NewResult(0, 10))
+		mock.ExpectQuery(`INSERT INTO "staff_recovery_codes" \("staff_id","code_hash"\) VALUES \(\$1,\$2\),\(\$3,\$4\)`).
+			WithArgs(5, "hash-1", 5, "hash-2").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+		mock.ExpectCommit()
+
+		err := repo.ConfirmTOTPEnrollment(5, 100, []string{"hash-1", "hash-2"})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - no enrolment is pending anymore
+	t.Run("confirmation without pending enrolment", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "staff_totps"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.ConfirmTOTPEnrollment(5, 100, []string{"hash-1"})
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success and failure cases - a step is used once, and only after the last one used
+	t.Run("use TOTP step", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "staff_totps" SET "last_used_step"=\$1 WHERE staff_id = \$2 AND confirmed_at IS NOT NULL AND last_used_step < \$3`).
+			WithArgs(int64(101), 5, int64(101)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "staff_totps"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		assert.NoError(t, repo.UseTOTPStep(5, 101))
+		assert.ErrorIs(t, repo.UseTOTPStep(5, 101), gorm.ErrRecordNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success and failure cases - a recovery code is deleted when used
+	t.Run("use recovery code", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "staff_recovery_codes" WHERE staff_id = \$1 AND code_hash = \$2`).
+			WithArgs(5, "hash-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "staff_recovery_codes"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		assert.NoError(t, repo.UseRecoveryCode(5, "hash-1"))
+		assert.ErrorIs(t, repo.UseRecoveryCode(5, "hash-1"), gorm.ErrRecordNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - the authenticator is removed with its recovery codes
+	t.Run("delete TOTP", func(t *testing.T) {
+		revokedBefore := time.Now()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "staff_recovery_codes" WHERE staff_id = \$1`).
+			WithArgs(5).
+			WillReturnResult(sqlmock.NewResult(0, 10))
+		mock.ExpectExec(`DELETE FROM "staff_totps" WHERE staff_id = \$1`).
+			WithArgs(5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		// The sessions of the staff member are revoked in the same transaction
+		mock.ExpectExec(`INSERT INTO "staff_session_revocations" \("staff_id","revoked_before","except_family_id"\) VALUES \(\$1,\$2,\$3\) ON CONFLICT`).
+			WithArgs(5, revokedBefore, "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE staff_id = \$2 AND revoked_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), 5).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		assert.NoError(t, repo.DeleteStaffTOTP(5, &pkg.StaffSessionRevocation{StaffID: 5, RevokedBefore: revokedBefore}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,374 @@
+package staff_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Peeranut-Kit/health_api_assignment/internal/fakesmtp"
+	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Mailer keeping the emails it sends
+type recordingMailer struct {
+	sent []sentEmail
+	err  error
+}
+
+type sentEmail struct {
+	to, subject, body string
+}
+
+func (m *recordingMailer) Send(to string, subject string, body string) error {
+	m.sent = append(m.sent, sentEmail{to, subject, body})
+	return m.err
+}
+
+// Token of a password reset email
+var resetTokenPattern = regexp.MustCompile(`(?m)^([A-Za-z0-9_-]{20,})$`)
+
+func TestStaffService_RequestPasswordReset(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	mailer := &recordingMailer{}
+	service := &staff.StaffService{Repo: mockRepo, Mailer: mailer}
+
+	reset := func() {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mailer.sent = nil
+	}
+
+	// Test case: the token is emailed, only its hash is stored
+	t.Run("successful reset request", func(t *testing.T) {
+		reset()
+		service.PasswordResetURL = "https://hospital.example/reset-password"
+		defer func() { service.PasswordResetURL = "" }()
+		mockRepo.On("GetStaffFromEmail", "nurse@hospital.example").Return(&pkg.Staff{ID: 5, Username: "nurse", Email: "nurse@hospital.example", Password: "hash"}, nil)
+		mockRepo.On("CreatePasswordReset", mock.AnythingOfType("*pkg.PasswordReset"), staff.PasswordResetCooldown).Return(nil)
+
+		err := service.RequestPasswordReset("  Nurse@Hospital.example ")
+
+		assert.NoError(t, err)
+		assert.Len(t, mailer.sent, 1)
+		assert.Equal(t, "nurse@hospital.example", mailer.sent[0].to)
+		token := resetTokenPattern.FindStringSubmatch(mailer.sent[0].body)[1]
+		assert.Contains(t, mailer.sent[0].body, "https://hospital.example/reset-password?token="+token)
+
+		stored := mockRepo.Calls[1].Arguments.Get(0).(*pkg.PasswordReset)
+		assert.Equal(t, 5, stored.StaffID)
+		assert.Equal(t, pkg.HashOpaqueToken(token), stored.TokenHash)
+		assert.WithinDuration(t, time.Now().Add(staff.PasswordResetTTL), stored.ExpiresAt, time.Minute)
+	})
+
+	// Test case: unknown emails, invited staff and repeated requests get no email and no error
+	t.Run("no email sent", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetStaffFromEmail", "unknown@hospital.example").Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("GetStaffFromEmail", "invited@hospital.example").Return(&pkg.Staff{ID: 6, Email: "invited@hospital.example"}, nil)
+		mockRepo.On("GetStaffFromEmail", "nurse@hospital.example").Return(&pkg.Staff{ID: 5, Email: "nurse@hospital.example", Password: "hash"}, nil)
+		mockRepo.On("CreatePasswordReset", mock.AnythingOfType("*pkg.PasswordReset"), staff.PasswordResetCooldown).Return(staff.ErrPasswordResetTooSoon)
+
+		for _, email := range []string{"unknown@hospital.example", "invited@hospital.example", "nurse@hospital.example"} {
+			assert.NoError(t, service.RequestPasswordReset(email), email)
+		}
+		assert.Empty(t, mailer.sent)
+		mockRepo.AssertNumberOfCalls(t, "CreatePasswordReset", 1)
+	})
+
+	// Test case: Failed - no mailer configured, or the database fails
+	t.Run("failed reset request", func(t *testing.T) {
+		reset()
+		disabled := &staff.StaffService{Repo: mockRepo}
+		assert.ErrorIs(t, disabled.RequestPasswordReset("nurse@hospital.example"), staff.ErrPasswordResetDisabled)
+
+		mockRepo.On("GetStaffFromEmail", "nurse@hospital.example").Return(nil, errors.New("database error"))
+		assert.Error(t, service.RequestPasswordReset("nurse@hospital.example"))
+		assert.Empty(t, mailer.sent)
+	})
+}
+
+func TestStaffService_ResetPassword(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	mockHasher := new(MockPasswordHasher)
+	service := newThrottledService(mockRepo, mockHasher)
+	service.PasswordPolicy = &staff.PasswordPolicy{MinLength: 12, MinCharacterClasses: 3, HistorySize: 3}
+
+	tokenHash := pkg.HashOpaqueToken("reset_token")
+	pending := &pkg.PasswordReset{
+		ID:        2,
+		StaffID:   5,
+		Staff:     pkg.Staff{ID: 5, Username: "nurse", Password: "current_hash"},
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+
+	reset := func() {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockHasher.ExpectedCalls = nil
+		mockHasher.Calls = nil
+	}
+
+	// Test case: the password is replaced, every session revoked and the lockout ended
+	t.Run("successful password reset", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetPasswordResetByTokenHash", tokenHash).Return(pending, nil)
+		mockRepo.On("GetPasswordHistory", 5, 2).Return([]pkg.PasswordHistory{}, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("Brand-New-Password-2")).Return(errors.New("mismatch"))
+		mockRepo.On("ResetPassword", pending, mock.AnythingOfType("string"), 2, mock.AnythingOfType("*pkg.StaffSessionRevocation")).Return(nil)
+		mockRepo.On("ClearLoginFailures", "username:nurse").Return(nil)
+
+		err := service.ResetPassword("reset_token", "Brand-New-Password-2")
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+
+		call := mockRepo.Calls[2]
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(call.Arguments.String(1)), []byte("Brand-New-Password-2")))
+		revocation := call.Arguments.Get(3).(*pkg.StaffSessionRevocation)
+		assert.Equal(t, 5, revocation.StaffID)
+		assert.Empty(t, revocation.ExceptFamilyID)
+	})
+
+	// Test case: Failed - unknown, expired or concurrently used token
+	t.Run("invalid reset token", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetPasswordResetByTokenHash", pkg.HashOpaqueToken("unknown_token")).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("GetPasswordResetByTokenHash", pkg.HashOpaqueToken("expired_token")).Return(&pkg.PasswordReset{
+			ID: 3, Staff: pkg.Staff{Password: "current_hash"}, ExpiresAt: time.Now().Add(-time.Second),
+		}, nil)
+
+		assert.ErrorIs(t, service.ResetPassword("unknown_token", "Brand-New-Password-2"), staff.ErrInvalidResetToken)
+		assert.ErrorIs(t, service.ResetPassword("expired_token", "Brand-New-Password-2"), staff.ErrInvalidResetToken)
+
+		mockRepo.On("GetPasswordResetByTokenHash", tokenHash).Return(pending, nil)
+		mockRepo.On("GetPasswordHistory", 5, 2).Return([]pkg.PasswordHistory{}, nil)
+		mockHasher.On("CompareHashAndPassword", mock.Anything, mock.Anything).Return(errors.New("mismatch"))
+		mockRepo.On("ResetPassword", pending, mock.AnythingOfType("string"), 2, mock.AnythingOfType("*pkg.StaffSessionRevocation")).Return(gorm.ErrRecordNotFound)
+
+		assert.ErrorIs(t, service.ResetPassword("reset_token", "Brand-New-Password-2"), staff.ErrInvalidResetToken)
+		mockRepo.AssertNotCalled(t, "ClearLoginFailures", mock.Anything)
+	})
+
+	// Test case: Failed - the new password breaks the policy, the token stays valid
+	t.Run("weak new password", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetPasswordResetByTokenHash", tokenHash).Return(pending, nil)
+
+		err := service.ResetPassword("reset_token", "nurse-Password-1")
+
+		assert.ErrorIs(t, err, staff.ErrWeakPassword)
+		mockRepo.AssertNotCalled(t, "ResetPassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+// Tests the reset email through SMTP, received by the fake SMTP server
+func TestStaffService_PasswordResetEmail(t *testing.T) {
+	server, err := fakesmtp.NewServer()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	mailer, err := pkg.NewSMTPMailer(server.Addr, "Hospital API <no-reply@hospital.example>", "", "")
+	assert.NoError(t, err)
+
+	mockRepo := new(mockStaffRepo)
+	mockRepo.On("GetStaffFromEmail", "nurse@hospital.example").Return(&pkg.Staff{ID: 5, Username: "nurse", Email: "nurse@hospital.example", Password: "hash"}, nil)
+	mockRepo.On("CreatePasswordReset", mock.AnythingOfType("*pkg.PasswordReset"), staff.PasswordResetCooldown).Return(nil)
+	service := &staff.StaffService{Repo: mockRepo, Mailer: &pkg.AsyncMailer{Next: mailer}}
+
+	assert.NoError(t, service.RequestPasswordReset("nurse@hospital.example"))
+
+	select {
+	case <-server.Received():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+	}
+	messages := server.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "no-reply@hospital.example", messages[0].From)
+	assert.Equal(t, []string{"nurse@hospital.example"}, messages[0].To)
+	assert.Equal(t, "Reset your password", messages[0].Subject)
+
+	token := resetTokenPattern.FindStringSubmatch(messages[0].Body)[1]
+	stored := mockRepo.Calls[1].Arguments.Get(0).(*pkg.PasswordReset)
+	assert.Equal(t, pkg.HashOpaqueToken(token), stored.TokenHash)
+
+	// Test case: Failed - recipients and subjects cannot inject headers
+	assert.Error(t, mailer.Send("nurse@hospital.example\r\nBcc: other@hospital.example", "Subject", "body"))
+	assert.Error(t, mailer.Send("nurse@hospital.example", "Subject\r\nBcc: other@hospital.example", "body"))
+}
+
+func TestStaffHandler_PasswordReset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := &staff.StaffHandler{Service: mockService}
+
+	r := gin.Default()
+	r.POST("/staff/password/reset-request", handler.RequestPasswordReset)
+	r.POST("/staff/password/reset", handler.ResetPassword)
+
+	post := func(path string, body map[string]string) *httptest.ResponseRecorder {
+		jsonValue, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Test case: known and unknown emails get the same response
+	t.Run("successful reset request", func(t *testing.T) {
+		mockService.On("RequestPasswordReset", "nurse@hospital.example").Return(nil).Once()
+
+		w := post("/staff/password/reset-request", map[string]string{"email": "nurse@hospital.example"})
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("failed reset requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post("/staff/password/reset-request", map[string]string{"email": "nurse"}).Code)
+
+		mockService.On("RequestPasswordReset", "nurse@hospital.example").Return(staff.ErrPasswordResetDisabled).Once()
+		assert.Equal(t, http.StatusNotFound, post("/staff/password/reset-request", map[string]string{"email": "nurse@hospital.example"}).Code)
+
+		mockService.On("RequestPasswordReset", "nurse@hospital.example").Return(errors.New("database error")).Once()
+		w := post("/staff/password/reset-request", map[string]string{"email": "nurse@hospital.example"})
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotContains(t, w.Body.String(), "database error")
+	})
+
+	t.Run("successful password reset", func(t *testing.T) {
+		mockService.On("ResetPassword", "reset_token", "Brand-New-Password-2").Return(nil).Once()
+
+		w := post("/staff/password/reset", map[string]string{"token": "reset_token", "new_password": "Brand-New-Password-2"})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	// Test case: Failed - invalid token or weak password
+	t.Run("failed password resets", func(t *testing.T) {
+		mockService.On("ResetPassword", "used_token", "Brand-New-Password-2").Return(staff.ErrInvalidResetToken).Once()
+		w := post("/staff/password/reset", map[string]string{"token": "used_token", "new_password": "Brand-New-Password-2"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), staff.ErrInvalidResetToken.Error())
+
+		mockService.On("ResetPassword", "reset_token", "weak").Return(&staff.PasswordPolicyError{Violations: []string{"must be at least 12 characters long"}}).Once()
+		w = post("/staff/password/reset", map[string]string{"token": "reset_token", "new_password": "weak"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.True(t, strings.Contains(w.Body.String(), staff.CodeWeakPassword))
+
+		assert.Equal(t, http.StatusBadRequest, post("/staff/password/reset", map[string]string{"token": "reset_token"}).Code)
+	})
+}
+
+func TestGormStaffRepository_PasswordResets(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+	createdAt := time.Now()
+	pending := &pkg.PasswordReset{StaffID: 5, TokenHash: "token-hash", CreatedAt: createdAt, ExpiresAt: createdAt.Add(staff.PasswordResetTTL)}
+
+	// Success case - expired resets are cleaned up, a pending reset older than the cooldown is replaced
+	t.Run("successful reset creation", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "password_resets" WHERE expires_at < \$1`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO password_resets \(staff_id, token_hash, created_at, expires_at\) VALUES \(\$1, \$2, \$3, \$4\)\s+ON CONFLICT \(staff_id\) DO UPDATE SET[\s\S]+WHERE password_resets.created_at < \$5`).
+			WithArgs(5, "token-hash", createdAt, pending.ExpiresAt, createdAt.Add(-staff.PasswordResetCooldown)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.CreatePasswordReset(pending, staff.PasswordResetCooldown)
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the pending reset is more recent than the cooldown
+	t.Run("reset requested too soon", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "password_resets"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO password_resets`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.CreatePasswordReset(pending, staff.PasswordResetCooldown)
+
+		assert.ErrorIs(t, err, staff.ErrPasswordResetTooSoon)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - the reset is consumed with the password change, every login is revoked
+	t.Run("successful password reset", func(t *testing.T) {
+		used := &pkg.PasswordReset{ID: 2, StaffID: 5, Staff: pkg.Staff{ID: 5, Password: "current_hash"}}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "password_resets" WHERE "password_resets"."id" = \$1`).
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "staffs" SET "password"=\$1 WHERE id = \$2 AND password = \$3`).
+			WithArgs("new_hash", 5, "current_hash").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM password_histories`).
+			WithArgs(5, 5, 0).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO "staff_session_revocations"`).
+			WithArgs(5, sqlmock.AnyArg(), "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE staff_id = \$2 AND revoked_at IS NULL$`).
+			WithArgs(sqlmock.AnyArg(), 5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.ResetPassword(used, "new_hash", 0, &pkg.StaffSessionRevocation{StaffID: 5, RevokedBefore: time.Now()})
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the reset was used in the meantime
+	t.Run("reset already used", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "password_resets"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.ResetPassword(&pkg.PasswordReset{ID: 2}, "new_hash", 0, &pkg.StaffSessionRevocation{StaffID: 5})
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
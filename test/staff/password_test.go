@@ -0,0 +1,295 @@
+package staff_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
+	"github.com/Peeranut-Kit/health_api_assignment/middleware"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	policy := staff.DefaultPasswordPolicy
+	policy.Breached = map[string]struct{}{"password1234!": {}}
+
+	// Test case: passwords meeting every rule, Thai letters count as other characters
+	t.Run("accepted passwords", func(t *testing.T) {
+		for _, password := range []string{"Secure-Password-1", "correct horse battery staple 7", "รหัสผ่านยาวมากๆ1A"} {
+			assert.Empty(t, policy.Check("test_user", password), password)
+		}
+	})
+
+	// Test case: Failed - every broken rule is listed
+	t.Run("refused passwords", func(t *testing.T) {
+		passwords := map[string][]string{
+			"Short-1":                  {"must be at least 12 characters long"},
+			"alllowercase":             {"must contain at least 3 of lowercase letters, uppercase letters, digits and other characters"},
+			"Test_User-2024":           {"must not contain the username"},
+			"PASSWORD1234!":            {"is a known breached password"},
+			strings.Repeat("Aa1-", 19): {"must be at most 72 bytes long"},
+			"short": {
+				"must be at least 12 characters long",
+				"must contain at least 3 of lowercase letters, uppercase letters, digits and other characters",
+			},
+		}
+		for password, violations := range passwords {
+			assert.Equal(t, violations, policy.Check("test_user", password), password)
+		}
+	})
+
+	// Test case: the zero policy only limits the length and refuses the username
+	t.Run("zero policy", func(t *testing.T) {
+		var zero staff.PasswordPolicy
+
+		assert.Empty(t, zero.Check("test_user", "a"))
+		assert.NotEmpty(t, zero.Check("test_user", "test_user"))
+		assert.NotEmpty(t, zero.Check("test_user", strings.Repeat("a", 73)))
+	})
+}
+
+func TestParsePasswordPolicy(t *testing.T) {
+	breachedList := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(breachedList, []byte("Password123!\n\n  qwerty123456  \n"), 0o600))
+
+	t.Run("successful parse", func(t *testing.T) {
+		policy, err := staff.ParsePasswordPolicy("16", "2", "0", breachedList)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 16, policy.MinLength)
+		assert.Equal(t, 2, policy.MinCharacterClasses)
+		assert.Zero(t, policy.HistorySize)
+		assert.Len(t, policy.Breached, 2)
+		assert.Contains(t, policy.Check("someone", "QWERTY123456"), "is a known breached password")
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		policy, err := staff.ParsePasswordPolicy("", "", "", "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, staff.DefaultPasswordPolicy, *policy)
+	})
+
+	// Test case: Failed - invalid settings or missing breached list
+	t.Run("invalid policies", func(t *testing.T) {
+		settings := map[string][4]string{
+			"length not a number":   {"twelve", "", "", ""},
+			"length over bcrypt":    {"73", "", "", ""},
+			"too many classes":      {"", "5", "", ""},
+			"negative history":      {"", "", "-1", ""},
+			"missing breached list": {"", "", "", filepath.Join(t.TempDir(), "missing.txt")},
+		}
+		for name, setting := range settings {
+			_, err := staff.ParsePasswordPolicy(setting[0], setting[1], setting[2], setting[3])
+
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestStaffService_ChangePassword(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	mockHasher := new(MockPasswordHasher)
+	service := newThrottledService(mockRepo, mockHasher)
+	service.PasswordPolicy = &staff.PasswordPolicy{MinLength: 12, MinCharacterClasses: 3, HistorySize: 3}
+
+	current := &pkg.Staff{ID: 5, Username: "test_user", Password: "current_hash", HospitalID: 1}
+	throttleKeys := []string{"username:test_user"}
+
+	reset := func() {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockHasher.ExpectedCalls = nil
+		mockHasher.Calls = nil
+		mockRepo.On("GetStaffByID", 5).Return(current, nil)
+		mockRepo.On("GetLoginFailures", throttleKeys).Return([]pkg.LoginFailure{}, nil)
+	}
+
+	// Test case: the password is replaced, the history keeps the current one and the other sessions are revoked
+	t.Run("successful password change", func(t *testing.T) {
+		reset()
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("Current-Password-1")).Return(nil)
+		mockRepo.On("GetPasswordHistory", 5, 2).Return([]pkg.PasswordHistory{{PasswordHash: "former_hash"}}, nil)
+		mockHasher.On("CompareHashAndPassword", mock.Anything, []byte("Brand-New-Password-2")).Return(errors.New("mismatch"))
+		mockRepo.On("ChangePassword", current, mock.AnythingOfType("string"), 2, mock.AnythingOfType("*pkg.StaffSessionRevocation")).Return(nil)
+
+		err := service.ChangePassword(5, "family-id", "Current-Password-1", "Brand-New-Password-2")
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+		mockHasher.AssertCalled(t, "CompareHashAndPassword", []byte("former_hash"), []byte("Brand-New-Password-2"))
+
+		call := mockRepo.Calls[len(mockRepo.Calls)-1]
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(call.Arguments.String(1)), []byte("Brand-New-Password-2")))
+		revocation := call.Arguments.Get(3).(*pkg.StaffSessionRevocation)
+		assert.Equal(t, 5, revocation.StaffID)
+		assert.Equal(t, "family-id", revocation.ExceptFamilyID)
+		assert.True(t, revocation.RevokedBefore.After(time.Now()))
+	})
+
+	// Test case: Failed - a wrong current password counts as a failed login of the username
+	t.Run("wrong current password", func(t *testing.T) {
+		reset()
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("guess")).Return(errors.New("mismatch"))
+		mockRepo.On("RecordLoginFailure", "username:test_user", staff.LoginFailureWindow).Return(nil)
+
+		err := service.ChangePassword(5, "family-id", "guess", "Brand-New-Password-2")
+
+		assert.ErrorIs(t, err, staff.ErrWrongPassword)
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNotCalled(t, "ChangePassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		reset()
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("GetStaffByID", 5).Return(current, nil)
+		mockRepo.On("GetLoginFailures", throttleKeys).Return([]pkg.LoginFailure{
+			{Key: "username:test_user", Failures: 10, LastFailureAt: time.Now()},
+		}, nil)
+
+		err := service.ChangePassword(5, "family-id", "Current-Password-1", "Brand-New-Password-2")
+
+		assert.ErrorIs(t, err, staff.ErrLoginThrottled)
+		mockHasher.AssertNotCalled(t, "CompareHashAndPassword", mock.Anything, mock.Anything)
+	})
+
+	// Test case: Failed - the new password breaks the policy or was used recently
+	t.Run("weak new password", func(t *testing.T) {
+		reset()
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("Current-Password-1")).Return(nil)
+
+		err := service.ChangePassword(5, "family-id", "Current-Password-1", "weak")
+
+		var weak *staff.PasswordPolicyError
+		assert.ErrorAs(t, err, &weak)
+		assert.Len(t, weak.Violations, 2)
+		mockRepo.AssertNotCalled(t, "GetPasswordHistory", mock.Anything, mock.Anything)
+	})
+
+	t.Run("reused password", func(t *testing.T) {
+		reset()
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("Current-Password-1")).Return(nil)
+		mockRepo.On("GetPasswordHistory", 5, 2).Return([]pkg.PasswordHistory{{PasswordHash: "former_hash"}}, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("Former-Password-0")).Return(errors.New("mismatch"))
+		mockHasher.On("CompareHashAndPassword", []byte("former_hash"), []byte("Former-Password-0")).Return(nil)
+
+		err := service.ChangePassword(5, "family-id", "Current-Password-1", "Former-Password-0")
+
+		var weak *staff.PasswordPolicyError
+		assert.ErrorAs(t, err, &weak)
+		assert.Equal(t, []string{"must not be one of the last 3 passwords"}, weak.Violations)
+		mockRepo.AssertNotCalled(t, "ChangePassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	// Test case: Failed - another change replaced the password since it was checked
+	t.Run("concurrent change", func(t *testing.T) {
+		reset()
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("Current-Password-1")).Return(nil)
+		mockRepo.On("GetPasswordHistory", 5, 2).Return([]pkg.PasswordHistory{}, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("current_hash"), []byte("Brand-New-Password-2")).Return(errors.New("mismatch"))
+		mockRepo.On("ChangePassword", current, mock.AnythingOfType("string"), 2, mock.AnythingOfType("*pkg.StaffSessionRevocation")).Return(gorm.ErrRecordNotFound)
+
+		err := service.ChangePassword(5, "family-id", "Current-Password-1", "Brand-New-Password-2")
+
+		assert.ErrorIs(t, err, staff.ErrWrongPassword)
+	})
+
+	t.Run("staff not found", func(t *testing.T) {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("GetStaffByID", 6).Return(nil, gorm.ErrRecordNotFound)
+
+		err := service.ChangePassword(6, "family-id", "Current-Password-1", "Brand-New-Password-2")
+
+		assert.ErrorIs(t, err, staff.ErrStaffNotFound)
+	})
+}
+
+func TestStaffHandler_ChangePassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockService := new(MockStaffService)
+	handler := &staff.StaffHandler{
+		Service: mockService,
+		GetSessionFn: func(c *gin.Context) (*middleware.Session, error) {
+			return &middleware.Session{TokenID: "token-id", FamilyID: "family-id", StaffID: 5}, nil
+		},
+	}
+
+	r := gin.Default()
+	r.PUT("/staff/password", handler.ChangePassword)
+
+	changePassword := func(body map[string]string) *httptest.ResponseRecorder {
+		jsonValue, _ := json.Marshal(body)
+		req := httptest.NewRequest("PUT", "/staff/password", bytes.NewBuffer(jsonValue))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	passwords := map[string]string{"current_password": "Current-Password-1", "new_password": "Brand-New-Password-2"}
+
+	// Test case: the session of the request is kept
+	t.Run("successful password change", func(t *testing.T) {
+		mockService.On("ChangePassword", 5, "family-id", "Current-Password-1", "Brand-New-Password-2").Return(nil).Once()
+
+		w := changePassword(passwords)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	// Test case: Failed - the violations of the policy are listed
+	t.Run("weak new password", func(t *testing.T) {
+		mockService.On("ChangePassword", 5, "family-id", "Current-Password-1", "Brand-New-Password-2").
+			Return(&staff.PasswordPolicyError{Violations: []string{"is a known breached password"}}).Once()
+
+		w := changePassword(passwords)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, staff.CodeWeakPassword, response["code"])
+		assert.Equal(t, []interface{}{"is a known breached password"}, response["violations"])
+	})
+
+	t.Run("failed password changes", func(t *testing.T) {
+		failures := map[error]int{
+			staff.ErrWrongPassword: http.StatusForbidden,
+			&staff.LoginThrottledError{RetryAfter: 1500 * time.Millisecond}: http.StatusTooManyRequests,
+			staff.ErrLoginBusy:           http.StatusServiceUnavailable,
+			errors.New("database error"): http.StatusInternalServerError,
+		}
+		for err, status := range failures {
+			mockService.On("ChangePassword", 5, "family-id", "Current-Password-1", "Brand-New-Password-2").Return(err).Once()
+
+			w := changePassword(passwords)
+
+			assert.Equal(t, status, w.Code, err.Error())
+			assert.NotContains(t, w.Body.String(), "database error")
+			if status == http.StatusTooManyRequests {
+				assert.Equal(t, "2", w.Header().Get("Retry-After"))
+			}
+		}
+	})
+
+	t.Run("missing current password", func(t *testing.T) {
+		w := changePassword(map[string]string{"new_password": "Brand-New-Password-2"})
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -3,16 +3,18 @@ package staff_test
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-func TestGormStaffRepository_CreateStaff(t *testing.T) {
+func TestGormStaffRepository_CreateInvitedStaff(t *testing.T) {
 	// Mock database
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -37,20 +39,25 @@ func TestGormStaffRepository_CreateStaff(t *testing.T) {
 		// Mock input
 		newStaff := pkg.Staff{
 			Username:   "test_user",
-			Password:   "secure_password",
+			Role:       pkg.RoleNurse,
 			HospitalID: 1,
 		}
+		invitation := pkg.StaffInvitation{TokenHash: "token_hash", ExpiresAt: time.Now().Add(time.Hour)}
 
-		// Setup expectations for the mock database
+		// Setup expectations for the mock database: staff and invitation in one transaction
 		mock.ExpectBegin()
-		mock.ExpectQuery(`INSERT INTO "staffs"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(`INSERT INTO "staffs"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectQuery(`INSERT INTO "staff_invitations" \("staff_id","token_hash","expires_at"\)`).
+			WithArgs(5, "token_hash", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		mock.ExpectCommit()
 
 		// Call the repository function
-		err := repo.CreateStaff(&newStaff)
+		err := repo.CreateInvitedStaff(&newStaff, &invitation)
 
 		// err == nil
 		assert.NoError(t, err)
+		assert.Equal(t, 5, invitation.StaffID)
 		// Ensure all expectations were met
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
@@ -60,7 +67,7 @@ func TestGormStaffRepository_CreateStaff(t *testing.T) {
 		// Mock input
 		newStaff := pkg.Staff{
 			Username:   "test_user",
-			Password:   "secure_password",
+			Role:       pkg.RoleNurse,
 			HospitalID: 1,
 		}
 
@@ -69,13 +76,247 @@ func TestGormStaffRepository_CreateStaff(t *testing.T) {
 		mock.ExpectQuery(`INSERT INTO "staffs"`).WillReturnError(errors.New("database error"))
 		mock.ExpectRollback()
 
-		err = repo.CreateStaff(&newStaff)
+		err = repo.CreateInvitedStaff(&newStaff, &pkg.StaffInvitation{TokenHash: "token_hash"})
 
 		// err happens and not nil
 		assert.Error(t, err)
 		// Ensure all expectations were met
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
+
+	// Failure case - constraint violations become domain errors
+	t.Run("failed staff creation (constraint violation)", func(t *testing.T) {
+		violations := map[error]*pgconn.PgError{
+			staff.ErrDuplicateUsername: {Code: "23505", ConstraintName: "staffs_username_key"},
+			staff.ErrDuplicateEmail:    {Code: "23505", ConstraintName: "staffs_email_key"},
+			staff.ErrHospitalNotFound:  {Code: "23503", ConstraintName: "staffs_hospital_id_fkey"},
+		}
+		for expectedErr, pgErr := range violations {
+			newStaff := pkg.Staff{Username: "test_user", Role: pkg.RoleNurse, HospitalID: 1}
+
+			// Setup expectations
+			mock.ExpectBegin()
+			mock.ExpectQuery(`INSERT INTO "staffs"`).WillReturnError(pgErr)
+			mock.ExpectRollback()
+
+			err = repo.CreateInvitedStaff(&newStaff, &pkg.StaffInvitation{TokenHash: "token_hash"})
+
+			assert.ErrorIs(t, err, expectedErr)
+			// Ensure all expectations were met
+			assert.NoError(t, mock.ExpectationsWereMet())
+		}
+	})
+}
+
+func TestGormStaffRepository_HospitalExists(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+
+	t.Run("existing and unknown hospital", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "hospitals" WHERE id = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "hospitals" WHERE id = \$1`).
+			WithArgs(99).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		exists, err := repo.HospitalExists(1)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = repo.HospitalExists(99)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormStaffRepository_CreateFirstAdmin(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+
+	// Success case
+	t.Run("successful first admin creation", func(t *testing.T) {
+		admin := pkg.Staff{Username: "admin", Password: "hashed_password", Role: pkg.RoleAdmin, HospitalID: 1}
+
+		// Setup expectations: lock the hospital, check it has no admin, then create
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "staffs" WHERE hospital_id = \$1 AND role = \$2`).
+			WithArgs(1, pkg.RoleAdmin).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INSERT INTO "staffs"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		err := repo.CreateFirstAdmin(&admin)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, admin.ID)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the hospital already has an admin
+	t.Run("admin already exists", func(t *testing.T) {
+		admin := pkg.Staff{Username: "admin2", Password: "hashed_password", Role: pkg.RoleAdmin, HospitalID: 1}
+
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "staffs"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		err := repo.CreateFirstAdmin(&admin)
+
+		assert.ErrorIs(t, err, staff.ErrAdminExists)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormStaffRepository_CreateFirstSuperAdmin(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+
+	// Success case
+	t.Run("successful super-admin creation", func(t *testing.T) {
+		superAdmin := pkg.Staff{Username: "root", Password: "hashed_password", Role: pkg.RoleSuperAdmin}
+
+		// Setup expectations: lock, check there is no super-admin, then create without hospital
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).WithArgs(0).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "staffs" WHERE role = \$1`).
+			WithArgs(pkg.RoleSuperAdmin).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`INSERT INTO "staffs" \("username","password","role","email"\) VALUES`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		err := repo.CreateFirstSuperAdmin(&superAdmin)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, superAdmin.ID)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the platform already has a super-admin
+	t.Run("super-admin already exists", func(t *testing.T) {
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "staffs"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		err := repo.CreateFirstSuperAdmin(&pkg.Staff{Username: "root2", Password: "hashed_password", Role: pkg.RoleSuperAdmin})
+
+		assert.ErrorIs(t, err, staff.ErrSuperAdminExists)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormStaffRepository_ActivateStaff(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+
+	// Success case
+	t.Run("successful staff activation", func(t *testing.T) {
+		invitation := pkg.StaffInvitation{ID: 3, StaffID: 5}
+
+		// Setup expectations: consume the invitation, then set the password
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "staff_invitations" WHERE "staff_invitations"."id" = \$1`).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "staffs" SET "password"=\$1 WHERE id = \$2`).WithArgs("hashed_password", 5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.ActivateStaff(&invitation, "hashed_password")
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - invitation used by another request in the meantime
+	t.Run("invitation already used", func(t *testing.T) {
+		invitation := pkg.StaffInvitation{ID: 3, StaffID: 5}
+
+		// Setup expectations
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "staff_invitations"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.ActivateStaff(&invitation, "hashed_password")
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
 }
 
 func TestGormStaffRepository_GetStaffFromUsername(t *testing.T) {
@@ -135,3 +376,367 @@ func TestGormStaffRepository_GetStaffFromUsername(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestGormStaffRepository_TokenRevocation(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+
+	// Success case - expired revocations are cleaned up, a second logout is ignored
+	t.Run("successful token revocation", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "revoked_tokens" WHERE expires_at < \$1`).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`INSERT INTO "revoked_tokens" \("token_id","expires_at"\) VALUES \(\$1,\$2\) ON CONFLICT DO NOTHING`).
+			WithArgs("token-id", expiresAt).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.RevokeToken(&pkg.RevokedToken{TokenID: "token-id", ExpiresAt: expiresAt})
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - a later revocation of the staff member replaces the previous one, refresh tokens are revoked too
+	t.Run("successful staff sessions revocation", func(t *testing.T) {
+		revokedBefore := time.Now()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO "staff_session_revocations" \("staff_id","revoked_before","except_family_id"\) VALUES \(\$1,\$2,\$3\) ON CONFLICT \("staff_id"\) DO UPDATE SET "revoked_before"="excluded"."revoked_before","except_family_id"="excluded"."except_family_id"`).
+			WithArgs(5, revokedBefore, "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE staff_id = \$2 AND revoked_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), 5).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		err := repo.RevokeStaffSessions(&pkg.StaffSessionRevocation{StaffID: 5, RevokedBefore: revokedBefore})
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("revoked token check", func(t *testing.T) {
+		issuedAt := time.Now()
+		for _, revoked := range []bool{true, false} {
+			mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM revoked_tokens WHERE token_id = \$1\)\s+OR EXISTS \(SELECT 1 FROM staff_session_revocations WHERE staff_id = \$2 AND revoked_before > \$3\s+AND \(except_family_id = '' OR except_family_id <> \$4\)\)`).
+				WithArgs("token-id", 5, issuedAt, "family-id").
+				WillReturnRows(sqlmock.NewRows([]string{"revoked"}).AddRow(revoked))
+
+			result, err := repo.IsTokenRevoked("token-id", 5, "family-id", issuedAt)
+
+			assert.NoError(t, err)
+			assert.Equal(t, revoked, result)
+		}
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the check fails closed in the middleware
+	t.Run("failed revoked token check", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnError(errors.New("database error"))
+
+		_, err := repo.IsTokenRevoked("token-id", 5, "family-id", time.Now())
+
+		assert.Error(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormStaffRepository_RefreshTokens(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+	refreshTokenColumns := []string{"id", "staff_id", "family_id", "token_hash", "expires_at", "used_at", "revoked_at", "auth_methods"}
+
+	// Success case - expired tokens of the staff member are cleaned up
+	t.Run("successful refresh token creation", func(t *testing.T) {
+		expiresAt := time.Now().Add(staff.RefreshTokenTTL)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "refresh_tokens" WHERE staff_id = \$1 AND expires_at < \$2`).
+			WithArgs(3, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "refresh_tokens" \("staff_id","family_id","token_hash","expires_at","used_at","revoked_at","auth_methods"\)`).
+			WithArgs(3, "family-id", "token-hash", expiresAt, nil, nil, "pwd").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		err := repo.CreateRefreshToken(&pkg.RefreshToken{StaffID: 3, FamilyID: "family-id", TokenHash: "token-hash", ExpiresAt: expiresAt, AuthMethods: "pwd"})
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - the token is marked used and the next one joins its family
+	t.Run("successful refresh token rotation", func(t *testing.T) {
+		next := &pkg.RefreshToken{TokenHash: "next-hash", ExpiresAt: time.Now().Add(staff.RefreshTokenTTL)}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "refresh_tokens" WHERE token_hash = \$1 ORDER BY "refresh_tokens"."id" LIMIT \$2 FOR UPDATE`).
+			WithArgs("token-hash", 1).
+			WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, 3, "family-id", "token-hash", time.Now().Add(time.Hour), nil, nil, "pwd otp mfa"))
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "used_at"=\$1 WHERE "id" = \$2`).
+			WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "refresh_tokens"`).
+			WithArgs(3, "family-id", "next-hash", next.ExpiresAt, nil, nil, "pwd otp mfa").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectCommit()
+
+		err := repo.RotateRefreshToken("token-hash", next)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, next.StaffID)
+		assert.Equal(t, "family-id", next.FamilyID)
+		assert.Equal(t, "pwd otp mfa", next.AuthMethods)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - a used token revokes its family, and the revocation is committed
+	t.Run("reused refresh token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).
+			WillReturnRows(sqlmock.NewRows(refreshTokenColumns).AddRow(1, 3, "family-id", "token-hash", time.Now().Add(time.Hour), time.Now(), nil, "pwd"))
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE family_id = \$2 AND revoked_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), "family-id").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.RotateRefreshToken("token-hash", &pkg.RefreshToken{TokenHash: "next-hash"})
+
+		assert.ErrorIs(t, err, staff.ErrRefreshTokenReused)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - unknown, revoked or expired tokens
+	t.Run("invalid refresh token", func(t *testing.T) {
+		rows := []*sqlmock.Rows{
+			sqlmock.NewRows(refreshTokenColumns),
+			sqlmock.NewRows(refreshTokenColumns).AddRow(1, 3, "family-id", "token-hash", time.Now().Add(time.Hour), nil, time.Now(), "pwd"),
+			sqlmock.NewRows(refreshTokenColumns).AddRow(1, 3, "family-id", "token-hash", time.Now().Add(-time.Hour), nil, nil, "pwd"),
+		}
+		for _, row := range rows {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM "refresh_tokens"`).WillReturnRows(row)
+			mock.ExpectRollback()
+
+			err := repo.RotateRefreshToken("token-hash", &pkg.RefreshToken{TokenHash: "next-hash"})
+
+			assert.ErrorIs(t, err, staff.ErrInvalidRefreshToken)
+		}
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("successful refresh token family revocation", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE family_id = \$2 AND revoked_at IS NULL`).
+			WithArgs(sqlmock.AnyArg(), "family-id").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.RevokeRefreshTokenFamily("family-id")
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormStaffRepository_LoginFailures(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+
+	// Success case - failures of the username and the client are read together
+	t.Run("successful failures lookup", func(t *testing.T) {
+		lastFailureAt := time.Now()
+		mock.ExpectQuery(`SELECT \* FROM "login_failures" WHERE key IN \(\$1,\$2\)`).
+			WithArgs("username:test_user", "ip:192.0.2.1").
+			WillReturnRows(sqlmock.NewRows([]string{"key", "failures", "last_failure_at"}).AddRow("username:test_user", 4, lastFailureAt))
+
+		failures, err := repo.GetLoginFailures("username:test_user", "ip:192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, []pkg.LoginFailure{{Key: "username:test_user", Failures: 4, LastFailureAt: lastFailureAt}}, failures)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - stale failures are cleaned up, the count restarts after the window
+	t.Run("successful failure record", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "login_failures" WHERE last_failure_at < \$1`).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(`INSERT INTO login_failures \(key, failures, last_failure_at\) VALUES \(\$1, 1, \$2\)\s+ON CONFLICT \(key\) DO UPDATE SET\s+failures = CASE WHEN login_failures.last_failure_at >= \$3 THEN login_failures.failures \+ 1 ELSE 1 END`).
+			WithArgs("username:test_user", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.RecordLoginFailure("username:test_user", staff.LoginFailureWindow)
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("successful failures clear", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "login_failures" WHERE key = \$1`).
+			WithArgs("username:test_user").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.ClearLoginFailures("username:test_user")
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the transaction is rolled back
+	t.Run("failed failure record", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "login_failures"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO login_failures`).WillReturnError(errors.New("database error"))
+		mock.ExpectRollback()
+
+		err := repo.RecordLoginFailure("ip:192.0.2.1", staff.LoginFailureWindow)
+
+		assert.Error(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGormStaffRepository_ChangePassword(t *testing.T) {
+	// Mock database
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dialector := postgres.New(postgres.Config{
+		Conn: db,
+	})
+
+	// GORM from mock database
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	repo := staff.NewGormStaffRepository(gormDB)
+	current := &pkg.Staff{ID: 5, Password: "current_hash"}
+
+	t.Run("successful history lookup", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \* FROM "password_histories" WHERE staff_id = \$1 ORDER BY created_at DESC, id DESC LIMIT \$2`).
+			WithArgs(5, 4).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "staff_id", "password_hash"}).AddRow(2, 5, "former_hash"))
+
+		history, err := repo.GetPasswordHistory(5, 4)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "former_hash", history[0].PasswordHash)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Success case - the replaced hash joins the history, which is trimmed, and the other logins are revoked
+	t.Run("successful password change", func(t *testing.T) {
+		revokedBefore := time.Now()
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "staffs" SET "password"=\$1 WHERE id = \$2 AND password = \$3`).
+			WithArgs("new_hash", 5, "current_hash").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "password_histories" \("staff_id","password_hash","created_at"\) VALUES \(\$1,\$2,\$3\) RETURNING "id"`).
+			WithArgs(5, "current_hash", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectExec(`DELETE FROM password_histories WHERE staff_id = \$1 AND id NOT IN \(\s+SELECT id FROM password_histories WHERE staff_id = \$2 ORDER BY created_at DESC, id DESC LIMIT \$3\)`).
+			WithArgs(5, 5, 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO "staff_session_revocations" \("staff_id","revoked_before","except_family_id"\) VALUES \(\$1,\$2,\$3\) ON CONFLICT`).
+			WithArgs(5, revokedBefore, "family-id").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE \(staff_id = \$2 AND revoked_at IS NULL\) AND family_id <> \$3`).
+			WithArgs(sqlmock.AnyArg(), 5, "family-id").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		err := repo.ChangePassword(current, "new_hash", 4, &pkg.StaffSessionRevocation{StaffID: 5, RevokedBefore: revokedBefore, ExceptFamilyID: "family-id"})
+
+		assert.NoError(t, err)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// Failure case - the password was changed since it was checked, nothing is changed
+	t.Run("concurrent password change", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "staffs" SET "password"=\$1 WHERE id = \$2 AND password = \$3`).
+			WithArgs("new_hash", 5, "current_hash").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.ChangePassword(current, "new_hash", 4, &pkg.StaffSessionRevocation{StaffID: 5, RevokedBefore: time.Now()})
+
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		// Ensure all expectations were met
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -3,11 +3,14 @@ package staff_test
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
 	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -15,11 +18,39 @@ type mockStaffRepo struct {
 	mock.Mock
 }
 
-func (m *mockStaffRepo) CreateStaff(staff *pkg.Staff) error {
+func (m *mockStaffRepo) CreateInvitedStaff(staff *pkg.Staff, invitation *pkg.StaffInvitation) error {
+	args := m.Called(staff, invitation)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) CreateFirstAdmin(staff *pkg.Staff) error {
 	args := m.Called(staff)
 	return args.Error(0)
 }
 
+func (m *mockStaffRepo) CreateFirstSuperAdmin(staff *pkg.Staff) error {
+	args := m.Called(staff)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) GetInvitationByTokenHash(tokenHash string) (*pkg.StaffInvitation, error) {
+	args := m.Called(tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.StaffInvitation), args.Error(1)
+}
+
+func (m *mockStaffRepo) ActivateStaff(invitation *pkg.StaffInvitation, hashedPassword string) error {
+	args := m.Called(invitation, hashedPassword)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) HospitalExists(hospitalID int) (bool, error) {
+	args := m.Called(hospitalID)
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *mockStaffRepo) GetStaffFromUsername(username string) (*pkg.Staff, error) {
 	args := m.Called(username)
 	if args.Get(0) == nil {
@@ -30,6 +61,142 @@ func (m *mockStaffRepo) GetStaffFromUsername(username string) (*pkg.Staff, error
 	return args.Get(0).(*pkg.Staff), args.Error(1)
 }
 
+func (m *mockStaffRepo) GetStaffByID(id int) (*pkg.Staff, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Staff), args.Error(1)
+}
+
+func (m *mockStaffRepo) RevokeToken(token *pkg.RevokedToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) RevokeStaffSessions(revocation *pkg.StaffSessionRevocation) error {
+	args := m.Called(revocation)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) IsTokenRevoked(tokenID string, staffID int, familyID string, issuedAt time.Time) (bool, error) {
+	args := m.Called(tokenID, staffID, familyID, issuedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockStaffRepo) CreateRefreshToken(token *pkg.RefreshToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) RotateRefreshToken(tokenHash string, next *pkg.RefreshToken) error {
+	args := m.Called(tokenHash, next)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) RevokeRefreshTokenFamily(familyID string) error {
+	args := m.Called(familyID)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) GetLoginFailures(keys ...string) ([]pkg.LoginFailure, error) {
+	args := m.Called(keys)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]pkg.LoginFailure), args.Error(1)
+}
+
+func (m *mockStaffRepo) RecordLoginFailure(key string, window time.Duration) error {
+	args := m.Called(key, window)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) ClearLoginFailures(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) GetPasswordHistory(staffID int, limit int) ([]pkg.PasswordHistory, error) {
+	args := m.Called(staffID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]pkg.PasswordHistory), args.Error(1)
+}
+
+func (m *mockStaffRepo) ChangePassword(staff *pkg.Staff, hashedPassword string, keepHistory int, revocation *pkg.StaffSessionRevocation) error {
+	args := m.Called(staff, hashedPassword, keepHistory, revocation)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) GetStaffFromEmail(email string) (*pkg.Staff, error) {
+	args := m.Called(email)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Staff), args.Error(1)
+}
+
+func (m *mockStaffRepo) CreatePasswordReset(reset *pkg.PasswordReset, cooldown time.Duration) error {
+	args := m.Called(reset, cooldown)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) GetPasswordResetByTokenHash(tokenHash string) (*pkg.PasswordReset, error) {
+	args := m.Called(tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.PasswordReset), args.Error(1)
+}
+
+func (m *mockStaffRepo) ResetPassword(reset *pkg.PasswordReset, hashedPassword string, keepHistory int, revocation *pkg.StaffSessionRevocation) error {
+	args := m.Called(reset, hashedPassword, keepHistory, revocation)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) GetHospital(hospitalID int) (*pkg.Hospital, error) {
+	args := m.Called(hospitalID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.Hospital), args.Error(1)
+}
+
+func (m *mockStaffRepo) GetStaffTOTP(staffID int) (*pkg.StaffTOTP, error) {
+	args := m.Called(staffID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*pkg.StaffTOTP), args.Error(1)
+}
+
+func (m *mockStaffRepo) StartTOTPEnrollment(totp *pkg.StaffTOTP) error {
+	args := m.Called(totp)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) ConfirmTOTPEnrollment(staffID int, step int64, recoveryCodeHashes []string) error {
+	args := m.Called(staffID, step, recoveryCodeHashes)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) UseTOTPStep(staffID int, step int64) error {
+	args := m.Called(staffID, step)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) UseRecoveryCode(staffID int, codeHash string) error {
+	args := m.Called(staffID, codeHash)
+	return args.Error(0)
+}
+
+func (m *mockStaffRepo) DeleteStaffTOTP(staffID int, revocation *pkg.StaffSessionRevocation) error {
+	args := m.Called(staffID, revocation)
+	return args.Error(0)
+}
+
 // Mock bcrypt hasher
 type MockPasswordHasher struct {
 	mock.Mock
@@ -42,29 +209,89 @@ func (m *MockPasswordHasher) CompareHashAndPassword(hashedPassword []byte, passw
 }
 
 // Mock createToken function to always success
-func mockCreateToken(staff *pkg.Staff) (string, error) {
+func mockCreateToken(staff *pkg.Staff, session staff.LoginSession, expiresAt time.Time) (string, error) {
 	return "mockTokenString", nil
 }
 
-func TestStaffService_CreateStaff(t *testing.T) {
+// Whether the hash is a bcrypt hash with the cost of the stored passwords
+func isBcryptHash(hashedPassword []byte) bool {
+	cost, err := bcrypt.Cost(hashedPassword)
+	return err == nil && cost == staff.PasswordHashCost
+}
+
+// Key ring signing the login tokens of the tests
+func newTestKeyRing(t *testing.T) *pkg.KeyRing {
+	key, err := pkg.GenerateSigningKey("test-1")
+	assert.NoError(t, err)
+	keys, err := pkg.NewKeyRing("test-1", key)
+	assert.NoError(t, err)
+	return keys
+}
+
+func TestStaffService_CreateToken(t *testing.T) {
+	keys := newTestKeyRing(t)
+	staffService, err := staff.NewStaffService(new(mockStaffRepo), keys, &staff.DefaultPasswordPolicy, nil, nil)
+	assert.NoError(t, err)
+	service := staffService.(*staff.StaffService)
+
+	// Test case: login tokens are signed with the active key, named in the kid header
+	t.Run("signed with the active key", func(t *testing.T) {
+		expiresAt := time.Now().Add(staff.AccessTokenTTL)
+		tokenString, err := service.CreateTokenFunc(&pkg.Staff{ID: 4, Username: "test_user", Role: pkg.RoleNurse, HospitalID: 1},
+			staff.LoginSession{FamilyID: "family", AuthMethods: []string{pkg.AuthMethodPassword}}, expiresAt)
+		assert.NoError(t, err)
+
+		token, err := jwt.Parse(tokenString, keys.Keyfunc)
+		assert.NoError(t, err)
+		assert.Equal(t, "test-1", token.Header["kid"])
+		assert.Equal(t, "EdDSA", token.Header["alg"])
+
+		claims := token.Claims.(jwt.MapClaims)
+		assert.Equal(t, float64(4), claims["staff_id"])
+		assert.Equal(t, "family", claims["fid"])
+		assert.Equal(t, []interface{}{"pwd"}, claims["amr"])
+		assert.Equal(t, false, claims["mfa_required"])
+		assert.NotEmpty(t, claims["jti"])
+		assert.Equal(t, float64(expiresAt.Unix()), claims["exp"])
+	})
+
+	// Test case: Failed - other key rings do not verify the token
+	t.Run("other key ring", func(t *testing.T) {
+		tokenString, err := service.CreateTokenFunc(&pkg.Staff{ID: 4}, staff.LoginSession{}, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		_, err = jwt.Parse(tokenString, newTestKeyRing(t).Keyfunc)
+		assert.Error(t, err)
+	})
+}
+
+func TestStaffService_InviteStaff(t *testing.T) {
 	mockRepo := new(mockStaffRepo)
-	service := staff.NewStaffService(mockRepo)
+	service, err := staff.NewStaffService(mockRepo, newTestKeyRing(t), &staff.DefaultPasswordPolicy, nil, nil)
+	assert.NoError(t, err)
 
-	// Test case: Successful staff creation
-	t.Run("successful staff creation", func(t *testing.T) {
-		// mock input body request
+	// Test case: Successful staff invitation
+	t.Run("successful staff invitation", func(t *testing.T) {
+		// mock input body request, a password is never taken from the admin
 		inputStaff := pkg.Staff{
 			Username:   "test_user",
-			Password:   "secure_password",
+			Password:   "set_by_admin",
+			Role:       pkg.RoleNurse,
 			HospitalID: 1,
 		}
 
-		mockRepo.On("CreateStaff", &inputStaff).Return(nil)
+		mockRepo.On("HospitalExists", 1).Return(true, nil)
+		mockRepo.On("CreateInvitedStaff", &inputStaff, mock.AnythingOfType("*pkg.StaffInvitation")).Return(nil)
 
-		createdStaff, err := service.CreateStaff(&inputStaff)
+		invitation, err := service.InviteStaff(&inputStaff)
 
 		assert.NoError(t, err)
-		assert.Equal(t, "test_user", createdStaff.Username)
+		assert.Empty(t, inputStaff.Password)
+		assert.Equal(t, "test_user", invitation.Staff.Username)
+		// Only the hash of the returned token is stored
+		assert.NotEmpty(t, invitation.Token)
+		assert.Equal(t, pkg.HashOpaqueToken(invitation.Token), invitation.TokenHash)
+		assert.WithinDuration(t, time.Now().Add(staff.InvitationTTL), invitation.ExpiresAt, time.Minute)
 
 		// Verify expectations
 		mockRepo.AssertExpectations(t)
@@ -75,16 +302,207 @@ func TestStaffService_CreateStaff(t *testing.T) {
 		// mock input body request
 		inputStaff := pkg.Staff{
 			Username:   "test_user",
-			Password:   "secure_password",
+			Role:       pkg.RoleNurse,
 			HospitalID: 1,
 		}
 
-		mockRepo.On("CreateStaff", &inputStaff).Return(errors.New("database error"))
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("HospitalExists", 1).Return(true, nil)
+		mockRepo.On("CreateInvitedStaff", &inputStaff, mock.AnythingOfType("*pkg.StaffInvitation")).Return(errors.New("database error"))
 
-		emptyStaff, err := service.CreateStaff(&inputStaff)
+		invitation, err := service.InviteStaff(&inputStaff)
 
 		assert.Error(t, err)
-		assert.Empty(t, emptyStaff)
+		assert.Nil(t, invitation)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - missing or unknown hospital, taken username
+	t.Run("failed staff invitation (hospital or username)", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+
+		_, err := service.InviteStaff(&pkg.Staff{Username: "test_user", Role: pkg.RoleNurse})
+		assert.ErrorIs(t, err, staff.ErrInvalidHospital)
+		mockRepo.AssertNotCalled(t, "HospitalExists", mock.Anything)
+
+		mockRepo.On("HospitalExists", 99).Return(false, nil)
+		_, err = service.InviteStaff(&pkg.Staff{Username: "test_user", Role: pkg.RoleNurse, HospitalID: 99})
+		assert.ErrorIs(t, err, staff.ErrHospitalNotFound)
+		mockRepo.AssertNotCalled(t, "CreateInvitedStaff", mock.Anything, mock.Anything)
+
+		mockRepo.On("HospitalExists", 1).Return(true, nil)
+		mockRepo.On("CreateInvitedStaff", mock.AnythingOfType("*pkg.Staff"), mock.AnythingOfType("*pkg.StaffInvitation")).Return(staff.ErrDuplicateUsername)
+		_, err = service.InviteStaff(&pkg.Staff{Username: "taken_user", Role: pkg.RoleNurse, HospitalID: 1})
+		assert.ErrorIs(t, err, staff.ErrDuplicateUsername)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestStaffService_ActivateStaff(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	service, err := staff.NewStaffService(mockRepo, newTestKeyRing(t), &staff.DefaultPasswordPolicy, nil, nil)
+	assert.NoError(t, err)
+
+	tokenHash := pkg.HashOpaqueToken("invitation_token")
+
+	// Test case: Successful activation sets a bcrypt hash of the password
+	t.Run("successful staff activation", func(t *testing.T) {
+		invitation := &pkg.StaffInvitation{
+			ID:        3,
+			StaffID:   5,
+			Staff:     pkg.Staff{ID: 5, Username: "test_user"},
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+
+		mockRepo.On("GetInvitationByTokenHash", tokenHash).Return(invitation, nil)
+		mockRepo.On("ActivateStaff", invitation, mock.AnythingOfType("string")).Return(nil)
+
+		activatedStaff, err := service.ActivateStaff("invitation_token", "Secure-Password-1")
+
+		assert.NoError(t, err)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(activatedStaff.Password), []byte("Secure-Password-1")))
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - the password breaks the policy, the invitation is kept
+	t.Run("weak password", func(t *testing.T) {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockRepo.On("GetInvitationByTokenHash", tokenHash).Return(&pkg.StaffInvitation{
+			ID:        3,
+			Staff:     pkg.Staff{ID: 5, Username: "test_user"},
+			ExpiresAt: time.Now().Add(time.Hour),
+		}, nil)
+
+		_, err := service.ActivateStaff("invitation_token", "test_user-Password-1")
+
+		var weak *staff.PasswordPolicyError
+		assert.ErrorAs(t, err, &weak)
+		assert.ErrorIs(t, err, staff.ErrWeakPassword)
+		assert.Equal(t, []string{"must not contain the username"}, weak.Violations)
+		mockRepo.AssertNotCalled(t, "ActivateStaff", mock.Anything, mock.Anything)
+	})
+
+	// Test case: Failed - unknown, expired or concurrently used invitation
+	t.Run("invalid invitation", func(t *testing.T) {
+		// Unknown token
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("GetInvitationByTokenHash", pkg.HashOpaqueToken("unknown_token")).Return(nil, gorm.ErrRecordNotFound)
+
+		_, err := service.ActivateStaff("unknown_token", "secure_password")
+		assert.ErrorIs(t, err, staff.ErrInvalidInvitation)
+
+		// Expired invitation
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("GetInvitationByTokenHash", tokenHash).Return(&pkg.StaffInvitation{ID: 3, ExpiresAt: time.Now().Add(-time.Minute)}, nil)
+
+		_, err = service.ActivateStaff("invitation_token", "secure_password")
+		assert.ErrorIs(t, err, staff.ErrInvalidInvitation)
+
+		// Used by another request in the meantime
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("GetInvitationByTokenHash", tokenHash).Return(&pkg.StaffInvitation{ID: 3, ExpiresAt: time.Now().Add(time.Hour)}, nil)
+		mockRepo.On("ActivateStaff", mock.AnythingOfType("*pkg.StaffInvitation"), mock.AnythingOfType("string")).Return(gorm.ErrRecordNotFound)
+
+		_, err = service.ActivateStaff("invitation_token", "Secure-Password-1")
+		assert.ErrorIs(t, err, staff.ErrInvalidInvitation)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestStaffService_BootstrapAdmin(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	service := &staff.StaffService{
+		Repo:           mockRepo,
+		BootstrapToken: "bootstrap_token",
+	}
+
+	// Test case: Successful creation of the first admin
+	t.Run("successful admin bootstrap", func(t *testing.T) {
+		inputStaff := pkg.Staff{Username: "admin", Password: "secure_password", Role: pkg.RoleNurse, HospitalID: 1}
+
+		mockRepo.On("HospitalExists", 1).Return(true, nil)
+		mockRepo.On("CreateFirstAdmin", &inputStaff).Return(nil)
+
+		admin, err := service.BootstrapAdmin(&inputStaff, "bootstrap_token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, pkg.RoleAdmin, admin.Role)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte("secure_password")))
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - wrong token, disabled bootstrap or existing admin
+	t.Run("failed admin bootstrap", func(t *testing.T) {
+		_, err := service.BootstrapAdmin(&pkg.Staff{Username: "admin", Password: "secure_password", HospitalID: 1}, "wrong_token")
+		assert.ErrorIs(t, err, staff.ErrInvalidBootstrap)
+
+		disabledService := &staff.StaffService{Repo: mockRepo}
+		_, err = disabledService.BootstrapAdmin(&pkg.Staff{Username: "admin", Password: "secure_password", HospitalID: 1}, "")
+		assert.ErrorIs(t, err, staff.ErrBootstrapDisabled)
+
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("HospitalExists", 1).Return(true, nil)
+		mockRepo.On("CreateFirstAdmin", mock.AnythingOfType("*pkg.Staff")).Return(staff.ErrAdminExists)
+		_, err = service.BootstrapAdmin(&pkg.Staff{Username: "admin2", Password: "secure_password", HospitalID: 1}, "bootstrap_token")
+		assert.ErrorIs(t, err, staff.ErrAdminExists)
+
+		mockRepo.On("HospitalExists", 99).Return(false, nil)
+		_, err = service.BootstrapAdmin(&pkg.Staff{Username: "admin3", Password: "secure_password", HospitalID: 99}, "bootstrap_token")
+		assert.ErrorIs(t, err, staff.ErrHospitalNotFound)
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestStaffService_BootstrapSuperAdmin(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	service := &staff.StaffService{
+		Repo:           mockRepo,
+		BootstrapToken: "bootstrap_token",
+	}
+
+	// Test case: Successful creation of the super-admin, who belongs to no hospital
+	t.Run("successful super-admin bootstrap", func(t *testing.T) {
+		inputStaff := pkg.Staff{Username: "root", Password: "secure_password", Role: pkg.RoleAdmin, HospitalID: 1}
+
+		mockRepo.On("CreateFirstSuperAdmin", &inputStaff).Return(nil)
+
+		superAdmin, err := service.BootstrapSuperAdmin(&inputStaff, "bootstrap_token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, pkg.RoleSuperAdmin, superAdmin.Role)
+		assert.Zero(t, superAdmin.HospitalID)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(superAdmin.Password), []byte("secure_password")))
+
+		// Verify expectations
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - wrong token or existing super-admin
+	t.Run("failed super-admin bootstrap", func(t *testing.T) {
+		_, err := service.BootstrapSuperAdmin(&pkg.Staff{Username: "root", Password: "secure_password"}, "wrong_token")
+		assert.ErrorIs(t, err, staff.ErrInvalidBootstrap)
+
+		mockRepo.ExpectedCalls = nil
+		mockRepo.On("CreateFirstSuperAdmin", mock.AnythingOfType("*pkg.Staff")).Return(staff.ErrSuperAdminExists)
+		_, err = service.BootstrapSuperAdmin(&pkg.Staff{Username: "root2", Password: "secure_password"}, "bootstrap_token")
+		assert.ErrorIs(t, err, staff.ErrSuperAdminExists)
 
 		// Verify expectations
 		mockRepo.AssertExpectations(t)
@@ -94,12 +512,15 @@ func TestStaffService_CreateStaff(t *testing.T) {
 func TestStaffService_SignInStaff(t *testing.T) {
 	mockRepo := new(mockStaffRepo)
 	mockHasher := new(MockPasswordHasher)
+	dummyPasswordHash, err := staff.NewDummyPasswordHash()
+	assert.NoError(t, err)
 
 	// Create service instance
 	service := &staff.StaffService{
-		Repo:            mockRepo,
-		PasswordHasher:  mockHasher,
-		CreateTokenFunc: mockCreateToken,
+		Repo:              mockRepo,
+		PasswordHasher:    mockHasher,
+		DummyPasswordHash: dummyPasswordHash,
+		CreateTokenFunc:   mockCreateToken,
 	}
 
 	// Test case: Successful staff sign in
@@ -112,16 +533,26 @@ func TestStaffService_SignInStaff(t *testing.T) {
 
 		// Mock the GetStaffFromUsername to return a staff member
 		mockRepo.On("GetStaffFromUsername", inputStaff.Username).Return(&pkg.Staff{
+			ID:       3,
 			Username: "test_user",
 			Password: "hash_password_is_oifdaifdhgiajgheahjrephjhg",
 		}, nil)
 		// Mock bcrypt CompareHashAndPassword to return nil (successful password match)
 		mockHasher.On("CompareHashAndPassword", mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]uint8")).Return(nil)
+		mockRepo.On("CreateRefreshToken", mock.AnythingOfType("*pkg.RefreshToken")).Return(nil)
 
-		token, err := service.SignInStaff(&inputStaff)
+		token, _, err := service.SignInStaff(&inputStaff, "")
 
 		assert.NoError(t, err)
-		assert.NotEmpty(t, token) // token is returned
+		assert.Equal(t, "mockTokenString", token.AccessToken) // token is returned
+		assert.WithinDuration(t, time.Now().Add(staff.AccessTokenTTL), token.AccessTokenExpiresAt, time.Second)
+
+		// Only the hash of the refresh token is stored, in a new family
+		stored := mockRepo.Calls[1].Arguments.Get(0).(*pkg.RefreshToken)
+		assert.Equal(t, 3, stored.StaffID)
+		assert.NotEmpty(t, stored.FamilyID)
+		assert.Equal(t, pkg.HashOpaqueToken(token.RefreshToken), stored.TokenHash)
+		assert.Equal(t, stored.ExpiresAt, token.RefreshTokenExpiresAt)
 
 		// Verify expectations
 		mockRepo.AssertExpectations(t)
@@ -138,10 +569,14 @@ func TestStaffService_SignInStaff(t *testing.T) {
 
 		// Reset expectations for this test case
 		mockRepo.ExpectedCalls = nil
+		mockHasher.ExpectedCalls = nil
+		mockHasher.Calls = nil
 
 		mockRepo.On("GetStaffFromUsername", inputStaff.Username).Return(nil, gorm.ErrRecordNotFound)
+		// The password is compared with a dummy hash, as long as a wrong password takes
+		mockHasher.On("CompareHashAndPassword", mock.MatchedBy(isBcryptHash), []byte("secure_password")).Return(errors.New("password does not matched"))
 
-		token, err := service.SignInStaff(&inputStaff)
+		token, _, err := service.SignInStaff(&inputStaff, "")
 
 		assert.Error(t, err)
 		assert.Empty(t, token) // token is empty string
@@ -149,6 +584,7 @@ func TestStaffService_SignInStaff(t *testing.T) {
 
 		// Verify expectations
 		mockRepo.AssertExpectations(t)
+		mockHasher.AssertExpectations(t)
 	})
 
 	// Test case: Failed - GetStaffFromUsername error in database
@@ -164,7 +600,7 @@ func TestStaffService_SignInStaff(t *testing.T) {
 
 		mockRepo.On("GetStaffFromUsername", inputStaff.Username).Return(nil, errors.New("database error"))
 
-		token, err := service.SignInStaff(&inputStaff)
+		token, _, err := service.SignInStaff(&inputStaff, "")
 
 		assert.Error(t, err)
 		assert.Empty(t, token) // token is empty string
@@ -194,7 +630,7 @@ func TestStaffService_SignInStaff(t *testing.T) {
 		// Mock bcrypt CompareHashAndPassword to return error (failed password match)
 		mockHasher.On("CompareHashAndPassword", mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]uint8")).Return(errors.New("password does not matched"))
 
-		token, err := service.SignInStaff(&inputStaff)
+		token, _, err := service.SignInStaff(&inputStaff, "")
 
 		assert.Error(t, err)
 		assert.Empty(t, token) // token is empty string
@@ -204,4 +640,136 @@ func TestStaffService_SignInStaff(t *testing.T) {
 		mockRepo.AssertExpectations(t)
 		mockHasher.AssertExpectations(t)
 	})
+
+	// Test case: Failed - invited staff which has not set a password yet
+	t.Run("staff not activated", func(t *testing.T) {
+		inputStaff := pkg.Staff{
+			Username: "test_user",
+			Password: "",
+		}
+
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockHasher.ExpectedCalls = nil
+		mockHasher.Calls = nil
+
+		mockRepo.On("GetStaffFromUsername", inputStaff.Username).Return(&pkg.Staff{Username: "test_user"}, nil)
+		// Even a hasher accepting the dummy hash does not log invited staff in
+		mockHasher.On("CompareHashAndPassword", mock.MatchedBy(isBcryptHash), []byte("")).Return(nil)
+
+		token, _, err := service.SignInStaff(&inputStaff, "")
+
+		assert.ErrorIs(t, err, staff.ErrUnauthorized)
+		assert.Empty(t, token)
+		mockHasher.AssertExpectations(t)
+		mockRepo.AssertNotCalled(t, "CreateRefreshToken", mock.Anything)
+	})
+}
+
+func TestStaffService_SignOutStaff(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	service := &staff.StaffService{Repo: mockRepo}
+
+	// Test case: the token is revoked until it expires, with the refresh tokens of the login
+	t.Run("successful staff sign out", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Hour)
+		mockRepo.On("RevokeToken", &pkg.RevokedToken{TokenID: "token-id", ExpiresAt: expiresAt}).Return(nil)
+		mockRepo.On("RevokeRefreshTokenFamily", "family-id").Return(nil)
+
+		err := service.SignOutStaff("token-id", "family-id", expiresAt)
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: tokens issued before refresh tokens have no family
+	t.Run("sign out without refresh tokens", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		expiresAt := time.Now().Add(time.Hour)
+		mockRepo.On("RevokeToken", &pkg.RevokedToken{TokenID: "token-id", ExpiresAt: expiresAt}).Return(nil)
+
+		err := service.SignOutStaff("token-id", "", expiresAt)
+
+		assert.NoError(t, err)
+		mockRepo.AssertNotCalled(t, "RevokeRefreshTokenFamily", mock.Anything)
+	})
+}
+
+func TestStaffService_RefreshToken(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	service := &staff.StaffService{
+		Repo:            mockRepo,
+		CreateTokenFunc: mockCreateToken,
+	}
+
+	// Test case: the refresh token is exchanged for the next one of its family
+	t.Run("successful token refresh", func(t *testing.T) {
+		mockRepo.On("RotateRefreshToken", pkg.HashOpaqueToken("refresh-token"), mock.AnythingOfType("*pkg.RefreshToken")).
+			Run(func(args mock.Arguments) {
+				next := args.Get(1).(*pkg.RefreshToken)
+				next.StaffID = 3
+				next.FamilyID = "family-id"
+			}).
+			Return(nil)
+		mockRepo.On("GetStaffByID", 3).Return(&pkg.Staff{ID: 3, Role: pkg.RoleNurse, HospitalID: 1}, nil)
+
+		token, err := service.RefreshToken("refresh-token")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "mockTokenString", token.AccessToken)
+		assert.NotEqual(t, "refresh-token", token.RefreshToken)
+		next := mockRepo.Calls[0].Arguments.Get(1).(*pkg.RefreshToken)
+		assert.Equal(t, pkg.HashOpaqueToken(token.RefreshToken), next.TokenHash)
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - invalid or reused refresh token
+	t.Run("invalid or reused refresh token", func(t *testing.T) {
+		for _, repoErr := range []error{staff.ErrInvalidRefreshToken, staff.ErrRefreshTokenReused} {
+			// Reset expectations for this test case
+			mockRepo.ExpectedCalls = nil
+			mockRepo.On("RotateRefreshToken", mock.Anything, mock.Anything).Return(repoErr)
+
+			token, err := service.RefreshToken("refresh-token")
+
+			assert.ErrorIs(t, err, repoErr)
+			assert.Nil(t, token)
+		}
+	})
+}
+
+func TestStaffService_RevokeStaffSessions(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	service := &staff.StaffService{Repo: mockRepo}
+
+	// Test case: tokens issued until now, in seconds, are revoked
+	t.Run("successful session revocation", func(t *testing.T) {
+		mockRepo.On("GetStaffByID", 5).Return(&pkg.Staff{ID: 5, HospitalID: 1}, nil)
+		mockRepo.On("RevokeStaffSessions", mock.AnythingOfType("*pkg.StaffSessionRevocation")).Return(nil)
+
+		err := service.RevokeStaffSessions(1, 5)
+
+		assert.NoError(t, err)
+		revocation := mockRepo.Calls[1].Arguments.Get(0).(*pkg.StaffSessionRevocation)
+		assert.Equal(t, 5, revocation.StaffID)
+		assert.True(t, revocation.RevokedBefore.After(time.Now()))
+		assert.Zero(t, revocation.RevokedBefore.Nanosecond())
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - unknown staff, or staff of another hospital
+	t.Run("staff not found", func(t *testing.T) {
+		// Reset expectations for this test case
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockRepo.On("GetStaffByID", 6).Return(&pkg.Staff{ID: 6, HospitalID: 2}, nil)
+		mockRepo.On("GetStaffByID", 7).Return(nil, gorm.ErrRecordNotFound)
+
+		assert.ErrorIs(t, service.RevokeStaffSessions(1, 6), staff.ErrStaffNotFound)
+		assert.ErrorIs(t, service.RevokeStaffSessions(1, 7), staff.ErrStaffNotFound)
+		mockRepo.AssertNotCalled(t, "RevokeStaffSessions", mock.Anything)
+	})
 }
@@ -0,0 +1,224 @@
+package staff_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+var loginThrottleKeys = []string{"username:test_user", "ip:192.0.2.1"}
+
+// Service throttling logins with the default policies
+func newThrottledService(mockRepo *mockStaffRepo, mockHasher *MockPasswordHasher) *staff.StaffService {
+	return &staff.StaffService{
+		Repo:             mockRepo,
+		PasswordHasher:   mockHasher,
+		CreateTokenFunc:  mockCreateToken,
+		UsernameThrottle: staff.UsernameThrottlePolicy,
+		IPThrottle:       staff.IPThrottlePolicy,
+	}
+}
+
+func TestStaffService_SignInStaffThrottle(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	mockHasher := new(MockPasswordHasher)
+	service := newThrottledService(mockRepo, mockHasher)
+	credentials := &pkg.Staff{Username: "test_user", Password: "secure_password"}
+
+	reset := func() {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockHasher.ExpectedCalls = nil
+		mockHasher.Calls = nil
+	}
+
+	// Test case: delays double with every failure beyond the free attempts, up to the maximum
+	t.Run("progressive delays", func(t *testing.T) {
+		expectedDelays := map[int]time.Duration{
+			3: time.Second,
+			4: 2 * time.Second,
+			5: 4 * time.Second,
+			7: 16 * time.Second,
+			8: 30 * time.Second,
+			9: 30 * time.Second,
+		}
+		for failures, expectedDelay := range expectedDelays {
+			reset()
+			mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{
+				{Key: "username:test_user", Failures: failures, LastFailureAt: time.Now()},
+			}, nil)
+
+			tokens, _, err := service.SignInStaff(credentials, "192.0.2.1")
+
+			var throttled *staff.LoginThrottledError
+			assert.ErrorAs(t, err, &throttled)
+			assert.ErrorIs(t, err, staff.ErrLoginThrottled)
+			assert.InDelta(t, expectedDelay.Seconds(), throttled.RetryAfter.Seconds(), 0.5, failures)
+			assert.Nil(t, tokens)
+		}
+
+		// The password is not checked while throttled
+		mockRepo.AssertNotCalled(t, "GetStaffFromUsername", mock.Anything)
+		mockHasher.AssertNotCalled(t, "CompareHashAndPassword", mock.Anything, mock.Anything)
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{
+			{Key: "username:test_user", Failures: 10, LastFailureAt: time.Now().Add(-5 * time.Minute)},
+		}, nil)
+
+		_, _, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		var throttled *staff.LoginThrottledError
+		assert.ErrorAs(t, err, &throttled)
+		assert.InDelta(t, (10 * time.Minute).Seconds(), throttled.RetryAfter.Seconds(), 1)
+	})
+
+	// Test case: the client is throttled after failures on any username
+	t.Run("client lockout", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{
+			{Key: "ip:192.0.2.1", Failures: 100, LastFailureAt: time.Now()},
+		}, nil)
+
+		_, _, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.ErrorIs(t, err, staff.ErrLoginThrottled)
+		mockRepo.AssertNotCalled(t, "GetStaffFromUsername", mock.Anything)
+	})
+
+	// Test case: once the delay passed, a wrong password counts against the username and the client
+	t.Run("failure recorded after the delay", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{
+			{Key: "username:test_user", Failures: 4, LastFailureAt: time.Now().Add(-10 * time.Second)},
+			{Key: "ip:192.0.2.1", Failures: 19, LastFailureAt: time.Now()},
+		}, nil)
+		mockRepo.On("GetStaffFromUsername", "test_user").Return(&pkg.Staff{ID: 3, Username: "test_user", Password: "hash"}, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("secure_password")).Return(errors.New("mismatch"))
+		mockRepo.On("RecordLoginFailure", "username:test_user", staff.LoginFailureWindow).Return(nil)
+		mockRepo.On("RecordLoginFailure", "ip:192.0.2.1", staff.LoginFailureWindow).Return(nil)
+
+		_, _, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.ErrorIs(t, err, staff.ErrUnauthorized)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("unknown username recorded", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{}, nil)
+		mockRepo.On("GetStaffFromUsername", "test_user").Return(nil, gorm.ErrRecordNotFound)
+		mockHasher.On("CompareHashAndPassword", mock.Anything, []byte("secure_password")).Return(errors.New("mismatch"))
+		mockRepo.On("RecordLoginFailure", mock.Anything, staff.LoginFailureWindow).Return(nil)
+
+		_, _, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.ErrorIs(t, err, staff.ErrUnauthorized)
+		mockRepo.AssertNumberOfCalls(t, "RecordLoginFailure", 2)
+	})
+
+	// Test case: a login clears the failures of the username, not those of the client
+	t.Run("successful login clears the username", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{
+			{Key: "username:test_user", Failures: 2, LastFailureAt: time.Now()},
+		}, nil)
+		mockRepo.On("GetStaffFromUsername", "test_user").Return(&pkg.Staff{ID: 3, Username: "test_user", Password: "hash"}, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("secure_password")).Return(nil)
+		mockRepo.On("ClearLoginFailures", "username:test_user").Return(nil)
+		mockRepo.On("CreateRefreshToken", mock.AnythingOfType("*pkg.RefreshToken")).Return(nil)
+
+		tokens, _, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "mockTokenString", tokens.AccessToken)
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNotCalled(t, "ClearLoginFailures", "ip:192.0.2.1")
+		mockRepo.AssertNotCalled(t, "RecordLoginFailure", mock.Anything, mock.Anything)
+	})
+
+	// Test case: Failed - a busy password hasher is not a failed login
+	t.Run("password hasher busy", func(t *testing.T) {
+		reset()
+		mockRepo.On("GetLoginFailures", loginThrottleKeys).Return([]pkg.LoginFailure{}, nil)
+		mockRepo.On("GetStaffFromUsername", "test_user").Return(&pkg.Staff{ID: 3, Username: "test_user", Password: "hash"}, nil)
+		mockHasher.On("CompareHashAndPassword", []byte("hash"), []byte("secure_password")).Return(staff.ErrLoginBusy)
+
+		_, _, err := service.SignInStaff(credentials, "192.0.2.1")
+
+		assert.ErrorIs(t, err, staff.ErrLoginBusy)
+		mockRepo.AssertNotCalled(t, "RecordLoginFailure", mock.Anything, mock.Anything)
+	})
+}
+
+func TestStaffService_UnlockStaff(t *testing.T) {
+	mockRepo := new(mockStaffRepo)
+	service := &staff.StaffService{Repo: mockRepo}
+
+	// Test case: the failures of the username are forgotten
+	t.Run("successful unlock", func(t *testing.T) {
+		mockRepo.On("GetStaffByID", 5).Return(&pkg.Staff{ID: 5, Username: "nurse", HospitalID: 1}, nil)
+		mockRepo.On("ClearLoginFailures", "username:nurse").Return(nil)
+
+		err := service.UnlockStaff(1, 5)
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: Failed - unknown staff, or staff of another hospital
+	t.Run("staff not found", func(t *testing.T) {
+		mockRepo.ExpectedCalls = nil
+		mockRepo.Calls = nil
+		mockRepo.On("GetStaffByID", 6).Return(&pkg.Staff{ID: 6, Username: "doctor", HospitalID: 2}, nil)
+		mockRepo.On("GetStaffByID", 7).Return(nil, gorm.ErrRecordNotFound)
+
+		assert.ErrorIs(t, service.UnlockStaff(1, 6), staff.ErrStaffNotFound)
+		assert.ErrorIs(t, service.UnlockStaff(1, 7), staff.ErrStaffNotFound)
+		mockRepo.AssertNotCalled(t, "ClearLoginFailures", mock.Anything)
+	})
+}
+
+// Password hasher blocking until released
+type blockingHasher struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHasher) CompareHashAndPassword(hashedPassword []byte, password []byte) error {
+	h.started <- struct{}{}
+	<-h.release
+	return nil
+}
+
+func TestBoundedHasher(t *testing.T) {
+	next := &blockingHasher{started: make(chan struct{}, 2), release: make(chan struct{})}
+	hasher := staff.NewBoundedHasher(next, 1)
+	hasher.QueueTimeout = 20 * time.Millisecond
+
+	// Test case: comparisons beyond the workers wait, and are refused after the queue timeout
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, hasher.CompareHashAndPassword([]byte("hash"), []byte("password")))
+	}()
+	<-next.started
+
+	assert.ErrorIs(t, hasher.CompareHashAndPassword([]byte("hash"), []byte("password")), staff.ErrLoginBusy)
+
+	// Test case: the worker is free again once the comparison ended
+	close(next.release)
+	wg.Wait()
+	assert.NoError(t, hasher.CompareHashAndPassword([]byte("hash"), []byte("password")))
+	assert.GreaterOrEqual(t, staff.DefaultPasswordHashWorkers(), 1)
+}
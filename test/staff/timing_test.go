@@ -0,0 +1,92 @@
+package staff_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Peeranut-Kit/health_api_assignment/internal/staff"
+	"github.com/Peeranut-Kit/health_api_assignment/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Rounds of each login path, and how much their median durations may differ
+const (
+	timingRounds    = 7
+	timingTolerance = 0.25
+)
+
+// Tests that a login of an unknown username cannot be told apart from a wrong password,
+// by its response or by its duration, with the real bcrypt hasher
+func TestStaffHandler_SignInStaffTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test hashes passwords with the production bcrypt cost")
+	}
+	gin.SetMode(gin.TestMode)
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("secure_password"), staff.PasswordHashCost)
+	assert.NoError(t, err)
+
+	mockRepo := new(mockStaffRepo)
+	mockRepo.On("GetStaffFromUsername", "known_user").Return(&pkg.Staff{ID: 3, Username: "known_user", Password: string(hashedPassword)}, nil)
+	mockRepo.On("GetStaffFromUsername", "invited_user").Return(&pkg.Staff{ID: 4, Username: "invited_user"}, nil)
+	mockRepo.On("GetStaffFromUsername", "unknown_user").Return(nil, gorm.ErrRecordNotFound)
+
+	dummyPasswordHash, err := staff.NewDummyPasswordHash()
+	assert.NoError(t, err)
+
+	// Throttling is left out, its database calls are the same on every path
+	service := &staff.StaffService{
+		Repo:              mockRepo,
+		PasswordHasher:    &staff.BcryptHasher{},
+		DummyPasswordHash: dummyPasswordHash,
+		CreateTokenFunc:   mockCreateToken,
+	}
+	r := gin.New()
+	r.POST("/staff/login", staff.NewHttpStaffHandler(service).SignInStaff)
+
+	login := func(username string) (*httptest.ResponseRecorder, time.Duration) {
+		req := httptest.NewRequest("POST", "/staff/login", bytes.NewBufferString(`{"username": "`+username+`", "password": "wrong_password"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		start := time.Now()
+		r.ServeHTTP(w, req)
+		return w, time.Since(start)
+	}
+
+	usernames := []string{"known_user", "unknown_user", "invited_user"}
+	durations := map[string][]time.Duration{}
+	for round := 0; round < timingRounds; round++ {
+		// Interleaved, so that a slow period of the machine affects every path
+		for _, username := range usernames {
+			w, duration := login(username)
+			durations[username] = append(durations[username], duration)
+
+			// Test case: uniform error responses
+			assert.Equal(t, http.StatusUnauthorized, w.Code, username)
+			assert.JSONEq(t, `{"error": "`+staff.ErrUnauthorized.Error()+`"}`, w.Body.String(), username)
+		}
+	}
+
+	// Test case: durations within the tolerance of the wrong password one
+	wrongPassword := median(durations["known_user"])
+	for _, username := range usernames[1:] {
+		duration := median(durations[username])
+		difference := float64(duration-wrongPassword) / float64(wrongPassword)
+
+		assert.InDelta(t, 0, difference, timingTolerance, "%s took %s, a wrong password %s", username, duration, wrongPassword)
+	}
+}
+
+func median(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}